@@ -31,6 +31,7 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"go.opencensus.io/trace"
+	authenticationv1 "k8s.io/api/authentication/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/fields"
@@ -41,6 +42,7 @@ import (
 	"github.com/virtual-kubelet/virtual-kubelet/log"
 	"github.com/virtual-kubelet/virtual-kubelet/manager"
 	"github.com/virtual-kubelet/virtual-kubelet/providers"
+	"github.com/virtual-kubelet/virtual-kubelet/providers/ratelimit"
 	"github.com/virtual-kubelet/virtual-kubelet/providers/register"
 	"github.com/virtual-kubelet/virtual-kubelet/vkubelet"
 )
@@ -51,6 +53,13 @@ const (
 	// It is set to the same value used by the Kubelet, and can be overridden via the "--full-resync-period" flag.
 	// https://github.com/kubernetes/kubernetes/blob/v1.12.2/pkg/kubelet/apis/config/v1beta1/defaults.go#L51
 	kubeSharedInformerFactoryDefaultResync = 1 * time.Minute
+
+	// resourceCacheModeInformer makes secrets and config maps be cached by cluster-wide informers.
+	resourceCacheModeInformer = "informer"
+	// resourceCacheModeWatch makes secrets and config maps be fetched on demand, and watched individually for as long as they are accessed.
+	resourceCacheModeWatch = "watch"
+	// resourceCacheModeGet makes secrets and config maps be fetched on demand, and cached for a fixed duration, so that only the "get" permission is required.
+	resourceCacheModeGet = "get"
 )
 
 var kubeletConfig string
@@ -70,8 +79,15 @@ var p providers.Provider
 var rm *manager.ResourceManager
 var apiConfig *apiServerConfig
 var podInformer corev1informers.PodInformer
+var secretInformer corev1informers.SecretInformer
+var configMapInformer corev1informers.ConfigMapInformer
 var kubeSharedInformerFactoryResync time.Duration
 var podSyncWorkers int
+var providerRateLimits ratelimit.Config
+var resourceCacheMode string
+var resourceCacheTTL time.Duration
+var kubernetesServiceHost string
+var kubernetesServicePort int32
 
 var userTraceExporters []string
 var userTraceConfig = TracingExporterOptions{Tags: make(map[string]string)}
@@ -91,14 +107,18 @@ This allows users to schedule kubernetes workloads on nodes that aren't running
 		defer rootContextCancel()
 
 		vk := vkubelet.New(vkubelet.Config{
-			Client:          k8sClient,
-			Namespace:       kubeNamespace,
-			NodeName:        nodeName,
-			Taint:           taint,
-			Provider:        p,
-			ResourceManager: rm,
-			PodSyncWorkers:  podSyncWorkers,
-			PodInformer:     podInformer,
+			Client:                k8sClient,
+			Namespace:             kubeNamespace,
+			NodeName:              nodeName,
+			Taint:                 taint,
+			Provider:              p,
+			ResourceManager:       rm,
+			PodSyncWorkers:        podSyncWorkers,
+			PodInformer:           podInformer,
+			SecretInformer:        secretInformer,
+			ConfigMapInformer:     configMapInformer,
+			KubernetesServiceHost: kubernetesServiceHost,
+			KubernetesServicePort: kubernetesServicePort,
 		})
 
 		sig := make(chan os.Signal, 1)
@@ -188,12 +208,22 @@ func init() {
 	RootCmd.PersistentFlags().MarkDeprecated("taint", "Taint key should now be configured using the VK_TAINT_KEY environment variable")
 	RootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "info", `set the log level, e.g. "trace", debug", "info", "warn", "error"`)
 	RootCmd.PersistentFlags().IntVar(&podSyncWorkers, "pod-sync-workers", 10, `set the number of pod synchronization workers`)
+	RootCmd.PersistentFlags().Float64Var(&providerRateLimits.Mutations.QPS, "provider-mutation-qps", 0, "maximum number of pod mutations (create, update, delete, restart) per second sent to the provider (0 means unlimited)")
+	RootCmd.PersistentFlags().IntVar(&providerRateLimits.Mutations.Burst, "provider-mutation-burst", 10, "maximum burst of pod mutations sent to the provider")
+	RootCmd.PersistentFlags().Float64Var(&providerRateLimits.Reads.QPS, "provider-read-qps", 0, "maximum number of reads (pods, pod statuses, stats) per second sent to the provider (0 means unlimited)")
+	RootCmd.PersistentFlags().IntVar(&providerRateLimits.Reads.Burst, "provider-read-burst", 20, "maximum burst of reads sent to the provider")
+	RootCmd.PersistentFlags().Float64Var(&providerRateLimits.Streams.QPS, "provider-stream-qps", 0, "maximum number of logs and exec requests per second sent to the provider (0 means unlimited)")
+	RootCmd.PersistentFlags().IntVar(&providerRateLimits.Streams.Burst, "provider-stream-burst", 5, "maximum burst of logs and exec requests sent to the provider")
 
 	RootCmd.PersistentFlags().StringSliceVar(&userTraceExporters, "trace-exporter", nil, fmt.Sprintf("sets the tracing exporter to use, available exporters: %s", AvailableTraceExporters()))
 	RootCmd.PersistentFlags().StringVar(&userTraceConfig.ServiceName, "trace-service-name", "virtual-kubelet", "sets the name of the service used to register with the trace exporter")
 	RootCmd.PersistentFlags().Var(mapVar(userTraceConfig.Tags), "trace-tag", "add tags to include with traces in key=value form")
 	RootCmd.PersistentFlags().StringVar(&traceSampler, "trace-sample-rate", "", "set probability of tracing samples")
 
+	RootCmd.PersistentFlags().StringVar(&resourceCacheMode, "resource-cache-mode", resourceCacheModeInformer, fmt.Sprintf("how secrets and config maps are cached: %q caches all of them using cluster-wide informers, %q and %q only fetch those referenced by pods on the node, respectively watching them or caching them for --resource-cache-ttl (%q only requires the \"get\" permission, but doesn't refresh the volumes of running pods)", resourceCacheModeInformer, resourceCacheModeWatch, resourceCacheModeGet, resourceCacheModeGet))
+	RootCmd.PersistentFlags().DurationVar(&resourceCacheTTL, "resource-cache-ttl", time.Minute, fmt.Sprintf("how long secrets and config maps are cached in the %q mode, or watched after their last access once no pod references them in the %q mode", resourceCacheModeGet, resourceCacheModeWatch))
+	RootCmd.PersistentFlags().StringVar(&kubernetesServiceHost, "kubernetes-service-host", "", `address of the API server advertised to pods through the "KUBERNETES_SERVICE_*" environment variables, instead of the cluster IP of the "kubernetes" service`)
+	RootCmd.PersistentFlags().Int32Var(&kubernetesServicePort, "kubernetes-service-port", 443, "port of the API server advertised to pods when --kubernetes-service-host is set")
 	RootCmd.PersistentFlags().DurationVar(&kubeSharedInformerFactoryResync, "full-resync-period", kubeSharedInformerFactoryDefaultResync, "how often to perform a full resync of pods between kubernetes and the provider")
 
 	// Cobra also supports local flags, which will only run
@@ -278,22 +308,42 @@ func initConfig() {
 	// Create a pod informer so we can pass its lister to the resource manager.
 	podInformer = podInformerFactory.Core().V1().Pods()
 
-	// Create another shared informer factory for Kubernetes secrets and configmaps (not subject to any selectors).
-	scmInformerFactory := kubeinformers.NewSharedInformerFactoryWithOptions(k8sClient, kubeSharedInformerFactoryResync)
-	// Create a secret informer and a config map informer so we can pass their listers to the resource manager.
-	secretInformer := scmInformerFactory.Core().V1().Secrets()
-	configMapInformer := scmInformerFactory.Core().V1().ConfigMaps()
-
-	// Create a new instance of the resource manager that uses the listers above for pods, secrets and config maps.
-	rm, err = manager.NewResourceManager(podInformer.Lister(), secretInformer.Lister(), configMapInformer.Lister())
+	switch resourceCacheMode {
+	case resourceCacheModeInformer:
+		// Create another shared informer factory for Kubernetes secrets and configmaps (not subject to any selectors).
+		scmInformerFactory := kubeinformers.NewSharedInformerFactoryWithOptions(k8sClient, kubeSharedInformerFactoryResync)
+		// Create a secret informer and a config map informer so we can pass their listers to the resource manager.
+		secretInformer = scmInformerFactory.Core().V1().Secrets()
+		configMapInformer = scmInformerFactory.Core().V1().ConfigMaps()
+
+		// Create a new instance of the resource manager that uses the listers above for pods, secrets and config maps.
+		rm, err = manager.NewResourceManager(podInformer.Lister(), secretInformer.Lister(), configMapInformer.Lister())
+		// Start the shared informer factory for secrets and configmaps.
+		go scmInformerFactory.Start(rootContext.Done())
+		if err == nil {
+			// Give access to the other resources providers may need through informers, each started the first time its resources are accessed.
+			rm.SetListers(manager.LazyListers(kubeinformers.NewSharedInformerFactory(k8sClient, kubeSharedInformerFactoryResync), rootContext.Done()))
+		}
+	case resourceCacheModeWatch, resourceCacheModeGet:
+		// Create a new instance of the resource manager that uses the lister above for pods, and fetches only the secrets, config maps and other resources it is asked for.
+		rm, err = manager.NewOnDemandResourceManager(podInformer.Lister(), manager.OnDemandConfig{
+			Client: k8sClient,
+			Watch:  resourceCacheMode == resourceCacheModeWatch,
+			TTL:    resourceCacheTTL,
+		})
+	default:
+		logger.WithField("mode", resourceCacheMode).Fatal("Invalid resource cache mode")
+	}
 	if err != nil {
 		logger.WithError(err).Fatal("Error initializing resource manager")
 	}
+	// Allow the resource manager to request bound service account tokens for projected volumes.
+	rm.SetTokenRequester(func(namespace, serviceAccountName string, tr *authenticationv1.TokenRequest) (*authenticationv1.TokenRequest, error) {
+		return k8sClient.CoreV1().ServiceAccounts(namespace).CreateToken(serviceAccountName, tr)
+	})
 
 	// Start the shared informer factory for pods.
 	go podInformerFactory.Start(rootContext.Done())
-	// Start the shared informer factory for secrets and configmaps.
-	go scmInformerFactory.Start(rootContext.Done())
 
 	daemonPortEnv := getEnv("KUBELET_PORT", defaultDaemonPort)
 	daemonPort, err := strconv.ParseInt(daemonPortEnv, 10, 32)
@@ -306,6 +356,7 @@ func initConfig() {
 		NodeName:        nodeName,
 		OperatingSystem: operatingSystem,
 		ResourceManager: rm,
+		Resources:       rm,
 		DaemonPort:      int32(daemonPort),
 		InternalIP:      os.Getenv("VKUBELET_POD_IP"),
 	}
@@ -314,6 +365,9 @@ func initConfig() {
 	if err != nil {
 		logger.WithError(err).Fatal("Error initializing provider")
 	}
+	if providerRateLimits.Enabled() {
+		p = ratelimit.New(p, providerRateLimits)
+	}
 
 	apiConfig, err = getAPIConfig(metricsAddr)
 	if err != nil {
@@ -0,0 +1,244 @@
+package manager
+
+import (
+	"fmt"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+)
+
+// ResourceAccessor provides read access to the Kubernetes resources that providers may need in order to run pods.
+// It is handed to providers via register.InitConfig, and is implemented by ResourceManager.
+// Tests can use the implementation returned by "test/util".FakeResourceManager, which is backed by the specified objects.
+type ResourceAccessor interface {
+	// GetPods returns the pods assigned to the current node.
+	GetPods() []*v1.Pod
+	// GetSecret returns the specified secret.
+	GetSecret(name, namespace string) (*v1.Secret, error)
+	// GetConfigMap returns the specified config map.
+	GetConfigMap(name, namespace string) (*v1.ConfigMap, error)
+	// GetPersistentVolumeClaim returns the specified persistent volume claim.
+	GetPersistentVolumeClaim(name, namespace string) (*v1.PersistentVolumeClaim, error)
+	// GetPersistentVolume returns the specified persistent volume.
+	GetPersistentVolume(name string) (*v1.PersistentVolume, error)
+	// GetBoundPersistentVolume returns the persistent volume bound to the specified persistent volume claim.
+	// It returns an error if the claim isn't bound yet.
+	GetBoundPersistentVolume(claimName, namespace string) (*v1.PersistentVolume, error)
+	// GetServiceAccount returns the specified service account.
+	GetServiceAccount(name, namespace string) (*v1.ServiceAccount, error)
+	// ListServices returns the services in the specified namespace.
+	ListServices(namespace string) ([]*v1.Service, error)
+	// GetNode returns the specified node.
+	GetNode(name string) (*v1.Node, error)
+}
+
+var _ ResourceAccessor = &ResourceManager{}
+
+// Listers holds the listers used by a resource manager to access resources other than pods, secrets and config maps.
+// The methods accessing resources whose lister is nil return an error, unless the resource manager fetches these resources on demand.
+type Listers struct {
+	PersistentVolumeClaims corev1listers.PersistentVolumeClaimLister
+	PersistentVolumes      corev1listers.PersistentVolumeLister
+	ServiceAccounts        corev1listers.ServiceAccountLister
+	Services               corev1listers.ServiceLister
+	Nodes                  corev1listers.NodeLister
+}
+
+// SetListers sets the listers used to access resources other than pods, secrets and config maps.
+func (rm *ResourceManager) SetListers(listers Listers) {
+	rm.Lock()
+	defer rm.Unlock()
+	rm.listers = listers
+}
+
+// getListers returns the listers used to access resources other than pods, secrets and config maps.
+func (rm *ResourceManager) getListers() Listers {
+	rm.RLock()
+	defer rm.RUnlock()
+	return rm.listers
+}
+
+// onDemandCaches hold the resources other than pods, secrets and config maps when they are fetched on demand (see NewOnDemandResourceManager).
+type onDemandCaches struct {
+	persistentVolumeClaims *objectCache
+	persistentVolumes      *objectCache
+	serviceAccounts        *objectCache
+	nodes                  *objectCache
+	// services holds the lists of services in each namespace, by namespace (with an empty name).
+	// It is nil unless watching, as listing services requires the "list" permission, which is otherwise not assumed to be granted.
+	services *objectCache
+}
+
+// newOnDemandCaches returns caches fetching the resources other than pods, secrets and config maps on demand using the specified configuration.
+// These resources are only accessed when pods are created, so they are cached for the configured TTL rather than watched.
+func newOnDemandCaches(cfg OnDemandConfig) *onDemandCaches {
+	c := &onDemandCaches{
+		persistentVolumeClaims: newObjectCache(func(namespace, name string) (runtime.Object, error) {
+			pvc, err := cfg.Client.CoreV1().PersistentVolumeClaims(namespace).Get(name, metav1.GetOptions{})
+			if err != nil {
+				return nil, err
+			}
+			return pvc, nil
+		}, nil, cfg.TTL),
+		persistentVolumes: newObjectCache(func(namespace, name string) (runtime.Object, error) {
+			pv, err := cfg.Client.CoreV1().PersistentVolumes().Get(name, metav1.GetOptions{})
+			if err != nil {
+				return nil, err
+			}
+			return pv, nil
+		}, nil, cfg.TTL),
+		serviceAccounts: newObjectCache(func(namespace, name string) (runtime.Object, error) {
+			sa, err := cfg.Client.CoreV1().ServiceAccounts(namespace).Get(name, metav1.GetOptions{})
+			if err != nil {
+				return nil, err
+			}
+			return sa, nil
+		}, nil, cfg.TTL),
+		nodes: newObjectCache(func(namespace, name string) (runtime.Object, error) {
+			node, err := cfg.Client.CoreV1().Nodes().Get(name, metav1.GetOptions{})
+			if err != nil {
+				return nil, err
+			}
+			return node, nil
+		}, nil, cfg.TTL),
+	}
+	if cfg.Watch {
+		c.services = newObjectCache(func(namespace, name string) (runtime.Object, error) {
+			services, err := cfg.Client.CoreV1().Services(namespace).List(metav1.ListOptions{})
+			if err != nil {
+				return nil, err
+			}
+			return services, nil
+		}, nil, cfg.TTL)
+	}
+	return c
+}
+
+// noListerError is the error returned when accessing resources for which no lister is set.
+type noListerError struct {
+	resource string
+}
+
+func (e noListerError) Error() string {
+	return fmt.Sprintf("the resource manager can't access %s as it has no lister for them", e.resource)
+}
+
+// errNoLister returns the error returned when accessing resources for which no lister is set.
+func errNoLister(resource string) error {
+	return noListerError{resource: resource}
+}
+
+// IsNoLister returns true if the specified error was returned because the resource manager has no access to the requested resources.
+func IsNoLister(err error) bool {
+	_, ok := err.(noListerError)
+	return ok
+}
+
+// GetPersistentVolumeClaim retrieves the specified persistent volume claim from the cache.
+func (rm *ResourceManager) GetPersistentVolumeClaim(name, namespace string) (*v1.PersistentVolumeClaim, error) {
+	if rm.caches != nil {
+		obj, err := rm.caches.persistentVolumeClaims.Get(namespace, name)
+		if err != nil {
+			return nil, err
+		}
+		return obj.(*v1.PersistentVolumeClaim), nil
+	}
+	l := rm.getListers().PersistentVolumeClaims
+	if l == nil {
+		return nil, errNoLister("persistent volume claims")
+	}
+	return l.PersistentVolumeClaims(namespace).Get(name)
+}
+
+// GetPersistentVolume retrieves the specified persistent volume from the cache.
+func (rm *ResourceManager) GetPersistentVolume(name string) (*v1.PersistentVolume, error) {
+	if rm.caches != nil {
+		obj, err := rm.caches.persistentVolumes.Get("", name)
+		if err != nil {
+			return nil, err
+		}
+		return obj.(*v1.PersistentVolume), nil
+	}
+	l := rm.getListers().PersistentVolumes
+	if l == nil {
+		return nil, errNoLister("persistent volumes")
+	}
+	return l.Get(name)
+}
+
+// GetBoundPersistentVolume retrieves the persistent volume bound to the specified persistent volume claim from the cache.
+func (rm *ResourceManager) GetBoundPersistentVolume(claimName, namespace string) (*v1.PersistentVolume, error) {
+	pvc, err := rm.GetPersistentVolumeClaim(claimName, namespace)
+	if err != nil {
+		return nil, err
+	}
+	if pvc.Status.Phase != v1.ClaimBound || pvc.Spec.VolumeName == "" {
+		return nil, fmt.Errorf("persistent volume claim %s/%s is not bound", namespace, claimName)
+	}
+	pv, err := rm.GetPersistentVolume(pvc.Spec.VolumeName)
+	if err != nil {
+		return nil, err
+	}
+	// Make sure that the volume is bound to this very claim, and not to a previous claim having the same name.
+	if ref := pv.Spec.ClaimRef; ref == nil || ref.Namespace != namespace || ref.Name != claimName || (ref.UID != "" && ref.UID != pvc.UID) {
+		return nil, errors.NewNotFound(v1.Resource("persistentvolumes"), pvc.Spec.VolumeName)
+	}
+	return pv, nil
+}
+
+// GetServiceAccount retrieves the specified service account from the cache.
+func (rm *ResourceManager) GetServiceAccount(name, namespace string) (*v1.ServiceAccount, error) {
+	if rm.caches != nil {
+		obj, err := rm.caches.serviceAccounts.Get(namespace, name)
+		if err != nil {
+			return nil, err
+		}
+		return obj.(*v1.ServiceAccount), nil
+	}
+	l := rm.getListers().ServiceAccounts
+	if l == nil {
+		return nil, errNoLister("service accounts")
+	}
+	return l.ServiceAccounts(namespace).Get(name)
+}
+
+// ListServices retrieves the services in the specified namespace from the cache.
+func (rm *ResourceManager) ListServices(namespace string) ([]*v1.Service, error) {
+	if rm.caches != nil && rm.caches.services != nil {
+		obj, err := rm.caches.services.Get(namespace, "")
+		if err != nil {
+			return nil, err
+		}
+		list := obj.(*v1.ServiceList)
+		services := make([]*v1.Service, 0, len(list.Items))
+		for i := range list.Items {
+			services = append(services, &list.Items[i])
+		}
+		return services, nil
+	}
+	l := rm.getListers().Services
+	if l == nil {
+		return nil, errNoLister("services")
+	}
+	return l.Services(namespace).List(labels.Everything())
+}
+
+// GetNode retrieves the specified node from the cache.
+func (rm *ResourceManager) GetNode(name string) (*v1.Node, error) {
+	if rm.caches != nil {
+		obj, err := rm.caches.nodes.Get("", name)
+		if err != nil {
+			return nil, err
+		}
+		return obj.(*v1.Node), nil
+	}
+	l := rm.getListers().Nodes
+	if l == nil {
+		return nil, errNoLister("nodes")
+	}
+	return l.Get(name)
+}
@@ -0,0 +1,142 @@
+package manager_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/virtual-kubelet/virtual-kubelet/manager"
+	testutil "github.com/virtual-kubelet/virtual-kubelet/test/util"
+)
+
+// TestResourceAccessor verifies that the resource manager gives access to persistent volumes (through their claims), service accounts, services and nodes.
+func TestResourceAccessor(t *testing.T) {
+	pvc := &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "namespace-0", Name: "claim-0", UID: "uid-0"},
+		Spec:       v1.PersistentVolumeClaimSpec{VolumeName: "volume-0"},
+		Status:     v1.PersistentVolumeClaimStatus{Phase: v1.ClaimBound},
+	}
+	pv := &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "volume-0"},
+		Spec: v1.PersistentVolumeSpec{
+			ClaimRef: &v1.ObjectReference{Namespace: "namespace-0", Name: "claim-0", UID: "uid-0"},
+		},
+	}
+	pending := &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "namespace-0", Name: "claim-1"},
+		Status:     v1.PersistentVolumeClaimStatus{Phase: v1.ClaimPending},
+	}
+	var rm manager.ResourceAccessor = testutil.FakeResourceManager(
+		pvc,
+		pv,
+		pending,
+		&v1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Namespace: "namespace-0", Name: "sa-0"}},
+		&v1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "namespace-0", Name: "service-0"}},
+		&v1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "namespace-1", Name: "service-1"}},
+		&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-0"}},
+	)
+
+	res, err := rm.GetBoundPersistentVolume("claim-0", "namespace-0")
+	require.NoError(t, err)
+	assert.Equal(t, "volume-0", res.Name)
+	_, err = rm.GetBoundPersistentVolume("claim-1", "namespace-0")
+	assert.Error(t, err)
+	_, err = rm.GetBoundPersistentVolume("missing", "namespace-0")
+	assert.True(t, errors.IsNotFound(err))
+
+	sa, err := rm.GetServiceAccount("sa-0", "namespace-0")
+	require.NoError(t, err)
+	assert.Equal(t, "sa-0", sa.Name)
+
+	services, err := rm.ListServices("namespace-0")
+	require.NoError(t, err)
+	require.Len(t, services, 1)
+	assert.Equal(t, "service-0", services[0].Name)
+
+	node, err := rm.GetNode("node-0")
+	require.NoError(t, err)
+	assert.Equal(t, "node-0", node.Name)
+}
+
+// TestResourceAccessorWithoutListers verifies that accessing resources for which the resource manager has no lister fails rather than panics.
+func TestResourceAccessorWithoutListers(t *testing.T) {
+	rm, err := manager.NewResourceManager(nil, nil, nil)
+	require.NoError(t, err)
+	_, err = rm.GetBoundPersistentVolume("claim-0", "namespace-0")
+	assert.Error(t, err)
+	_, err = rm.ListServices("namespace-0")
+	assert.Error(t, err)
+	assert.True(t, manager.IsNoLister(err))
+	_, err = rm.GetNode("node-0")
+	assert.Error(t, err)
+}
+
+// TestOnDemandResourceAccessor verifies that the on-demand resource manager fetches the resources it is asked for, and only lists services when watching.
+func TestOnDemandResourceAccessor(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&v1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "namespace-0", Name: "claim-0"},
+			Spec:       v1.PersistentVolumeClaimSpec{VolumeName: "volume-0"},
+			Status:     v1.PersistentVolumeClaimStatus{Phase: v1.ClaimBound},
+		},
+		&v1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: "volume-0"},
+			Spec: v1.PersistentVolumeSpec{
+				ClaimRef: &v1.ObjectReference{Namespace: "namespace-0", Name: "claim-0"},
+			},
+		},
+		&v1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Namespace: "namespace-0", Name: "sa-0"}},
+		&v1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "namespace-0", Name: "service-0"}},
+		&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-0"}},
+	)
+
+	rm, err := manager.NewOnDemandResourceManager(nil, manager.OnDemandConfig{Client: client, TTL: time.Minute})
+	require.NoError(t, err)
+	pv, err := rm.GetBoundPersistentVolume("claim-0", "namespace-0")
+	require.NoError(t, err)
+	assert.Equal(t, "volume-0", pv.Name)
+	sa, err := rm.GetServiceAccount("sa-0", "namespace-0")
+	require.NoError(t, err)
+	assert.Equal(t, "sa-0", sa.Name)
+	node, err := rm.GetNode("node-0")
+	require.NoError(t, err)
+	assert.Equal(t, "node-0", node.Name)
+	_, err = rm.ListServices("namespace-0")
+	assert.Error(t, err)
+	for _, action := range client.Actions() {
+		assert.Equal(t, "get", action.GetVerb())
+	}
+
+	rm, err = manager.NewOnDemandResourceManager(nil, manager.OnDemandConfig{Client: client, Watch: true, TTL: time.Minute})
+	require.NoError(t, err)
+	services, err := rm.ListServices("namespace-0")
+	require.NoError(t, err)
+	require.Len(t, services, 1)
+	assert.Equal(t, "service-0", services[0].Name)
+}
+
+// TestLazyListers verifies that the informers behind lazy listers only list their resources once these are accessed.
+func TestLazyListers(t *testing.T) {
+	client := fake.NewSimpleClientset(&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-0"}})
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	rm, err := manager.NewResourceManager(nil, nil, nil)
+	require.NoError(t, err)
+	rm.SetListers(manager.LazyListers(informers.NewSharedInformerFactory(client, 0), stopCh))
+	assert.Empty(t, client.Actions())
+
+	node, err := rm.GetNode("node-0")
+	require.NoError(t, err)
+	assert.Equal(t, "node-0", node.Name)
+	for _, action := range client.Actions() {
+		assert.Equal(t, "nodes", action.GetResource().Resource)
+	}
+}
@@ -1,7 +1,9 @@
 // Package manager provides access to kubernetes resources for providers.
 //
+// Providers should access resources through the ResourceAccessor interface, which is handed to them via register.InitConfig.
+// ResourceManager is the implementation of this interface backed by informers.
+//
 // DEPRECATION WARNING:
-// Though this package is still in use, it should be considered deprecated as it
-// is just wrapping a k8s client and not much else.
-// Implementers should look at replacing their use of this with something else.
+// Though the ResourceManager type is still in use, depending on it directly should be considered deprecated.
+// Implementers should use the ResourceAccessor interface instead.
 package manager
@@ -0,0 +1,130 @@
+package manager
+
+import (
+	"sync"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+)
+
+// LazyListers returns listers backed by informers created from the specified factory.
+// Each informer is only started, and its cache synced, the first time its lister is used, so that only the resources that providers and features actually access are listed and watched.
+// The informers run until the specified channel is closed. The factory must not be started, as this would start all of them.
+func LazyListers(factory informers.SharedInformerFactory, stopCh <-chan struct{}) Listers {
+	pvcs := factory.Core().V1().PersistentVolumeClaims()
+	pvs := factory.Core().V1().PersistentVolumes()
+	serviceAccounts := factory.Core().V1().ServiceAccounts()
+	services := factory.Core().V1().Services()
+	nodes := factory.Core().V1().Nodes()
+	return Listers{
+		PersistentVolumeClaims: lazyPersistentVolumeClaimLister{pvcs.Lister(), lazyStart("persistent volume claims", pvcs.Informer(), stopCh)},
+		PersistentVolumes:      lazyPersistentVolumeLister{pvs.Lister(), lazyStart("persistent volumes", pvs.Informer(), stopCh)},
+		ServiceAccounts:        lazyServiceAccountLister{serviceAccounts.Lister(), lazyStart("service accounts", serviceAccounts.Informer(), stopCh)},
+		Services:               lazyServiceLister{services.Lister(), lazyStart("services", services.Informer(), stopCh)},
+		Nodes:                  lazyNodeLister{nodes.Lister(), lazyStart("nodes", nodes.Informer(), stopCh)},
+	}
+}
+
+// lazyStart returns a function that starts the specified informer the first time it is called, and waits for its cache to be synced.
+func lazyStart(resource string, informer cache.SharedIndexInformer, stopCh <-chan struct{}) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			log.L.Infof("Starting the informer for %s", resource)
+			go informer.Run(stopCh)
+		})
+		if !informer.HasSynced() && !cache.WaitForCacheSync(stopCh, informer.HasSynced) {
+			log.L.Errorf("Failed to wait for the informer for %s to be synced", resource)
+		}
+	}
+}
+
+type lazyPersistentVolumeClaimLister struct {
+	corev1listers.PersistentVolumeClaimLister
+	start func()
+}
+
+func (l lazyPersistentVolumeClaimLister) List(selector labels.Selector) ([]*v1.PersistentVolumeClaim, error) {
+	l.start()
+	return l.PersistentVolumeClaimLister.List(selector)
+}
+
+func (l lazyPersistentVolumeClaimLister) PersistentVolumeClaims(namespace string) corev1listers.PersistentVolumeClaimNamespaceLister {
+	l.start()
+	return l.PersistentVolumeClaimLister.PersistentVolumeClaims(namespace)
+}
+
+type lazyPersistentVolumeLister struct {
+	corev1listers.PersistentVolumeLister
+	start func()
+}
+
+func (l lazyPersistentVolumeLister) List(selector labels.Selector) ([]*v1.PersistentVolume, error) {
+	l.start()
+	return l.PersistentVolumeLister.List(selector)
+}
+
+func (l lazyPersistentVolumeLister) Get(name string) (*v1.PersistentVolume, error) {
+	l.start()
+	return l.PersistentVolumeLister.Get(name)
+}
+
+type lazyServiceAccountLister struct {
+	corev1listers.ServiceAccountLister
+	start func()
+}
+
+func (l lazyServiceAccountLister) List(selector labels.Selector) ([]*v1.ServiceAccount, error) {
+	l.start()
+	return l.ServiceAccountLister.List(selector)
+}
+
+func (l lazyServiceAccountLister) ServiceAccounts(namespace string) corev1listers.ServiceAccountNamespaceLister {
+	l.start()
+	return l.ServiceAccountLister.ServiceAccounts(namespace)
+}
+
+type lazyServiceLister struct {
+	corev1listers.ServiceLister
+	start func()
+}
+
+func (l lazyServiceLister) List(selector labels.Selector) ([]*v1.Service, error) {
+	l.start()
+	return l.ServiceLister.List(selector)
+}
+
+func (l lazyServiceLister) Services(namespace string) corev1listers.ServiceNamespaceLister {
+	l.start()
+	return l.ServiceLister.Services(namespace)
+}
+
+func (l lazyServiceLister) GetPodServices(pod *v1.Pod) ([]*v1.Service, error) {
+	l.start()
+	return l.ServiceLister.GetPodServices(pod)
+}
+
+type lazyNodeLister struct {
+	corev1listers.NodeLister
+	start func()
+}
+
+func (l lazyNodeLister) List(selector labels.Selector) ([]*v1.Node, error) {
+	l.start()
+	return l.NodeLister.List(selector)
+}
+
+func (l lazyNodeLister) Get(name string) (*v1.Node, error) {
+	l.start()
+	return l.NodeLister.Get(name)
+}
+
+func (l lazyNodeLister) ListWithPredicate(predicate corev1listers.NodeConditionPredicate) ([]*v1.Node, error) {
+	l.start()
+	return l.NodeLister.ListWithPredicate(predicate)
+}
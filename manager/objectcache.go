@@ -0,0 +1,227 @@
+package manager
+
+import (
+	"reflect"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+)
+
+// watchRetryPeriod is the delay after which objects referenced by pods are watched again when their watch is closed.
+const watchRetryPeriod = time.Second
+
+// objectCache fetches objects of a given kind on demand, and caches them either for a fixed duration or, when watching, for as long as they are referenced by pods or keep being accessed.
+// Unlike informers, it only ever holds the objects that have actually been requested (i.e. those referenced by pods assigned to the current node).
+type objectCache struct {
+	// get fetches the specified object from the API server.
+	get func(namespace, name string) (runtime.Object, error)
+	// watch watches the specified object, starting at the specified resource version.
+	// It is nil if objects must not be watched, in which case they are fetched again once their cache entry expires.
+	watch func(namespace, name, resourceVersion string) (watch.Interface, error)
+	// ttl is the duration for which objects are cached when not watching, and the duration after which objects that haven't been accessed stop being watched otherwise.
+	ttl time.Duration
+	// now returns the current time, and can be overridden in tests.
+	now func() time.Time
+
+	mu        sync.Mutex
+	entries   map[string]*objectCacheEntry
+	lastSweep time.Time
+	// refs counts the pods referencing each object, by key. Referenced objects keep being watched even when they aren't accessed.
+	refs map[string]int
+	// handlers are called whenever a cached object is observed to have changed.
+	handlers []func(oldObj, newObj runtime.Object)
+}
+
+// objectCacheEntry is the cached result of fetching an object.
+type objectCacheEntry struct {
+	obj runtime.Object
+	// err is the error that occurred when fetching the object, if any.
+	// Only "not found" errors are cached, so that optional objects that don't exist don't cause a request to the API server every time they are accessed.
+	// Other errors (e.g. timeouts or throttling) are returned without being cached, so that the next access retries.
+	err     error
+	expires time.Time
+	// watcher is the watch keeping obj up-to-date, if any.
+	watcher watch.Interface
+}
+
+// newObjectCache returns an object cache using the specified functions to fetch and (if not nil) watch objects.
+func newObjectCache(get func(namespace, name string) (runtime.Object, error), watch func(namespace, name, resourceVersion string) (watch.Interface, error), ttl time.Duration) *objectCache {
+	return &objectCache{
+		get:     get,
+		watch:   watch,
+		ttl:     ttl,
+		now:     time.Now,
+		entries: make(map[string]*objectCacheEntry),
+		refs:    make(map[string]int),
+	}
+}
+
+// ref records that the specified object is referenced by one more pod.
+// When watching, the object is fetched in the background if needed, so that it is watched from then on.
+func (c *objectCache) ref(namespace, name string) {
+	c.mu.Lock()
+	c.refs[namespace+"/"+name]++
+	c.mu.Unlock()
+	if c.watch != nil {
+		go c.Get(namespace, name)
+	}
+}
+
+// unref records that the specified object is referenced by one pod fewer.
+// Objects no longer referenced by any pod stop being watched once they haven't been accessed for ttl.
+func (c *objectCache) unref(namespace, name string) {
+	key := namespace + "/" + name
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.refs[key] <= 1 {
+		delete(c.refs, key)
+	} else {
+		c.refs[key]--
+	}
+}
+
+// addUpdateHandler registers a function to be called whenever a cached object is observed to have changed.
+func (c *objectCache) addUpdateHandler(handler func(oldObj, newObj runtime.Object)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.handlers = append(c.handlers, handler)
+}
+
+// Get returns the specified object, fetching it from the API server if it isn't cached.
+func (c *objectCache) Get(namespace, name string) (runtime.Object, error) {
+	key := namespace + "/" + name
+	now := c.now()
+
+	c.mu.Lock()
+	c.sweep(now)
+	if e, ok := c.entries[key]; ok && (e.watcher != nil || now.Before(e.expires)) {
+		if e.watcher != nil {
+			// Keep on watching the object for as long as it is being accessed.
+			e.expires = now.Add(c.ttl)
+		}
+		obj, err := e.obj, e.err
+		c.mu.Unlock()
+		return obj, err
+	}
+	c.mu.Unlock()
+
+	obj, err := c.get(namespace, name)
+	if err != nil && !errors.IsNotFound(err) {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	old, hadOld := c.entries[key]
+	e := &objectCacheEntry{obj: obj, err: err, expires: now.Add(c.ttl)}
+	if hadOld && old.watcher != nil {
+		// The object was fetched concurrently, and is already being watched.
+		c.mu.Unlock()
+		return obj, err
+	}
+	if err == nil && c.watch != nil {
+		if accessor, aErr := meta.Accessor(obj); aErr == nil {
+			if w, wErr := c.watch(namespace, name, accessor.GetResourceVersion()); wErr == nil {
+				e.watcher = w
+				go c.consume(namespace, name, key, e)
+			} else {
+				log.L.WithError(wErr).Warnf("failed to watch %s, caching it for %s", key, c.ttl)
+			}
+		}
+	}
+	c.entries[key] = e
+	handlers := c.handlers
+	c.mu.Unlock()
+
+	if hadOld && old.obj != nil && obj != nil && !reflect.DeepEqual(old.obj, obj) {
+		for _, h := range handlers {
+			h(old.obj, obj)
+		}
+	}
+	return obj, err
+}
+
+// consume updates the specified cache entry with the events received from its watch, until the latter is stopped or closed.
+// If the object is still referenced by pods by then, it is fetched and watched again.
+func (c *objectCache) consume(namespace, name, key string, e *objectCacheEntry) {
+	w := e.watcher
+	for ev := range w.ResultChan() {
+		accessor, err := meta.Accessor(ev.Object)
+		if err != nil || accessor.GetName() != name {
+			continue
+		}
+		c.mu.Lock()
+		old := e.obj
+		switch ev.Type {
+		case watch.Added, watch.Modified:
+			e.obj, e.err = ev.Object, nil
+		case watch.Deleted:
+			// Have the next access fetch the object again, so that it reports that the object doesn't exist.
+			e.watcher = nil
+			e.expires = c.now()
+			w.Stop()
+		}
+		handlers := c.handlers
+		c.mu.Unlock()
+
+		if ev.Type == watch.Modified && old != nil && !reflect.DeepEqual(old, ev.Object) {
+			for _, h := range handlers {
+				h(old, ev.Object)
+			}
+		}
+	}
+	// The watch was stopped or closed by the API server, so the next access must fetch the object again.
+	c.mu.Lock()
+	rewatch := false
+	if c.entries[key] == e {
+		e.watcher = nil
+		e.expires = c.now()
+		rewatch = c.refs[key] > 0
+	}
+	c.mu.Unlock()
+	if rewatch {
+		c.rewatch(namespace, name)
+	}
+}
+
+// rewatch fetches the specified object again after watchRetryPeriod, which reports the changes missed in the meantime and watches it anew.
+// It keeps on trying for as long as fetching the object fails and pods reference it.
+func (c *objectCache) rewatch(namespace, name string) {
+	time.AfterFunc(watchRetryPeriod, func() {
+		if _, err := c.Get(namespace, name); err == nil || errors.IsNotFound(err) {
+			return
+		}
+		c.mu.Lock()
+		referenced := c.refs[namespace+"/"+name] > 0
+		c.mu.Unlock()
+		if referenced {
+			c.rewatch(namespace, name)
+		}
+	})
+}
+
+// sweep stops watching and forgets objects that haven't been accessed for a while, unless they are referenced by pods.
+// Stopping a watch closes its result channel, which makes the corresponding consume goroutine return.
+// It must be called with the lock held, and does nothing if it has been called less than ttl ago.
+func (c *objectCache) sweep(now time.Time) {
+	if now.Sub(c.lastSweep) < c.ttl {
+		return
+	}
+	c.lastSweep = now
+	for key, e := range c.entries {
+		switch {
+		case c.refs[key] > 0:
+		case e.watcher != nil && !now.Before(e.expires):
+			e.watcher.Stop()
+			delete(c.entries, key)
+		case e.watcher == nil && now.After(e.expires.Add(c.ttl)):
+			// Expired entries are kept for a while, so that changes to the objects can be detected when they are fetched again.
+			delete(c.entries, key)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package manager
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+)
+
+// countActions returns the number of actions with the specified verb performed by the specified fake clientset.
+func countActions(client *fake.Clientset, verb string) int {
+	n := 0
+	for _, action := range client.Actions() {
+		if action.GetVerb() == verb {
+			n++
+		}
+	}
+	return n
+}
+
+func fakeSecret(data string) *v1.Secret {
+	return &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "namespace-0", Name: "secret-0"},
+		Data:       map[string][]byte{"key-0": []byte(data)},
+	}
+}
+
+// TestOnDemandGetOnly verifies that, when not watching, secrets are fetched on demand and cached for the configured duration only, and that changes are detected when they are fetched again.
+func TestOnDemandGetOnly(t *testing.T) {
+	client := fake.NewSimpleClientset(fakeSecret("val-0"))
+	rm, err := NewOnDemandResourceManager(nil, OnDemandConfig{Client: client, TTL: time.Minute})
+	require.NoError(t, err)
+	now := time.Now()
+	rm.secretCache.now = func() time.Time { return now }
+	var changes []string
+	rm.secretCache.addUpdateHandler(func(oldObj, newObj runtime.Object) {
+		changes = append(changes, string(newObj.(*v1.Secret).Data["key-0"]))
+	})
+
+	secret, err := rm.GetSecret("secret-0", "namespace-0")
+	require.NoError(t, err)
+	assert.Equal(t, "val-0", string(secret.Data["key-0"]))
+	_, err = rm.GetSecret("secret-0", "namespace-0")
+	require.NoError(t, err)
+	assert.Equal(t, 1, countActions(client, "get"))
+	assert.Zero(t, countActions(client, "list")+countActions(client, "watch"))
+
+	// Missing secrets are reported as such, and cached as well.
+	_, err = rm.GetSecret("missing", "namespace-0")
+	assert.True(t, errors.IsNotFound(err))
+	_, err = rm.GetSecret("missing", "namespace-0")
+	assert.True(t, errors.IsNotFound(err))
+	assert.Equal(t, 2, countActions(client, "get"))
+
+	// Other errors aren't cached, so that the next access retries.
+	client.PrependReactor("get", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.(k8stesting.GetAction).GetName() != "unavailable" {
+			return false, nil, nil
+		}
+		return true, nil, errors.NewServiceUnavailable("unavailable")
+	})
+	_, err = rm.GetSecret("unavailable", "namespace-0")
+	assert.True(t, errors.IsServiceUnavailable(err))
+	_, err = rm.GetSecret("unavailable", "namespace-0")
+	assert.True(t, errors.IsServiceUnavailable(err))
+	assert.Equal(t, 4, countActions(client, "get"))
+
+	// Once the cache entry expires, the secret is fetched again and the change is reported.
+	_, err = client.CoreV1().Secrets("namespace-0").Update(fakeSecret("val-1"))
+	require.NoError(t, err)
+	now = now.Add(time.Minute + time.Second)
+	secret, err = rm.GetSecret("secret-0", "namespace-0")
+	require.NoError(t, err)
+	assert.Equal(t, "val-1", string(secret.Data["key-0"]))
+	assert.Equal(t, []string{"val-1"}, changes)
+}
+
+// TestOnDemandWatch verifies that, when watching, secrets are kept up-to-date without being fetched again, and stop being watched once they haven't been accessed for the configured duration.
+func TestOnDemandWatch(t *testing.T) {
+	client := fake.NewSimpleClientset(fakeSecret("val-0"))
+	watcher := make(chan *k8stesting.WatchActionImpl, 1)
+	client.PrependWatchReactor("secrets", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		a := action.(k8stesting.WatchActionImpl)
+		watcher <- &a
+		return false, nil, nil
+	})
+	rm, err := NewOnDemandResourceManager(nil, OnDemandConfig{Client: client, Watch: true, TTL: time.Minute})
+	require.NoError(t, err)
+	now := time.Now()
+	rm.secretCache.now = func() time.Time { return now }
+	changes := make(chan string, 1)
+	rm.secretCache.addUpdateHandler(func(oldObj, newObj runtime.Object) {
+		changes <- string(newObj.(*v1.Secret).Data["key-0"])
+	})
+
+	_, err = rm.GetSecret("secret-0", "namespace-0")
+	require.NoError(t, err)
+	a := <-watcher
+	assert.Equal(t, "metadata.name=secret-0", a.GetWatchRestrictions().Fields.String())
+
+	_, err = client.CoreV1().Secrets("namespace-0").Update(fakeSecret("val-1"))
+	require.NoError(t, err)
+	select {
+	case v := <-changes:
+		assert.Equal(t, "val-1", v)
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the change to be observed")
+	}
+	// The secret is still cached long after it was first fetched, as it keeps being accessed.
+	for i := 0; i < 3; i++ {
+		now = now.Add(50 * time.Second)
+		secret, err := rm.GetSecret("secret-0", "namespace-0")
+		require.NoError(t, err)
+		assert.Equal(t, "val-1", string(secret.Data["key-0"]))
+	}
+	assert.Equal(t, 1, countActions(client, "get"))
+
+	// Once it hasn't been accessed for a while, the secret stops being watched and is forgotten.
+	now = now.Add(2 * time.Minute)
+	rm.secretCache.mu.Lock()
+	rm.secretCache.sweep(now)
+	assert.Empty(t, rm.secretCache.entries)
+	rm.secretCache.mu.Unlock()
+}
+
+// handlerInformer is a shared informer whose registered event handler is called explicitly.
+type handlerInformer struct {
+	cache.SharedInformer
+	handler cache.ResourceEventHandler
+}
+
+func (i *handlerInformer) AddEventHandler(handler cache.ResourceEventHandler) {
+	i.handler = handler
+}
+
+// TestOnDemandWatchReferenced verifies that secrets referenced by pods keep being watched for as long as the pods exist, even if they aren't accessed, and are watched again if their watch is closed.
+func TestOnDemandWatchReferenced(t *testing.T) {
+	client := fake.NewSimpleClientset(fakeSecret("val-0"))
+	watcher := make(chan *k8stesting.WatchActionImpl, 1)
+	client.PrependWatchReactor("secrets", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		a := action.(k8stesting.WatchActionImpl)
+		watcher <- &a
+		return false, nil, nil
+	})
+	rm, err := NewOnDemandResourceManager(nil, OnDemandConfig{Client: client, Watch: true, TTL: time.Minute})
+	require.NoError(t, err)
+	now := time.Now()
+	rm.secretCache.now = func() time.Time { return now }
+	changes := make(chan string, 1)
+	rm.secretCache.addUpdateHandler(func(oldObj, newObj runtime.Object) {
+		changes <- string(newObj.(*v1.Secret).Data["key-0"])
+	})
+	waitForWatch := func() {
+		select {
+		case <-watcher:
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out waiting for the secret to be watched")
+		}
+	}
+	waitForChange := func(expected string) {
+		select {
+		case v := <-changes:
+			assert.Equal(t, expected, v)
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out waiting for the change to be observed")
+		}
+	}
+
+	informer := &handlerInformer{}
+	rm.TrackPodReferences(informer)
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "namespace-0", Name: "pod-0"},
+		Spec: v1.PodSpec{Volumes: []v1.Volume{{Name: "projected", VolumeSource: v1.VolumeSource{Projected: &v1.ProjectedVolumeSource{
+			Sources: []v1.VolumeProjection{{Secret: &v1.SecretProjection{LocalObjectReference: v1.LocalObjectReference{Name: "secret-0"}}}},
+		}}}}},
+	}
+	// The secret is watched as soon as a pod referencing it is added, and for as long as the pod exists.
+	informer.handler.OnAdd(pod)
+	waitForWatch()
+	now = now.Add(10 * time.Minute)
+	rm.secretCache.mu.Lock()
+	rm.secretCache.sweep(now)
+	assert.Len(t, rm.secretCache.entries, 1)
+	rm.secretCache.mu.Unlock()
+	_, err = client.CoreV1().Secrets("namespace-0").Update(fakeSecret("val-1"))
+	require.NoError(t, err)
+	waitForChange("val-1")
+
+	// The secret is fetched and watched again once its watch is closed, and the changes missed in the meantime are reported.
+	rm.secretCache.mu.Lock()
+	w := rm.secretCache.entries["namespace-0/secret-0"].watcher
+	rm.secretCache.mu.Unlock()
+	w.Stop()
+	_, err = client.CoreV1().Secrets("namespace-0").Update(fakeSecret("val-2"))
+	require.NoError(t, err)
+	waitForWatch()
+	waitForChange("val-2")
+
+	// Once the pod is deleted, the secret stops being watched as soon as it hasn't been accessed for a while.
+	informer.handler.OnDelete(cache.DeletedFinalStateUnknown{Key: "namespace-0/pod-0", Obj: pod})
+	now = now.Add(2 * time.Minute)
+	rm.secretCache.mu.Lock()
+	rm.secretCache.sweep(now)
+	assert.Empty(t, rm.secretCache.entries)
+	assert.Empty(t, rm.secretCache.refs)
+	rm.secretCache.mu.Unlock()
+}
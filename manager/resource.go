@@ -2,22 +2,43 @@ package manager
 
 import (
 	"sync"
+	"time"
 
 	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
 	corev1listers "k8s.io/client-go/listers/core/v1"
 
 	"github.com/virtual-kubelet/virtual-kubelet/log"
 )
 
 // ResourceManager acts as a passthrough to a cache (lister) for pods assigned to the current node.
-// It is also a passthrough to a cache (lister) for Kubernetes secrets and config maps.
+// It is also a passthrough to a cache for Kubernetes secrets and config maps, which is either a lister or (see NewOnDemandResourceManager) a cache of the objects fetched on demand.
 type ResourceManager struct {
 	sync.RWMutex
 
 	podLister       corev1listers.PodLister
 	secretLister    corev1listers.SecretLister
 	configMapLister corev1listers.ConfigMapLister
+
+	// secretCache and configMapCache hold the secrets and config maps fetched on demand, and are used instead of the listers when set.
+	secretCache    *objectCache
+	configMapCache *objectCache
+
+	// requestToken is used to request bound service account tokens, if set.
+	requestToken TokenRequester
+	// tokens caches the bound service account tokens until they must be refreshed, by pod, service account, audiences and expiration.
+	tokens map[string]string
+	// tokenRefreshHandlers are called with the pods whose service account tokens must be refreshed.
+	tokenRefreshHandlers []func(pod *v1.Pod)
+	// listers are used to access resources other than pods, secrets and config maps.
+	listers Listers
+	// caches hold the resources other than pods, secrets and config maps fetched on demand, and are used instead of the listers when set.
+	caches *onDemandCaches
 }
 
 // NewResourceManager returns a ResourceManager with the internal maps initialized.
@@ -30,6 +51,61 @@ func NewResourceManager(podLister corev1listers.PodLister, secretLister corev1li
 	return &rm, nil
 }
 
+// OnDemandConfig configures how a resource manager fetches secrets and config maps on demand.
+type OnDemandConfig struct {
+	// Client is used to fetch secrets and config maps.
+	Client kubernetes.Interface
+	// Watch makes each secret and config map be watched individually once fetched, so that it is kept up-to-date for as long as it is referenced by pods (see TrackPodReferences) or keeps being accessed.
+	// This requires the "list" and "watch" permissions on secrets and config maps in addition to "get".
+	// Otherwise, only the "get" permission is required, and objects are fetched again when accessed once they have been cached for TTL.
+	// As running pods don't access the objects referenced by their volumes, changes to them are then not reported (see WatchVolumeSources), and these volumes are not refreshed.
+	Watch bool
+	// TTL is the duration for which secrets and config maps are cached when not watching them, and the duration after which they stop being watched if they are neither referenced by pods nor accessed otherwise.
+	TTL time.Duration
+}
+
+// NewOnDemandResourceManager returns a ResourceManager that uses the specified lister for pods, but fetches secrets and config maps on demand and only caches those actually requested.
+// This avoids holding every secret and config map in the cluster in memory, and requires fewer permissions than using listers.
+// The other resources accessible through the resource manager are fetched on demand as well, and cached for the configured TTL without being watched.
+func NewOnDemandResourceManager(podLister corev1listers.PodLister, cfg OnDemandConfig) (*ResourceManager, error) {
+	rm := ResourceManager{
+		podLister: podLister,
+	}
+	var watchSecret, watchConfigMap func(namespace, name, resourceVersion string) (watch.Interface, error)
+	if cfg.Watch {
+		watchSecret = func(namespace, name, resourceVersion string) (watch.Interface, error) {
+			return cfg.Client.CoreV1().Secrets(namespace).Watch(singleObjectListOptions(name, resourceVersion))
+		}
+		watchConfigMap = func(namespace, name, resourceVersion string) (watch.Interface, error) {
+			return cfg.Client.CoreV1().ConfigMaps(namespace).Watch(singleObjectListOptions(name, resourceVersion))
+		}
+	}
+	rm.secretCache = newObjectCache(func(namespace, name string) (runtime.Object, error) {
+		secret, err := cfg.Client.CoreV1().Secrets(namespace).Get(name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return secret, nil
+	}, watchSecret, cfg.TTL)
+	rm.configMapCache = newObjectCache(func(namespace, name string) (runtime.Object, error) {
+		configMap, err := cfg.Client.CoreV1().ConfigMaps(namespace).Get(name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return configMap, nil
+	}, watchConfigMap, cfg.TTL)
+	rm.caches = newOnDemandCaches(cfg)
+	return &rm, nil
+}
+
+// singleObjectListOptions returns the options used to watch the object with the specified name, starting at the specified resource version.
+func singleObjectListOptions(name, resourceVersion string) metav1.ListOptions {
+	return metav1.ListOptions{
+		FieldSelector:   fields.OneTermEqualSelector("metadata.name", name).String(),
+		ResourceVersion: resourceVersion,
+	}
+}
+
 // GetPods returns a list of all known pods assigned to this virtual node.
 func (rm *ResourceManager) GetPods() []*v1.Pod {
 	l, err := rm.podLister.List(labels.Everything())
@@ -42,10 +118,24 @@ func (rm *ResourceManager) GetPods() []*v1.Pod {
 
 // GetConfigMap retrieves the specified config map from the cache.
 func (rm *ResourceManager) GetConfigMap(name, namespace string) (*v1.ConfigMap, error) {
+	if rm.configMapCache != nil {
+		obj, err := rm.configMapCache.Get(namespace, name)
+		if err != nil {
+			return nil, err
+		}
+		return obj.(*v1.ConfigMap), nil
+	}
 	return rm.configMapLister.ConfigMaps(namespace).Get(name)
 }
 
 // GetSecret retrieves the specified secret from Kubernetes.
 func (rm *ResourceManager) GetSecret(name, namespace string) (*v1.Secret, error) {
+	if rm.secretCache != nil {
+		obj, err := rm.secretCache.Get(namespace, name)
+		if err != nil {
+			return nil, err
+		}
+		return obj.(*v1.Secret), nil
+	}
 	return rm.secretLister.Secrets(namespace).Get(name)
 }
@@ -0,0 +1,68 @@
+package manager
+
+import (
+	"fmt"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// tokenRefreshRatio is the fraction of their validity after which service account tokens are requested again, as the kubelet does.
+const tokenRefreshRatio = 0.8
+
+// getServiceAccountToken returns a token for the specified service account bound to the specified pod, requesting one if none is cached.
+// Tokens are cached until tokenRefreshRatio of their validity has elapsed.
+// The pod is then reported to the functions registered with WatchVolumeSources, so that its volumes are refreshed with a new token before the current one expires.
+func (rm *ResourceManager) getServiceAccountToken(requestToken TokenRequester, pod *v1.Pod, serviceAccountName string, tr *authenticationv1.TokenRequest) (string, error) {
+	key := fmt.Sprintf("%s/%s/%s/%q/%d", pod.UID, pod.Namespace, serviceAccountName, tr.Spec.Audiences, *tr.Spec.ExpirationSeconds)
+	rm.RLock()
+	token, ok := rm.tokens[key]
+	rm.RUnlock()
+	if ok {
+		return token, nil
+	}
+
+	requested := time.Now()
+	res, err := requestToken(pod.Namespace, serviceAccountName, tr)
+	if err != nil {
+		return "", err
+	}
+	// The API server may issue tokens valid for a different duration than requested.
+	validity := time.Duration(*tr.Spec.ExpirationSeconds) * time.Second
+	if exp := res.Status.ExpirationTimestamp; !exp.IsZero() {
+		validity = exp.Sub(requested)
+	}
+
+	rm.Lock()
+	if rm.tokens == nil {
+		rm.tokens = make(map[string]string)
+	}
+	rm.tokens[key] = res.Status.Token
+	rm.Unlock()
+	namespace, name, uid := pod.Namespace, pod.Name, pod.UID
+	time.AfterFunc(time.Duration(float64(validity)*tokenRefreshRatio), func() {
+		rm.refreshServiceAccountToken(key, namespace, name, uid)
+	})
+	return res.Status.Token, nil
+}
+
+// refreshServiceAccountToken forgets the specified cached token, so that a new one is requested when it is next needed.
+// The pod the token is bound to is then reported to the functions registered with WatchVolumeSources, unless it doesn't exist anymore.
+func (rm *ResourceManager) refreshServiceAccountToken(key, namespace, name string, uid types.UID) {
+	rm.Lock()
+	delete(rm.tokens, key)
+	handlers := rm.tokenRefreshHandlers
+	rm.Unlock()
+	if rm.podLister == nil || len(handlers) == 0 {
+		return
+	}
+	pod, err := rm.podLister.Pods(namespace).Get(name)
+	if err != nil || pod.UID != uid {
+		return
+	}
+	for _, h := range handlers {
+		h(pod)
+	}
+}
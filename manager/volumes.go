@@ -0,0 +1,359 @@
+package manager
+
+import (
+	"fmt"
+	"math"
+	"path"
+	"sort"
+	"strings"
+
+	pkgerrors "github.com/pkg/errors"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	// defaultServiceAccountName is the name of the service account used by pods that don't specify one.
+	defaultServiceAccountName = "default"
+	// defaultTokenExpirationSeconds is the validity of service account tokens whose projection doesn't specify one.
+	defaultTokenExpirationSeconds = int64(3600)
+)
+
+// VolumeFile is a file that must be present in a volume.
+type VolumeFile struct {
+	// Path is the path of the file, relative to the root of the volume.
+	// It never is absolute nor contains ".." elements, but may contain "/" separators.
+	Path string
+	// Mode is the mode bits of the file.
+	Mode int32
+	// Data is the contents of the file.
+	Data []byte
+}
+
+// TokenRequester requests a token for the specified service account.
+// It is usually implemented by calling "CreateToken" on a service account client.
+type TokenRequester func(namespace, serviceAccountName string, tr *authenticationv1.TokenRequest) (*authenticationv1.TokenRequest, error)
+
+// SetTokenRequester sets the function used to request bound service account tokens for "serviceAccountToken" volume projections.
+// Resolving volumes that contain such projections fails if no token requester is set.
+func (rm *ResourceManager) SetTokenRequester(requestToken TokenRequester) {
+	rm.Lock()
+	defer rm.Unlock()
+	rm.requestToken = requestToken
+}
+
+// IsResolvableVolume returns whether the contents of the specified volume can be resolved by "ResolveVolumes", i.e. whether it is a Secret, ConfigMap, DownwardAPI or Projected volume.
+func IsResolvableVolume(volume v1.Volume) bool {
+	return volume.Secret != nil || volume.ConfigMap != nil || volume.DownwardAPI != nil || volume.Projected != nil
+}
+
+// ResolveVolumes resolves the contents of the Secret, ConfigMap, DownwardAPI and Projected volumes of the specified pod into a provider-neutral set of files, keyed by volume name.
+// Keys and items are mapped to paths, and default and per-item modes are applied, as the kubelet does.
+// Providers only need to map the returned files onto their storage primitive.
+// Volumes of other types are not present in the returned map, and volumes referencing an optional object that doesn't exist are present but empty.
+func (rm *ResourceManager) ResolveVolumes(pod *v1.Pod) (map[string][]VolumeFile, error) {
+	res := make(map[string][]VolumeFile)
+	for _, volume := range pod.Spec.Volumes {
+		if !IsResolvableVolume(volume) {
+			continue
+		}
+		files, err := rm.ResolveVolume(pod, volume)
+		if err != nil {
+			return nil, err
+		}
+		res[volume.Name] = files
+	}
+	return res, nil
+}
+
+// ResolveVolume resolves the contents of the specified Secret, ConfigMap, DownwardAPI or Projected volume of the specified pod into a set of files sorted by path.
+func (rm *ResourceManager) ResolveVolume(pod *v1.Pod, volume v1.Volume) ([]VolumeFile, error) {
+	var (
+		files []VolumeFile
+		err   error
+	)
+	switch {
+	case volume.Secret != nil:
+		src := volume.Secret
+		files, err = rm.resolveSecret(pod, src.SecretName, src.Items, src.Optional, fileMode(src.DefaultMode, v1.SecretVolumeSourceDefaultMode))
+	case volume.ConfigMap != nil:
+		src := volume.ConfigMap
+		files, err = rm.resolveConfigMap(pod, src.Name, src.Items, src.Optional, fileMode(src.DefaultMode, v1.ConfigMapVolumeSourceDefaultMode))
+	case volume.DownwardAPI != nil:
+		src := volume.DownwardAPI
+		files, err = resolveDownwardAPI(pod, src.Items, fileMode(src.DefaultMode, v1.DownwardAPIVolumeSourceDefaultMode))
+	case volume.Projected != nil:
+		files, err = rm.resolveProjected(pod, volume.Projected)
+	default:
+		return nil, fmt.Errorf("volume %q of pod %s/%s is of an unsupported type", volume.Name, pod.Namespace, pod.Name)
+	}
+	if err != nil {
+		return nil, pkgerrors.Wrapf(err, "failed to resolve volume %q of pod %s/%s", volume.Name, pod.Namespace, pod.Name)
+	}
+	return sortAndCheckFiles(files)
+}
+
+// resolveSecret returns the files projected from the keys (or the specified items) of the specified secret.
+func (rm *ResourceManager) resolveSecret(pod *v1.Pod, name string, items []v1.KeyToPath, optional *bool, defaultMode int32) ([]VolumeFile, error) {
+	secret, err := rm.GetSecret(name, pod.Namespace)
+	if err != nil {
+		if errors.IsNotFound(err) && isOptional(optional) {
+			return nil, nil
+		}
+		return nil, pkgerrors.Wrapf(err, "failed to get secret %q", name)
+	}
+	return projectKeys(secret.Data, items, optional, defaultMode, fmt.Sprintf("secret %q", name))
+}
+
+// resolveConfigMap returns the files projected from the keys (or the specified items) of the specified config map, including binary ones.
+func (rm *ResourceManager) resolveConfigMap(pod *v1.Pod, name string, items []v1.KeyToPath, optional *bool, defaultMode int32) ([]VolumeFile, error) {
+	configMap, err := rm.GetConfigMap(name, pod.Namespace)
+	if err != nil {
+		if errors.IsNotFound(err) && isOptional(optional) {
+			return nil, nil
+		}
+		return nil, pkgerrors.Wrapf(err, "failed to get config map %q", name)
+	}
+	data := make(map[string][]byte, len(configMap.Data)+len(configMap.BinaryData))
+	for k, v := range configMap.Data {
+		data[k] = []byte(v)
+	}
+	for k, v := range configMap.BinaryData {
+		data[k] = v
+	}
+	return projectKeys(data, items, optional, defaultMode, fmt.Sprintf("config map %q", name))
+}
+
+// resolveProjected returns the files of all the sources of the specified projected volume.
+func (rm *ResourceManager) resolveProjected(pod *v1.Pod, src *v1.ProjectedVolumeSource) ([]VolumeFile, error) {
+	defaultMode := fileMode(src.DefaultMode, v1.ProjectedVolumeSourceDefaultMode)
+	var res []VolumeFile
+	for _, source := range src.Sources {
+		var (
+			files []VolumeFile
+			err   error
+		)
+		switch {
+		case source.Secret != nil:
+			files, err = rm.resolveSecret(pod, source.Secret.Name, source.Secret.Items, source.Secret.Optional, defaultMode)
+		case source.ConfigMap != nil:
+			files, err = rm.resolveConfigMap(pod, source.ConfigMap.Name, source.ConfigMap.Items, source.ConfigMap.Optional, defaultMode)
+		case source.DownwardAPI != nil:
+			files, err = resolveDownwardAPI(pod, source.DownwardAPI.Items, defaultMode)
+		case source.ServiceAccountToken != nil:
+			files, err = rm.resolveServiceAccountToken(pod, source.ServiceAccountToken, defaultMode)
+		}
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, files...)
+	}
+	return res, nil
+}
+
+// resolveServiceAccountToken returns a file containing a token for the pod's service account, bound to the pod.
+// Tokens are cached, and requested again once most of their validity has elapsed (see getServiceAccountToken).
+func (rm *ResourceManager) resolveServiceAccountToken(pod *v1.Pod, src *v1.ServiceAccountTokenProjection, mode int32) ([]VolumeFile, error) {
+	rm.RLock()
+	requestToken := rm.requestToken
+	rm.RUnlock()
+	if requestToken == nil {
+		return nil, fmt.Errorf("service account token projections are not supported as no token requester is set")
+	}
+
+	serviceAccountName := pod.Spec.ServiceAccountName
+	if serviceAccountName == "" {
+		serviceAccountName = defaultServiceAccountName
+	}
+	expirationSeconds := defaultTokenExpirationSeconds
+	if src.ExpirationSeconds != nil {
+		expirationSeconds = *src.ExpirationSeconds
+	}
+	tr := &authenticationv1.TokenRequest{
+		Spec: authenticationv1.TokenRequestSpec{
+			ExpirationSeconds: &expirationSeconds,
+			BoundObjectRef: &authenticationv1.BoundObjectReference{
+				APIVersion: "v1",
+				Kind:       "Pod",
+				Name:       pod.Name,
+				UID:        pod.UID,
+			},
+		},
+	}
+	// An empty audience means that the token is intended for the API server, which the latter assumes when no audiences are requested.
+	if src.Audience != "" {
+		tr.Spec.Audiences = []string{src.Audience}
+	}
+	token, err := rm.getServiceAccountToken(requestToken, pod, serviceAccountName, tr)
+	if err != nil {
+		return nil, pkgerrors.Wrapf(err, "failed to request a token for service account %q", serviceAccountName)
+	}
+	return []VolumeFile{{Path: src.Path, Mode: mode, Data: []byte(token)}}, nil
+}
+
+// resolveDownwardAPI returns the files containing the values of the specified pod fields and container resources.
+func resolveDownwardAPI(pod *v1.Pod, items []v1.DownwardAPIVolumeFile, defaultMode int32) ([]VolumeFile, error) {
+	res := make([]VolumeFile, 0, len(items))
+	for _, item := range items {
+		var (
+			value string
+			err   error
+		)
+		switch {
+		case item.FieldRef != nil:
+			value, err = podFieldValue(pod, item.FieldRef.FieldPath)
+		case item.ResourceFieldRef != nil:
+			value, err = containerResourceValue(pod, item.ResourceFieldRef)
+		default:
+			err = fmt.Errorf("item %q references neither a field nor a resource", item.Path)
+		}
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, VolumeFile{Path: item.Path, Mode: fileMode(item.Mode, defaultMode), Data: []byte(value)})
+	}
+	return res, nil
+}
+
+// podFieldValue returns the value of the specified pod field, formatted as the kubelet does.
+// Only the fields supported by the kubelet in DownwardAPI volumes are supported.
+func podFieldValue(pod *v1.Pod, fieldPath string) (string, error) {
+	switch fieldPath {
+	case "metadata.name":
+		return pod.Name, nil
+	case "metadata.namespace":
+		return pod.Namespace, nil
+	case "metadata.uid":
+		return string(pod.UID), nil
+	case "metadata.labels":
+		return formatMap(pod.Labels), nil
+	case "metadata.annotations":
+		return formatMap(pod.Annotations), nil
+	}
+	if key, ok := subscript(fieldPath, "metadata.labels"); ok {
+		return pod.Labels[key], nil
+	}
+	if key, ok := subscript(fieldPath, "metadata.annotations"); ok {
+		return pod.Annotations[key], nil
+	}
+	return "", fmt.Errorf("unsupported field path %q", fieldPath)
+}
+
+// subscript returns the key of a field path of the form "<prefix>['<key>']".
+func subscript(fieldPath, prefix string) (string, bool) {
+	if !strings.HasPrefix(fieldPath, prefix+"['") || !strings.HasSuffix(fieldPath, "']") {
+		return "", false
+	}
+	return fieldPath[len(prefix)+2 : len(fieldPath)-2], true
+}
+
+// formatMap formats the specified labels or annotations as "key="value"" lines sorted by key.
+func formatMap(m map[string]string) string {
+	lines := make([]string, 0, len(m))
+	for k, v := range m {
+		lines = append(lines, fmt.Sprintf("%s=%q", k, v))
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
+
+// containerResourceValue returns the value of the specified container resource, expressed in units of the specified divisor and rounded up.
+// Unlike the kubelet, which defaults unset limits to the node's allocatable resources, unset limits default to the corresponding requests as the virtual node's resources are not meaningful to containers.
+func containerResourceValue(pod *v1.Pod, ref *v1.ResourceFieldSelector) (string, error) {
+	var container *v1.Container
+	for i := range pod.Spec.Containers {
+		if pod.Spec.Containers[i].Name == ref.ContainerName {
+			container = &pod.Spec.Containers[i]
+		}
+	}
+	if container == nil {
+		return "", fmt.Errorf("container %q not found", ref.ContainerName)
+	}
+
+	var (
+		name   v1.ResourceName
+		values v1.ResourceList
+	)
+	switch {
+	case strings.HasPrefix(ref.Resource, "limits."):
+		name = v1.ResourceName(strings.TrimPrefix(ref.Resource, "limits."))
+		values = container.Resources.Limits
+		if _, ok := values[name]; !ok {
+			values = container.Resources.Requests
+		}
+	case strings.HasPrefix(ref.Resource, "requests."):
+		name = v1.ResourceName(strings.TrimPrefix(ref.Resource, "requests."))
+		values = container.Resources.Requests
+	default:
+		return "", fmt.Errorf("unsupported resource %q", ref.Resource)
+	}
+
+	divisor := ref.Divisor
+	if divisor.IsZero() {
+		divisor = resource.MustParse("1")
+	}
+	value := values[name]
+	switch name {
+	case v1.ResourceCPU:
+		return fmt.Sprint(int64(math.Ceil(float64(value.MilliValue()) / float64(divisor.MilliValue())))), nil
+	case v1.ResourceMemory, v1.ResourceEphemeralStorage:
+		return fmt.Sprint(int64(math.Ceil(float64(value.Value()) / float64(divisor.Value())))), nil
+	default:
+		return "", fmt.Errorf("unsupported resource %q", ref.Resource)
+	}
+}
+
+// projectKeys returns the files projected from the specified keys, or from the specified items if any.
+// Items referencing a key that doesn't exist are an error unless the referenced object is optional.
+func projectKeys(data map[string][]byte, items []v1.KeyToPath, optional *bool, defaultMode int32, object string) ([]VolumeFile, error) {
+	if len(items) == 0 {
+		res := make([]VolumeFile, 0, len(data))
+		for k, v := range data {
+			res = append(res, VolumeFile{Path: k, Mode: defaultMode, Data: v})
+		}
+		return res, nil
+	}
+	res := make([]VolumeFile, 0, len(items))
+	for _, item := range items {
+		v, ok := data[item.Key]
+		if !ok {
+			if isOptional(optional) {
+				continue
+			}
+			return nil, fmt.Errorf("key %q does not exist in %s", item.Key, object)
+		}
+		res = append(res, VolumeFile{Path: item.Path, Mode: fileMode(item.Mode, defaultMode), Data: v})
+	}
+	return res, nil
+}
+
+// sortAndCheckFiles sorts the specified files by path and checks that their paths are valid and unique.
+func sortAndCheckFiles(files []VolumeFile) ([]VolumeFile, error) {
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Path < files[j].Path
+	})
+	for i, file := range files {
+		if file.Path == "" || path.IsAbs(file.Path) || path.Clean(file.Path) != file.Path || file.Path == ".." || strings.HasPrefix(file.Path, "../") {
+			return nil, fmt.Errorf("invalid path %q", file.Path)
+		}
+		if i > 0 && files[i-1].Path == file.Path {
+			return nil, fmt.Errorf("duplicate path %q", file.Path)
+		}
+	}
+	return files, nil
+}
+
+// fileMode returns the specified mode if set, and the specified default mode otherwise.
+func fileMode(mode *int32, defaultMode int32) int32 {
+	if mode != nil {
+		return *mode
+	}
+	return defaultMode
+}
+
+// isOptional returns whether an object reference is optional, which it isn't unless explicitly stated.
+func isOptional(optional *bool) bool {
+	return optional != nil && *optional
+}
@@ -0,0 +1,185 @@
+package manager_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/virtual-kubelet/virtual-kubelet/manager"
+	testutil "github.com/virtual-kubelet/virtual-kubelet/test/util"
+)
+
+func int32Ptr(i int32) *int32 {
+	return &i
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+// TestResolveVolumes verifies that Secret, ConfigMap, DownwardAPI and Projected volumes are resolved into files with the expected paths and modes.
+func TestResolveVolumes(t *testing.T) {
+	pod := testutil.FakePodWithSingleContainer("namespace-0", "pod-0", "image-0")
+	pod.UID = "uid-0"
+	pod.Labels = map[string]string{"b": "2", "a": "1"}
+	pod.Spec.ServiceAccountName = "sa-0"
+	pod.Spec.Containers[0].Resources.Requests = v1.ResourceList{
+		v1.ResourceMemory: resource.MustParse("64Mi"),
+	}
+	pod.Spec.Containers[0].Resources.Limits = v1.ResourceList{
+		v1.ResourceCPU: resource.MustParse("1500m"),
+	}
+	pod.Spec.Volumes = []v1.Volume{
+		{Name: "empty", VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{}}},
+		{Name: "secret", VolumeSource: v1.VolumeSource{Secret: &v1.SecretVolumeSource{
+			SecretName:  "secret-0",
+			DefaultMode: int32Ptr(0600),
+		}}},
+		{Name: "configmap", VolumeSource: v1.VolumeSource{ConfigMap: &v1.ConfigMapVolumeSource{
+			LocalObjectReference: v1.LocalObjectReference{Name: "configmap-0"},
+			Items: []v1.KeyToPath{
+				{Key: "key-0", Path: "dir/file-0", Mode: int32Ptr(0400)},
+				{Key: "binary", Path: "file-1"},
+			},
+		}}},
+		{Name: "optional", VolumeSource: v1.VolumeSource{Secret: &v1.SecretVolumeSource{
+			SecretName: "missing",
+			Optional:   boolPtr(true),
+		}}},
+		{Name: "downwardapi", VolumeSource: v1.VolumeSource{DownwardAPI: &v1.DownwardAPIVolumeSource{
+			Items: []v1.DownwardAPIVolumeFile{
+				{Path: "name", FieldRef: &v1.ObjectFieldSelector{FieldPath: "metadata.name"}},
+				{Path: "labels", FieldRef: &v1.ObjectFieldSelector{FieldPath: "metadata.labels"}},
+				{Path: "label-a", FieldRef: &v1.ObjectFieldSelector{FieldPath: "metadata.labels['a']"}},
+				{Path: "cpu", ResourceFieldRef: &v1.ResourceFieldSelector{ContainerName: pod.Spec.Containers[0].Name, Resource: "limits.cpu"}},
+				{Path: "memory", ResourceFieldRef: &v1.ResourceFieldSelector{ContainerName: pod.Spec.Containers[0].Name, Resource: "limits.memory", Divisor: resource.MustParse("1Mi")}},
+			},
+		}}},
+		{Name: "projected", VolumeSource: v1.VolumeSource{Projected: &v1.ProjectedVolumeSource{
+			DefaultMode: int32Ptr(0440),
+			Sources: []v1.VolumeProjection{
+				{Secret: &v1.SecretProjection{
+					LocalObjectReference: v1.LocalObjectReference{Name: "secret-0"},
+					Items:                []v1.KeyToPath{{Key: "key-0", Path: "secret"}},
+				}},
+				{ServiceAccountToken: &v1.ServiceAccountTokenProjection{Path: "token", Audience: "vault"}},
+			},
+		}}},
+	}
+	configMap := testutil.FakeConfigMap("namespace-0", "configmap-0", map[string]string{"key-0": "val-0", "key-1": "val-1"})
+	configMap.BinaryData = map[string][]byte{"binary": {0, 1}}
+	rm := testutil.FakeResourceManager(
+		testutil.FakeSecret("namespace-0", "secret-0", map[string]string{"key-0": "val-0", "key-1": "val-1"}),
+		configMap,
+	)
+	rm.SetTokenRequester(func(namespace, serviceAccountName string, tr *authenticationv1.TokenRequest) (*authenticationv1.TokenRequest, error) {
+		assert.Equal(t, "namespace-0", namespace)
+		assert.Equal(t, "sa-0", serviceAccountName)
+		assert.Equal(t, []string{"vault"}, tr.Spec.Audiences)
+		assert.Equal(t, pod.UID, tr.Spec.BoundObjectRef.UID)
+		tr.Status.Token = "token-0"
+		return tr, nil
+	})
+
+	res, err := rm.ResolveVolumes(pod)
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]manager.VolumeFile{
+		"secret": {
+			{Path: "key-0", Mode: 0600, Data: []byte("val-0")},
+			{Path: "key-1", Mode: 0600, Data: []byte("val-1")},
+		},
+		"configmap": {
+			{Path: "dir/file-0", Mode: 0400, Data: []byte("val-0")},
+			{Path: "file-1", Mode: v1.ConfigMapVolumeSourceDefaultMode, Data: []byte{0, 1}},
+		},
+		"optional": nil,
+		"downwardapi": {
+			{Path: "cpu", Mode: v1.DownwardAPIVolumeSourceDefaultMode, Data: []byte("2")},
+			{Path: "label-a", Mode: v1.DownwardAPIVolumeSourceDefaultMode, Data: []byte("1")},
+			{Path: "labels", Mode: v1.DownwardAPIVolumeSourceDefaultMode, Data: []byte("a=\"1\"\nb=\"2\"")},
+			{Path: "memory", Mode: v1.DownwardAPIVolumeSourceDefaultMode, Data: []byte("64")},
+			{Path: "name", Mode: v1.DownwardAPIVolumeSourceDefaultMode, Data: []byte("pod-0")},
+		},
+		"projected": {
+			{Path: "secret", Mode: 0440, Data: []byte("val-0")},
+			{Path: "token", Mode: 0440, Data: []byte("token-0")},
+		},
+	}, res)
+}
+
+// TestResolveServiceAccountTokenRefresh verifies that service account tokens are cached, and requested again once most of their validity has elapsed.
+func TestResolveServiceAccountTokenRefresh(t *testing.T) {
+	pod := testutil.FakePodWithSingleContainer("namespace-0", "pod-0", "image-0")
+	volume := v1.Volume{Name: "token", VolumeSource: v1.VolumeSource{Projected: &v1.ProjectedVolumeSource{
+		Sources: []v1.VolumeProjection{
+			{ServiceAccountToken: &v1.ServiceAccountTokenProjection{Path: "token"}},
+		},
+	}}}
+	rm := testutil.FakeResourceManager()
+	requests := 0
+	rm.SetTokenRequester(func(namespace, serviceAccountName string, tr *authenticationv1.TokenRequest) (*authenticationv1.TokenRequest, error) {
+		requests++
+		tr.Status.Token = fmt.Sprintf("token-%d", requests)
+		tr.Status.ExpirationTimestamp = metav1.NewTime(time.Now().Add(100 * time.Millisecond))
+		return tr, nil
+	})
+
+	for i := 0; i < 2; i++ {
+		files, err := rm.ResolveVolume(pod, volume)
+		require.NoError(t, err)
+		require.Len(t, files, 1)
+		assert.Equal(t, "token-1", string(files[0].Data))
+	}
+	assert.Equal(t, 1, requests)
+
+	// The token is refreshed after 80% of its validity.
+	time.Sleep(200 * time.Millisecond)
+	files, err := rm.ResolveVolume(pod, volume)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	assert.Equal(t, "token-2", string(files[0].Data))
+	assert.Equal(t, 2, requests)
+}
+
+// TestResolveVolumeErrors verifies that volumes referencing mandatory objects or keys that don't exist, or mapping keys to invalid paths, fail to resolve.
+func TestResolveVolumeErrors(t *testing.T) {
+	pod := testutil.FakePodWithSingleContainer("namespace-0", "pod-0", "image-0")
+	rm := testutil.FakeResourceManager(
+		testutil.FakeSecret("namespace-0", "secret-0", map[string]string{"key-0": "val-0"}),
+	)
+
+	volumes := []v1.Volume{
+		{Name: "missing-secret", VolumeSource: v1.VolumeSource{Secret: &v1.SecretVolumeSource{SecretName: "missing"}}},
+		{Name: "missing-key", VolumeSource: v1.VolumeSource{Secret: &v1.SecretVolumeSource{
+			SecretName: "secret-0",
+			Items:      []v1.KeyToPath{{Key: "missing", Path: "file"}},
+		}}},
+		{Name: "invalid-path", VolumeSource: v1.VolumeSource{Secret: &v1.SecretVolumeSource{
+			SecretName: "secret-0",
+			Items:      []v1.KeyToPath{{Key: "key-0", Path: "../file"}},
+		}}},
+		{Name: "token", VolumeSource: v1.VolumeSource{Projected: &v1.ProjectedVolumeSource{
+			Sources: []v1.VolumeProjection{{ServiceAccountToken: &v1.ServiceAccountTokenProjection{Path: "token"}}},
+		}}},
+	}
+	for _, volume := range volumes {
+		_, err := rm.ResolveVolume(pod, volume)
+		assert.Error(t, err, volume.Name)
+	}
+
+	// Missing keys are skipped when the secret is optional.
+	files, err := rm.ResolveVolume(pod, v1.Volume{Name: "optional-key", VolumeSource: v1.VolumeSource{Secret: &v1.SecretVolumeSource{
+		SecretName: "secret-0",
+		Items:      []v1.KeyToPath{{Key: "missing", Path: "file"}},
+		Optional:   boolPtr(true),
+	}}})
+	require.NoError(t, err)
+	assert.Empty(t, files)
+}
@@ -0,0 +1,153 @@
+package manager
+
+import (
+	"reflect"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/cache"
+)
+
+// WatchVolumeSources registers event handlers on the specified secret and config map informers, so that the specified function is called with each pod assigned to the current node having a volume that references a secret or config map whose contents change.
+// Changes to secrets and config maps referenced only by environment variables are ignored, as the kubelet doesn't update the environment of running containers either.
+// Either informer may be nil, in which case changes to the corresponding objects are not watched.
+// When the resource manager fetches secrets and config maps on demand (see NewOnDemandResourceManager), the changes it observes are reported as well.
+// The function is also called with the pods whose service account tokens projected into volumes must be refreshed, as they are about to expire.
+func (rm *ResourceManager) WatchVolumeSources(secretInformer, configMapInformer cache.SharedInformer, onChange func(pod *v1.Pod)) {
+	rm.Lock()
+	rm.tokenRefreshHandlers = append(rm.tokenRefreshHandlers, onChange)
+	rm.Unlock()
+
+	onSecretUpdate := func(oldObj, newObj interface{}) {
+		oldSecret, newSecret := oldObj.(*v1.Secret), newObj.(*v1.Secret)
+		if reflect.DeepEqual(oldSecret.Data, newSecret.Data) {
+			return
+		}
+		for _, pod := range rm.podsReferencing(newSecret.Namespace, func(volume v1.Volume) bool {
+			return referencesSecret(volume, newSecret.Name)
+		}) {
+			onChange(pod)
+		}
+	}
+	onConfigMapUpdate := func(oldObj, newObj interface{}) {
+		oldConfigMap, newConfigMap := oldObj.(*v1.ConfigMap), newObj.(*v1.ConfigMap)
+		if reflect.DeepEqual(oldConfigMap.Data, newConfigMap.Data) && reflect.DeepEqual(oldConfigMap.BinaryData, newConfigMap.BinaryData) {
+			return
+		}
+		for _, pod := range rm.podsReferencing(newConfigMap.Namespace, func(volume v1.Volume) bool {
+			return referencesConfigMap(volume, newConfigMap.Name)
+		}) {
+			onChange(pod)
+		}
+	}
+
+	if secretInformer != nil {
+		secretInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{UpdateFunc: onSecretUpdate})
+	}
+	if configMapInformer != nil {
+		configMapInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{UpdateFunc: onConfigMapUpdate})
+	}
+	if rm.secretCache != nil {
+		rm.secretCache.addUpdateHandler(func(oldObj, newObj runtime.Object) {
+			onSecretUpdate(oldObj, newObj)
+		})
+	}
+	if rm.configMapCache != nil {
+		rm.configMapCache.addUpdateHandler(func(oldObj, newObj runtime.Object) {
+			onConfigMapUpdate(oldObj, newObj)
+		})
+	}
+}
+
+// TrackPodReferences registers event handlers on the specified pod informer, so that the secrets and config maps referenced by the volumes of the pods assigned to the current node keep being watched for as long as these pods exist.
+// It only has an effect when the resource manager fetches secrets and config maps on demand and watches them (see NewOnDemandResourceManager).
+// Otherwise, running pods only access these objects when they are created, so their watches would be stopped once the cache TTL has elapsed, and changes would go unnoticed.
+func (rm *ResourceManager) TrackPodReferences(podInformer cache.SharedInformer) {
+	if rm.secretCache == nil || rm.configMapCache == nil {
+		return
+	}
+	podInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		// Volumes can't be changed once a pod is created, so updates don't matter.
+		AddFunc: func(obj interface{}) {
+			if pod, ok := obj.(*v1.Pod); ok {
+				forEachVolumeSource(pod, rm.secretCache.ref, rm.configMapCache.ref)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if pod, ok := obj.(*v1.Pod); ok {
+				forEachVolumeSource(pod, rm.secretCache.unref, rm.configMapCache.unref)
+			}
+		},
+	})
+}
+
+// forEachVolumeSource calls the specified functions with the namespace and name of each secret and config map referenced by the volumes of the specified pod, either directly or through a projection.
+func forEachVolumeSource(pod *v1.Pod, secretFunc, configMapFunc func(namespace, name string)) {
+	for _, volume := range pod.Spec.Volumes {
+		switch {
+		case volume.Secret != nil:
+			secretFunc(pod.Namespace, volume.Secret.SecretName)
+		case volume.ConfigMap != nil:
+			configMapFunc(pod.Namespace, volume.ConfigMap.Name)
+		case volume.Projected != nil:
+			for _, source := range volume.Projected.Sources {
+				if source.Secret != nil {
+					secretFunc(pod.Namespace, source.Secret.Name)
+				}
+				if source.ConfigMap != nil {
+					configMapFunc(pod.Namespace, source.ConfigMap.Name)
+				}
+			}
+		}
+	}
+}
+
+// podsReferencing returns the pods in the specified namespace having a volume for which the specified function returns true.
+func (rm *ResourceManager) podsReferencing(namespace string, references func(volume v1.Volume) bool) []*v1.Pod {
+	var res []*v1.Pod
+	for _, pod := range rm.GetPods() {
+		if pod.Namespace != namespace {
+			continue
+		}
+		for _, volume := range pod.Spec.Volumes {
+			if references(volume) {
+				res = append(res, pod)
+				break
+			}
+		}
+	}
+	return res
+}
+
+// referencesSecret returns whether the specified volume references the specified secret, either directly or through a projection.
+func referencesSecret(volume v1.Volume, name string) bool {
+	if volume.Secret != nil {
+		return volume.Secret.SecretName == name
+	}
+	if volume.Projected != nil {
+		for _, source := range volume.Projected.Sources {
+			if source.Secret != nil && source.Secret.Name == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// referencesConfigMap returns whether the specified volume references the specified config map, either directly or through a projection.
+func referencesConfigMap(volume v1.Volume, name string) bool {
+	if volume.ConfigMap != nil {
+		return volume.ConfigMap.Name == name
+	}
+	if volume.Projected != nil {
+		for _, source := range volume.Projected.Sources {
+			if source.ConfigMap != nil && source.ConfigMap.Name == name {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,97 @@
+package manager_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+
+	testutil "github.com/virtual-kubelet/virtual-kubelet/test/util"
+)
+
+// fakeInformer is a shared informer whose registered event handlers are called explicitly.
+type fakeInformer struct {
+	cache.SharedInformer
+	handlers []cache.ResourceEventHandler
+}
+
+func (i *fakeInformer) AddEventHandler(handler cache.ResourceEventHandler) {
+	i.handlers = append(i.handlers, handler)
+}
+
+func (i *fakeInformer) update(oldObj, newObj interface{}) {
+	for _, h := range i.handlers {
+		h.OnUpdate(oldObj, newObj)
+	}
+}
+
+// TestWatchVolumeSources verifies that pods are notified when the contents of a secret or config map referenced by their volumes change.
+func TestWatchVolumeSources(t *testing.T) {
+	secretPod := testutil.FakePodWithSingleContainer("namespace-0", "secret-pod", "image-0")
+	secretPod.Spec.Volumes = []v1.Volume{{Name: "secret", VolumeSource: v1.VolumeSource{Secret: &v1.SecretVolumeSource{SecretName: "secret-0"}}}}
+	projectedPod := testutil.FakePodWithSingleContainer("namespace-0", "projected-pod", "image-0")
+	projectedPod.Spec.Volumes = []v1.Volume{{Name: "projected", VolumeSource: v1.VolumeSource{Projected: &v1.ProjectedVolumeSource{
+		Sources: []v1.VolumeProjection{
+			{ConfigMap: &v1.ConfigMapProjection{LocalObjectReference: v1.LocalObjectReference{Name: "configmap-0"}}},
+		},
+	}}}}
+	// This pod references a secret with the same name, but in another namespace.
+	otherPod := testutil.FakePodWithSingleContainer("namespace-1", "other-pod", "image-0")
+	otherPod.Spec.Volumes = secretPod.Spec.Volumes
+	rm := testutil.FakeResourceManager(secretPod, projectedPod, otherPod)
+
+	var changed []string
+	secretInformer, configMapInformer := &fakeInformer{}, &fakeInformer{}
+	rm.WatchVolumeSources(secretInformer, configMapInformer, func(pod *v1.Pod) {
+		changed = append(changed, pod.Name)
+	})
+
+	secret := testutil.FakeSecret("namespace-0", "secret-0", map[string]string{"key-0": "val-0"})
+	// Updates that don't change the contents are ignored.
+	resynced := secret.DeepCopy()
+	resynced.ResourceVersion = "2"
+	secretInformer.update(secret, resynced)
+	assert.Empty(t, changed)
+	updated := testutil.FakeSecret("namespace-0", "secret-0", map[string]string{"key-0": "val-1"})
+	secretInformer.update(secret, updated)
+	assert.Equal(t, []string{"secret-pod"}, changed)
+
+	changed = nil
+	configMap := testutil.FakeConfigMap("namespace-0", "configmap-0", map[string]string{"key-0": "val-0"})
+	configMapInformer.update(configMap, testutil.FakeConfigMap("namespace-0", "configmap-0", map[string]string{"key-0": "val-1"}))
+	assert.Equal(t, []string{"projected-pod"}, changed)
+}
+
+// TestWatchVolumeSourcesTokenRefresh verifies that pods are notified when the service account tokens projected into their volumes must be refreshed.
+func TestWatchVolumeSourcesTokenRefresh(t *testing.T) {
+	pod := testutil.FakePodWithSingleContainer("namespace-0", "pod-0", "image-0")
+	pod.Spec.Volumes = []v1.Volume{{Name: "token", VolumeSource: v1.VolumeSource{Projected: &v1.ProjectedVolumeSource{
+		Sources: []v1.VolumeProjection{
+			{ServiceAccountToken: &v1.ServiceAccountTokenProjection{Path: "token"}},
+		},
+	}}}}
+	rm := testutil.FakeResourceManager(pod)
+	rm.SetTokenRequester(func(namespace, serviceAccountName string, tr *authenticationv1.TokenRequest) (*authenticationv1.TokenRequest, error) {
+		tr.Status.Token = "token-0"
+		tr.Status.ExpirationTimestamp = metav1.NewTime(time.Now().Add(100 * time.Millisecond))
+		return tr, nil
+	})
+	changed := make(chan string, 1)
+	rm.WatchVolumeSources(nil, nil, func(pod *v1.Pod) {
+		changed <- pod.Name
+	})
+
+	_, err := rm.ResolveVolumes(pod)
+	require.NoError(t, err)
+	select {
+	case name := <-changed:
+		assert.Equal(t, "pod-0", name)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the pod wasn't notified that its token must be refreshed")
+	}
+}
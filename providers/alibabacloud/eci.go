@@ -1,12 +1,10 @@
 package alibabacloud
 
 import (
-	"bytes"
 	"context"
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/hex"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -20,8 +18,8 @@ import (
 	"github.com/virtual-kubelet/virtual-kubelet/log"
 	"github.com/virtual-kubelet/virtual-kubelet/manager"
 	"github.com/virtual-kubelet/virtual-kubelet/providers/alibabacloud/eci"
+	"github.com/virtual-kubelet/virtual-kubelet/providers/pullsecrets"
 	"k8s.io/api/core/v1"
-	k8serr "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
@@ -52,17 +50,6 @@ type ECIProvider struct {
 	vSwitch            string
 }
 
-// AuthConfig is the secret returned from an ImageRegistryCredential
-type AuthConfig struct {
-	Username      string `json:"username,omitempty"`
-	Password      string `json:"password,omitempty"`
-	Auth          string `json:"auth,omitempty"`
-	Email         string `json:"email,omitempty"`
-	ServerAddress string `json:"serveraddress,omitempty"`
-	IdentityToken string `json:"identitytoken,omitempty"`
-	RegistryToken string `json:"registrytoken,omitempty"`
-}
-
 var validEciRegions = []string{
 	"cn-hangzhou",
 	"cn-shanghai",
@@ -470,89 +457,19 @@ func (p *ECIProvider) OperatingSystem() string {
 }
 
 func (p *ECIProvider) getImagePullSecrets(pod *v1.Pod) ([]eci.ImageRegistryCredential, error) {
-	ips := make([]eci.ImageRegistryCredential, 0, len(pod.Spec.ImagePullSecrets))
-	for _, ref := range pod.Spec.ImagePullSecrets {
-		secret, err := p.resourceManager.GetSecret(ref.Name, pod.Namespace)
-		if err != nil {
-			return ips, err
-		}
-		if secret == nil {
-			return nil, fmt.Errorf("error getting image pull secret")
-		}
-		// TODO: Check if secret type is v1.SecretTypeDockercfg and use DockerConfigKey instead of hardcoded value
-		// TODO: Check if secret type is v1.SecretTypeDockerConfigJson and use DockerConfigJsonKey to determine if it's in json format
-		// TODO: Return error if it's not one of these two types
-		switch secret.Type {
-		case v1.SecretTypeDockercfg:
-			ips, err = readDockerCfgSecret(secret, ips)
-		case v1.SecretTypeDockerConfigJson:
-			ips, err = readDockerConfigJSONSecret(secret, ips)
-		default:
-			return nil, fmt.Errorf("image pull secret type is not one of kubernetes.io/dockercfg or kubernetes.io/dockerconfigjson")
-		}
-
-		if err != nil {
-			return ips, err
-		}
-	}
-	return ips, nil
-}
-
-func readDockerCfgSecret(secret *v1.Secret, ips []eci.ImageRegistryCredential) ([]eci.ImageRegistryCredential, error) {
-	var err error
-	var authConfigs map[string]AuthConfig
-	repoData, ok := secret.Data[string(v1.DockerConfigKey)]
-
-	if !ok {
-		return ips, fmt.Errorf("no dockercfg present in secret")
-	}
-
-	err = json.Unmarshal(repoData, &authConfigs)
-	if err != nil {
-		return ips, fmt.Errorf("failed to unmarshal auth config %+v", err)
-	}
-
-	for server, authConfig := range authConfigs {
-		ips = append(ips, eci.ImageRegistryCredential{
-			Password: authConfig.Password,
-			Server:   server,
-			UserName: authConfig.Username,
-		})
-	}
-
-	return ips, err
-}
-
-func readDockerConfigJSONSecret(secret *v1.Secret, ips []eci.ImageRegistryCredential) ([]eci.ImageRegistryCredential, error) {
-	var err error
-	repoData, ok := secret.Data[string(v1.DockerConfigJsonKey)]
-
-	if !ok {
-		return ips, fmt.Errorf("no dockerconfigjson present in secret")
-	}
-
-	var authConfigs map[string]map[string]AuthConfig
-
-	err = json.Unmarshal(repoData, &authConfigs)
+	keyring, err := pullsecrets.ForPod(p.resourceManager, pod)
 	if err != nil {
-		return ips, err
-	}
-
-	auths, ok := authConfigs["auths"]
-
-	if !ok {
-		return ips, fmt.Errorf("malformed dockerconfigjson in secret")
+		return nil, err
 	}
-
-	for server, authConfig := range auths {
+	ips := make([]eci.ImageRegistryCredential, 0, len(keyring.Credentials()))
+	for _, cred := range keyring.Credentials() {
 		ips = append(ips, eci.ImageRegistryCredential{
-			Password: authConfig.Password,
-			Server:   server,
-			UserName: authConfig.Username,
+			Password: cred.Password,
+			Server:   cred.Server,
+			UserName: cred.Username,
 		})
 	}
-
-	return ips, err
+	return ips, nil
 }
 
 func (p *ECIProvider) getContainers(pod *v1.Pod, init bool) ([]eci.CreateContainer, error) {
@@ -637,49 +554,16 @@ func (p *ECIProvider) getVolumes(pod *v1.Pod) ([]eci.Volume, error) {
 			continue
 		}
 
-		// Handle the case for ConfigMap volume.
-		if v.ConfigMap != nil {
-			ConfigFileToPaths := make([]eci.ConfigFileToPath, 0)
-			configMap, err := p.resourceManager.GetConfigMap(v.ConfigMap.Name, pod.Namespace)
-			if v.ConfigMap.Optional != nil && !*v.ConfigMap.Optional && k8serr.IsNotFound(err) {
-				return nil, fmt.Errorf("ConfigMap %s is required by Pod %s and does not exist", v.ConfigMap.Name, pod.Name)
-			}
-			if configMap == nil {
-				continue
-			}
-
-			for k, v := range configMap.Data {
-				var b bytes.Buffer
-				enc := base64.NewEncoder(base64.StdEncoding, &b)
-				enc.Write([]byte(v))
-
-				ConfigFileToPaths = append(ConfigFileToPaths, eci.ConfigFileToPath{Path: k, Content: b.String()})
-			}
-
-			if len(ConfigFileToPaths) != 0 {
-				volumes = append(volumes, eci.Volume{
-					Type:              eci.VOL_TYPE_CONFIGFILEVOLUME,
-					Name:              v.Name,
-					ConfigFileToPaths: ConfigFileToPaths,
-				})
+		// Handle the case for Secret, ConfigMap, DownwardAPI and Projected volumes, which are all mapped onto ECI config file volumes.
+		if manager.IsResolvableVolume(v) {
+			files, err := p.resourceManager.ResolveVolume(pod, v)
+			if err != nil {
+				return nil, err
 			}
-			continue
-		}
 
-		if v.Secret != nil {
-			ConfigFileToPaths := make([]eci.ConfigFileToPath, 0)
-			secret, err := p.resourceManager.GetSecret(v.Secret.SecretName, pod.Namespace)
-			if v.Secret.Optional != nil && !*v.Secret.Optional && k8serr.IsNotFound(err) {
-				return nil, fmt.Errorf("Secret %s is required by Pod %s and does not exist", v.Secret.SecretName, pod.Name)
-			}
-			if secret == nil {
-				continue
-			}
-			for k, v := range secret.Data {
-				var b bytes.Buffer
-				enc := base64.NewEncoder(base64.StdEncoding, &b)
-				enc.Write(v)
-				ConfigFileToPaths = append(ConfigFileToPaths, eci.ConfigFileToPath{Path: k, Content: b.String()})
+			ConfigFileToPaths := make([]eci.ConfigFileToPath, 0, len(files))
+			for _, f := range files {
+				ConfigFileToPaths = append(ConfigFileToPaths, eci.ConfigFileToPath{Path: f.Path, Content: base64.StdEncoding.EncodeToString(f.Data)})
 			}
 
 			if len(ConfigFileToPaths) != 0 {
@@ -9,6 +9,7 @@ import (
 
 	"github.com/virtual-kubelet/virtual-kubelet/manager"
 	"github.com/virtual-kubelet/virtual-kubelet/providers/aws/fargate"
+	"github.com/virtual-kubelet/virtual-kubelet/providers/pullsecrets"
 
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
@@ -112,6 +113,8 @@ func NewFargateProvider(
 func (p *FargateProvider) CreatePod(ctx context.Context, pod *corev1.Pod) error {
 	log.Printf("Received CreatePod request for %+v.\n", pod)
 
+	p.checkImagePullSecrets(pod)
+
 	fgPod, err := fargate.NewPod(p.cluster, pod)
 	if err != nil {
 		log.Printf("Failed to create pod: %v.\n", err)
@@ -127,6 +130,21 @@ func (p *FargateProvider) CreatePod(ctx context.Context, pod *corev1.Pod) error
 	return nil
 }
 
+// checkImagePullSecrets logs a warning for each container of the specified pod whose image has credentials in the image pull secrets of the pod or of its service account.
+// Fargate only authenticates with registries through the task execution role, as the ECS API in use doesn't support per-container registry credentials, so these credentials can't be used and the image may fail to be pulled.
+func (p *FargateProvider) checkImagePullSecrets(pod *corev1.Pod) {
+	keyring, err := pullsecrets.ForPod(p.resourceManager, pod)
+	if err != nil {
+		log.Printf("Failed to resolve image pull secrets of pod %s/%s: %v.\n", pod.Namespace, pod.Name, err)
+		return
+	}
+	for _, c := range pod.Spec.Containers {
+		if creds := keyring.Lookup(c.Image); len(creds) > 0 {
+			log.Printf("Ignoring the credentials for %s found in the image pull secrets of pod %s/%s, as Fargate only pulls image %s using the task execution role.\n", creds[0].Server, pod.Namespace, pod.Name, c.Image)
+		}
+	}
+}
+
 // UpdatePod takes a Kubernetes Pod and updates it within the provider.
 func (p *FargateProvider) UpdatePod(ctx context.Context, pod *corev1.Pod) error {
 	log.Printf("Received UpdatePod request for %s/%s.\n", pod.Namespace, pod.Name)
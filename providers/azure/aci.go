@@ -26,9 +26,9 @@ import (
 	client "github.com/virtual-kubelet/virtual-kubelet/providers/azure/client"
 	"github.com/virtual-kubelet/virtual-kubelet/providers/azure/client/aci"
 	"github.com/virtual-kubelet/virtual-kubelet/providers/azure/client/network"
+	"github.com/virtual-kubelet/virtual-kubelet/providers/pullsecrets"
 	"go.opencensus.io/trace"
 	"k8s.io/api/core/v1"
-	k8serr "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
@@ -82,17 +82,6 @@ type ACIProvider struct {
 	lastMetric      *stats.Summary
 }
 
-// AuthConfig is the secret returned from an ImageRegistryCredential
-type AuthConfig struct {
-	Username      string `json:"username,omitempty"`
-	Password      string `json:"password,omitempty"`
-	Auth          string `json:"auth,omitempty"`
-	Email         string `json:"email,omitempty"`
-	ServerAddress string `json:"serveraddress,omitempty"`
-	IdentityToken string `json:"identitytoken,omitempty"`
-	RegistryToken string `json:"registrytoken,omitempty"`
-}
-
 // See https://azure.microsoft.com/en-us/status/ for valid regions.
 var validAciRegions = []string{
 	"australiaeast",
@@ -972,124 +961,37 @@ func (p *ACIProvider) OperatingSystem() string {
 	return p.operatingSystem
 }
 
-func (p *ACIProvider) getImagePullSecrets(pod *v1.Pod) ([]aci.ImageRegistryCredential, error) {
-	ips := make([]aci.ImageRegistryCredential, 0, len(pod.Spec.ImagePullSecrets))
-	for _, ref := range pod.Spec.ImagePullSecrets {
-		secret, err := p.resourceManager.GetSecret(ref.Name, pod.Namespace)
-		if err != nil {
-			return ips, err
-		}
-		if secret == nil {
-			return nil, fmt.Errorf("error getting image pull secret")
-		}
-		// TODO: Check if secret type is v1.SecretTypeDockercfg and use DockerConfigKey instead of hardcoded value
-		// TODO: Check if secret type is v1.SecretTypeDockerConfigJson and use DockerConfigJsonKey to determine if it's in json format
-		// TODO: Return error if it's not one of these two types
-		switch secret.Type {
-		case v1.SecretTypeDockercfg:
-			ips, err = readDockerCfgSecret(secret, ips)
-		case v1.SecretTypeDockerConfigJson:
-			ips, err = readDockerConfigJSONSecret(secret, ips)
-		default:
-			return nil, fmt.Errorf("image pull secret type is not one of kubernetes.io/dockercfg or kubernetes.io/dockerconfigjson")
-		}
-
-		if err != nil {
-			return ips, err
-		}
-
-	}
-	return ips, nil
-}
-
-func makeRegistryCredential(server string, authConfig AuthConfig) (*aci.ImageRegistryCredential, error) {
-	username := authConfig.Username
-	password := authConfig.Password
-
-	if username == "" {
-		if authConfig.Auth == "" {
-			return nil, fmt.Errorf("no username present in auth config for server: %s", server)
-		}
-
-		decoded, err := base64.StdEncoding.DecodeString(authConfig.Auth)
-		if err != nil {
-			return nil, fmt.Errorf("error decoding the auth for server: %s Error: %v", server, err)
-		}
-
-		parts := strings.Split(string(decoded), ":")
-		if len(parts) != 2 {
-			return nil, fmt.Errorf("malformed auth for server: %s", server)
-		}
-
-		username = parts[0]
-		password = parts[1]
-	}
-
-	cred := aci.ImageRegistryCredential{
-		Server:   server,
-		Username: username,
-		Password: password,
-	}
-
-	return &cred, nil
+// SupportsNativeProbes returns true since liveness and readiness probes are mapped onto ACI container probes.
+func (p *ACIProvider) SupportsNativeProbes() bool {
+	return true
 }
 
-func readDockerCfgSecret(secret *v1.Secret, ips []aci.ImageRegistryCredential) ([]aci.ImageRegistryCredential, error) {
-	var err error
-	var authConfigs map[string]AuthConfig
-	repoData, ok := secret.Data[string(v1.DockerConfigKey)]
-
-	if !ok {
-		return ips, fmt.Errorf("no dockercfg present in secret")
-	}
-
-	err = json.Unmarshal(repoData, &authConfigs)
+func (p *ACIProvider) getImagePullSecrets(pod *v1.Pod) ([]aci.ImageRegistryCredential, error) {
+	keyring, err := pullsecrets.ForPod(p.resourceManager, pod)
 	if err != nil {
-		return ips, err
+		return nil, err
 	}
-
-	for server := range authConfigs {
-		cred, err := makeRegistryCredential(server, authConfigs[server])
+	ips := make([]aci.ImageRegistryCredential, 0, len(keyring.Credentials()))
+	for _, cred := range keyring.Credentials() {
+		ip, err := makeRegistryCredential(cred)
 		if err != nil {
 			return ips, err
 		}
-
-		ips = append(ips, *cred)
+		ips = append(ips, *ip)
 	}
-
-	return ips, err
+	return ips, nil
 }
 
-func readDockerConfigJSONSecret(secret *v1.Secret, ips []aci.ImageRegistryCredential) ([]aci.ImageRegistryCredential, error) {
-	var err error
-	repoData, ok := secret.Data[string(v1.DockerConfigJsonKey)]
-
-	if !ok {
-		return ips, fmt.Errorf("no dockerconfigjson present in secret")
-	}
-
-	var authConfigs map[string]map[string]AuthConfig
-
-	err = json.Unmarshal(repoData, &authConfigs)
-	if err != nil {
-		return ips, err
-	}
-
-	auths, ok := authConfigs["auths"]
-	if !ok {
-		return ips, fmt.Errorf("malformed dockerconfigjson in secret")
+func makeRegistryCredential(cred pullsecrets.Credential) (*aci.ImageRegistryCredential, error) {
+	if cred.Username == "" {
+		return nil, fmt.Errorf("no username present in auth config for server: %s", cred.Server)
 	}
 
-	for server := range auths {
-		cred, err := makeRegistryCredential(server, auths[server])
-		if err != nil {
-			return ips, err
-		}
-
-		ips = append(ips, *cred)
-	}
-
-	return ips, err
+	return &aci.ImageRegistryCredential{
+		Server:   cred.Server,
+		Username: cred.Username,
+		Password: cred.Password,
+	}, nil
 }
 
 func (p *ACIProvider) getContainers(pod *v1.Pod) ([]aci.Container, error) {
@@ -1279,43 +1181,17 @@ func (p *ACIProvider) getVolumes(pod *v1.Pod) ([]aci.Volume, error) {
 			continue
 		}
 
-		// Handle the case for Secret volume.
-		if v.Secret != nil {
-			paths := make(map[string]string)
-			secret, err := p.resourceManager.GetSecret(v.Secret.SecretName, pod.Namespace)
-			if v.Secret.Optional != nil && !*v.Secret.Optional && k8serr.IsNotFound(err) {
-				return nil, fmt.Errorf("Secret %s is required by Pod %s and does not exist", v.Secret.SecretName, pod.Name)
-			}
-			if secret == nil {
-				continue
-			}
-
-			for k, v := range secret.Data {
-				paths[k] = base64.StdEncoding.EncodeToString(v)
-			}
-
-			if len(paths) != 0 {
-				volumes = append(volumes, aci.Volume{
-					Name:   v.Name,
-					Secret: paths,
-				})
-			}
-			continue
-		}
-
-		// Handle the case for ConfigMap volume.
-		if v.ConfigMap != nil {
-			paths := make(map[string]string)
-			configMap, err := p.resourceManager.GetConfigMap(v.ConfigMap.Name, pod.Namespace)
-			if v.ConfigMap.Optional != nil && !*v.ConfigMap.Optional && k8serr.IsNotFound(err) {
-				return nil, fmt.Errorf("ConfigMap %s is required by Pod %s and does not exist", v.ConfigMap.Name, pod.Name)
-			}
-			if configMap == nil {
-				continue
+		// Handle the case for Secret, ConfigMap, DownwardAPI and Projected volumes, which are all mapped onto ACI secret volumes.
+		// ACI doesn't support setting the mode of the files.
+		if manager.IsResolvableVolume(v) {
+			files, err := p.resourceManager.ResolveVolume(pod, v)
+			if err != nil {
+				return nil, err
 			}
 
-			for k, v := range configMap.BinaryData {
-				paths[k] = base64.StdEncoding.EncodeToString(v)
+			paths := make(map[string]string, len(files))
+			for _, f := range files {
+				paths[f.Path] = base64.StdEncoding.EncodeToString(f.Data)
 			}
 
 			if len(paths) != 0 {
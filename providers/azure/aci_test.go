@@ -7,7 +7,6 @@ package azure
 import (
 	"bytes"
 	"context"
-	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -21,6 +20,7 @@ import (
 	"github.com/virtual-kubelet/virtual-kubelet/manager"
 	"github.com/virtual-kubelet/virtual-kubelet/providers/azure/client"
 	"github.com/virtual-kubelet/virtual-kubelet/providers/azure/client/aci"
+	"github.com/virtual-kubelet/virtual-kubelet/providers/pullsecrets"
 	"k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -41,49 +41,30 @@ func TestMakeRegistryCredential(t *testing.T) {
 	server := "server-" + uuid.New().String()
 	username := "user-" + uuid.New().String()
 	password := "pass-" + uuid.New().String()
-	auth := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", username, password)))
 
 	tt := []struct {
 		name        string
-		authConfig  AuthConfig
+		credential  pullsecrets.Credential
 		shouldFail  bool
 		failMessage string
 	}{
 		{
 			"Valid username and password",
-			AuthConfig{Username: username, Password: password},
-			false,
-			"",
-		},
-		{
-			"Username and password in auth",
-			AuthConfig{Auth: auth},
+			pullsecrets.Credential{Server: server, Username: username, Password: password},
 			false,
 			"",
 		},
 		{
 			"No Username",
-			AuthConfig{},
+			pullsecrets.Credential{Server: server},
 			true,
 			"no username present in auth config for server",
 		},
-		{
-			"Invalid Auth",
-			AuthConfig{Auth: "123"},
-			true,
-			"error decoding the auth for server",
-		},
-		{
-			"Malformed Auth",
-			AuthConfig{Auth: base64.StdEncoding.EncodeToString([]byte("123"))},
-			true,
-			"malformed auth for server",
-		},
 	}
 
 	for _, tc := range tt {
 		t.Run(tc.name, func(t *testing.T) {
-			cred, err := makeRegistryCredential(server, tc.authConfig)
+			cred, err := makeRegistryCredential(tc.credential)
 
 			if tc.shouldFail {
 				assert.NotNil(t, err, "convertion should fail")
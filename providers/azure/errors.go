@@ -2,8 +2,9 @@ package azure
 
 import (
 	"net/http"
+	"strings"
 
-	"github.com/cpuguy83/strongerrors"
+	"github.com/virtual-kubelet/virtual-kubelet/providers"
 	"github.com/virtual-kubelet/virtual-kubelet/providers/azure/client/api"
 )
 
@@ -17,10 +18,9 @@ func wrapError(err error) error {
 		return err
 	}
 
-	switch e.StatusCode {
-	case http.StatusNotFound:
-		return strongerrors.NotFound(err)
-	default:
-		return err
+	// ACI reports exhausted quotas (e.g. "ContainerGroupQuotaReached") using status codes that would otherwise be classified differently.
+	if strings.Contains(e.Code, "Quota") && e.StatusCode != http.StatusTooManyRequests {
+		return providers.QuotaExceeded(err)
 	}
+	return providers.FromHTTPStatus(err, e.StatusCode, e.Header)
 }
@@ -0,0 +1,421 @@
+// Package conformance implements a test suite checking that a provider honors the contract virtual-kubelet relies on.
+//
+// Providers run the suite from their own tests by passing a function that creates new instances of the provider:
+//
+//	func TestConformance(t *testing.T) {
+//		conformance.Run(t, func(cfg conformance.ProviderConfig) (providers.Provider, error) {
+//			return NewMyProvider(cfg.NodeName, cfg.ResourceManager)
+//		}, conformance.Options{})
+//	}
+package conformance
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/cpuguy83/strongerrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"github.com/virtual-kubelet/virtual-kubelet/manager"
+	"github.com/virtual-kubelet/virtual-kubelet/providers"
+	testutil "github.com/virtual-kubelet/virtual-kubelet/test/util"
+)
+
+const (
+	// defaultNamespace is the namespace in which test pods are created by default.
+	defaultNamespace = "default"
+	// defaultImage is the image used by test pods by default.
+	defaultImage = "nginx"
+	// defaultTimeout is the maximum amount of time to wait for a pod to reach the desired state by default.
+	defaultTimeout = 5 * time.Minute
+	// pollInterval is the interval at which the provider is polled while waiting for a pod to reach the desired state.
+	pollInterval = time.Second
+	// execTimeout is the maximum amount of time commands run in containers may take.
+	execTimeout = 30 * time.Second
+
+	// configMapName is the name of the configmap referenced by test pods.
+	configMapName = "vk-conformance"
+	// secretName is the name of the secret referenced by test pods.
+	secretName = "vk-conformance"
+	// configMapValue is the value of the "key" key of the configmap referenced by test pods.
+	configMapValue = "configmap-value"
+	// secretValue is the value of the "key" key of the secret referenced by test pods.
+	secretValue = "secret-value"
+)
+
+// ProviderConfig is passed to the function creating instances of the provider under test.
+type ProviderConfig struct {
+	// NodeName is the name of the virtual node the provider must serve.
+	NodeName string
+	// OperatingSystem is the operating system of the virtual node.
+	OperatingSystem string
+	// ResourceManager gives access to the configmaps and secrets referenced by test pods.
+	ResourceManager *manager.ResourceManager
+}
+
+// Constructor creates a new instance of the provider under test.
+// Instances created for different node names must not share pods, but may share the same backend.
+type Constructor func(cfg ProviderConfig) (providers.Provider, error)
+
+// Options configures the conformance suite.
+type Options struct {
+	// Namespace is the namespace in which test pods are created.
+	Namespace string
+	// Image is the image used by test pods.
+	Image string
+	// Timeout is the maximum amount of time to wait for a pod to reach the desired state (e.g. to be running).
+	Timeout time.Duration
+	// SkipVolumes skips the tests involving volumes, for providers that don't support them.
+	SkipVolumes bool
+	// SkipExec skips the checks reading files from containers by running commands in them, for providers whose ExecInContainer doesn't actually run commands.
+	SkipExec bool
+}
+
+func (o *Options) setDefaults() {
+	if o.Namespace == "" {
+		o.Namespace = defaultNamespace
+	}
+	if o.Image == "" {
+		o.Image = defaultImage
+	}
+	if o.Timeout == 0 {
+		o.Timeout = defaultTimeout
+	}
+}
+
+// suite holds the state shared by the conformance tests.
+type suite struct {
+	opts     Options
+	newFunc  Constructor
+	rm       *manager.ResourceManager
+	nodeName string
+	provider providers.Provider
+}
+
+// Run runs the conformance suite against providers created using the specified function.
+func Run(t *testing.T, newFunc Constructor, opts Options) {
+	opts.setDefaults()
+
+	s := &suite{
+		opts:     opts,
+		newFunc:  newFunc,
+		nodeName: randomName("vk-conformance"),
+		rm: testutil.FakeResourceManager(
+			testutil.FakeConfigMap(opts.Namespace, configMapName, map[string]string{"key": configMapValue}),
+			testutil.FakeSecret(opts.Namespace, secretName, map[string]string{"key": secretValue}),
+		),
+	}
+	s.provider = s.newProvider(t, s.nodeName)
+
+	t.Run("Node", s.testNode)
+	t.Run("PodLifecycle", s.testPodLifecycle)
+	t.Run("NotFound", s.testNotFound)
+	t.Run("DeleteIdempotency", s.testDeleteIdempotency)
+	t.Run("GetPodsExcludesOtherNodes", s.testGetPodsExcludesOtherNodes)
+	t.Run("Environment", s.testEnvironment)
+	if !opts.SkipVolumes {
+		t.Run("Volumes", s.testVolumes)
+	}
+}
+
+// newProvider creates a new instance of the provider under test, serving the specified node.
+func (s *suite) newProvider(t *testing.T, nodeName string) providers.Provider {
+	p, err := s.newFunc(ProviderConfig{
+		NodeName:        nodeName,
+		OperatingSystem: providers.OperatingSystemLinux,
+		ResourceManager: s.rm,
+	})
+	require.NoError(t, err, "failed to create provider")
+	require.NotNil(t, p, "failed to create provider")
+	return p
+}
+
+// newPod returns a pod scheduled on the specified node, having a single container.
+func (s *suite) newPod(nodeName string) *corev1.Pod {
+	name := randomName("vk-conformance")
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:         s.opts.Namespace,
+			Name:              name,
+			UID:               types.UID(name),
+			CreationTimestamp: metav1.Now(),
+		},
+		Spec: corev1.PodSpec{
+			NodeName:      nodeName,
+			RestartPolicy: corev1.RestartPolicyAlways,
+			Containers: []corev1.Container{
+				{
+					Name:  "container-0",
+					Image: s.opts.Image,
+				},
+			},
+		},
+	}
+}
+
+// createPod creates the specified pod in the specified provider, and returns a function deleting it, to be deferred until the end of the test.
+func (s *suite) createPod(t *testing.T, p providers.Provider, pod *corev1.Pod) func() {
+	require.NoError(t, p.CreatePod(context.Background(), pod), "CreatePod must succeed for a valid pod")
+	return func() {
+		p.DeletePod(context.Background(), pod)
+	}
+}
+
+// waitForRunning waits for the specified pod to be reported as running, and returns its status.
+func (s *suite) waitForRunning(t *testing.T, p providers.Provider, pod *corev1.Pod) *corev1.PodStatus {
+	var status *corev1.PodStatus
+	err := wait.PollImmediate(pollInterval, s.opts.Timeout, func() (bool, error) {
+		var err error
+		status, err = p.GetPodStatus(context.Background(), pod.Namespace, pod.Name)
+		if err != nil {
+			return false, err
+		}
+		if status == nil {
+			return false, fmt.Errorf("no status reported for pod %s/%s", pod.Namespace, pod.Name)
+		}
+		if status.Phase == corev1.PodFailed || status.Phase == corev1.PodSucceeded {
+			return false, fmt.Errorf("pod %s/%s terminated (phase %q, reason %q)", pod.Namespace, pod.Name, status.Phase, status.Reason)
+		}
+		return status.Phase == corev1.PodRunning, nil
+	})
+	require.NoError(t, err, "pod must eventually be running")
+	return status
+}
+
+// assertPodNotFound asserts that the provider reports the specified pod as missing.
+// vkubelet accepts both (nil, nil) and (nil, NotFound) from GetPod and GetPodStatus.
+func (s *suite) assertPodNotFound(t *testing.T, p providers.Provider, namespace, name string) {
+	pod, err := p.GetPod(context.Background(), namespace, name)
+	assert.Nil(t, pod, "GetPod must not return a pod that doesn't exist")
+	if err != nil {
+		assert.True(t, strongerrors.IsNotFound(err), "GetPod must return either no error or a NotFound error for a pod that doesn't exist, got: %v", err)
+	}
+
+	status, err := p.GetPodStatus(context.Background(), namespace, name)
+	assert.Nil(t, status, "GetPodStatus must not return a status for a pod that doesn't exist")
+	if err != nil {
+		assert.True(t, strongerrors.IsNotFound(err), "GetPodStatus must return either no error or a NotFound error for a pod that doesn't exist, got: %v", err)
+	}
+}
+
+// readFile returns the contents of the specified file in the specified container, by running "cat" in it.
+func (s *suite) readFile(t *testing.T, p providers.Provider, pod *corev1.Pod, container, path string) string {
+	var stdout, stderr bytes.Buffer
+	// The pod name is built the same way as in vkubelet's exec handler.
+	name := fmt.Sprintf("%s-%s", pod.Namespace, pod.Name)
+	err := p.ExecInContainer(name, pod.UID, container, []string{"cat", path}, nil, nopWriteCloser{&stdout}, nopWriteCloser{&stderr}, false, nil, execTimeout)
+	require.NoError(t, err, "ExecInContainer must succeed for a running container (stderr: %q)", stderr.String())
+	return stdout.String()
+}
+
+// randomName returns a random name having the specified prefix.
+func randomName(prefix string) string {
+	return fmt.Sprintf("%s-%08x", prefix, rand.Uint32())
+}
+
+// findPod returns the pod having the specified namespace and name in the specified list, if any.
+func findPod(pods []*corev1.Pod, namespace, name string) *corev1.Pod {
+	for _, pod := range pods {
+		if pod.Namespace == namespace && pod.Name == name {
+			return pod
+		}
+	}
+	return nil
+}
+
+// testNode checks that the provider reports sane node information.
+func (s *suite) testNode(t *testing.T) {
+	ctx := context.Background()
+
+	capacity := s.provider.Capacity(ctx)
+	for _, name := range []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory, corev1.ResourcePods} {
+		q, ok := capacity[name]
+		if assert.True(t, ok, "capacity must include %q", name) {
+			assert.True(t, q.Cmp(resource.MustParse("0")) > 0, "capacity for %q must be positive, got %s", name, q.String())
+		}
+	}
+
+	var ready *corev1.NodeCondition
+	for _, c := range s.provider.NodeConditions(ctx) {
+		c := c
+		if c.Type == corev1.NodeReady {
+			ready = &c
+		}
+	}
+	assert.NotNil(t, ready, "node conditions must include %q", corev1.NodeReady)
+
+	assert.NotNil(t, s.provider.NodeDaemonEndpoints(ctx), "node daemon endpoints must not be nil")
+	assert.True(t, providers.ValidOperatingSystems[s.provider.OperatingSystem()], "operating system must be one of %v, got %q", providers.ValidOperatingSystems.Names(), s.provider.OperatingSystem())
+}
+
+// testPodLifecycle checks that a pod can be created, retrieved, reported as running and deleted.
+func (s *suite) testPodLifecycle(t *testing.T) {
+	ctx := context.Background()
+	pod := s.newPod(s.nodeName)
+	deletePod := s.createPod(t, s.provider, pod)
+	defer deletePod()
+
+	res, err := s.provider.GetPod(ctx, pod.Namespace, pod.Name)
+	require.NoError(t, err, "GetPod must succeed for an existing pod")
+	require.NotNil(t, res, "GetPod must return an existing pod")
+	assert.Equal(t, pod.Namespace, res.Namespace)
+	assert.Equal(t, pod.Name, res.Name)
+
+	status := s.waitForRunning(t, s.provider, pod)
+	assert.Len(t, status.ContainerStatuses, len(pod.Spec.Containers), "a status must be reported for each container")
+	for _, cs := range status.ContainerStatuses {
+		assert.NotNil(t, cs.State.Running, "container %q of a running pod must be running", cs.Name)
+	}
+
+	pods, err := s.provider.GetPods(ctx)
+	require.NoError(t, err)
+	assert.NotNil(t, findPod(pods, pod.Namespace, pod.Name), "GetPods must include existing pods")
+
+	require.NoError(t, s.provider.DeletePod(ctx, pod), "DeletePod must succeed for an existing pod")
+	err = wait.PollImmediate(pollInterval, s.opts.Timeout, func() (bool, error) {
+		res, err := s.provider.GetPod(ctx, pod.Namespace, pod.Name)
+		if err != nil && !strongerrors.IsNotFound(err) {
+			return false, err
+		}
+		return res == nil, nil
+	})
+	require.NoError(t, err, "pod must eventually be deleted")
+	s.assertPodNotFound(t, s.provider, pod.Namespace, pod.Name)
+
+	pods, err = s.provider.GetPods(ctx)
+	require.NoError(t, err)
+	assert.Nil(t, findPod(pods, pod.Namespace, pod.Name), "GetPods must not include deleted pods")
+}
+
+// testNotFound checks that missing pods are reported as such.
+func (s *suite) testNotFound(t *testing.T) {
+	s.assertPodNotFound(t, s.provider, s.opts.Namespace, randomName("vk-conformance-missing"))
+}
+
+// testDeleteIdempotency checks that deleting a pod that was already deleted either succeeds or returns a NotFound error.
+func (s *suite) testDeleteIdempotency(t *testing.T) {
+	ctx := context.Background()
+	pod := s.newPod(s.nodeName)
+	deletePod := s.createPod(t, s.provider, pod)
+	defer deletePod()
+
+	require.NoError(t, s.provider.DeletePod(ctx, pod))
+	if err := s.provider.DeletePod(ctx, pod); err != nil {
+		assert.True(t, strongerrors.IsNotFound(err), "DeletePod must return either no error or a NotFound error for a pod that was already deleted, got: %v", err)
+	}
+}
+
+// testGetPodsExcludesOtherNodes checks that GetPods only returns the pods of the node served by the provider.
+func (s *suite) testGetPodsExcludesOtherNodes(t *testing.T) {
+	ctx := context.Background()
+	other := s.newProvider(t, s.nodeName+"-other")
+	pod := s.newPod(s.nodeName + "-other")
+	deletePod := s.createPod(t, other, pod)
+	defer deletePod()
+
+	pods, err := other.GetPods(ctx)
+	require.NoError(t, err)
+	assert.NotNil(t, findPod(pods, pod.Namespace, pod.Name), "GetPods must include the node's pods")
+
+	pods, err = s.provider.GetPods(ctx)
+	require.NoError(t, err)
+	assert.Nil(t, findPod(pods, pod.Namespace, pod.Name), "GetPods must not include other nodes' pods")
+}
+
+// testEnvironment checks that pods defining environment variables can be run.
+// Environment variables are resolved by virtual-kubelet before pods are handed to providers, so only literal values are used.
+func (s *suite) testEnvironment(t *testing.T) {
+	ctx := context.Background()
+	pod := s.newPod(s.nodeName)
+	pod.Spec.Containers[0].Env = []corev1.EnvVar{
+		{Name: "FOO", Value: "bar"},
+		{Name: "EMPTY", Value: ""},
+	}
+	deletePod := s.createPod(t, s.provider, pod)
+	defer deletePod()
+
+	res, err := s.provider.GetPod(ctx, pod.Namespace, pod.Name)
+	require.NoError(t, err)
+	require.NotNil(t, res)
+	if assert.Len(t, res.Spec.Containers, 1) {
+		assert.Contains(t, res.Spec.Containers[0].Env, corev1.EnvVar{Name: "FOO", Value: "bar"}, "GetPod must report the container's environment")
+	}
+	s.waitForRunning(t, s.provider, pod)
+}
+
+// testVolumes checks that pods mounting configmap, secret, downward API and emptyDir volumes can be run, and that the files of the volumes have the expected contents.
+func (s *suite) testVolumes(t *testing.T) {
+	pod := s.newPod(s.nodeName)
+	pod.Spec.Volumes = []corev1.Volume{
+		{
+			Name: "configmap",
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{LocalObjectReference: corev1.LocalObjectReference{Name: configMapName}},
+			},
+		},
+		{
+			Name: "secret",
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{SecretName: secretName},
+			},
+		},
+		{
+			Name: "podinfo",
+			VolumeSource: corev1.VolumeSource{
+				DownwardAPI: &corev1.DownwardAPIVolumeSource{
+					Items: []corev1.DownwardAPIVolumeFile{
+						{Path: "name", FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.name"}},
+						{Path: "namespace", FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.namespace"}},
+					},
+				},
+			},
+		},
+		{
+			Name: "scratch",
+			VolumeSource: corev1.VolumeSource{
+				EmptyDir: &corev1.EmptyDirVolumeSource{},
+			},
+		},
+	}
+	pod.Spec.Containers[0].VolumeMounts = []corev1.VolumeMount{
+		{Name: "configmap", MountPath: "/etc/configmap"},
+		{Name: "secret", MountPath: "/etc/secret", ReadOnly: true},
+		{Name: "podinfo", MountPath: "/etc/podinfo"},
+		{Name: "scratch", MountPath: "/scratch"},
+	}
+	deletePod := s.createPod(t, s.provider, pod)
+	defer deletePod()
+	s.waitForRunning(t, s.provider, pod)
+	if s.opts.SkipExec {
+		return
+	}
+
+	container := pod.Spec.Containers[0].Name
+	for path, expected := range map[string]string{
+		"/etc/configmap/key":     configMapValue,
+		"/etc/secret/key":        secretValue,
+		"/etc/podinfo/name":      pod.Name,
+		"/etc/podinfo/namespace": pod.Namespace,
+	} {
+		assert.Equal(t, expected, s.readFile(t, s.provider, pod, container, path), "%s must have the expected contents", path)
+	}
+}
+
+// nopWriteCloser wraps a bytes.Buffer so it can be used where an io.WriteCloser is required.
+type nopWriteCloser struct {
+	*bytes.Buffer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
@@ -0,0 +1,171 @@
+// +build linux
+
+package cri
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+	criapi "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+// Maximum age of the state of the pods read by provider functions
+// Pods are polled at most this often, and whenever the provider changed them
+const podCacheMaxAge = time.Second
+
+// podCache holds the state of the pod sandboxes and containers of the runtime, indexed by pod UID and by namespace and name
+// It is refreshed incrementally: sandboxes and containers are listed, but their status is only requested again when their state changed
+// The CRIPods it returns are never modified, and may be used after the cache is refreshed
+type podCache struct {
+	maxAge time.Duration
+
+	mu         sync.RWMutex
+	byUID      map[types.UID]*CRIPod
+	byName     map[string]*CRIPod                  // Indexed by namespace/name
+	sandboxes  map[string]*criapi.PodSandboxStatus // Indexed by sandbox ID
+	containers map[string]*criapi.ContainerStatus  // Indexed by container ID
+	refreshed  time.Time
+	generation uint64 // Incremented whenever the cache is invalidated
+	fresh      uint64 // Generation of the state held by the cache
+
+	refreshLock sync.Mutex // Serializes refreshes
+}
+
+func newPodCache(maxAge time.Duration) *podCache {
+	return &podCache{
+		maxAge:     maxAge,
+		byUID:      make(map[types.UID]*CRIPod),
+		byName:     make(map[string]*CRIPod),
+		sandboxes:  make(map[string]*criapi.PodSandboxStatus),
+		containers: make(map[string]*criapi.ContainerStatus),
+	}
+}
+
+// Mark the state held by the cache as outdated, so that it is refreshed when it is next read
+// This must be called after every change made to the pods, so that the change is seen by the next reads
+func (c *podCache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+}
+
+// Refresh the cache if it was invalidated, or if its state is older than its maximum age
+func (c *podCache) refreshIfStale(client criapi.RuntimeServiceClient) error {
+	c.refreshLock.Lock()
+	defer c.refreshLock.Unlock()
+
+	c.mu.RLock()
+	generation := c.generation
+	stale := c.fresh != generation || time.Since(c.refreshed) >= c.maxAge
+	c.mu.RUnlock()
+	if !stale {
+		return nil
+	}
+	return c.refresh(client, generation)
+}
+
+// Read the current state of the pods from the runtime, only requesting the status of the sandboxes and containers which changed
+// Changes made after the cache was invalidated for the given generation may not be seen
+func (c *podCache) refresh(client criapi.RuntimeServiceClient, generation uint64) error {
+	refreshed := time.Now()
+	sandboxes, err := getPodSandboxes(client)
+	if err != nil {
+		return err
+	}
+	// List the containers of all the sandboxes
+	containers, err := getContainersForSandbox(client, "")
+	if err != nil {
+		return err
+	}
+
+	c.mu.RLock()
+	oldSandboxes, oldContainers := c.sandboxes, c.containers
+	c.mu.RUnlock()
+
+	sandboxStatuses := make(map[string]*criapi.PodSandboxStatus, len(sandboxes))
+	for _, ps := range sandboxes {
+		status, ok := oldSandboxes[ps.Id]
+		if !ok || status.State != ps.State {
+			status, err = getPodSandboxStatus(client, ps.Id)
+			if err != nil {
+				return err
+			}
+		}
+		sandboxStatuses[ps.Id] = status
+	}
+
+	containerStatuses := make(map[string]*criapi.ContainerStatus, len(containers))
+	containersOf := make(map[string][]*criapi.ContainerStatus)
+	for _, container := range containers {
+		if _, ok := sandboxStatuses[container.PodSandboxId]; !ok {
+			// The sandbox was created after the sandboxes were listed
+			continue
+		}
+		status, ok := oldContainers[container.Id]
+		if !ok || status.State != container.State {
+			status, err = getContainerCRIStatus(client, container.Id)
+			if err != nil {
+				return err
+			}
+		}
+		containerStatuses[container.Id] = status
+		containersOf[container.PodSandboxId] = append(containersOf[container.PodSandboxId], status)
+	}
+
+	byUID := make(map[types.UID]*CRIPod, len(sandboxStatuses))
+	byName := make(map[string]*CRIPod, len(sandboxStatuses))
+	for id, status := range sandboxStatuses {
+		cp := newCRIPod(id, status, containersOf[id])
+		uid := types.UID(status.Metadata.Uid)
+		if other, ok := byUID[uid]; !ok || isPreferredSandbox(cp, other) {
+			byUID[uid] = cp
+		}
+		name := status.Metadata.Namespace + "/" + status.Metadata.Name
+		if other, ok := byName[name]; !ok || isPreferredSandbox(cp, other) {
+			byName[name] = cp
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.byUID, c.byName = byUID, byName
+	c.sandboxes, c.containers = sandboxStatuses, containerStatuses
+	c.refreshed, c.fresh = refreshed, generation
+	return nil
+}
+
+// Decide which of two sandboxes of the same pod represents it: the ready one, or else the most recent one
+func isPreferredSandbox(cp, other *CRIPod) bool {
+	ready := cp.status.State == criapi.PodSandboxState_SANDBOX_READY
+	otherReady := other.status.State == criapi.PodSandboxState_SANDBOX_READY
+	if ready != otherReady {
+		return ready
+	}
+	return cp.status.CreatedAt > other.status.CreatedAt
+}
+
+// Get the pod with the given UID, or nil
+func (c *podCache) getByUID(uid types.UID) *CRIPod {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.byUID[uid]
+}
+
+// Get the pod with the given namespace and name, or nil
+func (c *podCache) getByName(namespace, name string) *CRIPod {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.byName[namespace+"/"+name]
+}
+
+// List all the pods, one per UID
+func (c *podCache) list() []*CRIPod {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	pods := make([]*CRIPod, 0, len(c.byUID))
+	for _, cp := range c.byUID {
+		pods = append(pods, cp)
+	}
+	return pods
+}
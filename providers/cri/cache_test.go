@@ -0,0 +1,91 @@
+// +build linux
+
+package cri
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+	"k8s.io/api/core/v1"
+	criapi "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+func TestPodCacheRefresh(t *testing.T) {
+	p, service, cleanup := newFakeRuntime(t)
+	defer cleanup()
+	p.pods = newPodCache(time.Hour)
+	ctx := context.Background()
+
+	status, err := p.GetPodStatus(ctx, "default", "pod")
+	require.NoError(t, err)
+	assert.NotNil(t, status.ContainerStatuses[0].State.Running)
+	assert.Equal(t, 2, service.statusRPCs, "the status of the sandbox and of its container should have been requested")
+
+	// The cache is used until it gets too old.
+	service.exitContainer(fakeContainerId, 1)
+	status, err = p.GetPodStatus(ctx, "default", "pod")
+	require.NoError(t, err)
+	assert.NotNil(t, status.ContainerStatuses[0].State.Running)
+	assert.Equal(t, 2, service.statusRPCs)
+
+	// Only the status of what changed is requested again.
+	p.pods.maxAge = 0
+	status, err = p.GetPodStatus(ctx, "default", "pod")
+	require.NoError(t, err)
+	assert.NotNil(t, status.ContainerStatuses[0].State.Terminated)
+	assert.Equal(t, 3, service.statusRPCs)
+	_, err = p.GetPods(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 3, service.statusRPCs)
+
+	// Changes made by the provider are seen right away.
+	p.pods.maxAge = time.Hour
+	pod := newSyncTestPod(v1.RestartPolicyAlways, "app")
+	require.NoError(t, p.CreatePod(ctx, pod))
+	status, err = p.GetPodStatus(ctx, pod.Namespace, pod.Name)
+	require.NoError(t, err)
+	assert.Len(t, status.ContainerStatuses, 1)
+	require.NotNil(t, p.pods.getByUID(pod.UID))
+
+	require.NoError(t, p.DeletePod(ctx, pod))
+	_, err = p.GetPodStatus(ctx, pod.Namespace, pod.Name)
+	assert.Error(t, err)
+	assert.Nil(t, p.pods.getByUID(pod.UID))
+}
+
+func TestPodCacheIndexes(t *testing.T) {
+	p, service, cleanup := newFakeRuntime(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	pod := newSyncTestPod(v1.RestartPolicyAlways, "app")
+	require.NoError(t, p.CreatePod(ctx, pod))
+
+	// A stale sandbox of the same pod doesn't hide the ready one.
+	service.mu.Lock()
+	service.sandboxes["sandbox-stale"] = &criapi.PodSandboxStatus{
+		Id:        "sandbox-stale",
+		Metadata:  &criapi.PodSandboxMetadata{Name: pod.Name, Namespace: pod.Namespace, Uid: string(pod.UID)},
+		State:     criapi.PodSandboxState_SANDBOX_NOTREADY,
+		CreatedAt: time.Now().Add(time.Hour).UnixNano(),
+		Network:   &criapi.PodSandboxNetworkStatus{},
+	}
+	service.mu.Unlock()
+
+	pods, err := p.GetPods(ctx)
+	require.NoError(t, err)
+	require.Len(t, pods, 2)
+	names := []string{pods[0].Name, pods[1].Name}
+	assert.Contains(t, names, "pod")
+	assert.Contains(t, names, pod.Name)
+
+	found := p.findPodByName(pod.Namespace, pod.Name)
+	require.NotNil(t, found)
+	assert.NotEqual(t, "sandbox-stale", found.id)
+	assert.Equal(t, found, p.pods.getByUID(pod.UID))
+	assert.Equal(t, fakeSandboxId, p.findPodByName("default", "pod").id)
+	assert.Nil(t, p.findPodByName("other", "pod"))
+}
@@ -2,6 +2,7 @@ package cri
 
 import (
 	"fmt"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"golang.org/x/net/context"
@@ -9,8 +10,8 @@ import (
 )
 
 // Call RunPodSandbox on the CRI client
-func runPodSandbox(client criapi.RuntimeServiceClient, config *criapi.PodSandboxConfig) (string, error) {
-	request := &criapi.RunPodSandboxRequest{Config: config}
+func runPodSandbox(client criapi.RuntimeServiceClient, config *criapi.PodSandboxConfig, runtimeHandler string) (string, error) {
+	request := &criapi.RunPodSandboxRequest{Config: config, RuntimeHandler: runtimeHandler}
 	log.Debugf("RunPodSandboxRequest: %v", request)
 	r, err := client.RunPodSandbox(context.Background(), request)
 	log.Debugf("RunPodSandboxResponse: %v", r)
@@ -127,6 +128,43 @@ func startContainer(client criapi.RuntimeServiceClient, cId string) error {
 	return nil
 }
 
+// Call StopContainer on the CRI client
+func stopContainer(client criapi.RuntimeServiceClient, cId string, timeout int64) error {
+	if cId == "" {
+		return fmt.Errorf("ID cannot be empty")
+	}
+	request := &criapi.StopContainerRequest{
+		ContainerId: cId,
+		Timeout:     timeout,
+	}
+	log.Debugf("StopContainerRequest: %v", request)
+	r, err := client.StopContainer(context.Background(), request)
+	log.Debugf("StopContainerResponse: %v", r)
+	if err != nil {
+		return err
+	}
+	log.Printf("Container stopped: %s\n", cId)
+	return nil
+}
+
+// Call RemoveContainer on the CRI client
+func removeContainer(client criapi.RuntimeServiceClient, cId string) error {
+	if cId == "" {
+		return fmt.Errorf("ID cannot be empty")
+	}
+	request := &criapi.RemoveContainerRequest{
+		ContainerId: cId,
+	}
+	log.Debugf("RemoveContainerRequest: %v", request)
+	r, err := client.RemoveContainer(context.Background(), request)
+	log.Debugf("RemoveContainerResponse: %v", r)
+	if err != nil {
+		return err
+	}
+	log.Printf("Container removed: %s\n", cId)
+	return nil
+}
+
 // Call ContainerStatus on the CRI client
 func getContainerCRIStatus(client criapi.RuntimeServiceClient, cId string) (*criapi.ContainerStatus, error) {
 	if cId == "" {
@@ -164,13 +202,15 @@ func getContainersForSandbox(client criapi.RuntimeServiceClient, psId string) ([
 }
 
 // Pull and image on the CRI client and return the image ref
-func pullImage(client criapi.ImageServiceClient, image string) (string, error) {
+// The auth config, if any, is not logged as it holds registry credentials.
+func pullImage(client criapi.ImageServiceClient, image string, auth *criapi.AuthConfig) (string, error) {
 	request := &criapi.PullImageRequest{
 		Image: &criapi.ImageSpec{
 			Image: image,
 		},
+		Auth: auth,
 	}
-	log.Debugf("PullImageRequest: %v", request)
+	log.Debugf("PullImageRequest: %v (with credentials: %t)", request.Image, auth != nil)
 	r, err := client.PullImage(context.Background(), request)
 	log.Debugf("PullImageResponse: %v", r)
 	if err != nil {
@@ -179,3 +219,146 @@ func pullImage(client criapi.ImageServiceClient, image string) (string, error) {
 
 	return r.ImageRef, nil
 }
+
+// Call ImageStatus on the CRI client and return the image, or nil if it isn't present
+func getImageStatus(client criapi.ImageServiceClient, image string) (*criapi.Image, error) {
+	request := &criapi.ImageStatusRequest{
+		Image: &criapi.ImageSpec{
+			Image: image,
+		},
+	}
+	log.Debugf("ImageStatusRequest: %v", request)
+	r, err := client.ImageStatus(context.Background(), request)
+	log.Debugf("ImageStatusResponse: %v", r)
+	if err != nil {
+		return nil, err
+	}
+	return r.Image, nil
+}
+
+// Call ListImages on the CRI client
+func listImages(client criapi.ImageServiceClient) ([]*criapi.Image, error) {
+	request := &criapi.ListImagesRequest{}
+	log.Debugf("ListImagesRequest: %v", request)
+	r, err := client.ListImages(context.Background(), request)
+	log.Debugf("ListImagesResponse: %v", r)
+	if err != nil {
+		return nil, err
+	}
+	return r.Images, nil
+}
+
+// Call RemoveImage on the CRI client
+func removeImage(client criapi.ImageServiceClient, image string) error {
+	request := &criapi.RemoveImageRequest{
+		Image: &criapi.ImageSpec{
+			Image: image,
+		},
+	}
+	log.Debugf("RemoveImageRequest: %v", request)
+	r, err := client.RemoveImage(context.Background(), request)
+	log.Debugf("RemoveImageResponse: %v", r)
+	return err
+}
+
+// Call ListContainerStats on the CRI client
+func listContainerStats(client criapi.RuntimeServiceClient) ([]*criapi.ContainerStats, error) {
+	request := &criapi.ListContainerStatsRequest{}
+	log.Debugf("ListContainerStatsRequest: %v", request)
+	r, err := client.ListContainerStats(context.Background(), request)
+	log.Debugf("ListContainerStatsResponse: %v", r)
+	if err != nil {
+		return nil, err
+	}
+	return r.Stats, nil
+}
+
+// Call PodSandboxStatus on the CRI client and return the runtime-specific information about the sandbox
+func getPodSandboxInfo(client criapi.RuntimeServiceClient, psId string) (map[string]string, error) {
+	if psId == "" {
+		return nil, fmt.Errorf("Pod ID cannot be empty in GPSS")
+	}
+	request := &criapi.PodSandboxStatusRequest{
+		PodSandboxId: psId,
+		Verbose:      true,
+	}
+	log.Debugf("PodSandboxStatusRequest: %v", request)
+	r, err := client.PodSandboxStatus(context.Background(), request)
+	if err != nil {
+		return nil, err
+	}
+	return r.Info, nil
+}
+
+// Call ImageFsInfo on the CRI client
+func getImageFsInfo(client criapi.ImageServiceClient) ([]*criapi.FilesystemUsage, error) {
+	request := &criapi.ImageFsInfoRequest{}
+	log.Debugf("ImageFsInfoRequest: %v", request)
+	r, err := client.ImageFsInfo(context.Background(), request)
+	log.Debugf("ImageFsInfoResponse: %v", r)
+	if err != nil {
+		return nil, err
+	}
+	return r.ImageFilesystems, nil
+}
+
+// Call ExecSync on the CRI client
+func execSync(client criapi.RuntimeServiceClient, containerId string, cmd []string, timeout time.Duration) (*criapi.ExecSyncResponse, error) {
+	if containerId == "" {
+		return nil, fmt.Errorf("Container ID cannot be empty")
+	}
+	request := &criapi.ExecSyncRequest{
+		ContainerId: containerId,
+		Cmd:         cmd,
+		Timeout:     int64(timeout.Seconds()),
+	}
+	log.Debugf("ExecSyncRequest: %v", request)
+	r, err := client.ExecSync(context.Background(), request)
+	log.Debugf("ExecSyncResponse: %v", r)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Call Exec on the CRI client
+func getExec(client criapi.RuntimeServiceClient, request *criapi.ExecRequest) (string, error) {
+	if request.ContainerId == "" {
+		return "", fmt.Errorf("Container ID cannot be empty")
+	}
+	log.Debugf("ExecRequest: %v", request)
+	r, err := client.Exec(context.Background(), request)
+	log.Debugf("ExecResponse: %v", r)
+	if err != nil {
+		return "", err
+	}
+	return r.Url, nil
+}
+
+// Call Attach on the CRI client
+func getAttach(client criapi.RuntimeServiceClient, request *criapi.AttachRequest) (string, error) {
+	if request.ContainerId == "" {
+		return "", fmt.Errorf("Container ID cannot be empty")
+	}
+	log.Debugf("AttachRequest: %v", request)
+	r, err := client.Attach(context.Background(), request)
+	log.Debugf("AttachResponse: %v", r)
+	if err != nil {
+		return "", err
+	}
+	return r.Url, nil
+}
+
+// Call PortForward on the CRI client
+func getPortForward(client criapi.RuntimeServiceClient, request *criapi.PortForwardRequest) (string, error) {
+	if request.PodSandboxId == "" {
+		return "", fmt.Errorf("Pod ID cannot be empty")
+	}
+	log.Debugf("PortForwardRequest: %v", request)
+	r, err := client.PortForward(context.Background(), request)
+	log.Debugf("PortForwardResponse: %v", r)
+	if err != nil {
+		return "", err
+	}
+	return r.Url, nil
+}
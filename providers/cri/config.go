@@ -0,0 +1,181 @@
+// +build linux
+
+package cri
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	// Provider configuration defaults.
+	defaultPodCapacity       = "1000"
+	defaultConnectionTimeout = 10 * time.Second
+	defaultRequestTimeout    = 2 * time.Minute
+	defaultImagePullTimeout  = 0 // Image pulls are not limited in time by default
+	defaultImageGCHighThresh = 0 // Images are not garbage collected by default
+	defaultImageGCLowThresh  = 80
+	defaultImageGCPeriod     = 5 * time.Minute
+)
+
+// Sockets of the well-known CRI runtimes, which may be used as runtime or image endpoints by name
+var knownEndpoints = map[string]string{
+	"containerd": "/run/containerd/containerd.sock",
+	"crio":       "/var/run/crio/crio.sock",
+	"cri-o":      "/var/run/crio/crio.sock",
+}
+
+// duration is a time.Duration read from a string such as "30s" in configuration files
+type duration struct {
+	time.Duration
+}
+
+// UnmarshalText parses the duration from its string representation
+func (d *duration) UnmarshalText(text []byte) error {
+	var err error
+	d.Duration, err = time.ParseDuration(string(text))
+	return err
+}
+
+// providerConfig represents the contents of the provider configuration file
+type providerConfig struct {
+	// RuntimeEndpoint is the unix socket of the runtime service, or the name of a well-known runtime ("containerd" or "crio")
+	RuntimeEndpoint string
+	// ImageEndpoint is the unix socket of the image service, which defaults to RuntimeEndpoint
+	ImageEndpoint string
+	// PodLogRoot is the directory where the logs of pods are written
+	PodLogRoot string
+	// PodVolRoot is the directory where the volumes of pods are created
+	PodVolRoot string
+	// RuntimeHandler is the runtime handler used to run pods which don't specify a runtime class
+	RuntimeHandler string
+	// RuntimeClasses maps runtime class names to runtime handlers. Runtime classes which aren't mapped use the handler of the same name
+	RuntimeClasses map[string]string
+	// Pods is the maximum number of pods advertised by the node
+	Pods string
+	// ConnectionTimeout is the maximum time spent connecting to the runtime
+	ConnectionTimeout duration
+	// RequestTimeout is the maximum time spent on requests to the runtime, except for image pulls
+	RequestTimeout duration
+	// ImagePullTimeout is the maximum time spent pulling an image, if not zero
+	ImagePullTimeout duration
+	// ImageGCHighThresholdPercent is the usage of the image filesystem above which unused images are removed, if not zero
+	// The node reports disk pressure while the usage can't be brought back below ImageGCLowThresholdPercent
+	ImageGCHighThresholdPercent int
+	// ImageGCLowThresholdPercent is the usage of the image filesystem down to which unused images are removed
+	ImageGCLowThresholdPercent int
+	// ImageGCPeriod is the interval at which the usage of the image filesystem is checked
+	ImageGCPeriod duration
+}
+
+// Load the provider configuration from the given file, which is parsed as JSON if it has a ".json" extension, and as TOML otherwise
+// The default configuration is returned if the path is empty
+func loadConfigFile(filePath string) (*providerConfig, error) {
+	if filePath == "" {
+		return loadConfig(strings.NewReader(""), false)
+	}
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return loadConfig(f, filepath.Ext(filePath) == ".json")
+}
+
+// Load the provider configuration from the given TOML or JSON stream, and validate it
+func loadConfig(r io.Reader, isJSON bool) (*providerConfig, error) {
+	// Set defaults for optional fields.
+	config := providerConfig{
+		RuntimeEndpoint:   CriSocketPath,
+		PodLogRoot:        PodLogRoot,
+		PodVolRoot:        PodVolRoot,
+		Pods:              defaultPodCapacity,
+		ConnectionTimeout: duration{defaultConnectionTimeout},
+		RequestTimeout:    duration{defaultRequestTimeout},
+		ImagePullTimeout:  duration{defaultImagePullTimeout},
+
+		ImageGCHighThresholdPercent: defaultImageGCHighThresh,
+		ImageGCLowThresholdPercent:  defaultImageGCLowThresh,
+		ImageGCPeriod:               duration{defaultImageGCPeriod},
+	}
+
+	// Read the user-supplied configuration.
+	if isJSON {
+		if err := json.NewDecoder(r).Decode(&config); err != nil && err != io.EOF {
+			return nil, err
+		}
+	} else {
+		if _, err := toml.DecodeReader(r, &config); err != nil {
+			return nil, err
+		}
+	}
+
+	// Validate aggregate configuration.
+	var err error
+	if config.RuntimeEndpoint, err = resolveEndpoint(config.RuntimeEndpoint); err != nil {
+		return nil, fmt.Errorf("Invalid runtime endpoint: %v", err)
+	}
+	if config.ImageEndpoint == "" {
+		config.ImageEndpoint = config.RuntimeEndpoint
+	} else if config.ImageEndpoint, err = resolveEndpoint(config.ImageEndpoint); err != nil {
+		return nil, fmt.Errorf("Invalid image endpoint: %v", err)
+	}
+	if !filepath.IsAbs(config.PodLogRoot) {
+		return nil, fmt.Errorf("PodLogRoot must be an absolute path, got %q", config.PodLogRoot)
+	}
+	if !filepath.IsAbs(config.PodVolRoot) {
+		return nil, fmt.Errorf("PodVolRoot must be an absolute path, got %q", config.PodVolRoot)
+	}
+	q, err := resource.ParseQuantity(config.Pods)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid pods value %v", config.Pods)
+	}
+	if q.Sign() <= 0 {
+		return nil, fmt.Errorf("Pods value %v must be positive", config.Pods)
+	}
+	if config.ConnectionTimeout.Duration <= 0 {
+		return nil, fmt.Errorf("ConnectionTimeout must be positive, got %v", config.ConnectionTimeout.Duration)
+	}
+	if config.RequestTimeout.Duration < 0 || config.ImagePullTimeout.Duration < 0 {
+		return nil, fmt.Errorf("Timeouts cannot be negative")
+	}
+	if config.ImageGCHighThresholdPercent != 0 {
+		if config.ImageGCHighThresholdPercent < 0 || config.ImageGCHighThresholdPercent > 100 {
+			return nil, fmt.Errorf("ImageGCHighThresholdPercent must be between 0 and 100, got %d", config.ImageGCHighThresholdPercent)
+		}
+		if config.ImageGCLowThresholdPercent < 0 || config.ImageGCLowThresholdPercent >= config.ImageGCHighThresholdPercent {
+			return nil, fmt.Errorf("ImageGCLowThresholdPercent must be between 0 and ImageGCHighThresholdPercent, got %d", config.ImageGCLowThresholdPercent)
+		}
+		if config.ImageGCPeriod.Duration <= 0 {
+			return nil, fmt.Errorf("ImageGCPeriod must be positive, got %v", config.ImageGCPeriod.Duration)
+		}
+	}
+
+	return &config, nil
+}
+
+// Resolve the given endpoint, which is either the name of a well-known runtime, or the path of a unix socket optionally prefixed with "unix://", to the path of a unix socket
+func resolveEndpoint(endpoint string) (string, error) {
+	if path, ok := knownEndpoints[endpoint]; ok {
+		return path, nil
+	}
+	if i := strings.Index(endpoint, "://"); i >= 0 {
+		if endpoint[:i] != "unix" {
+			return "", fmt.Errorf("unsupported protocol %q in endpoint %q, only unix sockets are supported", endpoint[:i], endpoint)
+		}
+		endpoint = endpoint[i+len("://"):]
+	}
+	if !filepath.IsAbs(endpoint) {
+		return "", fmt.Errorf("endpoint %q must be an absolute path or one of containerd and crio", endpoint)
+	}
+	return endpoint, nil
+}
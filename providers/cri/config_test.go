@@ -0,0 +1,111 @@
+// +build linux
+
+package cri
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/api/core/v1"
+)
+
+func TestLoadConfigDefaults(t *testing.T) {
+	config, err := loadConfigFile("")
+	require.NoError(t, err)
+	assert.Equal(t, CriSocketPath, config.RuntimeEndpoint)
+	assert.Equal(t, CriSocketPath, config.ImageEndpoint)
+	assert.Equal(t, PodLogRoot, config.PodLogRoot)
+	assert.Equal(t, PodVolRoot, config.PodVolRoot)
+	assert.Equal(t, "", config.RuntimeHandler)
+	assert.Equal(t, defaultPodCapacity, config.Pods)
+	assert.Equal(t, defaultConnectionTimeout, config.ConnectionTimeout.Duration)
+	assert.Equal(t, defaultRequestTimeout, config.RequestTimeout.Duration)
+	assert.Equal(t, time.Duration(0), config.ImagePullTimeout.Duration)
+	assert.Equal(t, 0, config.ImageGCHighThresholdPercent)
+}
+
+func TestLoadConfigTOML(t *testing.T) {
+	config, err := loadConfig(strings.NewReader(`
+RuntimeEndpoint = "crio"
+ImageEndpoint = "unix:///run/images.sock"
+PodLogRoot = "/var/log/pods"
+RuntimeHandler = "runc"
+Pods = "110"
+RequestTimeout = "30s"
+ImagePullTimeout = "5m"
+ImageGCHighThresholdPercent = 90
+ImageGCPeriod = "1m"
+
+[RuntimeClasses]
+sandboxed = "runsc"
+`), false)
+	require.NoError(t, err)
+	assert.Equal(t, "/var/run/crio/crio.sock", config.RuntimeEndpoint)
+	assert.Equal(t, "/run/images.sock", config.ImageEndpoint)
+	assert.Equal(t, "/var/log/pods", config.PodLogRoot)
+	assert.Equal(t, PodVolRoot, config.PodVolRoot)
+	assert.Equal(t, "runc", config.RuntimeHandler)
+	assert.Equal(t, map[string]string{"sandboxed": "runsc"}, config.RuntimeClasses)
+	assert.Equal(t, "110", config.Pods)
+	assert.Equal(t, defaultConnectionTimeout, config.ConnectionTimeout.Duration)
+	assert.Equal(t, 30*time.Second, config.RequestTimeout.Duration)
+	assert.Equal(t, 5*time.Minute, config.ImagePullTimeout.Duration)
+	assert.Equal(t, 90, config.ImageGCHighThresholdPercent)
+	assert.Equal(t, defaultImageGCLowThresh, config.ImageGCLowThresholdPercent)
+	assert.Equal(t, time.Minute, config.ImageGCPeriod.Duration)
+}
+
+func TestLoadConfigJSON(t *testing.T) {
+	config, err := loadConfig(strings.NewReader(`{
+	"runtimeEndpoint": "/run/custom.sock",
+	"podVolRoot": "/var/lib/volumes",
+	"runtimeClasses": {"kata": "kata-qemu"},
+	"connectionTimeout": "1s"
+}`), true)
+	require.NoError(t, err)
+	assert.Equal(t, "/run/custom.sock", config.RuntimeEndpoint)
+	assert.Equal(t, "/run/custom.sock", config.ImageEndpoint)
+	assert.Equal(t, "/var/lib/volumes", config.PodVolRoot)
+	assert.Equal(t, map[string]string{"kata": "kata-qemu"}, config.RuntimeClasses)
+	assert.Equal(t, time.Second, config.ConnectionTimeout.Duration)
+}
+
+func TestLoadConfigInvalid(t *testing.T) {
+	for name, data := range map[string]string{
+		"endpoint protocol": `RuntimeEndpoint = "tcp://localhost:1234"`,
+		"relative endpoint": `ImageEndpoint = "run/containerd.sock"`,
+		"relative root":     `PodLogRoot = "logs"`,
+		"pods":              `Pods = "many"`,
+		"no pods":           `Pods = "0"`,
+		"timeout":           `RequestTimeout = "soon"`,
+		"no timeout":        `ConnectionTimeout = "0s"`,
+		"negative timeout":  `ImagePullTimeout = "-1m"`,
+		"gc threshold":      `ImageGCHighThresholdPercent = 101`,
+		"gc thresholds":     "ImageGCHighThresholdPercent = 70\nImageGCLowThresholdPercent = 70",
+		"gc period":         "ImageGCHighThresholdPercent = 70\nImageGCLowThresholdPercent = 50\nImageGCPeriod = \"0s\"",
+	} {
+		_, err := loadConfig(strings.NewReader(data), false)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestRuntimeHandlerFor(t *testing.T) {
+	p := &CRIProvider{runtimeHandler: "runc", runtimeClasses: map[string]string{"sandboxed": "runsc"}}
+	runtimeClass := func(name string) *v1.Pod {
+		return &v1.Pod{Spec: v1.PodSpec{RuntimeClassName: &name}}
+	}
+	assert.Equal(t, "runc", p.runtimeHandlerFor(&v1.Pod{}))
+	assert.Equal(t, "runc", p.runtimeHandlerFor(runtimeClass("")))
+	assert.Equal(t, "runsc", p.runtimeHandlerFor(runtimeClass("sandboxed")))
+	assert.Equal(t, "kata", p.runtimeHandlerFor(runtimeClass("kata")))
+}
+
+func TestLoadConfigExample(t *testing.T) {
+	config, err := loadConfigFile("cri.toml")
+	require.NoError(t, err)
+	assert.Equal(t, CriSocketPath, config.RuntimeEndpoint)
+	assert.Empty(t, config.RuntimeClasses)
+}
@@ -6,13 +6,13 @@ import (
 	"bufio"
 	"context"
 	"fmt"
-	"io"
-	"io/ioutil"
 	"net"
 	"os"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -22,27 +22,29 @@ import (
 	"github.com/virtual-kubelet/virtual-kubelet/providers"
 	"google.golang.org/grpc"
 	"k8s.io/api/core/v1"
-	k8serr "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	types "k8s.io/apimachinery/pkg/types"
-	"k8s.io/client-go/tools/remotecommand"
 	criapi "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
 )
 
-// TODO: Make these configurable
+// Defaults of the configurable locations, see providerConfig
 const CriSocketPath = "/run/containerd/containerd.sock"
 const PodLogRoot = "/var/log/vk-cri/"
 const PodVolRoot = "/run/vk-cri/volumes/"
 const PodLogRootPerms = 0755
 const PodVolRootPerms = 0755
 const PodVolPerms = 0755
-const PodSecretVolPerms = 0755
 const PodSecretVolDir = "/secrets"
-const PodSecretFilePerms = 0644
-const PodConfigMapVolPerms = 0755
 const PodConfigMapVolDir = "/configmaps"
-const PodConfigMapFilePerms = 0644
+const PodDownwardAPIVolDir = "/downwardapi"
+const PodProjectedVolDir = "/projected"
+
+// Annotation of pod sandboxes recording the restart policy of their pod
+const RestartPolicyAnnotation = "virtual-kubelet.io/restart-policy"
+
+// Annotation of pod sandboxes recording the names of the init containers of their pod, in order
+const InitContainersAnnotation = "virtual-kubelet.io/init-containers"
 
 // CRIProvider implements the virtual-kubelet provider interface and manages pods in a CRI runtime
 // NOTE: CRIProvider is not inteded as an alternative to Kubelet, rather it's intended for testing and POC purposes
@@ -55,62 +57,62 @@ type CRIProvider struct {
 	operatingSystem    string
 	internalIP         string
 	daemonEndpointPort int32
-	podStatus          map[types.UID]CRIPod // Indexed by Pod Spec UID
+	pods               *podCache
 	runtimeClient      criapi.RuntimeServiceClient
 	imageClient        criapi.ImageServiceClient
+	runtimeHandler     string            // Runtime handler of pods without a runtime class
+	runtimeClasses     map[string]string // Runtime handlers indexed by runtime class name
+	podCapacity        resource.Quantity
+	syncers            map[types.UID]*podSyncer // Sync loops restarting the containers of pods, indexed by Pod Spec UID
+	syncersLock        sync.Mutex
+	cpuSamples         map[string]cpuSample // Last CPU usage of each container indexed by ID, and of the node, from which usage rates are computed
+	cpuSamplesLock     sync.Mutex
+	imageGC            *imageGC // Removes unused images when the image filesystem is full, if enabled
 }
 
 type CRIPod struct {
 	id         string                             // This is the CRI Pod ID, not the UID from the Pod Spec
-	containers map[string]*criapi.ContainerStatus // ContainerStatus is a superset of Container, so no need to store both
+	containers map[string]*criapi.ContainerStatus // ContainerStatus is a superset of Container, so no need to store both. Only the latest attempt of each container is kept
+	previous   map[string]*criapi.ContainerStatus // Status of the attempt preceding the latest one of each restarted container
 	status     *criapi.PodSandboxStatus           // PodStatus is a superset of PodSandbox, so no need to store both
 }
 
-// Build an internal representation of the state of the pods and containers on the node
+// Refresh the internal representation of the state of the pods and containers on the node, if it is outdated
 // Call this at the start of every function that needs to read any pod or container state
 func (p *CRIProvider) refreshNodeState() error {
-	allPods, err := getPodSandboxes(p.runtimeClient)
-	if err != nil {
-		return err
-	}
-
-	newStatus := make(map[types.UID]CRIPod)
-	for _, pod := range allPods {
-		psId := pod.Id
-
-		pss, err := getPodSandboxStatus(p.runtimeClient, psId)
-		if err != nil {
-			return err
-		}
-
-		containers, err := getContainersForSandbox(p.runtimeClient, psId)
-		if err != nil {
-			return err
-		}
+	return p.pods.refreshIfStale(p.runtimeClient)
+}
 
-		var css = make(map[string]*criapi.ContainerStatus)
-		for _, c := range containers {
-			cstatus, err := getContainerCRIStatus(p.runtimeClient, c.Id)
-			if err != nil {
-				return err
+// Build the internal representation of a pod from the status of its sandbox and of its containers
+func newCRIPod(psId string, pss *criapi.PodSandboxStatus, cstatuses []*criapi.ContainerStatus) *CRIPod {
+	var css = make(map[string]*criapi.ContainerStatus)
+	var previous = make(map[string]*criapi.ContainerStatus)
+	for _, cstatus := range cstatuses {
+		name := cstatus.Metadata.Name
+		latest := css[name]
+		switch {
+		case latest == nil || cstatus.Metadata.Attempt > latest.Metadata.Attempt:
+			css[name] = cstatus
+			if latest != nil {
+				previous[name] = latest
 			}
-			css[cstatus.Metadata.Name] = cstatus
+		case previous[name] == nil || cstatus.Metadata.Attempt > previous[name].Metadata.Attempt:
+			previous[name] = cstatus
 		}
+	}
 
-		newStatus[types.UID(pss.Metadata.Uid)] = CRIPod{
-			id:         pod.Id,
-			status:     pss,
-			containers: css,
-		}
+	return &CRIPod{
+		id:         psId,
+		status:     pss,
+		containers: css,
+		previous:   previous,
 	}
-	p.podStatus = newStatus
-	return nil
 }
 
 // Initialize the CRI APIs required
-func getClientAPIs(criSocketPath string) (criapi.RuntimeServiceClient, criapi.ImageServiceClient, error) {
+func getClientAPIs(config *providerConfig) (criapi.RuntimeServiceClient, criapi.ImageServiceClient, error) {
 	// Set up a connection to the server.
-	conn, err := getClientConnection(criSocketPath)
+	conn, err := getClientConnection(config.RuntimeEndpoint, config)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to connect: %v", err)
 	}
@@ -118,7 +120,14 @@ func getClientAPIs(criSocketPath string) (criapi.RuntimeServiceClient, criapi.Im
 	if rc == nil {
 		return nil, nil, fmt.Errorf("failed to create runtime service client")
 	}
-	ic := criapi.NewImageServiceClient(conn)
+	imageConn := conn
+	if config.ImageEndpoint != config.RuntimeEndpoint {
+		imageConn, err = getClientConnection(config.ImageEndpoint, config)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to connect to image service: %v", err)
+		}
+	}
+	ic := criapi.NewImageServiceClient(imageConn)
 	if ic == nil {
 		return nil, nil, fmt.Errorf("failed to create image service client")
 	}
@@ -130,31 +139,57 @@ func unixDialer(addr string, timeout time.Duration) (net.Conn, error) {
 }
 
 // Initialize CRI client connection
-func getClientConnection(criSocketPath string) (*grpc.ClientConn, error) {
-	conn, err := grpc.Dial(criSocketPath, grpc.WithInsecure(), grpc.WithTimeout(10*time.Second), grpc.WithDialer(unixDialer))
+// Requests made without a deadline are limited to the request timeout, or the image pull timeout for image pulls
+func getClientConnection(criSocketPath string, config *providerConfig) (*grpc.ClientConn, error) {
+	conn, err := grpc.Dial(criSocketPath, grpc.WithInsecure(), grpc.WithTimeout(config.ConnectionTimeout.Duration), grpc.WithDialer(unixDialer), grpc.WithUnaryInterceptor(timeoutInterceptor(config)))
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect: %v", err)
 	}
 	return conn, nil
 }
 
+// Create an interceptor limiting the time spent on requests to the runtime, as configured
+func timeoutInterceptor(config *providerConfig) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		timeout := config.RequestTimeout.Duration
+		if strings.HasSuffix(method, "/PullImage") {
+			timeout = config.ImagePullTimeout.Duration
+		}
+		if _, ok := ctx.Deadline(); !ok && timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
 // Create a new CRIProvider
-func NewCRIProvider(nodeName, operatingSystem string, internalIP string, resourceManager *manager.ResourceManager, daemonEndpointPort int32) (*CRIProvider, error) {
-	runtimeClient, imageClient, err := getClientAPIs(CriSocketPath)
+func NewCRIProvider(providerConfig, nodeName, operatingSystem string, internalIP string, resourceManager *manager.ResourceManager, daemonEndpointPort int32) (*CRIProvider, error) {
+	config, err := loadConfigFile(providerConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load configuration file %s: %v", providerConfig, err)
+	}
+	runtimeClient, imageClient, err := getClientAPIs(config)
 	if err != nil {
 		return nil, err
 	}
 	provider := CRIProvider{
 		resourceManager:    resourceManager,
-		podLogRoot:         PodLogRoot,
-		podVolRoot:         PodVolRoot,
+		podLogRoot:         config.PodLogRoot,
+		podVolRoot:         config.PodVolRoot,
 		nodeName:           nodeName,
 		operatingSystem:    operatingSystem,
 		internalIP:         internalIP,
 		daemonEndpointPort: daemonEndpointPort,
-		podStatus:          make(map[types.UID]CRIPod),
+		pods:               newPodCache(podCacheMaxAge),
 		runtimeClient:      runtimeClient,
 		imageClient:        imageClient,
+		runtimeHandler:     config.RuntimeHandler,
+		runtimeClasses:     config.RuntimeClasses,
+		podCapacity:        resource.MustParse(config.Pods),
+		syncers:            make(map[types.UID]*podSyncer),
+		cpuSamples:         make(map[string]cpuSample),
 	}
 	err = os.MkdirAll(provider.podLogRoot, PodLogRootPerms)
 	if err != nil {
@@ -164,9 +199,44 @@ func NewCRIProvider(nodeName, operatingSystem string, internalIP string, resourc
 	if err != nil {
 		return nil, err
 	}
+	if config.ImageGCHighThresholdPercent > 0 {
+		provider.imageGC = newImageGC(config.ImageGCHighThresholdPercent, config.ImageGCLowThresholdPercent)
+		go provider.runImageGC(config.ImageGCPeriod.Duration)
+	}
 	return &provider, err
 }
 
+// Return the runtime handler used to run the given pod, based on its runtime class
+func (p *CRIProvider) runtimeHandlerFor(pod *v1.Pod) string {
+	name := pod.Spec.RuntimeClassName
+	if name == nil || *name == "" {
+		return p.runtimeHandler
+	}
+	if handler, ok := p.runtimeClasses[*name]; ok {
+		return handler
+	}
+	return *name
+}
+
+// Take the annotations from the Pod spec, and record its restart policy and init containers so that its status can be derived from the state of its containers
+func createPodAnnotations(pod *v1.Pod) map[string]string {
+	annotations := make(map[string]string)
+
+	for k, v := range pod.Annotations {
+		annotations[k] = v
+	}
+	annotations[RestartPolicyAnnotation] = string(pod.Spec.RestartPolicy)
+	if len(pod.Spec.InitContainers) > 0 {
+		names := make([]string, 0, len(pod.Spec.InitContainers))
+		for _, c := range pod.Spec.InitContainers {
+			names = append(names, c.Name)
+		}
+		annotations[InitContainersAnnotation] = strings.Join(names, ",")
+	}
+
+	return annotations
+}
+
 // Take the labels from the Pod spec and turn the into a map
 // Note: None of the "special" labels appear to have any meaning outside of Kubelet
 func createPodLabels(pod *v1.Pod) map[string]string {
@@ -229,7 +299,8 @@ func createPortMappings(pod *v1.Pod) []*criapi.PortMapping {
 
 // A Pod is privileged if it contains a privileged container. Look for one in the Pod spec
 func existsPrivilegedContainerInSpec(pod *v1.Pod) bool {
-	for _, c := range pod.Spec.Containers {
+	containers := append(append([]v1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...)
+	for _, c := range containers {
 		if c.SecurityContext != nil &&
 			c.SecurityContext.Privileged != nil &&
 			*c.SecurityContext.Privileged {
@@ -240,26 +311,29 @@ func existsPrivilegedContainerInSpec(pod *v1.Pod) bool {
 }
 
 // Create CRI LinuxPodSandboxConfig from the Pod spec
-// TODO: This mapping is currently incomplete
 func createPodSandboxLinuxConfig(pod *v1.Pod) *criapi.LinuxPodSandboxConfig {
-	return &criapi.LinuxPodSandboxConfig{
+	config := &criapi.LinuxPodSandboxConfig{
 		CgroupParent: "",
 		SecurityContext: &criapi.LinuxSandboxSecurityContext{
-			NamespaceOptions:   nil, // type *NamespaceOption
-			SelinuxOptions:     nil, // type *SELinuxOption
-			RunAsUser:          nil, // type *Int64Value
-			RunAsGroup:         nil, // type *Int64Value
+			NamespaceOptions:   createNamespaceOptions(pod),
 			ReadonlyRootfs:     false,
-			SupplementalGroups: []int64{},
+			SupplementalGroups: getSupplementalGroups(pod),
 			Privileged:         existsPrivilegedContainerInSpec(pod),
-			SeccompProfilePath: "",
+			SeccompProfilePath: getSeccompProfilePath(pod.Annotations, ""),
 		},
 		Sysctls: make(map[string]string),
 	}
+	if sc := pod.Spec.SecurityContext; sc != nil {
+		config.SecurityContext.SelinuxOptions = createSELinuxOptions(sc.SELinuxOptions)
+		config.SecurityContext.RunAsUser = createInt64Value(sc.RunAsUser)
+		config.SecurityContext.RunAsGroup = createInt64Value(sc.RunAsGroup)
+		for _, sysctl := range sc.Sysctls {
+			config.Sysctls[sysctl.Name] = sysctl.Value
+		}
+	}
+	return config
 }
 
-// Greate CRI PodSandboxConfig from the Pod spec
-// TODO: This is probably incomplete
 func generatePodSandboxConfig(pod *v1.Pod, logDir string, attempt uint32) (*criapi.PodSandboxConfig, error) {
 	podUID := string(pod.UID)
 	config := &criapi.PodSandboxConfig{
@@ -270,7 +344,7 @@ func generatePodSandboxConfig(pod *v1.Pod, logDir string, attempt uint32) (*cria
 			Attempt:   attempt,
 		},
 		Labels:       createPodLabels(pod),
-		Annotations:  pod.Annotations,
+		Annotations:  createPodAnnotations(pod),
 		LogDirectory: logDir,
 		DnsConfig:    createPodDnsConfig(pod),
 		Hostname:     createPodHostname(pod),
@@ -330,9 +404,41 @@ func convertMountPropagationToCRI(input *v1.MountPropagationMode) criapi.MountPr
 	return criapi.MountPropagation_PROPAGATION_PRIVATE
 }
 
+// Returns the directory in which the files of the specified Secret, ConfigMap, DownwardAPI or Projected volume are written
+func resolvedVolumeDir(podVolRoot string, volume *v1.Volume) string {
+	dir := PodProjectedVolDir
+	switch {
+	case volume.Secret != nil:
+		dir = PodSecretVolDir
+	case volume.ConfigMap != nil:
+		dir = PodConfigMapVolDir
+	case volume.DownwardAPI != nil:
+		dir = PodDownwardAPIVolDir
+	}
+	return filepath.Join(podVolRoot, dir, volume.Name)
+}
+
 // Create a CRI specification for the container mounts from the Pod and Container specs
-func createCtrMounts(container *v1.Container, pod *v1.Pod, podVolRoot string, rm *manager.ResourceManager) ([]*criapi.Mount, error) {
-	mounts := []*criapi.Mount{}
+// The volumes are prepared on the host as needed, and those created by this call are removed if it fails
+func createCtrMounts(container *v1.Container, pod *v1.Pod, podVolRoot string, rm *manager.ResourceManager) (mounts []*criapi.Mount, err error) {
+	var created []string
+	defer func() {
+		if err != nil {
+			for i := len(created) - 1; i >= 0; i-- {
+				if rmErr := removeVolume(created[i]); rmErr != nil {
+					log.Printf("Failed to remove volume %s: %v", created[i], rmErr)
+				}
+			}
+		}
+	}()
+	// Keep track of the volume directories which don't exist yet
+	prepare := func(path string) {
+		if _, statErr := os.Lstat(path); os.IsNotExist(statErr) {
+			created = append(created, path)
+		}
+	}
+
+	mounts = []*criapi.Mount{}
 	for _, mountSpec := range container.VolumeMounts {
 		podVolSpec := findPodVolumeSpec(pod, mountSpec.Name)
 		if podVolSpec == nil {
@@ -341,83 +447,60 @@ func createCtrMounts(container *v1.Container, pod *v1.Pod, podVolRoot string, rm
 		}
 		// Common fields to all mount types
 		newMount := criapi.Mount{
-			ContainerPath: filepath.Join(mountSpec.MountPath, mountSpec.SubPath),
+			ContainerPath: mountSpec.MountPath,
 			Readonly:      mountSpec.ReadOnly,
 			Propagation:   convertMountPropagationToCRI(mountSpec.MountPropagation),
 		}
 		// Iterate over the volume types we care about
 		if podVolSpec.HostPath != nil {
+			if err := setupHostPath(podVolSpec.HostPath); err != nil {
+				return nil, err
+			}
 			newMount.HostPath = podVolSpec.HostPath.Path
 		} else if podVolSpec.EmptyDir != nil {
-			// TODO: Currently ignores the SizeLimit
 			newMount.HostPath = filepath.Join(podVolRoot, mountSpec.Name)
+			prepare(newMount.HostPath)
 			// TODO: Maybe not the best place to modify the filesystem, but clear enough for now
-			err := os.MkdirAll(newMount.HostPath, PodVolPerms)
-			if err != nil {
-				return nil, fmt.Errorf("Error making emptyDir for path %s: %v", newMount.HostPath, err)
+			if err := setupEmptyDir(newMount.HostPath, podVolSpec.EmptyDir); err != nil {
+				return nil, err
 			}
-		} else if podVolSpec.Secret != nil {
-			spec := podVolSpec.Secret
-			podSecretDir := filepath.Join(podVolRoot, PodSecretVolDir, mountSpec.Name)
-			newMount.HostPath = podSecretDir
-			err := os.MkdirAll(newMount.HostPath, PodSecretVolPerms)
+		} else if podVolSpec.PersistentVolumeClaim != nil {
+			// Only volumes backed by a path on the host can be mounted by the CRI runtime
+			claim := podVolSpec.PersistentVolumeClaim
+			pv, err := rm.GetBoundPersistentVolume(claim.ClaimName, pod.Namespace)
 			if err != nil {
-				return nil, fmt.Errorf("Error making secret dir for path %s: %v", newMount.HostPath, err)
+				return nil, fmt.Errorf("Error getting the volume bound to claim %s: %v", claim.ClaimName, err)
 			}
-			secret, err := rm.GetSecret(spec.SecretName, pod.Namespace)
-			if spec.Optional != nil && !*spec.Optional && k8serr.IsNotFound(err) {
-				return nil, fmt.Errorf("Secret %s is required by Pod %s and does not exist", spec.SecretName, pod.Name)
+			switch {
+			case pv.Spec.HostPath != nil:
+				newMount.HostPath = pv.Spec.HostPath.Path
+			case pv.Spec.Local != nil:
+				newMount.HostPath = pv.Spec.Local.Path
+			default:
+				return nil, fmt.Errorf("Volume %s bound to claim %s is of an unsupported type", pv.Name, claim.ClaimName)
 			}
+			newMount.Readonly = newMount.Readonly || claim.ReadOnly
+		} else if volume := (v1.Volume{Name: mountSpec.Name, VolumeSource: *podVolSpec}); manager.IsResolvableVolume(volume) {
+			newMount.HostPath = resolvedVolumeDir(podVolRoot, &volume)
+			files, err := rm.ResolveVolume(pod, volume)
 			if err != nil {
-				return nil, fmt.Errorf("Error getting secret %s from API server: %v", spec.SecretName, err)
-			}
-			if secret == nil {
-				continue
+				return nil, err
 			}
-			// TODO: Check podVolSpec.Secret.Items and map to specified paths
-			// TODO: Check podVolSpec.Secret.StringData
-			// TODO: What to do with podVolSpec.Secret.SecretType?
-			for k, v := range secret.Data {
-				// TODO: Arguably the wrong place to be writing files, but clear enough for now
-				// TODO: Ensure that these files are deleted in failure cases
-				fullPath := filepath.Join(podSecretDir, k)
-				err = ioutil.WriteFile(fullPath, v, PodSecretFilePerms) // Not encoded
-				if err != nil {
-					return nil, fmt.Errorf("Could not write secret file %s", fullPath)
-				}
-			}
-		} else if podVolSpec.ConfigMap != nil {
-			spec := podVolSpec.ConfigMap
-			podConfigMapDir := filepath.Join(podVolRoot, PodConfigMapVolDir, mountSpec.Name)
-			newMount.HostPath = podConfigMapDir
-			err := os.MkdirAll(newMount.HostPath, PodConfigMapVolPerms)
-			if err != nil {
-				return nil, fmt.Errorf("Error making configmap dir for path %s: %v", newMount.HostPath, err)
-			}
-			configMap, err := rm.GetConfigMap(spec.Name, pod.Namespace)
-			if spec.Optional != nil && !*spec.Optional && k8serr.IsNotFound(err) {
-				return nil, fmt.Errorf("Configmap %s is required by Pod %s and does not exist", spec.Name, pod.Name)
-			}
-			if err != nil {
-				return nil, fmt.Errorf("Error getting configmap %s from API server: %v", spec.Name, err)
-			}
-			if configMap == nil {
-				continue
-			}
-			// TODO: Check podVolSpec.ConfigMap.Items and map to paths
-			// TODO: Check podVolSpec.ConfigMap.BinaryData
-			for k, v := range configMap.Data {
-				// TODO: Arguably the wrong place to be writing files, but clear enough for now
-				// TODO: Ensure that these files are deleted in failure cases
-				fullPath := filepath.Join(podConfigMapDir, k)
-				err = ioutil.WriteFile(fullPath, []byte(v), PodConfigMapFilePerms)
-				if err != nil {
-					return nil, fmt.Errorf("Could not write configmap file %s", fullPath)
-				}
+			prepare(newMount.HostPath)
+			if err := writeVolumeFiles(newMount.HostPath, files); err != nil {
+				return nil, err
 			}
 		} else {
 			continue
 		}
+
+		// Only the given path of the volume is mounted, rather than its root
+		if mountSpec.SubPath != "" {
+			newMount.HostPath, err = resolveSubPath(newMount.HostPath, mountSpec.SubPath)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid subPath of volume mount %s: %v", mountSpec.Name, err)
+			}
+		}
 		mounts = append(mounts, &newMount)
 	}
 	return mounts, nil
@@ -432,29 +515,25 @@ func valueOrDefaultBool(input *bool, defVal bool) bool {
 }
 
 // Create CRI LinuxContainerConfig from Pod and Container spec
-// TODO: Currently incomplete
-func createCtrLinuxConfig(container *v1.Container, pod *v1.Pod) *criapi.LinuxContainerConfig {
-	v1sc := container.SecurityContext
-	var sc *criapi.LinuxContainerSecurityContext
-
-	if v1sc != nil {
-		sc = &criapi.LinuxContainerSecurityContext{
-			Capabilities:       nil,                                        // type: *Capability
-			Privileged:         valueOrDefaultBool(v1sc.Privileged, false), // No default Pod value
-			NamespaceOptions:   nil,                                        // type: *NamespaceOption
-			SelinuxOptions:     nil,                                        // type: *SELinuxOption
-			RunAsUser:          nil,                                        // type: *Int64Value
-			RunAsGroup:         nil,                                        // type: *Int64Value
-			RunAsUsername:      "",
-			ReadonlyRootfs:     false,
-			SupplementalGroups: []int64{},
-			ApparmorProfile:    "",
-			SeccompProfilePath: "",
-			NoNewPrivs:         false,
-		}
+// The settings of the security context of the Pod apply to the container unless it overrides them
+func createCtrLinuxConfig(container *v1.Container, pod *v1.Pod, memoryCapacity int64) *criapi.LinuxContainerConfig {
+	v1sc := getEffectiveSecurityContext(container, pod)
+	sc := &criapi.LinuxContainerSecurityContext{
+		Capabilities:       createCapabilities(v1sc.Capabilities),
+		Privileged:         valueOrDefaultBool(v1sc.Privileged, false), // No default Pod value
+		NamespaceOptions:   createNamespaceOptions(pod),
+		SelinuxOptions:     createSELinuxOptions(v1sc.SELinuxOptions),
+		RunAsUser:          createInt64Value(v1sc.RunAsUser),
+		RunAsGroup:         createInt64Value(v1sc.RunAsGroup),
+		RunAsUsername:      "",
+		ReadonlyRootfs:     valueOrDefaultBool(v1sc.ReadOnlyRootFilesystem, false),
+		SupplementalGroups: getSupplementalGroups(pod),
+		ApparmorProfile:    getAppArmorProfile(pod.Annotations, container.Name),
+		SeccompProfilePath: getSeccompProfilePath(pod.Annotations, container.Name),
+		NoNewPrivs:         !valueOrDefaultBool(v1sc.AllowPrivilegeEscalation, true),
 	}
 	return &criapi.LinuxContainerConfig{
-		Resources:       nil, // type: *LinuxContainerResources
+		Resources:       createCtrResources(container, pod, memoryCapacity),
 		SecurityContext: sc,
 	}
 }
@@ -475,7 +554,7 @@ func generateContainerConfig(container *v1.Container, pod *v1.Pod, imageRef, pod
 		Envs:        createCtrEnvVars(container.Env),
 		Labels:      createCtrLabels(container, pod),
 		Annotations: createCtrAnnotations(container, pod),
-		Linux:       createCtrLinuxConfig(container, pod),
+		Linux:       createCtrLinuxConfig(container, pod, int64(getSystemTotalMemory())),
 		LogPath:     fmt.Sprintf("%s-%d.log", container.Name, attempt),
 		Stdin:       container.Stdin,
 		StdinOnce:   container.StdinOnce,
@@ -490,17 +569,17 @@ func generateContainerConfig(container *v1.Container, pod *v1.Pod, imageRef, pod
 }
 
 // Provider function to create a Pod
-func (p *CRIProvider) CreatePod(ctx context.Context, pod *v1.Pod) error {
+// Its init containers are run to completion one after the other before its app containers are started, and its containers are restarted according to its restart policy until it is deleted
+func (p *CRIProvider) CreatePod(ctx context.Context, pod *v1.Pod) (err error) {
 	log.Printf("receive CreatePod %q", pod.Name)
 
-	var attempt uint32 // TODO: Track attempts. Currently always 0
 	logPath := filepath.Join(p.podLogRoot, string(pod.UID))
 	volPath := filepath.Join(p.podVolRoot, string(pod.UID))
-	err := p.refreshNodeState()
+	err = p.refreshNodeState()
 	if err != nil {
 		return err
 	}
-	pConfig, err := generatePodSandboxConfig(pod, logPath, attempt)
+	pConfig, err := generatePodSandboxConfig(pod, logPath, 0)
 	if err != nil {
 		return err
 	}
@@ -508,7 +587,6 @@ func (p *CRIProvider) CreatePod(ctx context.Context, pod *v1.Pod) error {
 	existing := p.findPodByName(pod.Namespace, pod.Name)
 
 	// TODO: Is re-using an existing sandbox with the UID the correct behavior?
-	// TODO: Should delete the sandbox if container creation fails
 	var pId string
 	if existing == nil {
 		err = os.MkdirAll(logPath, 0755)
@@ -520,41 +598,32 @@ func (p *CRIProvider) CreatePod(ctx context.Context, pod *v1.Pod) error {
 			return err
 		}
 		// TODO: Is there a race here?
-		pId, err = runPodSandbox(p.runtimeClient, pConfig)
+		pId, err = runPodSandbox(p.runtimeClient, pConfig, p.runtimeHandlerFor(pod))
+		p.pods.invalidate()
 		if err != nil {
 			return err
 		}
+		// Remove the sandbox if any of its containers can't be started, so that creating the pod can be retried from scratch
+		defer func() {
+			if err != nil {
+				if rmErr := p.removeSandbox(pod.UID, pId); rmErr != nil {
+					log.Printf("Failed to remove sandbox %s: %v", pId, rmErr)
+				}
+			}
+		}()
 	} else {
-		pId = existing.status.Metadata.Uid
-	}
-
-	for _, c := range pod.Spec.Containers {
-		log.Printf("Pulling image %s", c.Image)
-		imageRef, err := pullImage(p.imageClient, c.Image)
-		if err != nil {
-			return err
-		}
-		log.Printf("Creating container %s", c.Name)
-		cConfig, err := generateContainerConfig(&c, pod, imageRef, volPath, p.resourceManager, attempt)
-		log.Debugf("%v", cConfig)
-		if err != nil {
-			return err
-		}
-		cId, err := createContainer(p.runtimeClient, cConfig, pConfig, pId)
-		if err != nil {
-			return err
-		}
-		log.Printf("Starting container %s", c.Name)
-		err = startContainer(p.runtimeClient, cId)
+		pId = existing.id
 	}
 
-	return err
+	// Containers are started by the sync loop, the first iteration of which is run right away
+	return p.startPodSync(pod, pId)
 }
 
-// Update is currently not required or even called by VK, so not implemented
+// Pods are not updated in place. Only the spec used to restart their containers is updated
 func (p *CRIProvider) UpdatePod(ctx context.Context, pod *v1.Pod) error {
 	log.Printf("receive UpdatePod %q", pod.Name)
 
+	p.updatePodSync(pod)
 	return nil
 }
 
@@ -562,32 +631,56 @@ func (p *CRIProvider) UpdatePod(ctx context.Context, pod *v1.Pod) error {
 func (p *CRIProvider) DeletePod(ctx context.Context, pod *v1.Pod) error {
 	log.Printf("receive DeletePod %q", pod.Name)
 
+	p.stopPodSync(pod.UID)
+
 	err := p.refreshNodeState()
 	if err != nil {
 		return err
 	}
 
-	ps, ok := p.podStatus[pod.UID]
-	if !ok {
+	ps := p.pods.getByUID(pod.UID)
+	if ps == nil {
 		return strongerrors.NotFound(fmt.Errorf("Pod %s not found", pod.UID))
 	}
 
+	return p.removeSandbox(pod.UID, ps.status.Id)
+}
+
+// Stop and remove a pod sandbox and its containers, along with the volumes of the pod
+func (p *CRIProvider) removeSandbox(podUID types.UID, psId string) error {
+	defer p.pods.invalidate()
+
 	// TODO: Check pod status for running state
-	err = stopPodSandbox(p.runtimeClient, ps.status.Id)
+	err := stopPodSandbox(p.runtimeClient, psId)
 	if err != nil {
 		// Note the error, but shouldn't prevent us trying to delete
 		log.Print(err)
 	}
 
-	// Remove any emptyDir volumes
+	// Remove the volumes, unmounting the memory-backed emptyDir ones
 	// TODO: Is there other cleanup that needs to happen here?
-	err = os.RemoveAll(filepath.Join(p.podVolRoot, string(pod.UID)))
+	err = removePodVolumes(filepath.Join(p.podVolRoot, string(podUID)))
 	if err != nil {
 		log.Print(err)
 	}
-	err = removePodSandbox(p.runtimeClient, ps.status.Id)
+	return removePodSandbox(p.runtimeClient, psId)
+}
+
+// Provider function to restart a container, for example when it fails its liveness probe
+// The container is stopped, and then restarted by the sync loop according to the restart policy of the pod
+func (p *CRIProvider) RestartContainer(ctx context.Context, pod *v1.Pod, containerName string) error {
+	log.Printf("receive RestartContainer %q", containerName)
 
-	return err
+	container, err := p.findContainer(pod.Namespace, pod.Name, containerName)
+	if err != nil {
+		return err
+	}
+	var gracePeriod int64 = v1.DefaultTerminationGracePeriodSeconds
+	if pod.Spec.TerminationGracePeriodSeconds != nil {
+		gracePeriod = *pod.Spec.TerminationGracePeriodSeconds
+	}
+	defer p.pods.invalidate()
+	return stopContainer(p.runtimeClient, container.Id, gracePeriod)
 }
 
 // Provider function to return a Pod spec - mostly used for its status
@@ -604,7 +697,7 @@ func (p *CRIProvider) GetPod(ctx context.Context, namespace, name string) (*v1.P
 		return nil, strongerrors.NotFound(fmt.Errorf("Pod %s in namespace %s could not be found on the node", name, namespace))
 	}
 
-	return createPodSpecFromCRI(pod, p.nodeName), nil
+	return createPodSpecFromCRI(pod, p.nodeName, p.getWaitingContainers(types.UID(pod.status.Metadata.Uid))), nil
 }
 
 // Reads a log file into a string
@@ -654,25 +747,9 @@ func (p *CRIProvider) GetPodFullName(namespace string, pod string) string {
 	return ""
 }
 
-// ExecInContainer executes a command in a container in the pod, copying data
-// between in/out/err and the container's stdin/stdout/stderr.
-// TODO: Implementation
-func (p *CRIProvider) ExecInContainer(name string, uid types.UID, container string, cmd []string, in io.Reader, out, err io.WriteCloser, tty bool, resize <-chan remotecommand.TerminalSize, timeout time.Duration) error {
-	log.Printf("receive ExecInContainer %q\n", container)
-	return nil
-}
-
-// Find a pod by name and namespace. Pods are indexed by UID
+// Find a pod by name and namespace in the current state of the node
 func (p *CRIProvider) findPodByName(namespace, name string) *CRIPod {
-	var found *CRIPod
-
-	for _, pod := range p.podStatus {
-		if pod.status.Metadata.Name == name && pod.status.Metadata.Namespace == namespace {
-			found = &pod
-			break
-		}
-	}
-	return found
+	return p.pods.getByName(namespace, name)
 }
 
 // Provider function to return the status of a Pod
@@ -689,7 +766,7 @@ func (p *CRIProvider) GetPodStatus(ctx context.Context, namespace, name string)
 		return nil, strongerrors.NotFound(fmt.Errorf("Pod %s in namespace %s could not be found on the node", name, namespace))
 	}
 
-	return createPodStatusFromCRI(pod), nil
+	return createPodStatusFromCRI(pod, p.getWaitingContainers(types.UID(pod.status.Metadata.Uid))), nil
 }
 
 // Converts CRI container state to ContainerState
@@ -725,11 +802,28 @@ func createContainerStateFromCRI(state criapi.ContainerState, status *criapi.Con
 	return result
 }
 
-// Converts CRI container spec to Container spec
-func createContainerSpecsFromCRI(containerMap map[string]*criapi.ContainerStatus) ([]v1.Container, []v1.ContainerStatus) {
-	containers := make([]v1.Container, 0, len(containerMap))
-	containerStatuses := make([]v1.ContainerStatus, 0, len(containerMap))
-	for _, c := range containerMap {
+// Converts CRI container spec to Container spec, for either the init containers, in order, or the app containers of a pod
+// The given containers which are waiting to be created are reported as such
+func createContainerSpecsFromCRI(p *CRIPod, init bool, waiting []waitingContainer) ([]v1.Container, []v1.ContainerStatus) {
+	initNames := getInitContainerNames(p)
+	criContainers := make([]*criapi.ContainerStatus, 0, len(p.containers))
+	if init {
+		for _, name := range initNames {
+			if c, ok := p.containers[name]; ok {
+				criContainers = append(criContainers, c)
+			}
+		}
+	} else {
+		for _, c := range p.containers {
+			if !containsString(initNames, c.Metadata.Name) {
+				criContainers = append(criContainers, c)
+			}
+		}
+	}
+
+	containers := make([]v1.Container, 0, len(criContainers))
+	containerStatuses := make([]v1.ContainerStatus, 0, len(criContainers))
+	for _, c := range criContainers {
 		// TODO: Fill out more fields
 		container := v1.Container{
 			Name:  c.Metadata.Name,
@@ -739,46 +833,171 @@ func createContainerSpecsFromCRI(containerMap map[string]*criapi.ContainerStatus
 		containers = append(containers, container)
 		// TODO: Fill out more fields
 		containerStatus := v1.ContainerStatus{
-			Name:        c.Metadata.Name,
-			Image:       c.Image.Image,
-			ImageID:     c.ImageRef,
-			ContainerID: c.Id,
-			Ready:       c.State == criapi.ContainerState_CONTAINER_RUNNING,
-			State:       *createContainerStateFromCRI(c.State, c),
-			// LastTerminationState:
-			// RestartCount:
+			Name:         c.Metadata.Name,
+			Image:        c.Image.Image,
+			ImageID:      c.ImageRef,
+			ContainerID:  c.Id,
+			Ready:        c.State == criapi.ContainerState_CONTAINER_RUNNING,
+			State:        *createContainerStateFromCRI(c.State, c),
+			RestartCount: int32(c.Metadata.Attempt),
+		}
+		if previous := p.previous[c.Metadata.Name]; previous != nil && previous.State == criapi.ContainerState_CONTAINER_EXITED {
+			containerStatus.LastTerminationState = *createContainerStateFromCRI(previous.State, previous)
 		}
 
 		containerStatuses = append(containerStatuses, containerStatus)
 	}
+
+	for _, w := range waiting {
+		if _, ok := p.containers[w.container.Name]; ok || w.init != init {
+			continue
+		}
+		state := w.state
+		containers = append(containers, v1.Container{
+			Name:  w.container.Name,
+			Image: w.container.Image,
+		})
+		containerStatuses = append(containerStatuses, v1.ContainerStatus{
+			Name:  w.container.Name,
+			Image: w.container.Image,
+			State: v1.ContainerState{Waiting: &state},
+		})
+	}
+	// Report the app containers in a stable order
+	if !init {
+		sort.Slice(containers, func(i, j int) bool {
+			return containers[i].Name < containers[j].Name
+		})
+		sort.Slice(containerStatuses, func(i, j int) bool {
+			return containerStatuses[i].Name < containerStatuses[j].Name
+		})
+	}
 	return containers, containerStatuses
 }
 
-// Converts CRI pod status to a PodStatus
-func createPodStatusFromCRI(p *CRIPod) *v1.PodStatus {
-	_, cStatuses := createContainerSpecsFromCRI(p.containers)
+// Get the names of the init containers of a pod, in order
+func getInitContainerNames(p *CRIPod) []string {
+	names := p.status.Annotations[InitContainersAnnotation]
+	if names == "" {
+		return nil
+	}
+	return strings.Split(names, ",")
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
 
-	// TODO: How to determine PodSucceeded and PodFailed?
-	phase := v1.PodPending
-	if p.status.State == criapi.PodSandboxState_SANDBOX_READY {
-		phase = v1.PodRunning
+// Build the Initialized condition of a pod from the statuses of its init containers
+// A pod is initialized once all its init containers have succeeded, after which its app containers are started
+func getPodInitializedCondition(p *CRIPod, initStatuses, containerStatuses []v1.ContainerStatus) v1.PodCondition {
+	condition := v1.PodCondition{
+		Type:   v1.PodInitialized,
+		Status: v1.ConditionTrue,
+	}
+	if len(containerStatuses) > 0 {
+		return condition
+	}
+
+	succeeded := make(map[string]bool)
+	for _, cs := range initStatuses {
+		succeeded[cs.Name] = cs.State.Terminated != nil && cs.State.Terminated.ExitCode == 0
+	}
+	var pending []string
+	for _, name := range getInitContainerNames(p) {
+		if !succeeded[name] {
+			pending = append(pending, name)
+		}
+	}
+	if len(pending) > 0 {
+		condition.Status = v1.ConditionFalse
+		condition.Reason = "ContainersNotInitialized"
+		condition.Message = fmt.Sprintf("containers with incomplete status: %v", pending)
 	}
+	return condition
+}
+
+// Derive the phase of a pod from the state of its sandbox and containers, and from its restart policy, as the kubelet does
+func getPodPhase(p *CRIPod, initStatuses, containerStatuses []v1.ContainerStatus) v1.PodPhase {
+	restartPolicy := v1.RestartPolicy(p.status.Annotations[RestartPolicyAnnotation])
+	if len(containerStatuses) == 0 {
+		// The pod is initializing, unless one of its init containers failed and won't be restarted
+		for _, cs := range initStatuses {
+			if restartPolicy == v1.RestartPolicyNever && cs.State.Terminated != nil && cs.State.Terminated.ExitCode != 0 {
+				return v1.PodFailed
+			}
+		}
+		return v1.PodPending
+	}
+
+	var waiting, running, stopped, succeeded int
+	for _, cs := range containerStatuses {
+		switch {
+		case cs.State.Running != nil:
+			running++
+		case cs.State.Terminated != nil:
+			stopped++
+			if cs.State.Terminated.ExitCode == 0 {
+				succeeded++
+			}
+		case cs.LastTerminationState.Terminated != nil:
+			// The container is being restarted
+			stopped++
+		default:
+			waiting++
+		}
+	}
+
+	switch {
+	case waiting > 0:
+		return v1.PodPending
+	case running > 0:
+		return v1.PodRunning
+	case stopped > 0:
+		// All containers are terminated
+		if restartPolicy == v1.RestartPolicyNever || restartPolicy == v1.RestartPolicyOnFailure {
+			if stopped == succeeded {
+				return v1.PodSucceeded
+			}
+			if restartPolicy == v1.RestartPolicyNever {
+				return v1.PodFailed
+			}
+		}
+		// The containers are being restarted
+		return v1.PodRunning
+	default:
+		return v1.PodPending
+	}
+}
+
+// Converts CRI pod status to a PodStatus, including the given containers waiting to be created
+func createPodStatusFromCRI(p *CRIPod, waiting []waitingContainer) *v1.PodStatus {
+	_, initStatuses := createContainerSpecsFromCRI(p, true, waiting)
+	_, cStatuses := createContainerSpecsFromCRI(p, false, waiting)
+
 	startTime := metav1.NewTime(time.Unix(0, p.status.CreatedAt))
 	return &v1.PodStatus{
-		Phase:             phase,
-		Conditions:        []v1.PodCondition{},
-		Message:           "",
-		Reason:            "",
-		HostIP:            "",
-		PodIP:             p.status.Network.Ip,
-		StartTime:         &startTime,
-		ContainerStatuses: cStatuses,
+		Phase:                 getPodPhase(p, initStatuses, cStatuses),
+		Conditions:            []v1.PodCondition{getPodInitializedCondition(p, initStatuses, cStatuses)},
+		Message:               "",
+		Reason:                "",
+		HostIP:                "",
+		PodIP:                 p.status.Network.Ip,
+		StartTime:             &startTime,
+		InitContainerStatuses: initStatuses,
+		ContainerStatuses:     cStatuses,
 	}
 }
 
-// Creates a Pod spec from data obtained through CRI
-func createPodSpecFromCRI(p *CRIPod, nodeName string) *v1.Pod {
-	cSpecs, _ := createContainerSpecsFromCRI(p.containers)
+// Creates a Pod spec from data obtained through CRI, including the given containers waiting to be created
+func createPodSpecFromCRI(p *CRIPod, nodeName string, waiting []waitingContainer) *v1.Pod {
+	initSpecs, _ := createContainerSpecsFromCRI(p, true, waiting)
+	cSpecs, _ := createContainerSpecsFromCRI(p, false, waiting)
 
 	// TODO: Fill out more fields here
 	podSpec := v1.Pod{
@@ -794,11 +1013,12 @@ func createPodSpecFromCRI(p *CRIPod, nodeName string) *v1.Pod {
 			CreationTimestamp: metav1.NewTime(time.Unix(0, p.status.CreatedAt)),
 		},
 		Spec: v1.PodSpec{
-			NodeName:   nodeName,
-			Volumes:    []v1.Volume{},
-			Containers: cSpecs,
+			NodeName:       nodeName,
+			Volumes:        []v1.Volume{},
+			InitContainers: initSpecs,
+			Containers:     cSpecs,
 		},
-		Status: *createPodStatusFromCRI(p),
+		Status: *createPodStatusFromCRI(p, waiting),
 	}
 
 	//	log.Printf("Created Pod Spec %v", podSpec)
@@ -817,8 +1037,8 @@ func (p *CRIProvider) GetPods(ctx context.Context) ([]*v1.Pod, error) {
 		return nil, err
 	}
 
-	for _, ps := range p.podStatus {
-		pods = append(pods, createPodSpecFromCRI(&ps, p.nodeName))
+	for _, ps := range p.pods.list() {
+		pods = append(pods, createPodSpecFromCRI(ps, p.nodeName, p.getWaitingContainers(types.UID(ps.status.Metadata.Uid))))
 	}
 
 	return pods, nil
@@ -851,12 +1071,12 @@ func (p *CRIProvider) Capacity(ctx context.Context) v1.ResourceList {
 	return v1.ResourceList{
 		"cpu":    cpuQ,
 		"memory": memQ,
-		"pods":   resource.MustParse("1000"),
+		"pods":   p.podCapacity,
 	}
 }
 
 // Provider function to return node conditions
-// TODO: For now, use the same node conditions as the MockProvider
+// TODO: For now, use the same node conditions as the MockProvider, except for DiskPressure which is reported by the image garbage collection, if enabled
 func (p *CRIProvider) NodeConditions(ctx context.Context) []v1.NodeCondition {
 	// TODO: Make this configurable
 	return []v1.NodeCondition{
@@ -884,14 +1104,7 @@ func (p *CRIProvider) NodeConditions(ctx context.Context) []v1.NodeCondition {
 			Reason:             "KubeletHasSufficientMemory",
 			Message:            "kubelet has sufficient memory available",
 		},
-		{
-			Type:               "DiskPressure",
-			Status:             v1.ConditionFalse,
-			LastHeartbeatTime:  metav1.Now(),
-			LastTransitionTime: metav1.Now(),
-			Reason:             "KubeletHasNoDiskPressure",
-			Message:            "kubelet has no disk pressure",
-		},
+		p.getDiskPressureCondition(),
 		{
 			Type:               "NetworkUnavailable",
 			Status:             v1.ConditionFalse,
@@ -904,6 +1117,28 @@ func (p *CRIProvider) NodeConditions(ctx context.Context) []v1.NodeCondition {
 
 }
 
+// Build the DiskPressure node condition, which is true while the image filesystem is full of images in use
+func (p *CRIProvider) getDiskPressureCondition() v1.NodeCondition {
+	if p.imageGC != nil && p.imageGC.hasDiskPressure() {
+		return v1.NodeCondition{
+			Type:               v1.NodeDiskPressure,
+			Status:             v1.ConditionTrue,
+			LastHeartbeatTime:  metav1.Now(),
+			LastTransitionTime: metav1.Now(),
+			Reason:             "KubeletHasDiskPressure",
+			Message:            "kubelet has disk pressure",
+		}
+	}
+	return v1.NodeCondition{
+		Type:               v1.NodeDiskPressure,
+		Status:             v1.ConditionFalse,
+		LastHeartbeatTime:  metav1.Now(),
+		LastTransitionTime: metav1.Now(),
+		Reason:             "KubeletHasNoDiskPressure",
+		Message:            "kubelet has no disk pressure",
+	}
+}
+
 // Provider function to return a list of node addresses
 func (p *CRIProvider) NodeAddresses(ctx context.Context) []v1.NodeAddress {
 	log.Printf("receive NodeAddresses - returning %s", p.internalIP)
@@ -0,0 +1,358 @@
+// +build linux
+
+package cri
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"k8s.io/apimachinery/pkg/types"
+	remotecommandconsts "k8s.io/apimachinery/pkg/util/remotecommand"
+	"k8s.io/client-go/tools/remotecommand"
+	criapi "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+	kubeletremotecommand "k8s.io/kubernetes/pkg/kubelet/server/remotecommand"
+)
+
+const (
+	fakeSandboxId   = "sandbox-1"
+	fakeContainerId = "container-1"
+	fakePodUID      = "uid-1"
+)
+
+// fakeRuntimeService is an in-memory runtime service, initially running a single pod "default/pod" with a single container "app".
+// The streaming URLs it returns point at streamingURL.
+type fakeRuntimeService struct {
+	criapi.RuntimeServiceServer
+	streamingURL string
+
+	mu         sync.Mutex
+	nextId     int
+	sandboxes  map[string]*criapi.PodSandboxStatus
+	containers map[string]*criapi.ContainerStatus
+	sandboxOf  map[string]string // Sandbox IDs indexed by container ID
+	execSync   []*criapi.ExecSyncRequest
+	exec       []*criapi.ExecRequest
+	attach     []*criapi.AttachRequest
+	forward    []*criapi.PortForwardRequest
+	handlers   []string                          // Runtime handlers of the sandboxes run
+	stats      map[string]*criapi.ContainerStats // Stats of the containers, indexed by ID
+	info       map[string]map[string]string      // Verbose information of the sandboxes, indexed by ID
+	statusRPCs int                               // Number of sandbox and container status requests
+}
+
+func newFakeRuntimeService(streamingURL string) *fakeRuntimeService {
+	s := &fakeRuntimeService{
+		streamingURL: streamingURL,
+		sandboxes: map[string]*criapi.PodSandboxStatus{
+			fakeSandboxId: {
+				Id:       fakeSandboxId,
+				Metadata: &criapi.PodSandboxMetadata{Name: "pod", Namespace: "default", Uid: fakePodUID},
+				State:    criapi.PodSandboxState_SANDBOX_READY,
+				Network:  &criapi.PodSandboxNetworkStatus{},
+			},
+		},
+		containers: map[string]*criapi.ContainerStatus{
+			fakeContainerId: {
+				Id:       fakeContainerId,
+				Metadata: &criapi.ContainerMetadata{Name: "app"},
+				Image:    &criapi.ImageSpec{Image: "app"},
+				State:    criapi.ContainerState_CONTAINER_RUNNING,
+			},
+		},
+		sandboxOf: map[string]string{fakeContainerId: fakeSandboxId},
+		stats:     make(map[string]*criapi.ContainerStats),
+		info:      make(map[string]map[string]string),
+	}
+	return s
+}
+
+// Generate a new ID with the given prefix
+func (s *fakeRuntimeService) newId(prefix string) string {
+	s.nextId++
+	return fmt.Sprintf("%s-gen-%d", prefix, s.nextId)
+}
+
+// Make the given container exit with the given code
+func (s *fakeRuntimeService) exitContainer(id string, exitCode int32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.containers[id]
+	c.State = criapi.ContainerState_CONTAINER_EXITED
+	c.ExitCode = exitCode
+	c.FinishedAt = time.Now().UnixNano()
+}
+
+// Return the IDs of the containers of the given sandbox with the given name, indexed by attempt
+func (s *fakeRuntimeService) attempts(psId, name string) map[uint32]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := make(map[uint32]string)
+	for id, c := range s.containers {
+		if s.sandboxOf[id] == psId && c.Metadata.Name == name {
+			res[c.Metadata.Attempt] = id
+		}
+	}
+	return res
+}
+
+func (s *fakeRuntimeService) RunPodSandbox(ctx context.Context, in *criapi.RunPodSandboxRequest) (*criapi.RunPodSandboxResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := s.newId("sandbox")
+	s.sandboxes[id] = &criapi.PodSandboxStatus{
+		Id:          id,
+		Metadata:    in.Config.Metadata,
+		State:       criapi.PodSandboxState_SANDBOX_READY,
+		CreatedAt:   time.Now().UnixNano(),
+		Network:     &criapi.PodSandboxNetworkStatus{Ip: "10.0.0.2"},
+		Labels:      in.Config.Labels,
+		Annotations: in.Config.Annotations,
+	}
+	s.handlers = append(s.handlers, in.RuntimeHandler)
+	return &criapi.RunPodSandboxResponse{PodSandboxId: id}, nil
+}
+
+func (s *fakeRuntimeService) StopPodSandbox(ctx context.Context, in *criapi.StopPodSandboxRequest) (*criapi.StopPodSandboxResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ps, ok := s.sandboxes[in.PodSandboxId]
+	if !ok {
+		return nil, fmt.Errorf("sandbox %s not found", in.PodSandboxId)
+	}
+	ps.State = criapi.PodSandboxState_SANDBOX_NOTREADY
+	for id, c := range s.containers {
+		if s.sandboxOf[id] == in.PodSandboxId && c.State == criapi.ContainerState_CONTAINER_RUNNING {
+			c.State = criapi.ContainerState_CONTAINER_EXITED
+			c.ExitCode = 137
+		}
+	}
+	return &criapi.StopPodSandboxResponse{}, nil
+}
+
+func (s *fakeRuntimeService) RemovePodSandbox(ctx context.Context, in *criapi.RemovePodSandboxRequest) (*criapi.RemovePodSandboxResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sandboxes, in.PodSandboxId)
+	for id, psId := range s.sandboxOf {
+		if psId == in.PodSandboxId {
+			delete(s.containers, id)
+			delete(s.sandboxOf, id)
+		}
+	}
+	return &criapi.RemovePodSandboxResponse{}, nil
+}
+
+func (s *fakeRuntimeService) ListPodSandbox(ctx context.Context, in *criapi.ListPodSandboxRequest) (*criapi.ListPodSandboxResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var items []*criapi.PodSandbox
+	for id := range s.sandboxes {
+		items = append(items, &criapi.PodSandbox{Id: id, State: s.sandboxes[id].State})
+	}
+	return &criapi.ListPodSandboxResponse{Items: items}, nil
+}
+
+func (s *fakeRuntimeService) PodSandboxStatus(ctx context.Context, in *criapi.PodSandboxStatusRequest) (*criapi.PodSandboxStatusResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.statusRPCs++
+	ps, ok := s.sandboxes[in.PodSandboxId]
+	if !ok {
+		return nil, fmt.Errorf("sandbox %s not found", in.PodSandboxId)
+	}
+	response := &criapi.PodSandboxStatusResponse{Status: ps}
+	if in.Verbose {
+		response.Info = s.info[in.PodSandboxId]
+	}
+	return response, nil
+}
+
+func (s *fakeRuntimeService) ListContainerStats(ctx context.Context, in *criapi.ListContainerStatsRequest) (*criapi.ListContainerStatsResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var items []*criapi.ContainerStats
+	for _, cs := range s.stats {
+		items = append(items, cs)
+	}
+	return &criapi.ListContainerStatsResponse{Stats: items}, nil
+}
+
+func (s *fakeRuntimeService) CreateContainer(ctx context.Context, in *criapi.CreateContainerRequest) (*criapi.CreateContainerResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.sandboxes[in.PodSandboxId]; !ok {
+		return nil, fmt.Errorf("sandbox %s not found", in.PodSandboxId)
+	}
+	if strings.Contains(in.Config.Image.Image, "invalid") {
+		return nil, fmt.Errorf("invalid image %s", in.Config.Image.Image)
+	}
+	id := s.newId("container")
+	s.containers[id] = &criapi.ContainerStatus{
+		Id:        id,
+		Metadata:  in.Config.Metadata,
+		Image:     in.Config.Image,
+		ImageRef:  in.Config.Image.Image,
+		State:     criapi.ContainerState_CONTAINER_CREATED,
+		CreatedAt: time.Now().UnixNano(),
+		LogPath:   in.Config.LogPath,
+	}
+	s.sandboxOf[id] = in.PodSandboxId
+	return &criapi.CreateContainerResponse{ContainerId: id}, nil
+}
+
+func (s *fakeRuntimeService) StartContainer(ctx context.Context, in *criapi.StartContainerRequest) (*criapi.StartContainerResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.containers[in.ContainerId]
+	if !ok {
+		return nil, fmt.Errorf("container %s not found", in.ContainerId)
+	}
+	c.State = criapi.ContainerState_CONTAINER_RUNNING
+	c.StartedAt = time.Now().UnixNano()
+	return &criapi.StartContainerResponse{}, nil
+}
+
+func (s *fakeRuntimeService) StopContainer(ctx context.Context, in *criapi.StopContainerRequest) (*criapi.StopContainerResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.containers[in.ContainerId]
+	if !ok {
+		return nil, fmt.Errorf("container %s not found", in.ContainerId)
+	}
+	c.State = criapi.ContainerState_CONTAINER_EXITED
+	c.ExitCode = 137
+	c.FinishedAt = time.Now().UnixNano()
+	return &criapi.StopContainerResponse{}, nil
+}
+
+func (s *fakeRuntimeService) RemoveContainer(ctx context.Context, in *criapi.RemoveContainerRequest) (*criapi.RemoveContainerResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.containers, in.ContainerId)
+	delete(s.sandboxOf, in.ContainerId)
+	return &criapi.RemoveContainerResponse{}, nil
+}
+
+func (s *fakeRuntimeService) ListContainers(ctx context.Context, in *criapi.ListContainersRequest) (*criapi.ListContainersResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var containers []*criapi.Container
+	for id := range s.containers {
+		if in.Filter == nil || in.Filter.PodSandboxId == "" || s.sandboxOf[id] == in.Filter.PodSandboxId {
+			containers = append(containers, &criapi.Container{Id: id, PodSandboxId: s.sandboxOf[id], State: s.containers[id].State})
+		}
+	}
+	return &criapi.ListContainersResponse{Containers: containers}, nil
+}
+
+func (s *fakeRuntimeService) ContainerStatus(ctx context.Context, in *criapi.ContainerStatusRequest) (*criapi.ContainerStatusResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.statusRPCs++
+	c, ok := s.containers[in.ContainerId]
+	if !ok {
+		return nil, fmt.Errorf("container %s not found", in.ContainerId)
+	}
+	return &criapi.ContainerStatusResponse{Status: c}, nil
+}
+
+func (s *fakeRuntimeService) ExecSync(ctx context.Context, in *criapi.ExecSyncRequest) (*criapi.ExecSyncResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.execSync = append(s.execSync, in)
+	if in.Cmd[0] == "false" {
+		return &criapi.ExecSyncResponse{Stderr: []byte("failed"), ExitCode: 1}, nil
+	}
+	return &criapi.ExecSyncResponse{Stdout: []byte(strings.Join(in.Cmd[1:], " "))}, nil
+}
+
+func (s *fakeRuntimeService) Exec(ctx context.Context, in *criapi.ExecRequest) (*criapi.ExecResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.exec = append(s.exec, in)
+	return &criapi.ExecResponse{Url: s.streamingURL + "/exec/token"}, nil
+}
+
+func (s *fakeRuntimeService) Attach(ctx context.Context, in *criapi.AttachRequest) (*criapi.AttachResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attach = append(s.attach, in)
+	return &criapi.AttachResponse{Url: s.streamingURL + "/attach/token"}, nil
+}
+
+func (s *fakeRuntimeService) PortForward(ctx context.Context, in *criapi.PortForwardRequest) (*criapi.PortForwardResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.forward = append(s.forward, in)
+	return &criapi.PortForwardResponse{Url: s.streamingURL + "/portforward/token"}, nil
+}
+
+// echoExecutor copies the stdin of exec sessions to their stdout.
+type echoExecutor struct{}
+
+func (echoExecutor) ExecInContainer(name string, uid types.UID, container string, cmd []string, in io.Reader, out, err io.WriteCloser, tty bool, resize <-chan remotecommand.TerminalSize, timeout time.Duration) error {
+	_, copyErr := io.Copy(out, in)
+	return copyErr
+}
+
+// newFakeRuntime starts a fake CRI runtime service, serving exec sessions with an echoExecutor, and returns a provider using it.
+// The returned function stops the runtime service.
+func newFakeRuntime(t *testing.T) (*CRIProvider, *fakeRuntimeService, func()) {
+	streaming := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// Actual streaming servers look up the options of the session using the token in the URL.
+		opts := &kubeletremotecommand.Options{Stdin: true, Stdout: true}
+		kubeletremotecommand.ServeExec(w, req, echoExecutor{}, "", "", "", nil, opts, 30*time.Second, 30*time.Second, remotecommandconsts.SupportedStreamingProtocols)
+	}))
+
+	dir, err := ioutil.TempDir("", "cri-runtime")
+	require.NoError(t, err)
+
+	socket := filepath.Join(dir, "cri.sock")
+	l, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+
+	service := newFakeRuntimeService(streaming.URL)
+	server := grpc.NewServer()
+	criapi.RegisterRuntimeServiceServer(server, service)
+	go server.Serve(l)
+
+	config, err := loadConfigFile("")
+	require.NoError(t, err)
+	conn, err := getClientConnection(socket, config)
+	require.NoError(t, err)
+
+	p := &CRIProvider{
+		podLogRoot:    filepath.Join(dir, "logs"),
+		podVolRoot:    filepath.Join(dir, "volumes"),
+		pods:          newPodCache(0),
+		runtimeClient: criapi.NewRuntimeServiceClient(conn),
+		imageClient:   &fakeImageService{},
+		syncers:       make(map[types.UID]*podSyncer),
+		cpuSamples:    make(map[string]cpuSample),
+	}
+	cleanup := func() {
+		p.syncersLock.Lock()
+		for _, s := range p.syncers {
+			s.cancel()
+		}
+		p.syncersLock.Unlock()
+		conn.Close()
+		server.Stop()
+		streaming.Close()
+		os.RemoveAll(dir)
+	}
+	return p, service, cleanup
+}
@@ -0,0 +1,220 @@
+// +build linux
+
+package cri
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	criapi "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+// Minimum age of the images which may be removed, so that images just pulled for containers about to be created are kept, as used by the kubelet
+const imageGCMinAge = 2 * time.Minute
+
+// Get the capacity and usage of the filesystem of the given path. Replaced in tests, so that the usage of the image filesystem can be controlled
+var imageFsStats = getFsStats
+
+// imageRecord tracks when an image was first seen and last used by a container
+type imageRecord struct {
+	firstDetected time.Time
+	lastUsed      time.Time
+	size          uint64
+}
+
+// imageGC removes the images which aren't used by any container, least recently used first, when the usage of the image filesystem exceeds a threshold, as the kubelet does
+// https://github.com/kubernetes/kubernetes/blob/v1.13.1/pkg/kubelet/images/image_gc_manager.go
+type imageGC struct {
+	highThreshold int // Usage of the image filesystem in percent above which images are removed
+	lowThreshold  int // Usage of the image filesystem in percent down to which images are removed
+
+	mu           sync.Mutex
+	images       map[string]*imageRecord // Indexed by image ID
+	diskPressure bool                    // Whether the usage couldn't be brought back below the low threshold by the last collection
+}
+
+func newImageGC(highThreshold, lowThreshold int) *imageGC {
+	return &imageGC{
+		highThreshold: highThreshold,
+		lowThreshold:  lowThreshold,
+		images:        make(map[string]*imageRecord),
+	}
+}
+
+// Whether the node is under disk pressure because the image filesystem is full of images in use
+func (gc *imageGC) hasDiskPressure() bool {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	return gc.diskPressure
+}
+
+// Collect unused images at the given period, until the process exits
+func (p *CRIProvider) runImageGC(period time.Duration) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		if err := p.collectImages(time.Now()); err != nil {
+			log.Printf("Image garbage collection failed: %v", err)
+		}
+		<-ticker.C
+	}
+}
+
+// Record which images are in use, and remove unused images if the usage of the image filesystem is above the high threshold
+func (p *CRIProvider) collectImages(now time.Time) error {
+	if err := p.detectImages(now); err != nil {
+		return err
+	}
+
+	capacity, available, err := p.getImageFsCapacity()
+	if err != nil {
+		return err
+	}
+	if capacity == 0 {
+		return nil
+	}
+	gc := p.imageGC
+	usage := int((capacity - available) * 100 / capacity)
+	if usage < gc.highThreshold {
+		gc.mu.Lock()
+		gc.diskPressure = false
+		gc.mu.Unlock()
+		return nil
+	}
+
+	amountToFree := int64(capacity)*int64(100-gc.lowThreshold)/100 - int64(available)
+	log.Printf("Image filesystem usage of %d%% is over the high threshold of %d%%, trying to free %d bytes", usage, gc.highThreshold, amountToFree)
+	freed, err := p.freeImageSpace(amountToFree, now)
+	gc.mu.Lock()
+	gc.diskPressure = freed < amountToFree
+	gc.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if freed < amountToFree {
+		return fmt.Errorf("failed to free %d bytes of images, only %d bytes were freed", amountToFree, freed)
+	}
+	return nil
+}
+
+// Update the records of the images present on the node, marking the ones used by containers as used at the given time
+func (p *CRIProvider) detectImages(now time.Time) error {
+	images, err := listImages(p.imageClient)
+	if err != nil {
+		return err
+	}
+	inUse, err := p.getImagesInUse()
+	if err != nil {
+		return err
+	}
+
+	gc := p.imageGC
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	present := make(map[string]bool, len(images))
+	for _, image := range images {
+		present[image.Id] = true
+		record, ok := gc.images[image.Id]
+		if !ok {
+			record = &imageRecord{firstDetected: now}
+			gc.images[image.Id] = record
+		}
+		record.size = image.Size_
+		used := inUse[image.Id]
+		for _, tag := range image.RepoTags {
+			used = used || inUse[tag]
+		}
+		if used {
+			record.lastUsed = now
+		}
+	}
+	for id := range gc.images {
+		if !present[id] {
+			delete(gc.images, id)
+		}
+	}
+	return nil
+}
+
+// Get the refs and names of the images used by the containers on the node, including exited ones
+func (p *CRIProvider) getImagesInUse() (map[string]bool, error) {
+	if err := p.refreshNodeState(); err != nil {
+		return nil, err
+	}
+	inUse := make(map[string]bool)
+	for _, cp := range p.pods.list() {
+		for _, containers := range []map[string]*criapi.ContainerStatus{cp.containers, cp.previous} {
+			for _, c := range containers {
+				inUse[c.ImageRef] = true
+				if c.Image != nil {
+					inUse[c.Image.Image] = true
+				}
+			}
+		}
+	}
+	return inUse, nil
+}
+
+// Get the capacity and the available space of the image filesystems which are mounted on the node
+func (p *CRIProvider) getImageFsCapacity() (uint64, uint64, error) {
+	filesystems, err := getImageFsInfo(p.imageClient)
+	if err != nil {
+		return 0, 0, err
+	}
+	var capacity, available uint64
+	for _, fs := range filesystems {
+		if fs.FsId == nil || fs.FsId.Mountpoint == "" {
+			continue
+		}
+		fsStats, err := imageFsStats(fs.FsId.Mountpoint)
+		if err != nil {
+			return 0, 0, err
+		}
+		capacity += *fsStats.CapacityBytes
+		available += *fsStats.AvailableBytes
+	}
+	return capacity, available, nil
+}
+
+// Remove images which weren't used when they were last detected, least recently used first, until the given amount of bytes is freed
+// Images detected less than imageGCMinAge before the given time are kept. The number of bytes freed is returned
+func (p *CRIProvider) freeImageSpace(amountToFree int64, now time.Time) (int64, error) {
+	gc := p.imageGC
+	gc.mu.Lock()
+	var candidates []string
+	for id, record := range gc.images {
+		if !record.lastUsed.Equal(now) && now.Sub(record.firstDetected) >= imageGCMinAge {
+			candidates = append(candidates, id)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := gc.images[candidates[i]], gc.images[candidates[j]]
+		if !a.lastUsed.Equal(b.lastUsed) {
+			return a.lastUsed.Before(b.lastUsed)
+		}
+		if !a.firstDetected.Equal(b.firstDetected) {
+			return a.firstDetected.Before(b.firstDetected)
+		}
+		return candidates[i] < candidates[j]
+	})
+	gc.mu.Unlock()
+
+	var freed int64
+	for _, id := range candidates {
+		if freed >= amountToFree {
+			break
+		}
+		log.Printf("Removing unused image %s", id)
+		if err := removeImage(p.imageClient, id); err != nil {
+			return freed, err
+		}
+		gc.mu.Lock()
+		freed += int64(gc.images[id].size)
+		delete(gc.images, id)
+		gc.mu.Unlock()
+	}
+	return freed, nil
+}
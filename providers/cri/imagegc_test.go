@@ -0,0 +1,106 @@
+// +build linux
+
+package cri
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+	"k8s.io/api/core/v1"
+	criapi "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+	stats "k8s.io/kubernetes/pkg/kubelet/apis/stats/v1alpha1"
+)
+
+// newFakeImageGC makes the provider collect the images of its image service, which are "app", used by the pod of the runtime, and unused images "old" and "older".
+// The image filesystem is reported with the given available space out of 1000 bytes. The returned function restores the actual filesystem stats.
+func newFakeImageGC(p *CRIProvider, highThreshold, lowThreshold int, available *uint64) func() {
+	p.imageGC = newImageGC(highThreshold, lowThreshold)
+	images := p.imageClient.(*fakeImageService)
+	images.images = map[string]*criapi.Image{
+		"sha256:app":   {Id: "sha256:app", RepoTags: []string{"app"}, Size_: 100},
+		"sha256:old":   {Id: "sha256:old", Size_: 200},
+		"sha256:older": {Id: "sha256:older", Size_: 300},
+	}
+	images.imageFs = []*criapi.FilesystemUsage{{FsId: &criapi.FilesystemIdentifier{Mountpoint: "/images"}}}
+	imageFsStats = func(path string) (*stats.FsStats, error) {
+		capacity := uint64(1000)
+		return &stats.FsStats{CapacityBytes: &capacity, AvailableBytes: available}, nil
+	}
+	return func() {
+		imageFsStats = getFsStats
+	}
+}
+
+func TestFreeImageSpace(t *testing.T) {
+	p, _, cleanup := newFakeRuntime(t)
+	defer cleanup()
+	available := uint64(500)
+	defer newFakeImageGC(p, 85, 80, &available)()
+	images := p.imageClient.(*fakeImageService)
+
+	start := time.Now()
+	require.NoError(t, p.detectImages(start))
+	now := start.Add(imageGCMinAge)
+	images.images["sha256:new"] = &criapi.Image{Id: "sha256:new", Size_: 400}
+	require.NoError(t, p.detectImages(now))
+	p.imageGC.images["sha256:old"].lastUsed = start
+
+	// Least recently used images are removed first, until enough space is freed.
+	freed, err := p.freeImageSpace(250, now)
+	require.NoError(t, err)
+	assert.Equal(t, int64(300), freed)
+	assert.Equal(t, []string{"sha256:older"}, images.removed)
+
+	// Images in use and recent images are kept.
+	freed, err = p.freeImageSpace(1000, now)
+	require.NoError(t, err)
+	assert.Equal(t, int64(200), freed)
+	assert.Equal(t, []string{"sha256:older", "sha256:old"}, images.removed)
+	assert.Len(t, images.images, 2)
+	assert.Contains(t, images.images, "sha256:app")
+	assert.Contains(t, images.images, "sha256:new")
+}
+
+func TestCollectImages(t *testing.T) {
+	p, _, cleanup := newFakeRuntime(t)
+	defer cleanup()
+	available := uint64(500)
+	defer newFakeImageGC(p, 85, 80, &available)()
+	images := p.imageClient.(*fakeImageService)
+	ctx := context.Background()
+	getDiskPressure := func() v1.ConditionStatus {
+		for _, condition := range p.NodeConditions(ctx) {
+			if condition.Type == v1.NodeDiskPressure {
+				return condition.Status
+			}
+		}
+		return ""
+	}
+
+	// Nothing is removed below the high threshold.
+	start := time.Now()
+	require.NoError(t, p.collectImages(start))
+	assert.Empty(t, images.removed)
+	assert.Equal(t, v1.ConditionFalse, getDiskPressure())
+
+	// Above it, images are removed down to the low threshold.
+	available = 100
+	require.NoError(t, p.collectImages(start.Add(imageGCMinAge)))
+	assert.Equal(t, []string{"sha256:old"}, images.removed)
+	assert.Equal(t, v1.ConditionFalse, getDiskPressure())
+	available = 0
+	require.NoError(t, p.collectImages(start.Add(2*imageGCMinAge)))
+	assert.Equal(t, []string{"sha256:old", "sha256:older"}, images.removed)
+
+	// The node is under disk pressure when not enough images can be removed.
+	assert.Error(t, p.collectImages(start.Add(3*imageGCMinAge)))
+	assert.Len(t, images.removed, 2, "images in use should be kept")
+	assert.Equal(t, v1.ConditionTrue, getDiskPressure())
+
+	available = 500
+	require.NoError(t, p.collectImages(start.Add(4*imageGCMinAge)))
+	assert.Equal(t, v1.ConditionFalse, getDiskPressure())
+}
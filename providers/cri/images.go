@@ -0,0 +1,105 @@
+// +build linux
+
+package cri
+
+import (
+	"fmt"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/virtual-kubelet/virtual-kubelet/providers/pullsecrets"
+	"k8s.io/api/core/v1"
+	"k8s.io/client-go/util/flowcontrol"
+	criapi "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+// Reasons for which containers wait for their image, as reported by the kubelet
+const (
+	ErrImagePull      = "ErrImagePull"
+	ImagePullBackOff  = "ImagePullBackOff"
+	ErrImageNeverPull = "ErrImageNeverPull"
+)
+
+// imagePullError is a failure to get the image of a container, which is reported as the reason why the container is waiting
+type imagePullError struct {
+	reason  string
+	message string
+}
+
+func (e *imagePullError) Error() string {
+	return fmt.Sprintf("%s: %s", e.reason, e.message)
+}
+
+// Get the pull policy of a container, defaulting it as the API server does if it isn't set
+// Images without a tag or with the "latest" tag are always pulled, and other images only if they aren't present
+func getPullPolicy(c *v1.Container) v1.PullPolicy {
+	if c.ImagePullPolicy != "" {
+		return c.ImagePullPolicy
+	}
+	if strings.Contains(c.Image, "@") {
+		return v1.PullIfNotPresent
+	}
+	name := c.Image[strings.LastIndex(c.Image, "/")+1:]
+	i := strings.LastIndex(name, ":")
+	if i < 0 || name[i+1:] == "latest" {
+		return v1.PullAlways
+	}
+	return v1.PullIfNotPresent
+}
+
+// Get the image of a container according to its pull policy, and return the image ref
+// Failed pulls are retried once the given back-off expires, and images which aren't present and may not be pulled fail right away
+// https://github.com/kubernetes/kubernetes/blob/v1.13.1/pkg/kubelet/images/image_manager.go#L86-L146
+func ensureImage(client criapi.ImageServiceClient, c *v1.Container, keyring *pullsecrets.Keyring, backOff *flowcontrol.Backoff) (string, error) {
+	policy := getPullPolicy(c)
+	if policy != v1.PullAlways {
+		image, err := getImageStatus(client, c.Image)
+		if err != nil {
+			return "", err
+		}
+		if image != nil {
+			return image.Id, nil
+		}
+		if policy == v1.PullNever {
+			return "", &imagePullError{ErrImageNeverPull, fmt.Sprintf("Container image %q is not present with pull policy of Never", c.Image)}
+		}
+	}
+
+	key := "image/" + c.Image
+	if backOff.IsInBackOffSinceUpdate(key, backOff.Clock.Now()) {
+		return "", &imagePullError{ImagePullBackOff, fmt.Sprintf("Back-off pulling image %q", c.Image)}
+	}
+	log.Printf("Pulling image %s", c.Image)
+	imageRef, err := pullImageWithKeyring(client, c.Image, keyring)
+	if err != nil {
+		backOff.Next(key, backOff.Clock.Now())
+		return "", &imagePullError{ErrImagePull, err.Error()}
+	}
+	return imageRef, nil
+}
+
+// pullImageWithKeyring pulls the specified image using the credentials in the specified keyring that apply to it, and returns the image ref.
+// As the kubelet does, each matching credential is tried in turn until one succeeds, and the image is pulled anonymously if none matches.
+// https://github.com/kubernetes/kubernetes/blob/v1.13.1/pkg/kubelet/kuberuntime/kuberuntime_image.go#L32-L73
+func pullImageWithKeyring(client criapi.ImageServiceClient, image string, keyring *pullsecrets.Keyring) (string, error) {
+	creds := keyring.Lookup(image)
+	if len(creds) == 0 {
+		return pullImage(client, image, nil)
+	}
+	var errs []string
+	for _, cred := range creds {
+		imageRef, err := pullImage(client, image, &criapi.AuthConfig{
+			Username:      cred.Username,
+			Password:      cred.Password,
+			ServerAddress: cred.Server,
+			IdentityToken: cred.IdentityToken,
+			RegistryToken: cred.RegistryToken,
+		})
+		if err == nil {
+			return imageRef, nil
+		}
+		log.Debugf("Failed to pull image %s with the credentials for %s: %v", image, cred.Server, err)
+		errs = append(errs, err.Error())
+	}
+	return "", fmt.Errorf("failed to pull image %s with any of the %d matching credentials: %s", image, len(creds), strings.Join(errs, "; "))
+}
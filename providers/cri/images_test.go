@@ -0,0 +1,149 @@
+// +build linux
+
+package cri
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/util/flowcontrol"
+	criapi "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+
+	"github.com/virtual-kubelet/virtual-kubelet/providers/pullsecrets"
+)
+
+// fakeImageService is an image service which only accepts pulls authenticated with the specified username, if any.
+// The images it reports run as the users specified for them, if any.
+// The images it lists, indexed by ID, are separate from the ones it reports the status of.
+type fakeImageService struct {
+	criapi.ImageServiceClient
+	username   string
+	requests   []*criapi.PullImageRequest
+	imageUsers map[string]*criapi.Image
+	imageFs    []*criapi.FilesystemUsage
+	images     map[string]*criapi.Image
+	removed    []string
+}
+
+func (s *fakeImageService) ListImages(ctx context.Context, in *criapi.ListImagesRequest, opts ...grpc.CallOption) (*criapi.ListImagesResponse, error) {
+	var images []*criapi.Image
+	for _, image := range s.images {
+		images = append(images, image)
+	}
+	return &criapi.ListImagesResponse{Images: images}, nil
+}
+
+func (s *fakeImageService) RemoveImage(ctx context.Context, in *criapi.RemoveImageRequest, opts ...grpc.CallOption) (*criapi.RemoveImageResponse, error) {
+	delete(s.images, in.Image.Image)
+	s.removed = append(s.removed, in.Image.Image)
+	return &criapi.RemoveImageResponse{}, nil
+}
+
+func (s *fakeImageService) ImageFsInfo(ctx context.Context, in *criapi.ImageFsInfoRequest, opts ...grpc.CallOption) (*criapi.ImageFsInfoResponse, error) {
+	return &criapi.ImageFsInfoResponse{ImageFilesystems: s.imageFs}, nil
+}
+
+func (s *fakeImageService) ImageStatus(ctx context.Context, in *criapi.ImageStatusRequest, opts ...grpc.CallOption) (*criapi.ImageStatusResponse, error) {
+	return &criapi.ImageStatusResponse{Image: s.imageUsers[in.Image.Image]}, nil
+}
+
+func (s *fakeImageService) PullImage(ctx context.Context, in *criapi.PullImageRequest, opts ...grpc.CallOption) (*criapi.PullImageResponse, error) {
+	s.requests = append(s.requests, in)
+	if s.username != "" && (in.Auth == nil || in.Auth.Username != s.username) {
+		return nil, errors.New("unauthorized")
+	}
+	return &criapi.PullImageResponse{ImageRef: "sha256:" + in.Image.Image}, nil
+}
+
+// TestPullImageWithKeyring verifies that the credentials matching an image are tried in turn, and that images without matching credentials are pulled anonymously.
+func TestPullImageWithKeyring(t *testing.T) {
+	keyring := pullsecrets.NewKeyring()
+	keyring.Add(
+		pullsecrets.Credential{Server: "registry.example.com/team", Username: "team", Password: "pass-0"},
+		pullsecrets.Credential{Server: "registry.example.com", Username: "user", Password: "pass-1"},
+	)
+
+	client := &fakeImageService{username: "user"}
+	imageRef, err := pullImageWithKeyring(client, "registry.example.com/team/app:1.0", keyring)
+	require.NoError(t, err)
+	assert.Equal(t, "sha256:registry.example.com/team/app:1.0", imageRef)
+	require.Len(t, client.requests, 2)
+	assert.Equal(t, "team", client.requests[0].Auth.Username)
+	assert.Equal(t, "registry.example.com", client.requests[1].Auth.ServerAddress)
+
+	client = &fakeImageService{}
+	_, err = pullImageWithKeyring(client, "busybox", keyring)
+	require.NoError(t, err)
+	require.Len(t, client.requests, 1)
+	assert.Nil(t, client.requests[0].Auth)
+
+	client = &fakeImageService{username: "other"}
+	_, err = pullImageWithKeyring(client, "registry.example.com/app", keyring)
+	assert.Error(t, err)
+	assert.Len(t, client.requests, 1)
+}
+
+func TestGetPullPolicy(t *testing.T) {
+	for image, expected := range map[string]v1.PullPolicy{
+		"busybox":                         v1.PullAlways,
+		"busybox:latest":                  v1.PullAlways,
+		"busybox:1.29":                    v1.PullIfNotPresent,
+		"registry.example.com:5000/app":   v1.PullAlways,
+		"registry.example.com:5000/app:1": v1.PullIfNotPresent,
+		"busybox@sha256:0123456789abcdef": v1.PullIfNotPresent,
+	} {
+		assert.Equal(t, expected, getPullPolicy(&v1.Container{Image: image}), image)
+	}
+	assert.Equal(t, v1.PullNever, getPullPolicy(&v1.Container{Image: "busybox", ImagePullPolicy: v1.PullNever}))
+}
+
+// TestEnsureImage verifies that images are only pulled as allowed by the pull policy of their container, and that failed pulls back off.
+func TestEnsureImage(t *testing.T) {
+	client := &fakeImageService{imageUsers: map[string]*criapi.Image{"present:1.0": {Id: "sha256:present"}}}
+	c := clock.NewFakeClock(time.Now())
+	backOff := flowcontrol.NewFakeBackOff(containerBackOffInitial, containerBackOffMax, c)
+	keyring := pullsecrets.NewKeyring()
+
+	imageRef, err := ensureImage(client, &v1.Container{Image: "present:1.0"}, keyring, backOff)
+	require.NoError(t, err)
+	assert.Equal(t, "sha256:present", imageRef)
+	imageRef, err = ensureImage(client, &v1.Container{Image: "present:1.0", ImagePullPolicy: v1.PullNever}, keyring, backOff)
+	require.NoError(t, err)
+	assert.Equal(t, "sha256:present", imageRef)
+	assert.Empty(t, client.requests)
+
+	imageRef, err = ensureImage(client, &v1.Container{Image: "present:1.0", ImagePullPolicy: v1.PullAlways}, keyring, backOff)
+	require.NoError(t, err)
+	assert.Equal(t, "sha256:present:1.0", imageRef)
+	_, err = ensureImage(client, &v1.Container{Image: "missing:1.0"}, keyring, backOff)
+	require.NoError(t, err)
+	assert.Len(t, client.requests, 2)
+
+	_, err = ensureImage(client, &v1.Container{Image: "missing:1.0", ImagePullPolicy: v1.PullNever}, keyring, backOff)
+	require.IsType(t, &imagePullError{}, err)
+	assert.Equal(t, ErrImageNeverPull, err.(*imagePullError).reason)
+	assert.Len(t, client.requests, 2)
+
+	// Failed pulls are only retried once their back-off expires.
+	client.username = "user"
+	_, err = ensureImage(client, &v1.Container{Image: "private"}, keyring, backOff)
+	require.IsType(t, &imagePullError{}, err)
+	assert.Equal(t, ErrImagePull, err.(*imagePullError).reason)
+	_, err = ensureImage(client, &v1.Container{Image: "private"}, keyring, backOff)
+	require.IsType(t, &imagePullError{}, err)
+	assert.Equal(t, ImagePullBackOff, err.(*imagePullError).reason)
+	assert.Len(t, client.requests, 3)
+
+	c.Step(containerBackOffInitial)
+	keyring.Add(pullsecrets.Credential{Server: "private", Username: "user"})
+	imageRef, err = ensureImage(client, &v1.Container{Image: "private"}, keyring, backOff)
+	require.NoError(t, err)
+	assert.Equal(t, "sha256:private", imageRef)
+}
@@ -0,0 +1,279 @@
+// +build linux
+
+package cri
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/api/core/v1"
+	criapi "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+// Conversions of CPU resources to cgroup settings, as done by the kubelet
+const (
+	minShares     = 2
+	sharesPerCPU  = 1024
+	milliCPUToCPU = 1000
+	// 100000 is equivalent to 100ms
+	quotaPeriod    = 100000
+	minQuotaPeriod = 1000
+)
+
+// OOM score adjustments of the containers of each QoS class, as set by the kubelet
+const (
+	guaranteedOOMScoreAdj = -998
+	besteffortOOMScoreAdj = 1000
+)
+
+// Directory holding the seccomp profiles referenced as "localhost/<profile>"
+const SeccompProfileRoot = "/var/lib/kubelet/seccomp"
+
+// Annotation key prefix of the AppArmor profiles of containers
+const AppArmorContainerAnnotationKeyPrefix = "container.apparmor.security.beta.kubernetes.io/"
+
+// Convert milli CPUs to CPU shares
+func milliCPUToShares(milliCPU int64) int64 {
+	if milliCPU == 0 {
+		// Return 2 here to really match kernel default for zero milliCPU.
+		return minShares
+	}
+	shares := (milliCPU * sharesPerCPU) / milliCPUToCPU
+	if shares < minShares {
+		return minShares
+	}
+	return shares
+}
+
+// Convert milli CPUs to a CFS quota over the given period
+func milliCPUToQuota(milliCPU int64, period int64) int64 {
+	if milliCPU == 0 {
+		return 0
+	}
+	quota := (milliCPU * period) / milliCPUToCPU
+	if quota < minQuotaPeriod {
+		quota = minQuotaPeriod
+	}
+	return quota
+}
+
+// Compute the QoS class of a pod from the resources of its containers
+func getPodQOS(pod *v1.Pod) v1.PodQOSClass {
+	requests := v1.ResourceList{}
+	limits := v1.ResourceList{}
+	isGuaranteed := true
+	containers := append(append([]v1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...)
+	for _, c := range containers {
+		for _, name := range []v1.ResourceName{v1.ResourceCPU, v1.ResourceMemory} {
+			request, hasRequest := c.Resources.Requests[name]
+			limit, hasLimit := c.Resources.Limits[name]
+			if hasRequest && !request.IsZero() {
+				requests[name] = request
+			}
+			if hasLimit && !limit.IsZero() {
+				limits[name] = limit
+			} else {
+				isGuaranteed = false
+			}
+			if hasRequest && hasLimit && request.Cmp(limit) != 0 {
+				isGuaranteed = false
+			}
+		}
+	}
+	if len(requests) == 0 && len(limits) == 0 {
+		return v1.PodQOSBestEffort
+	}
+	if isGuaranteed {
+		return v1.PodQOSGuaranteed
+	}
+	return v1.PodQOSBurstable
+}
+
+// Compute the OOM score adjustment of a container from the QoS class of its pod, and from its share of the memory of the node for burstable pods
+func getOOMScoreAdj(container *v1.Container, pod *v1.Pod, memoryCapacity int64) int64 {
+	switch getPodQOS(pod) {
+	case v1.PodQOSGuaranteed:
+		return guaranteedOOMScoreAdj
+	case v1.PodQOSBestEffort:
+		return besteffortOOMScoreAdj
+	}
+
+	// Containers requesting more memory are less likely to be killed, but always more so than guaranteed ones and less so than best effort ones
+	if memoryCapacity <= 0 {
+		return besteffortOOMScoreAdj - 1
+	}
+	memoryRequest := container.Resources.Requests.Memory().Value()
+	adj := 1000 - (1000*memoryRequest)/memoryCapacity
+	if adj < 1000+guaranteedOOMScoreAdj {
+		return 1000 + guaranteedOOMScoreAdj
+	}
+	if adj == besteffortOOMScoreAdj {
+		return adj - 1
+	}
+	return adj
+}
+
+// Create CRI LinuxContainerResources from the resources of a container
+// Containers without a CPU request get the CPU limit as their request, as the API server would
+func createCtrResources(container *v1.Container, pod *v1.Pod, memoryCapacity int64) *criapi.LinuxContainerResources {
+	cpuRequest := container.Resources.Requests.Cpu()
+	cpuLimit := container.Resources.Limits.Cpu()
+	if cpuRequest.IsZero() && !cpuLimit.IsZero() {
+		cpuRequest = cpuLimit
+	}
+
+	resources := &criapi.LinuxContainerResources{
+		CpuShares:          milliCPUToShares(cpuRequest.MilliValue()),
+		MemoryLimitInBytes: container.Resources.Limits.Memory().Value(),
+		OomScoreAdj:        getOOMScoreAdj(container, pod, memoryCapacity),
+	}
+	if !cpuLimit.IsZero() {
+		resources.CpuPeriod = quotaPeriod
+		resources.CpuQuota = milliCPUToQuota(cpuLimit.MilliValue(), quotaPeriod)
+	}
+	return resources
+}
+
+// Create the CRI namespace options of a pod, which shares the namespaces of the node as requested
+func createNamespaceOptions(pod *v1.Pod) *criapi.NamespaceOption {
+	options := &criapi.NamespaceOption{
+		Network: criapi.NamespaceMode_POD,
+		Pid:     criapi.NamespaceMode_CONTAINER,
+		Ipc:     criapi.NamespaceMode_POD,
+	}
+	if pod.Spec.HostNetwork {
+		options.Network = criapi.NamespaceMode_NODE
+	}
+	if pod.Spec.HostPID {
+		options.Pid = criapi.NamespaceMode_NODE
+	} else if pod.Spec.ShareProcessNamespace != nil && *pod.Spec.ShareProcessNamespace {
+		options.Pid = criapi.NamespaceMode_POD
+	}
+	if pod.Spec.HostIPC {
+		options.Ipc = criapi.NamespaceMode_NODE
+	}
+	return options
+}
+
+// Convert SELinux options to their CRI equivalent
+func createSELinuxOptions(options *v1.SELinuxOptions) *criapi.SELinuxOption {
+	if options == nil {
+		return nil
+	}
+	return &criapi.SELinuxOption{
+		User:  options.User,
+		Role:  options.Role,
+		Type:  options.Type,
+		Level: options.Level,
+	}
+}
+
+// Convert an optional ID to its CRI equivalent
+func createInt64Value(value *int64) *criapi.Int64Value {
+	if value == nil {
+		return nil
+	}
+	return &criapi.Int64Value{Value: *value}
+}
+
+// Get the supplemental groups of the processes of a pod, including the group owning its volumes
+func getSupplementalGroups(pod *v1.Pod) []int64 {
+	groups := []int64{}
+	if sc := pod.Spec.SecurityContext; sc != nil {
+		groups = append(groups, sc.SupplementalGroups...)
+		if sc.FSGroup != nil {
+			groups = append(groups, *sc.FSGroup)
+		}
+	}
+	return groups
+}
+
+// Get the path of a seccomp profile, given as "runtime/default", "docker/default", "unconfined" or "localhost/<profile>", as expected by CRI
+func getSeccompProfilePath(annotations map[string]string, containerName string) string {
+	profile, ok := annotations[v1.SeccompContainerAnnotationKeyPrefix+containerName]
+	if !ok || containerName == "" {
+		profile, ok = annotations[v1.SeccompPodAnnotationKey]
+	}
+	if !ok {
+		return ""
+	}
+	if strings.HasPrefix(profile, "localhost/") {
+		name := strings.TrimPrefix(profile, "localhost/")
+		return "localhost/" + filepath.Join(SeccompProfileRoot, filepath.FromSlash(name))
+	}
+	return profile
+}
+
+// Get the AppArmor profile of a container, given as "runtime/default", "unconfined" or "localhost/<profile>" as expected by CRI
+func getAppArmorProfile(annotations map[string]string, containerName string) string {
+	return annotations[AppArmorContainerAnnotationKeyPrefix+containerName]
+}
+
+// Convert capabilities to their CRI equivalent
+func createCapabilities(capabilities *v1.Capabilities) *criapi.Capability {
+	if capabilities == nil {
+		return nil
+	}
+	result := &criapi.Capability{}
+	for _, c := range capabilities.Add {
+		result.AddCapabilities = append(result.AddCapabilities, string(c))
+	}
+	for _, c := range capabilities.Drop {
+		result.DropCapabilities = append(result.DropCapabilities, string(c))
+	}
+	return result
+}
+
+// Get the security context of a container, with the settings of its pod applied unless it overrides them
+func getEffectiveSecurityContext(container *v1.Container, pod *v1.Pod) *v1.SecurityContext {
+	sc := &v1.SecurityContext{}
+	if container.SecurityContext != nil {
+		*sc = *container.SecurityContext
+	}
+	if psc := pod.Spec.SecurityContext; psc != nil {
+		if sc.SELinuxOptions == nil {
+			sc.SELinuxOptions = psc.SELinuxOptions
+		}
+		if sc.RunAsUser == nil {
+			sc.RunAsUser = psc.RunAsUser
+		}
+		if sc.RunAsGroup == nil {
+			sc.RunAsGroup = psc.RunAsGroup
+		}
+		if sc.RunAsNonRoot == nil {
+			sc.RunAsNonRoot = psc.RunAsNonRoot
+		}
+	}
+	return sc
+}
+
+// Verify that a container which must run as a non-root user does, either because of its security context or of the user of its image
+func verifyRunAsNonRoot(client criapi.ImageServiceClient, container *v1.Container, pod *v1.Pod, imageRef string) error {
+	sc := getEffectiveSecurityContext(container, pod)
+	if sc.RunAsNonRoot == nil || !*sc.RunAsNonRoot {
+		return nil
+	}
+	if sc.RunAsUser != nil {
+		if *sc.RunAsUser == 0 {
+			return fmt.Errorf("container %s has runAsNonRoot and runAsUser 0, which is root", container.Name)
+		}
+		return nil
+	}
+
+	image, err := getImageStatus(client, imageRef)
+	if err != nil {
+		return err
+	}
+	// Images which don't specify a user run as root
+	if image == nil || (image.Uid == nil && image.Username == "") {
+		return fmt.Errorf("container %s has runAsNonRoot and image will run as root", container.Name)
+	}
+	if image.Uid == nil {
+		return fmt.Errorf("container %s has runAsNonRoot and image has non-numeric user (%s), cannot verify user is non-root", container.Name, image.Username)
+	}
+	if image.Uid.Value == 0 {
+		return fmt.Errorf("container %s has runAsNonRoot and image will run as root", container.Name)
+	}
+	return nil
+}
@@ -0,0 +1,221 @@
+// +build linux
+
+package cri
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	criapi "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+func resourceList(cpu, memory string) v1.ResourceList {
+	list := v1.ResourceList{}
+	if cpu != "" {
+		list[v1.ResourceCPU] = resource.MustParse(cpu)
+	}
+	if memory != "" {
+		list[v1.ResourceMemory] = resource.MustParse(memory)
+	}
+	return list
+}
+
+func TestCreateCtrResources(t *testing.T) {
+	const memoryCapacity = 4 << 30
+
+	for _, tc := range []struct {
+		name      string
+		resources v1.ResourceRequirements
+		expected  criapi.LinuxContainerResources
+	}{
+		{
+			name:     "best effort",
+			expected: criapi.LinuxContainerResources{CpuShares: minShares, OomScoreAdj: besteffortOOMScoreAdj},
+		},
+		{
+			name: "guaranteed",
+			resources: v1.ResourceRequirements{
+				Requests: resourceList("500m", "1Gi"),
+				Limits:   resourceList("500m", "1Gi"),
+			},
+			expected: criapi.LinuxContainerResources{CpuShares: 512, CpuPeriod: 100000, CpuQuota: 50000, MemoryLimitInBytes: 1 << 30, OomScoreAdj: guaranteedOOMScoreAdj},
+		},
+		{
+			name: "limits only",
+			resources: v1.ResourceRequirements{
+				Limits: resourceList("2", "1Gi"),
+			},
+			expected: criapi.LinuxContainerResources{CpuShares: 2048, CpuPeriod: 100000, CpuQuota: 200000, MemoryLimitInBytes: 1 << 30, OomScoreAdj: guaranteedOOMScoreAdj},
+		},
+		{
+			name: "burstable",
+			resources: v1.ResourceRequirements{
+				Requests: resourceList("100m", "1Gi"),
+				Limits:   resourceList("", "2Gi"),
+			},
+			expected: criapi.LinuxContainerResources{CpuShares: 102, MemoryLimitInBytes: 2 << 30, OomScoreAdj: 750},
+		},
+		{
+			name: "tiny",
+			resources: v1.ResourceRequirements{
+				Requests: resourceList("1m", "1"),
+				Limits:   resourceList("1m", ""),
+			},
+			expected: criapi.LinuxContainerResources{CpuShares: minShares, CpuPeriod: 100000, CpuQuota: minQuotaPeriod, OomScoreAdj: besteffortOOMScoreAdj - 1},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			pod := &v1.Pod{Spec: v1.PodSpec{Containers: []v1.Container{{Name: "a", Resources: tc.resources}}}}
+			resources := createCtrResources(&pod.Spec.Containers[0], pod, memoryCapacity)
+			assert.Equal(t, tc.expected, *resources)
+		})
+	}
+}
+
+func TestGetPodQOS(t *testing.T) {
+	guaranteed := v1.ResourceRequirements{Requests: resourceList("1", "1Gi"), Limits: resourceList("1", "1Gi")}
+	pod := &v1.Pod{Spec: v1.PodSpec{Containers: []v1.Container{{Resources: guaranteed}}}}
+	assert.Equal(t, v1.PodQOSGuaranteed, getPodQOS(pod))
+
+	pod.Spec.InitContainers = []v1.Container{{Resources: v1.ResourceRequirements{Requests: resourceList("1", "")}}}
+	assert.Equal(t, v1.PodQOSBurstable, getPodQOS(pod))
+
+	pod = &v1.Pod{Spec: v1.PodSpec{Containers: []v1.Container{{}, {}}}}
+	assert.Equal(t, v1.PodQOSBestEffort, getPodQOS(pod))
+}
+
+func TestCreatePodSandboxLinuxConfig(t *testing.T) {
+	user, group, fsGroup := int64(1000), int64(2000), int64(3000)
+	shareProcessNamespace := true
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{v1.SeccompPodAnnotationKey: "localhost/profiles/audit.json"},
+		},
+		Spec: v1.PodSpec{
+			HostNetwork:           true,
+			HostIPC:               true,
+			ShareProcessNamespace: &shareProcessNamespace,
+			SecurityContext: &v1.PodSecurityContext{
+				SELinuxOptions:     &v1.SELinuxOptions{User: "system_u", Level: "s0:c1,c2"},
+				RunAsUser:          &user,
+				RunAsGroup:         &group,
+				SupplementalGroups: []int64{4000},
+				FSGroup:            &fsGroup,
+				Sysctls:            []v1.Sysctl{{Name: "net.core.somaxconn", Value: "1024"}},
+			},
+			Containers: []v1.Container{{Name: "a"}},
+		},
+	}
+
+	config := createPodSandboxLinuxConfig(pod)
+	sc := config.SecurityContext
+	assert.Equal(t, &criapi.NamespaceOption{Network: criapi.NamespaceMode_NODE, Pid: criapi.NamespaceMode_POD, Ipc: criapi.NamespaceMode_NODE}, sc.NamespaceOptions)
+	assert.Equal(t, &criapi.SELinuxOption{User: "system_u", Level: "s0:c1,c2"}, sc.SelinuxOptions)
+	assert.Equal(t, &criapi.Int64Value{Value: user}, sc.RunAsUser)
+	assert.Equal(t, &criapi.Int64Value{Value: group}, sc.RunAsGroup)
+	assert.Equal(t, []int64{4000, fsGroup}, sc.SupplementalGroups)
+	assert.False(t, sc.Privileged)
+	assert.Equal(t, "localhost/"+SeccompProfileRoot+"/profiles/audit.json", sc.SeccompProfilePath)
+	assert.Equal(t, map[string]string{"net.core.somaxconn": "1024"}, config.Sysctls)
+
+	// Pods without a security context run in their own namespaces
+	config = createPodSandboxLinuxConfig(&v1.Pod{})
+	assert.Equal(t, &criapi.NamespaceOption{Network: criapi.NamespaceMode_POD, Pid: criapi.NamespaceMode_CONTAINER, Ipc: criapi.NamespaceMode_POD}, config.SecurityContext.NamespaceOptions)
+	assert.Nil(t, config.SecurityContext.RunAsUser)
+	assert.Empty(t, config.SecurityContext.SeccompProfilePath)
+}
+
+func TestCreateCtrLinuxConfig(t *testing.T) {
+	podUser, containerUser, group := int64(1000), int64(1001), int64(2000)
+	readOnly, privileged, allowPrivilegeEscalation := true, true, false
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{
+				v1.SeccompPodAnnotationKey:                   "unconfined",
+				v1.SeccompContainerAnnotationKeyPrefix + "a": "runtime/default",
+				AppArmorContainerAnnotationKeyPrefix + "a":   "localhost/k8s-apparmor",
+			},
+		},
+		Spec: v1.PodSpec{
+			HostPID: true,
+			SecurityContext: &v1.PodSecurityContext{
+				SELinuxOptions: &v1.SELinuxOptions{Type: "spc_t"},
+				RunAsUser:      &podUser,
+				RunAsGroup:     &group,
+			},
+			Containers: []v1.Container{
+				{
+					Name: "a",
+					SecurityContext: &v1.SecurityContext{
+						Capabilities:             &v1.Capabilities{Add: []v1.Capability{"NET_ADMIN"}, Drop: []v1.Capability{"ALL"}},
+						RunAsUser:                &containerUser,
+						ReadOnlyRootFilesystem:   &readOnly,
+						AllowPrivilegeEscalation: &allowPrivilegeEscalation,
+					},
+					Resources: v1.ResourceRequirements{Limits: resourceList("1", "1Gi")},
+				},
+				{
+					Name:            "b",
+					SecurityContext: &v1.SecurityContext{Privileged: &privileged},
+				},
+			},
+		},
+	}
+
+	config := createCtrLinuxConfig(&pod.Spec.Containers[0], pod, 4<<30)
+	require.NotNil(t, config.Resources)
+	assert.Equal(t, int64(1<<30), config.Resources.MemoryLimitInBytes)
+	sc := config.SecurityContext
+	assert.Equal(t, &criapi.Capability{AddCapabilities: []string{"NET_ADMIN"}, DropCapabilities: []string{"ALL"}}, sc.Capabilities)
+	assert.False(t, sc.Privileged)
+	assert.Equal(t, criapi.NamespaceMode_NODE, sc.NamespaceOptions.Pid)
+	assert.Equal(t, &criapi.SELinuxOption{Type: "spc_t"}, sc.SelinuxOptions)
+	assert.Equal(t, &criapi.Int64Value{Value: containerUser}, sc.RunAsUser)
+	assert.Equal(t, &criapi.Int64Value{Value: group}, sc.RunAsGroup)
+	assert.True(t, sc.ReadonlyRootfs)
+	assert.True(t, sc.NoNewPrivs)
+	assert.Equal(t, "localhost/k8s-apparmor", sc.ApparmorProfile)
+	assert.Equal(t, "runtime/default", sc.SeccompProfilePath)
+
+	// Settings not overridden by the container come from the pod
+	config = createCtrLinuxConfig(&pod.Spec.Containers[1], pod, 4<<30)
+	sc = config.SecurityContext
+	assert.True(t, sc.Privileged)
+	assert.Equal(t, &criapi.Int64Value{Value: podUser}, sc.RunAsUser)
+	assert.False(t, sc.ReadonlyRootfs)
+	assert.False(t, sc.NoNewPrivs)
+	assert.Empty(t, sc.ApparmorProfile)
+	assert.Equal(t, "unconfined", sc.SeccompProfilePath)
+	assert.Equal(t, int64(besteffortOOMScoreAdj-1), config.Resources.OomScoreAdj, "the pod is burstable")
+
+	assert.True(t, createPodSandboxLinuxConfig(pod).SecurityContext.Privileged)
+}
+
+func TestVerifyRunAsNonRoot(t *testing.T) {
+	client := &fakeImageService{imageUsers: map[string]*criapi.Image{
+		"user":     {Uid: &criapi.Int64Value{Value: 1000}},
+		"root":     {Uid: &criapi.Int64Value{Value: 0}},
+		"username": {Username: "app"},
+		"default":  {},
+	}}
+	root, user, nonRoot := int64(0), int64(1000), true
+	container := &v1.Container{Name: "a"}
+	pod := &v1.Pod{Spec: v1.PodSpec{SecurityContext: &v1.PodSecurityContext{RunAsNonRoot: &nonRoot}}}
+
+	assert.NoError(t, verifyRunAsNonRoot(client, container, pod, "user"))
+	assert.Error(t, verifyRunAsNonRoot(client, container, pod, "root"))
+	assert.Error(t, verifyRunAsNonRoot(client, container, pod, "username"))
+	assert.Error(t, verifyRunAsNonRoot(client, container, pod, "default"))
+	assert.Error(t, verifyRunAsNonRoot(client, container, pod, "missing"))
+
+	container.SecurityContext = &v1.SecurityContext{RunAsUser: &user}
+	assert.NoError(t, verifyRunAsNonRoot(client, container, pod, "root"))
+	container.SecurityContext.RunAsUser = &root
+	assert.Error(t, verifyRunAsNonRoot(client, container, pod, "user"))
+
+	assert.NoError(t, verifyRunAsNonRoot(client, container, &v1.Pod{}, "root"))
+}
@@ -0,0 +1,443 @@
+// +build linux
+
+package cri
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	criapi "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+	stats "k8s.io/kubernetes/pkg/kubelet/apis/stats/v1alpha1"
+)
+
+// Location of the proc filesystem, from which the stats of the node and the network stats of pods are read
+var procRoot = "/proc"
+
+// Filesystem reported as the filesystem of the node
+var nodeFsRoot = "/"
+
+// Number of jiffies per second in /proc/stat
+const clockTicksPerSecond = 100
+
+// Interface reported as the network interface of the node and of pods when they have several
+const defaultNetworkInterface = "eth0"
+
+// Key of the CPU usage samples of the node, as opposed to those of containers which are indexed by ID
+const nodeCPUSampleKey = ""
+
+// A cumulated CPU usage at a given time, from which the usage rate is computed
+type cpuSample struct {
+	timestamp int64  // In nanoseconds since the epoch
+	usage     uint64 // In core nanoseconds
+}
+
+// Provider function to return the stats of the node, and of its pods and their containers
+// CPU usage rates are computed from the usage reported by the previous call
+func (p *CRIProvider) GetStatsSummary(ctx context.Context) (*stats.Summary, error) {
+	log.Printf("receive GetStatsSummary")
+
+	err := p.refreshNodeState()
+	if err != nil {
+		return nil, err
+	}
+	containerStats, err := listContainerStats(p.runtimeClient)
+	if err != nil {
+		return nil, err
+	}
+	statsById := make(map[string]*criapi.ContainerStats, len(containerStats))
+	for _, cs := range containerStats {
+		if cs.Attributes != nil {
+			statsById[cs.Attributes.Id] = cs
+		}
+	}
+
+	node, err := p.getNodeStats()
+	if err != nil {
+		return nil, err
+	}
+	summary := &stats.Summary{Node: *node}
+
+	sampled := map[string]bool{nodeCPUSampleKey: true}
+	for _, cp := range p.pods.list() {
+		ps := p.getPodStats(cp, statsById)
+		for _, c := range cp.containers {
+			sampled[c.Id] = true
+		}
+		summary.Pods = append(summary.Pods, ps)
+	}
+	sort.Slice(summary.Pods, func(i, j int) bool {
+		return summary.Pods[i].PodRef.UID < summary.Pods[j].PodRef.UID
+	})
+	p.pruneCPUSamples(sampled)
+
+	return summary, nil
+}
+
+// Build the stats of the given pod from the stats of its running containers, and from its network namespace if possible
+func (p *CRIProvider) getPodStats(cp *CRIPod, statsById map[string]*criapi.ContainerStats) stats.PodStats {
+	now := metav1.NewTime(time.Now())
+	ps := stats.PodStats{
+		PodRef: stats.PodReference{
+			Name:      cp.status.Metadata.Name,
+			Namespace: cp.status.Metadata.Namespace,
+			UID:       cp.status.Metadata.Uid,
+		},
+		StartTime:  metav1.NewTime(time.Unix(0, cp.status.CreatedAt)),
+		Containers: []stats.ContainerStats{},
+	}
+
+	var cpuUsage, cpuRate, memoryUsage, rootfsUsage uint64
+	var hasCPURate bool
+	for _, c := range cp.containers {
+		cs, ok := statsById[c.Id]
+		if !ok || c.State != criapi.ContainerState_CONTAINER_RUNNING {
+			continue
+		}
+		s := p.createContainerStats(c, cs)
+		if s.CPU != nil && s.CPU.UsageCoreNanoSeconds != nil {
+			cpuUsage += *s.CPU.UsageCoreNanoSeconds
+			if s.CPU.UsageNanoCores != nil {
+				cpuRate += *s.CPU.UsageNanoCores
+				hasCPURate = true
+			}
+		}
+		if s.Memory != nil && s.Memory.WorkingSetBytes != nil {
+			memoryUsage += *s.Memory.WorkingSetBytes
+		}
+		if s.Rootfs != nil && s.Rootfs.UsedBytes != nil {
+			rootfsUsage += *s.Rootfs.UsedBytes
+		}
+		ps.Containers = append(ps.Containers, s)
+	}
+	sort.Slice(ps.Containers, func(i, j int) bool {
+		return ps.Containers[i].Name < ps.Containers[j].Name
+	})
+
+	if len(ps.Containers) > 0 {
+		ps.CPU = &stats.CPUStats{Time: now, UsageCoreNanoSeconds: &cpuUsage}
+		if hasCPURate {
+			ps.CPU.UsageNanoCores = &cpuRate
+		}
+		ps.Memory = &stats.MemoryStats{Time: now, WorkingSetBytes: &memoryUsage}
+		ps.EphemeralStorage = &stats.FsStats{Time: now, UsedBytes: &rootfsUsage}
+	}
+
+	// The network stats are read from the network namespace of the sandbox, if the runtime tells which process runs in it
+	if pid := p.getSandboxPid(cp.id); pid > 0 {
+		interfaces, err := readNetDev(filepath.Join(procRoot, strconv.Itoa(pid), "net", "dev"))
+		if err != nil {
+			log.Debugf("Failed to read network stats of pod %s/%s: %v", ps.PodRef.Namespace, ps.PodRef.Name, err)
+		} else {
+			ps.Network = createNetworkStats(now, interfaces)
+		}
+	}
+	return ps
+}
+
+// Convert the CRI stats of a container
+func (p *CRIProvider) createContainerStats(c *criapi.ContainerStatus, cs *criapi.ContainerStats) stats.ContainerStats {
+	s := stats.ContainerStats{
+		Name:      c.Metadata.Name,
+		StartTime: metav1.NewTime(time.Unix(0, c.StartedAt)),
+	}
+	if cs.Cpu != nil && cs.Cpu.UsageCoreNanoSeconds != nil {
+		usage := cs.Cpu.UsageCoreNanoSeconds.Value
+		s.CPU = &stats.CPUStats{
+			Time:                 metav1.NewTime(time.Unix(0, cs.Cpu.Timestamp)),
+			UsageCoreNanoSeconds: &usage,
+			UsageNanoCores:       p.getCPUUsageRate(c.Id, cs.Cpu.Timestamp, usage),
+		}
+	}
+	if cs.Memory != nil && cs.Memory.WorkingSetBytes != nil {
+		workingSet := cs.Memory.WorkingSetBytes.Value
+		s.Memory = &stats.MemoryStats{
+			Time:            metav1.NewTime(time.Unix(0, cs.Memory.Timestamp)),
+			WorkingSetBytes: &workingSet,
+		}
+	}
+	if cs.WritableLayer != nil {
+		s.Rootfs = &stats.FsStats{Time: metav1.NewTime(time.Unix(0, cs.WritableLayer.Timestamp))}
+		if cs.WritableLayer.UsedBytes != nil {
+			used := cs.WritableLayer.UsedBytes.Value
+			s.Rootfs.UsedBytes = &used
+		}
+		if cs.WritableLayer.InodesUsed != nil {
+			inodesUsed := cs.WritableLayer.InodesUsed.Value
+			s.Rootfs.InodesUsed = &inodesUsed
+		}
+	}
+	return s
+}
+
+// Get the PID of a process running in the namespaces of the given sandbox from its verbose status, or 0 if the runtime doesn't report it
+func (p *CRIProvider) getSandboxPid(psId string) int {
+	info, err := getPodSandboxInfo(p.runtimeClient, psId)
+	if err != nil || info["info"] == "" {
+		return 0
+	}
+	var sandboxInfo struct {
+		Pid int `json:"pid"`
+	}
+	if err := json.Unmarshal([]byte(info["info"]), &sandboxInfo); err != nil {
+		return 0
+	}
+	return sandboxInfo.Pid
+}
+
+// Record the given cumulated CPU usage, and return the usage rate since the previous one recorded with the same key, if any
+func (p *CRIProvider) getCPUUsageRate(key string, timestamp int64, usage uint64) *uint64 {
+	p.cpuSamplesLock.Lock()
+	defer p.cpuSamplesLock.Unlock()
+	previous, ok := p.cpuSamples[key]
+	p.cpuSamples[key] = cpuSample{timestamp: timestamp, usage: usage}
+	if !ok || timestamp <= previous.timestamp || usage < previous.usage {
+		return nil
+	}
+	rate := uint64(float64(usage-previous.usage) / float64(timestamp-previous.timestamp) * float64(time.Second))
+	return &rate
+}
+
+// Forget the CPU usage samples of the containers which are gone
+func (p *CRIProvider) pruneCPUSamples(keep map[string]bool) {
+	p.cpuSamplesLock.Lock()
+	defer p.cpuSamplesLock.Unlock()
+	for key := range p.cpuSamples {
+		if !keep[key] {
+			delete(p.cpuSamples, key)
+		}
+	}
+}
+
+// Build the stats of the node from the proc filesystem, its root filesystem and the image filesystem of the runtime
+func (p *CRIProvider) getNodeStats() (*stats.NodeStats, error) {
+	now := time.Now()
+	node := &stats.NodeStats{NodeName: p.nodeName}
+
+	procStat, err := readProcStat(filepath.Join(procRoot, "stat"))
+	if err != nil {
+		return nil, err
+	}
+	node.StartTime = metav1.NewTime(time.Unix(procStat.bootTime, 0))
+	node.CPU = &stats.CPUStats{
+		Time:                 metav1.NewTime(now),
+		UsageCoreNanoSeconds: &procStat.cpuUsage,
+		UsageNanoCores:       p.getCPUUsageRate(nodeCPUSampleKey, now.UnixNano(), procStat.cpuUsage),
+	}
+
+	meminfo, err := readMeminfo(filepath.Join(procRoot, "meminfo"))
+	if err != nil {
+		return nil, err
+	}
+	total, free, available := meminfo["MemTotal"], meminfo["MemFree"], meminfo["MemAvailable"]
+	usage, workingSet := total-free, total-available
+	node.Memory = &stats.MemoryStats{
+		Time:            metav1.NewTime(now),
+		AvailableBytes:  &available,
+		UsageBytes:      &usage,
+		WorkingSetBytes: &workingSet,
+	}
+
+	interfaces, err := readNetDev(filepath.Join(procRoot, "net", "dev"))
+	if err != nil {
+		log.Debugf("Failed to read network stats of the node: %v", err)
+	} else {
+		node.Network = createNetworkStats(metav1.NewTime(now), interfaces)
+	}
+
+	node.Fs, err = getFsStats(nodeFsRoot)
+	if err != nil {
+		log.Debugf("Failed to get stats of filesystem %s: %v", nodeFsRoot, err)
+	}
+
+	// Runtimes may not report their image filesystem
+	imageFs, err := getImageFsInfo(p.imageClient)
+	if err != nil {
+		log.Debugf("Failed to get image filesystem info: %v", err)
+	} else if len(imageFs) > 0 {
+		node.Runtime = &stats.RuntimeStats{ImageFs: createImageFsStats(imageFs[0])}
+	}
+
+	return node, nil
+}
+
+// Convert the CRI usage of the image filesystem, completed with the capacity of the filesystem if it is mounted on the node
+func createImageFsStats(usage *criapi.FilesystemUsage) *stats.FsStats {
+	fs := &stats.FsStats{Time: metav1.NewTime(time.Unix(0, usage.Timestamp))}
+	if usage.FsId != nil && usage.FsId.Mountpoint != "" {
+		if capacity, err := getFsStats(usage.FsId.Mountpoint); err == nil {
+			fs = capacity
+		}
+	}
+	if usage.UsedBytes != nil {
+		used := usage.UsedBytes.Value
+		fs.UsedBytes = &used
+	}
+	if usage.InodesUsed != nil {
+		inodesUsed := usage.InodesUsed.Value
+		fs.InodesUsed = &inodesUsed
+	}
+	return fs
+}
+
+// Get the capacity and usage of the filesystem of the given path
+func getFsStats(path string) (*stats.FsStats, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return nil, err
+	}
+	capacity := st.Blocks * uint64(st.Bsize)
+	available := st.Bavail * uint64(st.Bsize)
+	used := (st.Blocks - st.Bfree) * uint64(st.Bsize)
+	inodes := st.Files
+	inodesFree := st.Ffree
+	inodesUsed := inodes - inodesFree
+	return &stats.FsStats{
+		Time:           metav1.NewTime(time.Now()),
+		AvailableBytes: &available,
+		CapacityBytes:  &capacity,
+		UsedBytes:      &used,
+		InodesFree:     &inodesFree,
+		Inodes:         &inodes,
+		InodesUsed:     &inodesUsed,
+	}, nil
+}
+
+// Build network stats from the stats of all the interfaces of a network namespace, except the loopback one
+// The default interface, or the first one, is reported as the main interface
+func createNetworkStats(now metav1.Time, interfaces []stats.InterfaceStats) *stats.NetworkStats {
+	ns := &stats.NetworkStats{Time: now}
+	for _, iface := range interfaces {
+		if iface.Name == "lo" {
+			continue
+		}
+		ns.Interfaces = append(ns.Interfaces, iface)
+		if iface.Name == defaultNetworkInterface || ns.InterfaceStats.Name == "" {
+			ns.InterfaceStats = iface
+		}
+	}
+	return ns
+}
+
+// Parsed contents of /proc/stat
+type procStat struct {
+	cpuUsage uint64 // Time spent by all CPUs on anything but idling, in nanoseconds
+	bootTime int64  // In seconds since the epoch
+}
+
+// Read the CPU usage and the boot time of the node from /proc/stat
+func readProcStat(path string) (*procStat, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	result := &procStat{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "cpu":
+			// user nice system idle iowait irq softirq steal...
+			for i, field := range fields[1:] {
+				if i == 3 || i == 4 || i > 7 {
+					continue
+				}
+				ticks, err := strconv.ParseUint(field, 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("invalid cpu line in %s: %v", path, err)
+				}
+				result.cpuUsage += ticks * uint64(time.Second/clockTicksPerSecond)
+			}
+		case "btime":
+			result.bootTime, err = strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid btime line in %s: %v", path, err)
+			}
+		}
+	}
+	return result, scanner.Err()
+}
+
+// Read the values of /proc/meminfo, in bytes
+func readMeminfo(path string) (map[string]uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	result := make(map[string]uint64)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Lines look like "MemTotal:       16318412 kB"
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		if len(fields) == 3 && fields[2] == "kB" {
+			value *= 1024
+		}
+		result[strings.TrimSuffix(fields[0], ":")] = value
+	}
+	return result, scanner.Err()
+}
+
+// Read the stats of the network interfaces of a network namespace from its /proc/<pid>/net/dev
+func readNetDev(path string) ([]stats.InterfaceStats, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var result []stats.InterfaceStats
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Lines look like "  eth0: rxBytes rxPackets rxErrs rxDrop rxFifo rxFrame rxCompressed rxMulticast txBytes txPackets txErrs..."
+		// after two header lines
+		line := scanner.Text()
+		i := strings.Index(line, ":")
+		if i < 0 {
+			continue
+		}
+		fields := strings.Fields(line[i+1:])
+		if len(fields) < 11 {
+			continue
+		}
+		values := make([]uint64, 11)
+		for j := range values {
+			values[j], err = strconv.ParseUint(fields[j], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid line in %s: %q", path, line)
+			}
+		}
+		rxBytes, rxErrors, txBytes, txErrors := values[0], values[2], values[8], values[10]
+		result = append(result, stats.InterfaceStats{
+			Name:     strings.TrimSpace(line[:i]),
+			RxBytes:  &rxBytes,
+			RxErrors: &rxErrors,
+			TxBytes:  &txBytes,
+			TxErrors: &txErrors,
+		})
+	}
+	return result, scanner.Err()
+}
@@ -0,0 +1,141 @@
+// +build linux
+
+package cri
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+	criapi "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+const testNetDev = `Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+    lo:    1000      10    0    0    0     0          0         0     1000      10    0    0    0     0       0          0
+  eth0:    2048      20    1    0    0     0          0         0     4096      40    2    0    0     0       0          0
+`
+
+// fakeProc creates a proc filesystem in which the process 42 runs in a network namespace of its own, and makes the provider read it.
+// The returned function restores the actual proc filesystem.
+func fakeProc(t *testing.T, cpuLine string) (string, func()) {
+	dir, err := ioutil.TempDir("", "cri-proc")
+	require.NoError(t, err)
+	files := map[string]string{
+		"stat":         cpuLine + "\ncpu0 0 0 0 0 0 0 0 0 0 0\nbtime 1500000000\n",
+		"meminfo":      "MemTotal:        4096 kB\nMemFree:         1024 kB\nMemAvailable:    2048 kB\n",
+		"net/dev":      testNetDev,
+		"42/net/dev":   testNetDev,
+		"images/.keep": "",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	}
+	procRoot = dir
+	return dir, func() {
+		procRoot = "/proc"
+		os.RemoveAll(dir)
+	}
+}
+
+func TestGetStatsSummary(t *testing.T) {
+	p, service, cleanup := newFakeRuntime(t)
+	defer cleanup()
+	dir, restore := fakeProc(t, "cpu 100 0 50 1000 10 0 0 0 0 0")
+	defer restore()
+
+	now := time.Now().UnixNano()
+	service.stats[fakeContainerId] = &criapi.ContainerStats{
+		Attributes:    &criapi.ContainerAttributes{Id: fakeContainerId},
+		Cpu:           &criapi.CpuUsage{Timestamp: now, UsageCoreNanoSeconds: &criapi.UInt64Value{Value: 1e9}},
+		Memory:        &criapi.MemoryUsage{Timestamp: now, WorkingSetBytes: &criapi.UInt64Value{Value: 1 << 20}},
+		WritableLayer: &criapi.FilesystemUsage{Timestamp: now, UsedBytes: &criapi.UInt64Value{Value: 4096}, InodesUsed: &criapi.UInt64Value{Value: 2}},
+	}
+	service.info[fakeSandboxId] = map[string]string{"info": `{"pid": 42}`}
+	p.imageClient.(*fakeImageService).imageFs = []*criapi.FilesystemUsage{
+		{Timestamp: now, FsId: &criapi.FilesystemIdentifier{Mountpoint: filepath.Join(dir, "images")}, UsedBytes: &criapi.UInt64Value{Value: 8192}},
+	}
+
+	summary, err := p.GetStatsSummary(context.Background())
+	require.NoError(t, err)
+
+	node := summary.Node
+	assert.Equal(t, int64(1500000000), node.StartTime.Unix())
+	require.NotNil(t, node.CPU)
+	assert.Equal(t, uint64(150*1e7), *node.CPU.UsageCoreNanoSeconds)
+	assert.Nil(t, node.CPU.UsageNanoCores, "the usage rate is only known from the second sample")
+	require.NotNil(t, node.Memory)
+	assert.Equal(t, uint64(2048*1024), *node.Memory.AvailableBytes)
+	assert.Equal(t, uint64(3072*1024), *node.Memory.UsageBytes)
+	assert.Equal(t, uint64(2048*1024), *node.Memory.WorkingSetBytes)
+	require.NotNil(t, node.Network)
+	assert.Equal(t, "eth0", node.Network.Name)
+	assert.Equal(t, uint64(2048), *node.Network.RxBytes)
+	assert.Equal(t, uint64(2), *node.Network.TxErrors)
+	assert.Len(t, node.Network.Interfaces, 1, "the loopback interface isn't reported")
+	require.NotNil(t, node.Fs)
+	assert.NotNil(t, node.Fs.CapacityBytes)
+	require.NotNil(t, node.Runtime)
+	assert.Equal(t, uint64(8192), *node.Runtime.ImageFs.UsedBytes)
+	assert.NotNil(t, node.Runtime.ImageFs.CapacityBytes)
+
+	require.Len(t, summary.Pods, 1)
+	pod := summary.Pods[0]
+	assert.Equal(t, "pod", pod.PodRef.Name)
+	assert.Equal(t, fakePodUID, pod.PodRef.UID)
+	require.Len(t, pod.Containers, 1)
+	c := pod.Containers[0]
+	assert.Equal(t, "app", c.Name)
+	assert.Equal(t, uint64(1e9), *c.CPU.UsageCoreNanoSeconds)
+	assert.Nil(t, c.CPU.UsageNanoCores)
+	assert.Equal(t, uint64(1<<20), *c.Memory.WorkingSetBytes)
+	assert.Equal(t, uint64(4096), *c.Rootfs.UsedBytes)
+	assert.Equal(t, uint64(2), *c.Rootfs.InodesUsed)
+	assert.Equal(t, uint64(1e9), *pod.CPU.UsageCoreNanoSeconds)
+	assert.Equal(t, uint64(1<<20), *pod.Memory.WorkingSetBytes)
+	assert.Equal(t, uint64(4096), *pod.EphemeralStorage.UsedBytes)
+	require.NotNil(t, pod.Network)
+	assert.Equal(t, "eth0", pod.Network.Name)
+	assert.Equal(t, uint64(4096), *pod.Network.TxBytes)
+
+	// Usage rates are computed from the previous sample.
+	service.stats[fakeContainerId].Cpu = &criapi.CpuUsage{Timestamp: now + int64(2*time.Second), UsageCoreNanoSeconds: &criapi.UInt64Value{Value: 2e9}}
+	summary, err = p.GetStatsSummary(context.Background())
+	require.NoError(t, err)
+	assert.NotNil(t, summary.Node.CPU.UsageNanoCores)
+	require.Len(t, summary.Pods, 1)
+	require.NotNil(t, summary.Pods[0].Containers[0].CPU.UsageNanoCores)
+	assert.Equal(t, uint64(5e8), *summary.Pods[0].Containers[0].CPU.UsageNanoCores)
+	assert.Equal(t, uint64(5e8), *summary.Pods[0].CPU.UsageNanoCores)
+
+	// Containers which aren't running and sandboxes without a known process have no stats.
+	service.exitContainer(fakeContainerId, 0)
+	delete(service.info, fakeSandboxId)
+	summary, err = p.GetStatsSummary(context.Background())
+	require.NoError(t, err)
+	require.Len(t, summary.Pods, 1)
+	assert.Empty(t, summary.Pods[0].Containers)
+	assert.Nil(t, summary.Pods[0].CPU)
+	assert.Nil(t, summary.Pods[0].Network)
+}
+
+func TestReadProcStat(t *testing.T) {
+	dir, restore := fakeProc(t, "cpu 1 2 3 400 500 6 7 8 9 10")
+	defer restore()
+
+	stat, err := readProcStat(filepath.Join(dir, "stat"))
+	require.NoError(t, err)
+	// Idle, iowait and guest times aren't counted
+	assert.Equal(t, uint64((1+2+3+6+7+8)*1e7), stat.cpuUsage)
+	assert.Equal(t, int64(1500000000), stat.bootTime)
+
+	_, err = readProcStat(filepath.Join(dir, "missing"))
+	assert.Error(t, err)
+}
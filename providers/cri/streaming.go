@@ -0,0 +1,210 @@
+// +build linux
+
+package cri
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/cpuguy83/strongerrors"
+	"github.com/virtual-kubelet/virtual-kubelet/providers"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+	criapi "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+// Find the CRI container with the given name in the given pod
+func (p *CRIProvider) findContainer(namespace, podName, containerName string) (*criapi.ContainerStatus, error) {
+	err := p.refreshNodeState()
+	if err != nil {
+		return nil, err
+	}
+
+	pod := p.findPodByName(namespace, podName)
+	if pod == nil {
+		return nil, strongerrors.NotFound(fmt.Errorf("Pod %s in namespace %s not found", podName, namespace))
+	}
+	container := pod.containers[containerName]
+	if container == nil {
+		return nil, strongerrors.NotFound(fmt.Errorf("Cannot find container %s in pod %s namespace %s", containerName, podName, namespace))
+	}
+	return container, nil
+}
+
+// Parse the URL of a streaming session returned by the CRI runtime
+func parseStreamingURL(rawURL string) (*url.URL, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid streaming URL %q returned by the runtime: %v", rawURL, err)
+	}
+	return u, nil
+}
+
+// Provider function to get the URL of the runtime's streaming server serving an exec session
+func (p *CRIProvider) GetExec(ctx context.Context, namespace, podName, containerName string, cmd []string, opts providers.StreamOptions) (*url.URL, error) {
+	log.Printf("receive GetExec %q", containerName)
+
+	container, err := p.findContainer(namespace, podName, containerName)
+	if err != nil {
+		return nil, err
+	}
+	rawURL, err := getExec(p.runtimeClient, &criapi.ExecRequest{
+		ContainerId: container.Id,
+		Cmd:         cmd,
+		Tty:         opts.TTY,
+		Stdin:       opts.Stdin,
+		Stdout:      opts.Stdout,
+		Stderr:      opts.Stderr,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return parseStreamingURL(rawURL)
+}
+
+// Provider function to get the URL of the runtime's streaming server serving an attach session
+func (p *CRIProvider) GetAttach(ctx context.Context, namespace, podName, containerName string, opts providers.StreamOptions) (*url.URL, error) {
+	log.Printf("receive GetAttach %q", containerName)
+
+	container, err := p.findContainer(namespace, podName, containerName)
+	if err != nil {
+		return nil, err
+	}
+	rawURL, err := getAttach(p.runtimeClient, &criapi.AttachRequest{
+		ContainerId: container.Id,
+		Tty:         opts.TTY,
+		Stdin:       opts.Stdin,
+		Stdout:      opts.Stdout,
+		Stderr:      opts.Stderr,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return parseStreamingURL(rawURL)
+}
+
+// Provider function to get the URL of the runtime's streaming server serving a port-forward session
+func (p *CRIProvider) GetPortForward(ctx context.Context, namespace, podName string, ports []int32) (*url.URL, error) {
+	log.Printf("receive GetPortForward %q", podName)
+
+	err := p.refreshNodeState()
+	if err != nil {
+		return nil, err
+	}
+
+	pod := p.findPodByName(namespace, podName)
+	if pod == nil {
+		return nil, strongerrors.NotFound(fmt.Errorf("Pod %s in namespace %s not found", podName, namespace))
+	}
+	rawURL, err := getPortForward(p.runtimeClient, &criapi.PortForwardRequest{
+		PodSandboxId: pod.id,
+		Port:         ports,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return parseStreamingURL(rawURL)
+}
+
+// ExecInContainer executes a command in a container in the pod, copying data
+// between in/out/err and the container's stdin/stdout/stderr.
+// Non-interactive commands are run synchronously by the runtime, while interactive ones are streamed from the runtime's streaming server.
+func (p *CRIProvider) ExecInContainer(name string, uid types.UID, container string, cmd []string, in io.Reader, out, errOut io.WriteCloser, tty bool, resize <-chan remotecommand.TerminalSize, timeout time.Duration) error {
+	log.Printf("receive ExecInContainer %q\n", container)
+
+	err := p.refreshNodeState()
+	if err != nil {
+		return err
+	}
+
+	pod := p.pods.getByUID(uid)
+	if pod == nil {
+		return strongerrors.NotFound(fmt.Errorf("Pod %s with UID %s not found", name, uid))
+	}
+	cstatus := pod.containers[container]
+	if cstatus == nil {
+		return strongerrors.NotFound(fmt.Errorf("Cannot find container %s in pod %s", container, name))
+	}
+
+	if in == nil && !tty {
+		return p.execSync(cstatus.Id, cmd, out, errOut, timeout)
+	}
+
+	rawURL, err := getExec(p.runtimeClient, &criapi.ExecRequest{
+		ContainerId: cstatus.Id,
+		Cmd:         cmd,
+		Tty:         tty,
+		Stdin:       in != nil,
+		Stdout:      out != nil,
+		// Multiplexing stderr is not supported with a TTY, in which case it is combined with stdout.
+		Stderr: errOut != nil && !tty,
+	})
+	if err != nil {
+		return err
+	}
+	u, err := parseStreamingURL(rawURL)
+	if err != nil {
+		return err
+	}
+	executor, err := remotecommand.NewSPDYExecutor(&rest.Config{}, "POST", u)
+	if err != nil {
+		return fmt.Errorf("failed to create streaming executor: %v", err)
+	}
+	opts := remotecommand.StreamOptions{
+		Stdin:  in,
+		Stdout: out,
+		Tty:    tty,
+	}
+	if !tty {
+		opts.Stderr = errOut
+	}
+	if resize != nil {
+		opts.TerminalSizeQueue = terminalSizeQueue(resize)
+	}
+	return executor.Stream(opts)
+}
+
+// ReportsExecExitStatus returns true, as commands exiting with a non-zero code are reported as errors by ExecInContainer
+func (p *CRIProvider) ReportsExecExitStatus() bool {
+	return true
+}
+
+// Run a command synchronously in a container, and copy its output to out and errOut
+// A non-zero exit code is reported as an error
+func (p *CRIProvider) execSync(containerId string, cmd []string, out, errOut io.Writer, timeout time.Duration) error {
+	r, err := execSync(p.runtimeClient, containerId, cmd, timeout)
+	if err != nil {
+		return err
+	}
+	if out != nil {
+		if _, err := out.Write(r.Stdout); err != nil {
+			return err
+		}
+	}
+	if errOut != nil {
+		if _, err := errOut.Write(r.Stderr); err != nil {
+			return err
+		}
+	}
+	if r.ExitCode != 0 {
+		return fmt.Errorf("command %q exited with code %d", cmd, r.ExitCode)
+	}
+	return nil
+}
+
+// terminalSizeQueue adapts a channel of terminal sizes to remotecommand.TerminalSizeQueue
+type terminalSizeQueue <-chan remotecommand.TerminalSize
+
+// Next returns the next terminal size, or nil once the channel is closed
+func (q terminalSizeQueue) Next() *remotecommand.TerminalSize {
+	size, ok := <-q
+	if !ok {
+		return nil
+	}
+	return &size
+}
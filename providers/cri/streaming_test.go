@@ -0,0 +1,88 @@
+// +build linux
+
+package cri
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cpuguy83/strongerrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+	criapi "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+
+	"github.com/virtual-kubelet/virtual-kubelet/providers"
+)
+
+func TestGetStreamingURLs(t *testing.T) {
+	p, service, cleanup := newFakeRuntime(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	u, err := p.GetExec(ctx, "default", "pod", "app", []string{"sh"}, providers.StreamOptions{Stdin: true, Stdout: true, TTY: true})
+	require.NoError(t, err)
+	assert.Equal(t, service.streamingURL+"/exec/token", u.String())
+	require.Len(t, service.exec, 1)
+	assert.Equal(t, &criapi.ExecRequest{ContainerId: fakeContainerId, Cmd: []string{"sh"}, Tty: true, Stdin: true, Stdout: true}, service.exec[0])
+
+	u, err = p.GetAttach(ctx, "default", "pod", "app", providers.StreamOptions{Stdout: true, Stderr: true})
+	require.NoError(t, err)
+	assert.Equal(t, service.streamingURL+"/attach/token", u.String())
+	require.Len(t, service.attach, 1)
+	assert.Equal(t, &criapi.AttachRequest{ContainerId: fakeContainerId, Stdout: true, Stderr: true}, service.attach[0])
+
+	u, err = p.GetPortForward(ctx, "default", "pod", []int32{80, 8080})
+	require.NoError(t, err)
+	assert.Equal(t, service.streamingURL+"/portforward/token", u.String())
+	require.Len(t, service.forward, 1)
+	assert.Equal(t, &criapi.PortForwardRequest{PodSandboxId: fakeSandboxId, Port: []int32{80, 8080}}, service.forward[0])
+
+	_, err = p.GetExec(ctx, "default", "pod", "missing", []string{"sh"}, providers.StreamOptions{Stdout: true})
+	assert.True(t, strongerrors.IsNotFound(err), "expected a not found error, got: %v", err)
+	_, err = p.GetPortForward(ctx, "default", "missing", nil)
+	assert.True(t, strongerrors.IsNotFound(err), "expected a not found error, got: %v", err)
+}
+
+func TestExecInContainerSync(t *testing.T) {
+	p, service, cleanup := newFakeRuntime(t)
+	defer cleanup()
+
+	var stdout, stderr bytes.Buffer
+	err := p.ExecInContainer("default-pod", fakePodUID, "app", []string{"echo", "hello", "world"}, nil, nopWriteCloser{&stdout}, nopWriteCloser{&stderr}, false, nil, 5*time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", stdout.String())
+	require.Len(t, service.execSync, 1)
+	assert.Equal(t, &criapi.ExecSyncRequest{ContainerId: fakeContainerId, Cmd: []string{"echo", "hello", "world"}, Timeout: 5}, service.execSync[0])
+
+	stdout.Reset()
+	err = p.ExecInContainer("default-pod", fakePodUID, "app", []string{"false"}, nil, nopWriteCloser{&stdout}, nopWriteCloser{&stderr}, false, nil, 0)
+	assert.Error(t, err)
+	assert.Equal(t, "failed", stderr.String())
+
+	err = p.ExecInContainer("default-other", "other-uid", "app", []string{"true"}, nil, nil, nil, false, nil, 0)
+	assert.True(t, strongerrors.IsNotFound(err), "expected a not found error, got: %v", err)
+}
+
+func TestExecInContainerStreaming(t *testing.T) {
+	p, service, cleanup := newFakeRuntime(t)
+	defer cleanup()
+
+	var stdout bytes.Buffer
+	err := p.ExecInContainer("default-pod", fakePodUID, "app", []string{"cat"}, strings.NewReader("ping"), nopWriteCloser{&stdout}, nil, false, nil, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", stdout.String())
+	require.Len(t, service.exec, 1)
+	assert.Equal(t, &criapi.ExecRequest{ContainerId: fakeContainerId, Cmd: []string{"cat"}, Stdin: true, Stdout: true}, service.exec[0])
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
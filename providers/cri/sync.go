@@ -0,0 +1,316 @@
+// +build linux
+
+package cri
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/virtual-kubelet/virtual-kubelet/providers/pullsecrets"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/flowcontrol"
+	criapi "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+const (
+	// Period at which the containers of each pod are synced
+	podSyncPeriod = time.Second
+	// Delays between successive restarts of a container, as used by the kubelet
+	containerBackOffInitial = 10 * time.Second
+	containerBackOffMax     = 5 * time.Minute
+)
+
+// podSyncer starts the containers of a pod, and restarts them according to its restart policy, until it is stopped
+type podSyncer struct {
+	mu      sync.Mutex
+	pod     *v1.Pod
+	psId    string
+	waiting map[string]v1.ContainerStateWaiting // Why the containers which couldn't be created yet are waiting, indexed by name
+	backOff *flowcontrol.Backoff                // Delays restarts of containers, indexed by name, and pulls of images
+	cancel  context.CancelFunc
+}
+
+// waitingContainer is a container which couldn't be created yet, along with the reason why
+type waitingContainer struct {
+	container *v1.Container
+	init      bool
+	state     v1.ContainerStateWaiting
+}
+
+// Return the spec of the pod to sync, and the ID of its sandbox
+func (s *podSyncer) get() (*v1.Pod, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pod, s.psId
+}
+
+// Sync the given pod, running in the given sandbox, and keep syncing it periodically if that succeeds
+// Any previous sync loop of the pod is stopped
+func (p *CRIProvider) startPodSync(pod *v1.Pod, psId string) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &podSyncer{
+		pod:     pod,
+		psId:    psId,
+		waiting: make(map[string]v1.ContainerStateWaiting),
+		backOff: flowcontrol.NewBackOff(containerBackOffInitial, containerBackOffMax),
+		cancel:  cancel,
+	}
+	if err := p.syncPod(s); err != nil {
+		cancel()
+		return err
+	}
+
+	p.syncersLock.Lock()
+	if previous, ok := p.syncers[pod.UID]; ok {
+		previous.cancel()
+	}
+	p.syncers[pod.UID] = s
+	p.syncersLock.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(podSyncPeriod)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := p.syncPod(s); err != nil {
+					log.Printf("Error syncing pod %s/%s: %v", pod.Namespace, pod.Name, err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// Record why the given container is waiting to be created, or that it isn't any more if state is nil
+func (s *podSyncer) setWaiting(name string, state *v1.ContainerStateWaiting) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if state == nil {
+		delete(s.waiting, name)
+	} else {
+		s.waiting[name] = *state
+	}
+}
+
+// Get the containers of the pod being synced which are waiting to be created, init containers first
+func (s *podSyncer) getWaiting() []waitingContainer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var res []waitingContainer
+	for i, c := range s.pod.Spec.InitContainers {
+		if state, ok := s.waiting[c.Name]; ok {
+			res = append(res, waitingContainer{container: &s.pod.Spec.InitContainers[i], init: true, state: state})
+		}
+	}
+	for i, c := range s.pod.Spec.Containers {
+		if state, ok := s.waiting[c.Name]; ok {
+			res = append(res, waitingContainer{container: &s.pod.Spec.Containers[i], state: state})
+		}
+	}
+	return res
+}
+
+// Update the spec of the given pod used to restart its containers, if it is being synced
+func (p *CRIProvider) updatePodSync(pod *v1.Pod) {
+	p.syncersLock.Lock()
+	defer p.syncersLock.Unlock()
+	if s, ok := p.syncers[pod.UID]; ok {
+		s.mu.Lock()
+		s.pod = pod
+		s.mu.Unlock()
+	}
+}
+
+// Get the containers of the pod with the given UID which are waiting to be created, if it is being synced
+func (p *CRIProvider) getWaitingContainers(uid types.UID) []waitingContainer {
+	p.syncersLock.Lock()
+	s, ok := p.syncers[uid]
+	p.syncersLock.Unlock()
+	if !ok {
+		return nil
+	}
+	return s.getWaiting()
+}
+
+// Stop syncing the pod with the given UID
+func (p *CRIProvider) stopPodSync(uid types.UID) {
+	p.syncersLock.Lock()
+	defer p.syncersLock.Unlock()
+	if s, ok := p.syncers[uid]; ok {
+		s.cancel()
+		delete(p.syncers, uid)
+	}
+}
+
+// Sync the pod being synced with its spec
+// Its state is read from the pod cache, which is only refreshed when outdated rather than on every sync
+func (p *CRIProvider) syncPod(s *podSyncer) error {
+	pod, psId := s.get()
+	if err := p.refreshNodeState(); err != nil {
+		return err
+	}
+	cp := p.pods.getByUID(pod.UID)
+	if cp == nil || cp.id != psId {
+		return fmt.Errorf("sandbox %s of pod %s/%s not found", psId, pod.Namespace, pod.Name)
+	}
+	return p.syncContainers(pod, cp, s)
+}
+
+// Start the containers of a pod which haven't been started yet, and restart the exited ones which should be, unless they are backing off from previous restarts
+// Init containers are run one after the other, and must all succeed before the app containers are started
+func (p *CRIProvider) syncContainers(pod *v1.Pod, cp *CRIPod, s *podSyncer) error {
+	if cp.status.State != criapi.PodSandboxState_SANDBOX_READY {
+		return nil
+	}
+
+	// The image pull secrets are only resolved if a container has to be created
+	var keyring *pullsecrets.Keyring
+	start := func(c *v1.Container) error {
+		if keyring == nil {
+			var err error
+			keyring, err = pullsecrets.ForPod(p.resourceManager, pod)
+			if err != nil {
+				return err
+			}
+		}
+		return p.startNewContainer(pod, c, cp, keyring, s)
+	}
+
+	for i := range pod.Spec.InitContainers {
+		c := &pod.Spec.InitContainers[i]
+		status := cp.containers[c.Name]
+		if status == nil {
+			return start(c)
+		}
+		if status.State == criapi.ContainerState_CONTAINER_EXITED && status.ExitCode == 0 {
+			continue
+		}
+		if shouldRestartContainer(pod.Spec.RestartPolicy, status) {
+			if err := p.restartContainerWithBackOff(pod, c, cp, status, s.backOff); err != nil {
+				return err
+			}
+		}
+		// The following containers wait for this one to succeed
+		return nil
+	}
+
+	for i := range pod.Spec.Containers {
+		c := &pod.Spec.Containers[i]
+		status := cp.containers[c.Name]
+		if status == nil {
+			if err := start(c); err != nil {
+				return err
+			}
+			continue
+		}
+		if shouldRestartContainer(pod.Spec.RestartPolicy, status) {
+			if err := p.restartContainerWithBackOff(pod, c, cp, status, s.backOff); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Restart the given exited container, unless it is backing off from previous restarts
+// Restarts are delayed exponentially, and the delay is reset once a container has run long enough
+func (p *CRIProvider) restartContainerWithBackOff(pod *v1.Pod, c *v1.Container, cp *CRIPod, exited *criapi.ContainerStatus, backOff *flowcontrol.Backoff) error {
+	finishedAt := time.Unix(0, exited.FinishedAt)
+	if backOff.IsInBackOffSince(c.Name, finishedAt) {
+		return nil
+	}
+	backOff.Next(c.Name, finishedAt)
+
+	err := p.restartContainer(pod, c, cp, exited)
+	if err != nil {
+		return fmt.Errorf("failed to restart container %s: %v", c.Name, err)
+	}
+	return nil
+}
+
+// Get the image of the given container according to its pull policy, and create and start its first attempt
+// Failures to get the image are not returned, but recorded as the reason why the container is waiting until the next sync
+func (p *CRIProvider) startNewContainer(pod *v1.Pod, c *v1.Container, cp *CRIPod, keyring *pullsecrets.Keyring, s *podSyncer) error {
+	imageRef, err := ensureImage(p.imageClient, c, keyring, s.backOff)
+	if pullErr, ok := err.(*imagePullError); ok {
+		log.Printf("Failed to get image %s for container %s of pod %s/%s: %v", c.Image, c.Name, pod.Namespace, pod.Name, err)
+		s.setWaiting(c.Name, &v1.ContainerStateWaiting{Reason: pullErr.reason, Message: pullErr.message})
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	s.setWaiting(c.Name, nil)
+	log.Printf("Creating container %s", c.Name)
+	return p.runContainer(pod, c, cp, imageRef, 0)
+}
+
+// Decide whether an exited container should be restarted, according to the restart policy of its pod
+func shouldRestartContainer(policy v1.RestartPolicy, status *criapi.ContainerStatus) bool {
+	if status.State != criapi.ContainerState_CONTAINER_EXITED {
+		return false
+	}
+	switch policy {
+	case v1.RestartPolicyNever:
+		return false
+	case v1.RestartPolicyOnFailure:
+		return status.ExitCode != 0
+	default:
+		return true
+	}
+}
+
+// Create and start the next attempt of the given exited container
+// Only the attempt preceding the new one is kept, so that its termination state can be reported
+func (p *CRIProvider) restartContainer(pod *v1.Pod, c *v1.Container, cp *CRIPod, exited *criapi.ContainerStatus) error {
+	attempt := exited.Metadata.Attempt + 1
+	log.Printf("Restarting container %s of pod %s/%s, attempt %d", c.Name, pod.Namespace, pod.Name, attempt)
+
+	err := p.runContainer(pod, c, cp, exited.ImageRef, attempt)
+	if err != nil {
+		return err
+	}
+
+	if previous := cp.previous[c.Name]; previous != nil {
+		err = removeContainer(p.runtimeClient, previous.Id)
+		p.pods.invalidate()
+		if err != nil {
+			// The container is removed on the next restart, or along with the sandbox
+			log.Printf("Failed to remove container %s: %v", previous.Id, err)
+		}
+	}
+	return nil
+}
+
+// Create and start the given attempt of a container in the sandbox of its pod
+func (p *CRIProvider) runContainer(pod *v1.Pod, c *v1.Container, cp *CRIPod, imageRef string, attempt uint32) error {
+	defer p.pods.invalidate()
+
+	pConfig, err := generatePodSandboxConfig(pod, filepath.Join(p.podLogRoot, string(pod.UID)), cp.status.Metadata.Attempt)
+	if err != nil {
+		return err
+	}
+	cConfig, err := generateContainerConfig(c, pod, imageRef, filepath.Join(p.podVolRoot, string(pod.UID)), p.resourceManager, attempt)
+	log.Debugf("%v", cConfig)
+	if err != nil {
+		return err
+	}
+	err = verifyRunAsNonRoot(p.imageClient, c, pod, imageRef)
+	if err != nil {
+		return err
+	}
+	cId, err := createContainer(p.runtimeClient, cConfig, pConfig, cp.id)
+	if err != nil {
+		return err
+	}
+	log.Printf("Starting container %s", c.Name)
+	return startContainer(p.runtimeClient, cId)
+}
@@ -0,0 +1,342 @@
+// +build linux
+
+package cri
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/util/flowcontrol"
+	criapi "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+)
+
+func newSyncTestPod(restartPolicy v1.RestartPolicy, images ...string) *v1.Pod {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "sync", UID: "sync-uid"},
+		Spec:       v1.PodSpec{RestartPolicy: restartPolicy},
+	}
+	for i, image := range images {
+		pod.Spec.Containers = append(pod.Spec.Containers, v1.Container{Name: string('a' + rune(i)), Image: image})
+	}
+	return pod
+}
+
+// withInitContainers adds init containers named "init-a", "init-b"... running the given images to the pod.
+func withInitContainers(pod *v1.Pod, images ...string) *v1.Pod {
+	for i, image := range images {
+		pod.Spec.InitContainers = append(pod.Spec.InitContainers, v1.Container{Name: "init-" + string('a'+rune(i)), Image: image})
+	}
+	return pod
+}
+
+func getPodCondition(status *v1.PodStatus, conditionType v1.PodConditionType) *v1.PodCondition {
+	for i := range status.Conditions {
+		if status.Conditions[i].Type == conditionType {
+			return &status.Conditions[i]
+		}
+	}
+	return nil
+}
+
+// pauseSync stops the sync loop of the given pod, so that it is only synced by the test, and makes its restarts back off using the returned clock.
+func pauseSync(t *testing.T, p *CRIProvider, pod *v1.Pod) (*podSyncer, *clock.FakeClock) {
+	p.syncersLock.Lock()
+	defer p.syncersLock.Unlock()
+	s, ok := p.syncers[pod.UID]
+	require.True(t, ok, "pod is not synced")
+	s.cancel()
+	c := clock.NewFakeClock(time.Now())
+	s.backOff = flowcontrol.NewFakeBackOff(containerBackOffInitial, containerBackOffMax, c)
+	return s, c
+}
+
+func TestCreatePodRemovesSandboxOnFailure(t *testing.T) {
+	p, service, cleanup := newFakeRuntime(t)
+	defer cleanup()
+
+	err := p.CreatePod(context.Background(), newSyncTestPod(v1.RestartPolicyAlways, "app", "invalid"))
+	assert.Error(t, err)
+	assert.Len(t, service.sandboxes, 1, "the sandbox should have been removed")
+	assert.Len(t, service.containers, 1, "the containers should have been removed")
+	assert.Empty(t, p.syncers)
+}
+
+func TestSyncPodRestartsContainers(t *testing.T) {
+	p, service, cleanup := newFakeRuntime(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	pod := newSyncTestPod(v1.RestartPolicyAlways, "app")
+	require.NoError(t, p.CreatePod(ctx, pod))
+	s, c := pauseSync(t, p, pod)
+	assert.Equal(t, []string{""}, service.handlers)
+
+	// The first restart is immediate.
+	service.exitContainer(service.attempts(s.psId, "a")[0], 1)
+	require.NoError(t, p.syncPod(s))
+	status, err := p.GetPodStatus(ctx, pod.Namespace, pod.Name)
+	require.NoError(t, err)
+	assert.Equal(t, v1.PodRunning, status.Phase)
+	require.Len(t, status.ContainerStatuses, 1)
+	cs := status.ContainerStatuses[0]
+	assert.Equal(t, int32(1), cs.RestartCount)
+	assert.NotNil(t, cs.State.Running)
+	require.NotNil(t, cs.LastTerminationState.Terminated)
+	assert.Equal(t, int32(1), cs.LastTerminationState.Terminated.ExitCode)
+
+	// The next one is delayed.
+	service.exitContainer(service.attempts(s.psId, "a")[1], 2)
+	require.NoError(t, p.syncPod(s))
+	status, err = p.GetPodStatus(ctx, pod.Namespace, pod.Name)
+	require.NoError(t, err)
+	assert.Equal(t, v1.PodRunning, status.Phase)
+	cs = status.ContainerStatuses[0]
+	assert.Equal(t, int32(1), cs.RestartCount)
+	require.NotNil(t, cs.State.Terminated)
+	assert.Equal(t, int32(2), cs.State.Terminated.ExitCode)
+
+	c.Step(containerBackOffInitial + time.Second)
+	require.NoError(t, p.syncPod(s))
+	attempts := service.attempts(s.psId, "a")
+	assert.Len(t, attempts, 2, "only the previous attempt should be kept")
+	assert.Contains(t, attempts, uint32(1))
+	assert.Contains(t, attempts, uint32(2))
+	status, err = p.GetPodStatus(ctx, pod.Namespace, pod.Name)
+	require.NoError(t, err)
+	cs = status.ContainerStatuses[0]
+	assert.Equal(t, int32(2), cs.RestartCount)
+	assert.NotNil(t, cs.State.Running)
+	assert.Equal(t, int32(2), cs.LastTerminationState.Terminated.ExitCode)
+
+	require.NoError(t, p.DeletePod(ctx, pod))
+	assert.Empty(t, p.syncers)
+	assert.NotContains(t, service.sandboxes, s.psId)
+}
+
+func TestSyncPodRestartPolicy(t *testing.T) {
+	p, service, cleanup := newFakeRuntime(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	pod := newSyncTestPod(v1.RestartPolicyOnFailure, "app", "app")
+	require.NoError(t, p.CreatePod(ctx, pod))
+	s, _ := pauseSync(t, p, pod)
+
+	// Containers which succeed aren't restarted.
+	service.exitContainer(service.attempts(s.psId, "a")[0], 0)
+	require.NoError(t, p.syncPod(s))
+	assert.Len(t, service.attempts(s.psId, "a"), 1)
+
+	// Containers which fail are, including when they are stopped because they failed their liveness probe.
+	require.NoError(t, p.RestartContainer(ctx, pod, "b"))
+	require.NoError(t, p.syncPod(s))
+	assert.Len(t, service.attempts(s.psId, "b"), 2)
+
+	status, err := p.GetPodStatus(ctx, pod.Namespace, pod.Name)
+	require.NoError(t, err)
+	assert.Equal(t, v1.PodRunning, status.Phase)
+
+	service.exitContainer(service.attempts(s.psId, "b")[1], 0)
+	status, err = p.GetPodStatus(ctx, pod.Namespace, pod.Name)
+	require.NoError(t, err)
+	assert.Equal(t, v1.PodSucceeded, status.Phase)
+}
+
+func TestSyncPodInitContainers(t *testing.T) {
+	p, service, cleanup := newFakeRuntime(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	pod := withInitContainers(newSyncTestPod(v1.RestartPolicyAlways, "app"), "init", "init")
+	require.NoError(t, p.CreatePod(ctx, pod))
+	s, _ := pauseSync(t, p, pod)
+
+	// Only the first init container is started.
+	assert.Len(t, service.attempts(s.psId, "init-a"), 1)
+	assert.Empty(t, service.attempts(s.psId, "init-b"))
+	assert.Empty(t, service.attempts(s.psId, "a"))
+	status, err := p.GetPodStatus(ctx, pod.Namespace, pod.Name)
+	require.NoError(t, err)
+	assert.Equal(t, v1.PodPending, status.Phase)
+	require.Len(t, status.InitContainerStatuses, 1)
+	assert.Equal(t, "init-a", status.InitContainerStatuses[0].Name)
+	assert.Empty(t, status.ContainerStatuses)
+	initialized := getPodCondition(status, v1.PodInitialized)
+	require.NotNil(t, initialized)
+	assert.Equal(t, v1.ConditionFalse, initialized.Status)
+	assert.Contains(t, initialized.Message, "init-a")
+	assert.Contains(t, initialized.Message, "init-b")
+
+	// The init containers run one after the other.
+	require.NoError(t, p.syncPod(s))
+	assert.Empty(t, service.attempts(s.psId, "init-b"), "the first init container is still running")
+	service.exitContainer(service.attempts(s.psId, "init-a")[0], 0)
+	require.NoError(t, p.syncPod(s))
+	assert.Len(t, service.attempts(s.psId, "init-b"), 1)
+	assert.Empty(t, service.attempts(s.psId, "a"))
+
+	// The app containers are started once they all succeeded.
+	service.exitContainer(service.attempts(s.psId, "init-b")[0], 0)
+	require.NoError(t, p.syncPod(s))
+	assert.Len(t, service.attempts(s.psId, "a"), 1)
+	assert.Len(t, service.attempts(s.psId, "init-a"), 1, "init containers which succeeded aren't restarted")
+
+	status, err = p.GetPodStatus(ctx, pod.Namespace, pod.Name)
+	require.NoError(t, err)
+	assert.Equal(t, v1.PodRunning, status.Phase)
+	require.Len(t, status.InitContainerStatuses, 2)
+	assert.Equal(t, "init-a", status.InitContainerStatuses[0].Name)
+	assert.Equal(t, "init-b", status.InitContainerStatuses[1].Name)
+	require.Len(t, status.ContainerStatuses, 1)
+	assert.Equal(t, "a", status.ContainerStatuses[0].Name)
+	assert.Equal(t, v1.ConditionTrue, getPodCondition(status, v1.PodInitialized).Status)
+
+	spec, err := p.GetPod(ctx, pod.Namespace, pod.Name)
+	require.NoError(t, err)
+	require.Len(t, spec.Spec.InitContainers, 2)
+	require.Len(t, spec.Spec.Containers, 1)
+}
+
+func TestSyncPodInitContainerFailure(t *testing.T) {
+	p, service, cleanup := newFakeRuntime(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	// Failed init containers are restarted unless the restart policy is Never.
+	pod := withInitContainers(newSyncTestPod(v1.RestartPolicyOnFailure, "app"), "init")
+	require.NoError(t, p.CreatePod(ctx, pod))
+	s, _ := pauseSync(t, p, pod)
+	service.exitContainer(service.attempts(s.psId, "init-a")[0], 1)
+	require.NoError(t, p.syncPod(s))
+	assert.Len(t, service.attempts(s.psId, "init-a"), 2)
+	assert.Empty(t, service.attempts(s.psId, "a"))
+	status, err := p.GetPodStatus(ctx, pod.Namespace, pod.Name)
+	require.NoError(t, err)
+	assert.Equal(t, v1.PodPending, status.Phase)
+	assert.Equal(t, int32(1), status.InitContainerStatuses[0].RestartCount)
+
+	pod = withInitContainers(newSyncTestPod(v1.RestartPolicyNever, "app"), "init")
+	pod.Name, pod.UID = "never", "never-uid"
+	require.NoError(t, p.CreatePod(ctx, pod))
+	s, _ = pauseSync(t, p, pod)
+	service.exitContainer(service.attempts(s.psId, "init-a")[0], 1)
+	require.NoError(t, p.syncPod(s))
+	assert.Len(t, service.attempts(s.psId, "init-a"), 1)
+	assert.Empty(t, service.attempts(s.psId, "a"))
+	status, err = p.GetPodStatus(ctx, pod.Namespace, pod.Name)
+	require.NoError(t, err)
+	assert.Equal(t, v1.PodFailed, status.Phase)
+	assert.Equal(t, v1.ConditionFalse, getPodCondition(status, v1.PodInitialized).Status)
+}
+
+func TestSyncPodImagePullFailure(t *testing.T) {
+	p, service, cleanup := newFakeRuntime(t)
+	defer cleanup()
+	ctx := context.Background()
+	images := p.imageClient.(*fakeImageService)
+	images.username = "user"
+
+	// Containers whose image can't be pulled wait for it, and the pod is still created.
+	pod := withInitContainers(newSyncTestPod(v1.RestartPolicyAlways, "app"), "init")
+	require.NoError(t, p.CreatePod(ctx, pod))
+	s, c := pauseSync(t, p, pod)
+	assert.Empty(t, service.attempts(s.psId, "init-a"))
+	status, err := p.GetPodStatus(ctx, pod.Namespace, pod.Name)
+	require.NoError(t, err)
+	assert.Equal(t, v1.PodPending, status.Phase)
+	require.Len(t, status.InitContainerStatuses, 1)
+	require.NotNil(t, status.InitContainerStatuses[0].State.Waiting)
+	assert.Equal(t, ErrImagePull, status.InitContainerStatuses[0].State.Waiting.Reason)
+	assert.Equal(t, "init", status.InitContainerStatuses[0].Image)
+	assert.Empty(t, status.ContainerStatuses)
+
+	// The sync loop was paused with a new back-off, so the next pull fails before the back-off starts.
+	require.NoError(t, p.syncPod(s))
+	require.NoError(t, p.syncPod(s))
+	status, err = p.GetPodStatus(ctx, pod.Namespace, pod.Name)
+	require.NoError(t, err)
+	assert.Equal(t, ImagePullBackOff, status.InitContainerStatuses[0].State.Waiting.Reason)
+	assert.Len(t, images.requests, 2)
+
+	images.username = ""
+	c.Step(containerBackOffInitial)
+	require.NoError(t, p.syncPod(s))
+	service.exitContainer(service.attempts(s.psId, "init-a")[0], 0)
+	require.NoError(t, p.syncPod(s))
+	status, err = p.GetPodStatus(ctx, pod.Namespace, pod.Name)
+	require.NoError(t, err)
+	assert.Equal(t, v1.PodRunning, status.Phase)
+	assert.NotNil(t, status.InitContainerStatuses[0].State.Terminated)
+	require.Len(t, status.ContainerStatuses, 1)
+	assert.NotNil(t, status.ContainerStatuses[0].State.Running)
+
+	// Images which aren't present and may not be pulled are reported right away.
+	pod = newSyncTestPod(v1.RestartPolicyAlways, "app", "missing:1.0")
+	pod.Name, pod.UID = "never", "never-uid"
+	pod.Spec.Containers[1].ImagePullPolicy = v1.PullNever
+	require.NoError(t, p.CreatePod(ctx, pod))
+	spec, err := p.GetPod(ctx, pod.Namespace, pod.Name)
+	require.NoError(t, err)
+	assert.Equal(t, v1.PodPending, spec.Status.Phase)
+	require.Len(t, spec.Spec.Containers, 2)
+	assert.Equal(t, "missing:1.0", spec.Spec.Containers[1].Image)
+	require.Len(t, spec.Status.ContainerStatuses, 2)
+	assert.NotNil(t, spec.Status.ContainerStatuses[0].State.Running)
+	require.NotNil(t, spec.Status.ContainerStatuses[1].State.Waiting)
+	assert.Equal(t, ErrImageNeverPull, spec.Status.ContainerStatuses[1].State.Waiting.Reason)
+}
+
+func TestGetPodPhase(t *testing.T) {
+	exited := func(exitCode int32) *criapi.ContainerStatus {
+		return &criapi.ContainerStatus{Metadata: &criapi.ContainerMetadata{Name: "a"}, Image: &criapi.ImageSpec{}, State: criapi.ContainerState_CONTAINER_EXITED, ExitCode: exitCode}
+	}
+	running := &criapi.ContainerStatus{Metadata: &criapi.ContainerMetadata{Name: "b"}, Image: &criapi.ImageSpec{}, State: criapi.ContainerState_CONTAINER_RUNNING}
+	created := &criapi.ContainerStatus{Metadata: &criapi.ContainerMetadata{Name: "b", Attempt: 1}, Image: &criapi.ImageSpec{}, State: criapi.ContainerState_CONTAINER_CREATED}
+
+	for _, tc := range []struct {
+		name          string
+		restartPolicy v1.RestartPolicy
+		sandboxReady  bool
+		containers    []*criapi.ContainerStatus
+		previous      []*criapi.ContainerStatus
+		expected      v1.PodPhase
+	}{
+		{name: "no sandbox", restartPolicy: v1.RestartPolicyAlways, expected: v1.PodPending},
+		{name: "created", restartPolicy: v1.RestartPolicyAlways, sandboxReady: true, containers: []*criapi.ContainerStatus{exited(0), {Metadata: &criapi.ContainerMetadata{Name: "b"}, Image: &criapi.ImageSpec{}}}, expected: v1.PodPending},
+		{name: "running", restartPolicy: v1.RestartPolicyNever, sandboxReady: true, containers: []*criapi.ContainerStatus{exited(1), running}, expected: v1.PodRunning},
+		{name: "restarting", restartPolicy: v1.RestartPolicyAlways, sandboxReady: true, containers: []*criapi.ContainerStatus{exited(0), created}, previous: []*criapi.ContainerStatus{{Metadata: &criapi.ContainerMetadata{Name: "b"}, Image: &criapi.ImageSpec{}, State: criapi.ContainerState_CONTAINER_EXITED}}, expected: v1.PodRunning},
+		{name: "always", restartPolicy: v1.RestartPolicyAlways, sandboxReady: true, containers: []*criapi.ContainerStatus{exited(0)}, expected: v1.PodRunning},
+		{name: "never succeeded", restartPolicy: v1.RestartPolicyNever, containers: []*criapi.ContainerStatus{exited(0)}, expected: v1.PodSucceeded},
+		{name: "never failed", restartPolicy: v1.RestartPolicyNever, sandboxReady: true, containers: []*criapi.ContainerStatus{exited(1)}, expected: v1.PodFailed},
+		{name: "on failure succeeded", restartPolicy: v1.RestartPolicyOnFailure, sandboxReady: true, containers: []*criapi.ContainerStatus{exited(0)}, expected: v1.PodSucceeded},
+		{name: "on failure failed", restartPolicy: v1.RestartPolicyOnFailure, sandboxReady: true, containers: []*criapi.ContainerStatus{exited(1)}, expected: v1.PodRunning},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cp := &CRIPod{
+				containers: make(map[string]*criapi.ContainerStatus),
+				previous:   make(map[string]*criapi.ContainerStatus),
+				status: &criapi.PodSandboxStatus{
+					State:       criapi.PodSandboxState_SANDBOX_NOTREADY,
+					Network:     &criapi.PodSandboxNetworkStatus{},
+					Annotations: map[string]string{RestartPolicyAnnotation: string(tc.restartPolicy)},
+				},
+			}
+			if tc.sandboxReady {
+				cp.status.State = criapi.PodSandboxState_SANDBOX_READY
+			}
+			for _, c := range tc.containers {
+				cp.containers[c.Metadata.Name] = c
+			}
+			for _, c := range tc.previous {
+				cp.previous[c.Metadata.Name] = c
+			}
+			assert.Equal(t, tc.expected, createPodStatusFromCRI(cp, nil).Phase)
+		})
+	}
+}
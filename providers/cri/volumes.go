@@ -0,0 +1,297 @@
+// +build linux
+
+package cri
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/cpuguy83/strongerrors"
+	"github.com/virtual-kubelet/virtual-kubelet/manager"
+	"k8s.io/api/core/v1"
+)
+
+// The volume files are written to a timestamped directory, which the "..data" symlink points to
+// The top-level paths of the volume are symlinks through "..data", so that swapping the latter updates all of them at once
+// This is the same layout as the one used by the Kubelet's atomic writer, and works with the bind mounts of running containers as the volume directory itself is never replaced
+const volumeDataDirName = "..data"
+const volumeDataTmpDirName = "..data_tmp"
+
+// Atomically writes the specified files, resolved from a volume, under the specified directory
+// Top-level paths that are no longer part of the volume are removed
+func writeVolumeFiles(dir string, files []manager.VolumeFile) error {
+	if err := os.MkdirAll(dir, PodVolPerms); err != nil {
+		return fmt.Errorf("Error making volume dir for path %s: %v", dir, err)
+	}
+
+	// Write the files to a new timestamped directory
+	tsDir, err := ioutil.TempDir(dir, "..")
+	if err != nil {
+		return fmt.Errorf("Error making volume data dir in path %s: %v", dir, err)
+	}
+	if err := os.Chmod(tsDir, PodVolPerms); err != nil {
+		os.RemoveAll(tsDir)
+		return fmt.Errorf("Could not change the mode of volume data dir %s: %v", tsDir, err)
+	}
+	topLevel := make(map[string]bool)
+	for _, file := range files {
+		topLevel[strings.SplitN(file.Path, "/", 2)[0]] = true
+		fullPath := filepath.Join(tsDir, filepath.FromSlash(file.Path))
+		if err := os.MkdirAll(filepath.Dir(fullPath), PodVolPerms); err != nil {
+			os.RemoveAll(tsDir)
+			return fmt.Errorf("Error making volume dir for path %s: %v", fullPath, err)
+		}
+		if err := ioutil.WriteFile(fullPath, file.Data, os.FileMode(file.Mode)); err != nil {
+			os.RemoveAll(tsDir)
+			return fmt.Errorf("Could not write volume file %s: %v", fullPath, err)
+		}
+		// Apply the exact mode, which may have been altered by the umask
+		if err := os.Chmod(fullPath, os.FileMode(file.Mode)); err != nil {
+			os.RemoveAll(tsDir)
+			return fmt.Errorf("Could not change the mode of volume file %s: %v", fullPath, err)
+		}
+	}
+
+	// Atomically point the "..data" symlink to the new timestamped directory
+	tmpLink := filepath.Join(dir, volumeDataTmpDirName)
+	os.Remove(tmpLink)
+	if err := os.Symlink(filepath.Base(tsDir), tmpLink); err != nil {
+		os.RemoveAll(tsDir)
+		return fmt.Errorf("Could not create volume data link in path %s: %v", dir, err)
+	}
+	if err := os.Rename(tmpLink, filepath.Join(dir, volumeDataDirName)); err != nil {
+		os.Remove(tmpLink)
+		os.RemoveAll(tsDir)
+		return fmt.Errorf("Could not update volume data link in path %s: %v", dir, err)
+	}
+
+	// Create the missing top-level symlinks, and remove the stale ones along with the previous timestamped directories
+	for name := range topLevel {
+		link := filepath.Join(dir, name)
+		if _, err := os.Lstat(link); err == nil {
+			continue
+		}
+		if err := os.Symlink(filepath.Join(volumeDataDirName, name), link); err != nil {
+			return fmt.Errorf("Could not create volume link %s: %v", link, err)
+		}
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("Could not list volume dir %s: %v", dir, err)
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if name == volumeDataDirName || name == filepath.Base(tsDir) || topLevel[name] {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(dir, name)); err != nil {
+			return fmt.Errorf("Could not remove stale volume path %s: %v", filepath.Join(dir, name), err)
+		}
+	}
+	return nil
+}
+
+// Refresh the contents of the Secret, ConfigMap, DownwardAPI and Projected volumes of a running Pod
+// Volumes are updated atomically, but containers mounting them using a subPath don't see the updates, as is the case with the Kubelet
+func (p *CRIProvider) UpdatePodVolumes(ctx context.Context, pod *v1.Pod) error {
+	volPath := filepath.Join(p.podVolRoot, string(pod.UID))
+	if _, err := os.Stat(volPath); err != nil {
+		if os.IsNotExist(err) {
+			return strongerrors.NotFound(fmt.Errorf("Pod %s/%s is not running", pod.Namespace, pod.Name))
+		}
+		return err
+	}
+	for _, volume := range pod.Spec.Volumes {
+		if !manager.IsResolvableVolume(volume) {
+			continue
+		}
+		files, err := p.resourceManager.ResolveVolume(pod, volume)
+		if err != nil {
+			return err
+		}
+		if err := writeVolumeFiles(resolvedVolumeDir(volPath, &volume), files); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Mount a tmpfs of the given size, or of the default size if zero, at the given path. Replaced in tests, which can't mount filesystems
+var mountTmpfs = func(path string, size int64) error {
+	options := ""
+	if size > 0 {
+		options = fmt.Sprintf("size=%d", size)
+	}
+	return syscall.Mount("tmpfs", path, "tmpfs", 0, options)
+}
+
+// Unmount the filesystem mounted at the given path. Replaced in tests along with mountTmpfs
+var unmount = func(path string) error {
+	return syscall.Unmount(path, 0)
+}
+
+// Decide whether a filesystem is mounted at the given path, i.e. whether it is on another device than its parent. Replaced in tests along with mountTmpfs
+var isMountPoint = func(path string) (bool, error) {
+	var st, parent syscall.Stat_t
+	if err := syscall.Lstat(path, &st); err != nil {
+		return false, err
+	}
+	if err := syscall.Lstat(filepath.Dir(path), &parent); err != nil {
+		return false, err
+	}
+	return st.Dev != parent.Dev, nil
+}
+
+// Create the directory of an emptyDir volume, and mount a tmpfs on it if its medium is memory
+// The size limit of the volume only applies to memory-backed volumes, as the size of the tmpfs
+func setupEmptyDir(path string, src *v1.EmptyDirVolumeSource) error {
+	if err := os.MkdirAll(path, PodVolPerms); err != nil {
+		return fmt.Errorf("Error making emptyDir for path %s: %v", path, err)
+	}
+	switch src.Medium {
+	case v1.StorageMediumDefault:
+		return nil
+	case v1.StorageMediumMemory:
+		mounted, err := isMountPoint(path)
+		if err != nil || mounted {
+			return err
+		}
+		var size int64
+		if src.SizeLimit != nil {
+			size = src.SizeLimit.Value()
+		}
+		if err := mountTmpfs(path, size); err != nil {
+			return fmt.Errorf("Error mounting tmpfs for emptyDir %s: %v", path, err)
+		}
+		return nil
+	default:
+		return fmt.Errorf("Unsupported emptyDir medium %q", src.Medium)
+	}
+}
+
+// Check the path of a hostPath volume against its type, creating it first if the type requests it
+func setupHostPath(src *v1.HostPathVolumeSource) error {
+	if src.Type == nil || *src.Type == v1.HostPathUnset {
+		return nil
+	}
+	path := src.Path
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		switch *src.Type {
+		case v1.HostPathDirectoryOrCreate:
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return fmt.Errorf("Error creating hostPath directory %s: %v", path, err)
+			}
+			return nil
+		case v1.HostPathFileOrCreate:
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return fmt.Errorf("Error creating the parent directory of hostPath file %s: %v", path, err)
+			}
+			f, err := os.OpenFile(path, os.O_CREATE|os.O_RDONLY, 0644)
+			if err != nil {
+				return fmt.Errorf("Error creating hostPath file %s: %v", path, err)
+			}
+			return f.Close()
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("hostPath type check failed: %s does not exist or cannot be read: %v", path, err)
+	}
+
+	mode := info.Mode()
+	var ok bool
+	switch *src.Type {
+	case v1.HostPathDirectoryOrCreate, v1.HostPathDirectory:
+		ok = mode.IsDir()
+	case v1.HostPathFileOrCreate, v1.HostPathFile:
+		ok = mode.IsRegular()
+	case v1.HostPathSocket:
+		ok = mode&os.ModeSocket != 0
+	case v1.HostPathCharDev:
+		ok = mode&os.ModeDevice != 0 && mode&os.ModeCharDevice != 0
+	case v1.HostPathBlockDev:
+		ok = mode&os.ModeDevice != 0 && mode&os.ModeCharDevice == 0
+	default:
+		return fmt.Errorf("Unsupported hostPath type %q", *src.Type)
+	}
+	if !ok {
+		return fmt.Errorf("hostPath type check failed: %s is not a %s", path, *src.Type)
+	}
+	return nil
+}
+
+// Resolve the path of the given subPath of a volume on the host, creating it as a directory if it doesn't exist, as the Kubelet does
+// Symlinks are resolved, so that the subPath can't point outside of the volume
+func resolveSubPath(volumePath, subPath string) (string, error) {
+	if filepath.IsAbs(subPath) {
+		return "", fmt.Errorf("subPath %q must be a relative path", subPath)
+	}
+	for _, element := range strings.Split(filepath.ToSlash(subPath), "/") {
+		if element == ".." {
+			return "", fmt.Errorf("subPath %q must not contain '..'", subPath)
+		}
+	}
+
+	root, err := filepath.EvalSymlinks(volumePath)
+	if err != nil {
+		return "", err
+	}
+	inVolume := func(path string) (string, error) {
+		resolved, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			return "", err
+		}
+		if resolved != root && !strings.HasPrefix(resolved, root+string(filepath.Separator)) {
+			return "", fmt.Errorf("subPath %q resolves to %s, outside of its volume", subPath, resolved)
+		}
+		return resolved, nil
+	}
+
+	path := filepath.Join(volumePath, subPath)
+	if _, err := os.Lstat(path); os.IsNotExist(err) {
+		// Check the closest existing parent first, so that no directory is created outside of the volume
+		parent := filepath.Dir(path)
+		for _, err := os.Lstat(parent); os.IsNotExist(err); _, err = os.Lstat(parent) {
+			parent = filepath.Dir(parent)
+		}
+		if _, err := inVolume(parent); err != nil {
+			return "", err
+		}
+		if err := os.MkdirAll(path, PodVolPerms); err != nil {
+			return "", fmt.Errorf("Error creating subPath %s: %v", path, err)
+		}
+	}
+	return inVolume(path)
+}
+
+// Remove the volumes of a pod, unmounting the memory-backed ones first
+func removePodVolumes(volPath string) error {
+	entries, err := ioutil.ReadDir(volPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, entry := range entries {
+		if err := removeVolume(filepath.Join(volPath, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return os.RemoveAll(volPath)
+}
+
+// Remove a volume directory, unmounting it first if it is a mount point
+func removeVolume(path string) error {
+	if mounted, err := isMountPoint(path); err == nil && mounted {
+		if err := unmount(path); err != nil {
+			return fmt.Errorf("Error unmounting volume %s: %v", path, err)
+		}
+	}
+	return os.RemoveAll(path)
+}
@@ -0,0 +1,339 @@
+// +build linux
+
+package cri
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/virtual-kubelet/virtual-kubelet/manager"
+	testutil "github.com/virtual-kubelet/virtual-kubelet/test/util"
+)
+
+// TestWriteVolumeFiles verifies that volume files are written with the expected modes, and that rewriting them replaces the previous ones through the same volume directory.
+func TestWriteVolumeFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vk-cri-volume")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	require.NoError(t, writeVolumeFiles(dir, []manager.VolumeFile{
+		{Path: "key-0", Mode: 0600, Data: []byte("val-0")},
+		{Path: "dir/key-1", Mode: 0644, Data: []byte("val-1")},
+	}))
+	data, err := ioutil.ReadFile(filepath.Join(dir, "key-0"))
+	require.NoError(t, err)
+	assert.Equal(t, "val-0", string(data))
+	info, err := os.Stat(filepath.Join(dir, "key-0"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	data, err = ioutil.ReadFile(filepath.Join(dir, "dir", "key-1"))
+	require.NoError(t, err)
+	assert.Equal(t, "val-1", string(data))
+
+	// Rewrite the volume with a changed key, a new key and without the "dir" directory.
+	require.NoError(t, writeVolumeFiles(dir, []manager.VolumeFile{
+		{Path: "key-0", Mode: 0600, Data: []byte("val-0-updated")},
+		{Path: "key-2", Mode: 0644, Data: []byte("val-2")},
+	}))
+	data, err = ioutil.ReadFile(filepath.Join(dir, "key-0"))
+	require.NoError(t, err)
+	assert.Equal(t, "val-0-updated", string(data))
+	data, err = ioutil.ReadFile(filepath.Join(dir, "key-2"))
+	require.NoError(t, err)
+	assert.Equal(t, "val-2", string(data))
+	_, err = os.Lstat(filepath.Join(dir, "dir"))
+	assert.True(t, os.IsNotExist(err))
+
+	// Only the data link, the current data directory and the top-level links remain.
+	entries, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 4)
+}
+
+// fakeMounts makes tmpfs mounts only be recorded, as tests can't mount filesystems, and returns them along with a function restoring actual mounts.
+func fakeMounts() (map[string]int64, func()) {
+	mounts := make(map[string]int64)
+	origMount, origUnmount, origIsMountPoint := mountTmpfs, unmount, isMountPoint
+	mountTmpfs = func(path string, size int64) error {
+		mounts[path] = size
+		return nil
+	}
+	unmount = func(path string) error {
+		delete(mounts, path)
+		return nil
+	}
+	isMountPoint = func(path string) (bool, error) {
+		_, ok := mounts[path]
+		return ok, nil
+	}
+	return mounts, func() {
+		mountTmpfs, unmount, isMountPoint = origMount, origUnmount, origIsMountPoint
+	}
+}
+
+func hostPathType(t v1.HostPathType) *v1.HostPathType {
+	return &t
+}
+
+// TestCreateCtrMounts verifies that each type of volume is prepared on the host, and that subPaths of volumes are mounted.
+func TestCreateCtrMounts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vk-cri-mounts")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	mounts, restore := fakeMounts()
+	defer restore()
+
+	mode := int32(0600)
+	sizeLimit := resource.MustParse("64Mi")
+	rm := testutil.FakeResourceManager(
+		&v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "config"},
+			Data:       map[string]string{"app.conf": "debug", "other": "value"},
+			BinaryData: map[string][]byte{"data.bin": {0, 1, 2}},
+		},
+		&v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "secret"},
+			Data:       map[string][]byte{"password": []byte("hunter2")},
+		},
+		&v1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "claim"},
+			Spec:       v1.PersistentVolumeClaimSpec{VolumeName: "local"},
+			Status:     v1.PersistentVolumeClaimStatus{Phase: v1.ClaimBound},
+		},
+		&v1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: "local"},
+			Spec: v1.PersistentVolumeSpec{
+				PersistentVolumeSource: v1.PersistentVolumeSource{Local: &v1.LocalVolumeSource{Path: "/mnt/disks/local"}},
+				ClaimRef:               &v1.ObjectReference{Namespace: "default", Name: "claim"},
+			},
+		},
+	)
+	hostDir := filepath.Join(dir, "host")
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pod", UID: "uid", Labels: map[string]string{"app": "test"}},
+		Spec: v1.PodSpec{
+			Volumes: []v1.Volume{
+				{Name: "config", VolumeSource: v1.VolumeSource{ConfigMap: &v1.ConfigMapVolumeSource{
+					LocalObjectReference: v1.LocalObjectReference{Name: "config"},
+					Items:                []v1.KeyToPath{{Key: "app.conf", Path: "conf/app.conf"}, {Key: "data.bin", Path: "data.bin", Mode: &mode}},
+				}}},
+				{Name: "secret", VolumeSource: v1.VolumeSource{Secret: &v1.SecretVolumeSource{SecretName: "secret", DefaultMode: &mode}}},
+				{Name: "podinfo", VolumeSource: v1.VolumeSource{DownwardAPI: &v1.DownwardAPIVolumeSource{
+					Items: []v1.DownwardAPIVolumeFile{{Path: "labels", FieldRef: &v1.ObjectFieldSelector{FieldPath: "metadata.labels"}}},
+				}}},
+				{Name: "scratch", VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{}}},
+				{Name: "cache", VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{Medium: v1.StorageMediumMemory, SizeLimit: &sizeLimit}}},
+				{Name: "host", VolumeSource: v1.VolumeSource{HostPath: &v1.HostPathVolumeSource{Path: hostDir, Type: hostPathType(v1.HostPathDirectoryOrCreate)}}},
+				{Name: "data", VolumeSource: v1.VolumeSource{PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: "claim", ReadOnly: true}}},
+			},
+			Containers: []v1.Container{{
+				Name: "app",
+				VolumeMounts: []v1.VolumeMount{
+					{Name: "config", MountPath: "/etc/app"},
+					{Name: "config", MountPath: "/etc/app.conf", SubPath: "conf/app.conf"},
+					{Name: "secret", MountPath: "/secret", ReadOnly: true},
+					{Name: "podinfo", MountPath: "/podinfo"},
+					{Name: "scratch", MountPath: "/scratch", SubPath: "app"},
+					{Name: "cache", MountPath: "/cache"},
+					{Name: "host", MountPath: "/host"},
+					{Name: "data", MountPath: "/data"},
+				},
+			}},
+		},
+	}
+
+	volPath := filepath.Join(dir, "volumes")
+	result, err := createCtrMounts(&pod.Spec.Containers[0], pod, volPath, rm)
+	require.NoError(t, err)
+	require.Len(t, result, 8)
+
+	// Only the items of the config map are projected, including binary ones, with their own modes.
+	assert.Equal(t, "/etc/app", result[0].ContainerPath)
+	data, err := ioutil.ReadFile(filepath.Join(result[0].HostPath, "conf", "app.conf"))
+	require.NoError(t, err)
+	assert.Equal(t, "debug", string(data))
+	data, err = ioutil.ReadFile(filepath.Join(result[0].HostPath, "data.bin"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0, 1, 2}, data)
+	info, err := os.Stat(filepath.Join(result[0].HostPath, "data.bin"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	_, err = os.Stat(filepath.Join(result[0].HostPath, "other"))
+	assert.True(t, os.IsNotExist(err))
+
+	// SubPaths are mounted at the mount path, from the file they resolve to.
+	assert.Equal(t, "/etc/app.conf", result[1].ContainerPath)
+	data, err = ioutil.ReadFile(result[1].HostPath)
+	require.NoError(t, err)
+	assert.Equal(t, "debug", string(data))
+	assert.NotContains(t, result[1].HostPath, "app.conf/", "the subPath should be resolved to a file")
+
+	info, err = os.Stat(filepath.Join(result[2].HostPath, "password"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	assert.True(t, result[2].Readonly)
+
+	data, err = ioutil.ReadFile(filepath.Join(result[3].HostPath, "labels"))
+	require.NoError(t, err)
+	assert.Equal(t, `app="test"`, string(data))
+
+	assert.Equal(t, "/scratch", result[4].ContainerPath)
+	resolvedVolPath, err := filepath.EvalSymlinks(volPath)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(resolvedVolPath, "scratch", "app"), result[4].HostPath)
+	info, err = os.Stat(result[4].HostPath)
+	require.NoError(t, err)
+	assert.True(t, info.IsDir(), "missing subPaths should be created as directories")
+
+	// Memory-backed emptyDirs are tmpfs mounts limited to their size limit.
+	assert.Equal(t, map[string]int64{filepath.Join(volPath, "cache"): 64 << 20}, mounts)
+
+	info, err = os.Stat(hostDir)
+	require.NoError(t, err)
+	assert.True(t, info.IsDir())
+	assert.Equal(t, hostDir, result[6].HostPath)
+
+	// Claims are mounted from the path of the local or hostPath volume they are bound to.
+	assert.Equal(t, "/mnt/disks/local", result[7].HostPath)
+	assert.True(t, result[7].Readonly)
+
+	// The volumes are unmounted and removed along with the pod.
+	require.NoError(t, removePodVolumes(volPath))
+	assert.Empty(t, mounts)
+	_, err = os.Stat(volPath)
+	assert.True(t, os.IsNotExist(err))
+}
+
+// TestCreateCtrMountsCleanup verifies that the volumes prepared for a container are removed if one of its mounts is invalid.
+func TestCreateCtrMountsCleanup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vk-cri-mounts")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	mounts, restore := fakeMounts()
+	defer restore()
+
+	volPath := filepath.Join(dir, "volumes")
+	require.NoError(t, os.MkdirAll(filepath.Join(volPath, "existing"), 0755))
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pod"},
+		Spec: v1.PodSpec{
+			Volumes: []v1.Volume{
+				{Name: "existing", VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{}}},
+				{Name: "cache", VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{Medium: v1.StorageMediumMemory}}},
+				{Name: "podinfo", VolumeSource: v1.VolumeSource{DownwardAPI: &v1.DownwardAPIVolumeSource{
+					Items: []v1.DownwardAPIVolumeFile{{Path: "name", FieldRef: &v1.ObjectFieldSelector{FieldPath: "metadata.name"}}},
+				}}},
+			},
+			Containers: []v1.Container{{
+				Name: "app",
+				VolumeMounts: []v1.VolumeMount{
+					{Name: "existing", MountPath: "/existing"},
+					{Name: "cache", MountPath: "/cache"},
+					{Name: "podinfo", MountPath: "/podinfo"},
+					{Name: "podinfo", MountPath: "/escape", SubPath: "../.."},
+				},
+			}},
+		},
+	}
+
+	_, err = createCtrMounts(&pod.Spec.Containers[0], pod, volPath, testutil.FakeResourceManager())
+	assert.Error(t, err)
+	assert.Empty(t, mounts)
+	entries, err := ioutil.ReadDir(volPath)
+	require.NoError(t, err)
+	require.Len(t, entries, 2, "only the volumes which existed before should remain")
+	names := []string{entries[0].Name(), entries[1].Name()}
+	assert.Contains(t, names, "existing")
+	assert.Contains(t, names, "downwardapi")
+	empty, err := ioutil.ReadDir(filepath.Join(volPath, "downwardapi"))
+	require.NoError(t, err)
+	assert.Empty(t, empty)
+}
+
+// TestResolveSubPath verifies that subPaths can't point outside of their volume, including through symlinks.
+func TestResolveSubPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vk-cri-subpath")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	dir, err = filepath.EvalSymlinks(dir)
+	require.NoError(t, err)
+
+	volume := filepath.Join(dir, "volume")
+	require.NoError(t, os.MkdirAll(filepath.Join(volume, "sub"), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "outside"), 0755))
+	require.NoError(t, os.Symlink(dir, filepath.Join(volume, "escape")))
+	require.NoError(t, os.Symlink("sub", filepath.Join(volume, "inside")))
+
+	path, err := resolveSubPath(volume, "sub")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(volume, "sub"), path)
+	path, err = resolveSubPath(volume, "inside")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(volume, "sub"), path)
+	path, err = resolveSubPath(volume, "new/dir")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(volume, "new", "dir"), path)
+
+	for _, subPath := range []string{"escape", "escape/outside", "escape/created/dir", "../volume", "/sub", "sub/../../volume"} {
+		_, err = resolveSubPath(volume, subPath)
+		assert.Error(t, err, subPath)
+	}
+	_, err = os.Stat(filepath.Join(dir, "created"))
+	assert.True(t, os.IsNotExist(err), "no directory should be created outside of the volume")
+}
+
+// TestSetupHostPath verifies the checks made for each type of hostPath volume.
+func TestSetupHostPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vk-cri-hostpath")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "file")
+	require.NoError(t, ioutil.WriteFile(file, nil, 0644))
+	socket := filepath.Join(dir, "socket")
+	l, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	defer l.Close()
+
+	for _, tc := range []struct {
+		path     string
+		pathType v1.HostPathType
+		valid    bool
+	}{
+		{path: filepath.Join(dir, "missing"), pathType: v1.HostPathUnset, valid: true},
+		{path: filepath.Join(dir, "created"), pathType: v1.HostPathDirectoryOrCreate, valid: true},
+		{path: file, pathType: v1.HostPathDirectoryOrCreate, valid: false},
+		{path: dir, pathType: v1.HostPathDirectory, valid: true},
+		{path: filepath.Join(dir, "missing"), pathType: v1.HostPathDirectory, valid: false},
+		{path: filepath.Join(dir, "parent", "created-file"), pathType: v1.HostPathFileOrCreate, valid: true},
+		{path: file, pathType: v1.HostPathFile, valid: true},
+		{path: dir, pathType: v1.HostPathFile, valid: false},
+		{path: socket, pathType: v1.HostPathSocket, valid: true},
+		{path: file, pathType: v1.HostPathSocket, valid: false},
+		{path: "/dev/null", pathType: v1.HostPathCharDev, valid: true},
+		{path: "/dev/null", pathType: v1.HostPathBlockDev, valid: false},
+		{path: file, pathType: "Unknown", valid: false},
+	} {
+		err := setupHostPath(&v1.HostPathVolumeSource{Path: tc.path, Type: hostPathType(tc.pathType)})
+		if tc.valid {
+			assert.NoError(t, err, "%s %s", tc.pathType, tc.path)
+		} else {
+			assert.Error(t, err, "%s %s", tc.pathType, tc.path)
+		}
+	}
+	info, err := os.Stat(filepath.Join(dir, "created"))
+	require.NoError(t, err)
+	assert.True(t, info.IsDir())
+	info, err = os.Stat(filepath.Join(dir, "parent", "created-file"))
+	require.NoError(t, err)
+	assert.True(t, info.Mode().IsRegular())
+}
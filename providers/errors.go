@@ -0,0 +1,162 @@
+package providers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cpuguy83/strongerrors"
+)
+
+// Errors returned by providers are classified using the "strongerrors" package, and virtual-kubelet acts upon them according to their class:
+//
+//   - Transient (strongerrors.Unavailable, or any unclassified error): the operation is retried with an exponential back-off, up to a maximum number of attempts.
+//   - Throttled (see Throttled): the operation is retried once the delay indicated by the provider has elapsed, or with an exponential back-off if the delay is not positive.
+//   - QuotaExceeded (strongerrors.Exhausted): the operation is retried periodically, until quota becomes available.
+//   - InvalidSpec (strongerrors.InvalidArgument): the pod can never be run by the provider, so it is failed immediately and creating it is not retried (deleting it still is).
+//   - NotFound (strongerrors.NotFound): the pod does not exist in the provider, which is not considered an error when deleting it.
+//
+// The Transient, QuotaExceeded and InvalidSpec functions are shorthands for the corresponding "strongerrors" functions.
+
+// ErrorClass is the class of an error returned by a provider.
+type ErrorClass string
+
+const (
+	// ErrorClassTransient is the class of errors that may not happen again if the operation is retried.
+	ErrorClassTransient ErrorClass = "Transient"
+	// ErrorClassThrottled is the class of errors signaling that the provider's backing API is throttling requests.
+	ErrorClassThrottled ErrorClass = "Throttled"
+	// ErrorClassQuotaExceeded is the class of errors signaling that the quota allotted to the provider is exhausted.
+	ErrorClassQuotaExceeded ErrorClass = "QuotaExceeded"
+	// ErrorClassInvalidSpec is the class of errors signaling that a pod can never be run by the provider.
+	ErrorClassInvalidSpec ErrorClass = "InvalidSpec"
+	// ErrorClassNotFound is the class of errors signaling that a pod does not exist in the provider.
+	ErrorClassNotFound ErrorClass = "NotFound"
+)
+
+// ClassifyError returns the class of the specified error returned by a provider.
+func ClassifyError(err error) ErrorClass {
+	if _, ok := RetryAfter(err); ok {
+		return ErrorClassThrottled
+	}
+	switch {
+	case strongerrors.IsExhausted(err):
+		return ErrorClassQuotaExceeded
+	case strongerrors.IsInvalidArgument(err):
+		return ErrorClassInvalidSpec
+	case strongerrors.IsNotFound(err):
+		return ErrorClassNotFound
+	default:
+		return ErrorClassTransient
+	}
+}
+
+// Transient signals that the specified error may not happen again if the operation is retried.
+func Transient(err error) error {
+	return strongerrors.Unavailable(err)
+}
+
+// QuotaExceeded signals that the specified error was caused by the quota allotted to the provider being exhausted.
+func QuotaExceeded(err error) error {
+	return strongerrors.Exhausted(err)
+}
+
+// InvalidSpec signals that the specified error was caused by a pod that can never be run by the provider (e.g. because it requests unsupported features).
+func InvalidSpec(err error) error {
+	return strongerrors.InvalidArgument(err)
+}
+
+// defaultRetryAfter is the delay used for throttling errors when the provider's backing API does not indicate one.
+const defaultRetryAfter = 10 * time.Second
+
+// FromHTTPStatus classifies the specified error, which was caused by an HTTP response having the specified status code and headers.
+// It can be used by providers whose backing API is HTTP-based to classify errors in a standard way.
+// Errors caused by responses whose status code doesn't imply a specific class (e.g. 409) are returned unchanged, so that providers can further classify them.
+func FromHTTPStatus(err error, statusCode int, header http.Header) error {
+	if err == nil {
+		return nil
+	}
+	switch {
+	case statusCode == http.StatusNotFound:
+		return strongerrors.NotFound(err)
+	case statusCode == http.StatusBadRequest || statusCode == http.StatusUnprocessableEntity:
+		return InvalidSpec(err)
+	case statusCode == http.StatusTooManyRequests:
+		return Throttled(err, parseRetryAfter(header))
+	case statusCode >= http.StatusInternalServerError:
+		if statusCode == http.StatusServiceUnavailable && header.Get("Retry-After") != "" {
+			return Throttled(err, parseRetryAfter(header))
+		}
+		return Transient(err)
+	default:
+		return err
+	}
+}
+
+// parseRetryAfter returns the delay indicated by the "Retry-After" header, which is either a number of seconds or an HTTP date.
+// https://tools.ietf.org/html/rfc7231#section-7.1.3
+func parseRetryAfter(header http.Header) time.Duration {
+	v := header.Get("Retry-After")
+	if v == "" {
+		return defaultRetryAfter
+	}
+	if seconds, err := strconv.Atoi(v); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+		return 0
+	}
+	return defaultRetryAfter
+}
+
+// ErrThrottled signals that the provider's backing API is throttling requests.
+// RetryAfter returns how long callers should wait before issuing further requests (e.g. as indicated by a "Retry-After" header).
+type ErrThrottled interface {
+	RetryAfter() time.Duration
+}
+
+type errThrottled struct {
+	error
+	retryAfter time.Duration
+}
+
+// Exhausted makes throttling errors be recognized as an "ErrExhausted" by the "strongerrors" package.
+func (errThrottled) Exhausted() {}
+
+func (e errThrottled) RetryAfter() time.Duration {
+	return e.retryAfter
+}
+
+func (e errThrottled) Cause() error {
+	return e.error
+}
+
+// Throttled wraps the specified error in order to signal that the provider's backing API is throttling requests, and that no further requests should be issued for the specified duration.
+func Throttled(err error, retryAfter time.Duration) error {
+	if err == nil {
+		return nil
+	}
+	return errThrottled{error: err, retryAfter: retryAfter}
+}
+
+type causer interface {
+	Cause() error
+}
+
+// RetryAfter returns the duration indicated by the first error in the specified error's chain of causes that implements ErrThrottled, if any.
+func RetryAfter(err error) (time.Duration, bool) {
+	for err != nil {
+		if e, ok := err.(ErrThrottled); ok {
+			return e.RetryAfter(), true
+		}
+		c, ok := err.(causer)
+		if !ok {
+			return 0, false
+		}
+		err = c.Cause()
+	}
+	return 0, false
+}
@@ -0,0 +1,69 @@
+package providers
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/cpuguy83/strongerrors"
+	pkgerrors "github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestClassifyError verifies that errors are classified according to the taxonomy, even when wrapped.
+func TestClassifyError(t *testing.T) {
+	err := pkgerrors.New("error")
+	tests := []struct {
+		err   error
+		class ErrorClass
+	}{
+		{err, ErrorClassTransient},
+		{Transient(err), ErrorClassTransient},
+		{Throttled(err, time.Second), ErrorClassThrottled},
+		{QuotaExceeded(err), ErrorClassQuotaExceeded},
+		{InvalidSpec(err), ErrorClassInvalidSpec},
+		{strongerrors.NotFound(err), ErrorClassNotFound},
+		{pkgerrors.Wrap(Throttled(err, time.Second), "wrapped"), ErrorClassThrottled},
+		{pkgerrors.Wrap(InvalidSpec(err), "wrapped"), ErrorClassInvalidSpec},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.class, ClassifyError(test.err), test.err.Error())
+	}
+}
+
+// TestFromHTTPStatus verifies that errors caused by HTTP responses are classified according to their status code and "Retry-After" header.
+func TestFromHTTPStatus(t *testing.T) {
+	err := pkgerrors.New("error")
+	retryAfter := http.Header{"Retry-After": []string{"30"}}
+	tests := []struct {
+		statusCode int
+		header     http.Header
+		class      ErrorClass
+		retryAfter time.Duration
+	}{
+		{http.StatusNotFound, nil, ErrorClassNotFound, 0},
+		{http.StatusBadRequest, nil, ErrorClassInvalidSpec, 0},
+		{http.StatusUnprocessableEntity, nil, ErrorClassInvalidSpec, 0},
+		{http.StatusTooManyRequests, nil, ErrorClassThrottled, defaultRetryAfter},
+		{http.StatusTooManyRequests, retryAfter, ErrorClassThrottled, 30 * time.Second},
+		{http.StatusServiceUnavailable, retryAfter, ErrorClassThrottled, 30 * time.Second},
+		{http.StatusServiceUnavailable, nil, ErrorClassTransient, 0},
+		{http.StatusInternalServerError, nil, ErrorClassTransient, 0},
+	}
+	for _, test := range tests {
+		res := FromHTTPStatus(err, test.statusCode, test.header)
+		assert.Equal(t, test.class, ClassifyError(res), "status code %d", test.statusCode)
+		d, _ := RetryAfter(res)
+		assert.Equal(t, test.retryAfter, d, "status code %d", test.statusCode)
+	}
+	assert.Equal(t, err, FromHTTPStatus(err, http.StatusConflict, nil))
+	assert.Nil(t, FromHTTPStatus(nil, http.StatusNotFound, nil))
+}
+
+// TestParseRetryAfter verifies that both forms of the "Retry-After" header are supported.
+func TestParseRetryAfter(t *testing.T) {
+	date := time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+	d := parseRetryAfter(http.Header{"Retry-After": []string{date}})
+	assert.True(t, d > 50*time.Second && d <= time.Minute, d.String())
+	assert.Equal(t, defaultRetryAfter, parseRetryAfter(http.Header{"Retry-After": []string{"soon"}}))
+}
@@ -14,8 +14,8 @@ import (
 	"os"
 	"time"
 
-	"github.com/cpuguy83/strongerrors"
 	"github.com/virtual-kubelet/virtual-kubelet/manager"
+	"github.com/virtual-kubelet/virtual-kubelet/providers"
 	"github.com/virtual-kubelet/virtual-kubelet/providers/huawei/auth"
 	"k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
@@ -254,12 +254,7 @@ func errorFromResponse(resp *http.Response) error {
 	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 16*1024))
 	err := fmt.Errorf("error during http request, status=%d: %q", resp.StatusCode, string(body))
 
-	switch resp.StatusCode {
-	case http.StatusNotFound:
-		return strongerrors.NotFound(err)
-	default:
-		return err
-	}
+	return providers.FromHTTPStatus(err, resp.StatusCode, resp.Header)
 }
 
 // GetPod retrieves a pod by name from the huawei CCI provider.
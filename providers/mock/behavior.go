@@ -0,0 +1,296 @@
+package mock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cpuguy83/strongerrors"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// defaultLogLines is the number of log lines written by containers whose image doesn't specify it.
+	defaultLogLines = 10
+)
+
+// Duration is a time.Duration read from a string such as "30s" in configuration files.
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalText parses the duration from its string representation.
+func (d *Duration) UnmarshalText(text []byte) error {
+	var err error
+	d.Duration, err = time.ParseDuration(string(text))
+	return err
+}
+
+// MarshalText returns the string representation of the duration.
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// ImageBehavior describes the lifecycle of the containers running an image.
+type ImageBehavior struct {
+	// PendingDuration is the time containers wait before starting, from the creation of their pod.
+	PendingDuration Duration `json:"pendingDuration,omitempty"`
+	// RunDuration is the time containers run before exiting. Containers never exit if it is zero.
+	// Exited containers are restarted right away according to the restart policy of their pod.
+	RunDuration Duration `json:"runDuration,omitempty"`
+	// ExitCode is the exit code of containers when they exit.
+	ExitCode int32 `json:"exitCode,omitempty"`
+	// LogLines is the number of log lines written by containers once they have started. Defaults to 10.
+	LogLines *int `json:"logLines,omitempty"`
+}
+
+// MethodBehavior injects latency and failures into the calls to a provider method.
+// Methods which don't return an error, such as Capacity, are only delayed.
+type MethodBehavior struct {
+	// Latency delays every call to the method.
+	Latency Duration `json:"latency,omitempty"`
+	// Error is the message of the error returned by failing calls. Calls only fail if it is set.
+	Error string `json:"error,omitempty"`
+	// ErrorType is the type of the error returned by failing calls, as understood by virtual-kubelet, such as "NotFound" or "Unavailable".
+	ErrorType string `json:"errorType,omitempty"`
+	// FailFirst makes the first calls fail. All calls fail if neither FailFirst nor FailEvery is set.
+	FailFirst int `json:"failFirst,omitempty"`
+	// FailEvery makes every FailEvery-th call fail.
+	FailEvery int `json:"failEvery,omitempty"`
+}
+
+// CapacityChange changes the capacity of the node once the provider has been running for some time.
+type CapacityChange struct {
+	// After is the time, from the start of the provider, after which the change applies.
+	After Duration `json:"after"`
+	// CPU, Memory and Pods replace the corresponding capacity, if set.
+	CPU    string `json:"cpu,omitempty"`
+	Memory string `json:"memory,omitempty"`
+	Pods   string `json:"pods,omitempty"`
+}
+
+// injectableMethods are the names of the provider methods into which behaviors may be injected.
+var injectableMethods = map[string]bool{
+	"CreatePod":           true,
+	"UpdatePod":           true,
+	"DeletePod":           true,
+	"GetPod":              true,
+	"GetContainerLogs":    true,
+	"ExecInContainer":     true,
+	"GetPodStatus":        true,
+	"GetPods":             true,
+	"Capacity":            true,
+	"NodeConditions":      true,
+	"NodeAddresses":       true,
+	"NodeDaemonEndpoints": true,
+	"GetStatsSummary":     true,
+}
+
+// errorTypes wrap injected errors so that virtual-kubelet handles them according to their type.
+var errorTypes = map[string]func(error) error{
+	"NotFound":        strongerrors.NotFound,
+	"InvalidArgument": strongerrors.InvalidArgument,
+	"Conflict":        strongerrors.Conflict,
+	"Unauthorized":    strongerrors.Unauthorized,
+	"Unauthenticated": strongerrors.Unauthenticated,
+	"Unavailable":     strongerrors.Unavailable,
+	"Forbidden":       strongerrors.Forbidden,
+	"System":          strongerrors.System,
+	"AlreadyExists":   strongerrors.AlreadyExists,
+	"NotImplemented":  strongerrors.NotImplemented,
+	"Unknown":         strongerrors.Unknown,
+	"Cancelled":       strongerrors.Cancelled,
+	"Deadline":        strongerrors.Deadline,
+	"Exhausted":       strongerrors.Exhausted,
+	"DataLoss":        strongerrors.DataLoss,
+}
+
+// validateBehaviors checks the images, methods and capacity changes of the given configuration.
+func validateBehaviors(config MockConfig) error {
+	for image, b := range config.Images {
+		if b.PendingDuration.Duration < 0 || b.RunDuration.Duration < 0 {
+			return fmt.Errorf("Invalid durations for image %s: durations cannot be negative", image)
+		}
+		if b.LogLines != nil && *b.LogLines < 0 {
+			return fmt.Errorf("Invalid log lines for image %s: %d", image, *b.LogLines)
+		}
+	}
+	for method, b := range config.Methods {
+		if !injectableMethods[method] {
+			return fmt.Errorf("Invalid method %q: behaviors can't be injected into it", method)
+		}
+		if b.Latency.Duration < 0 {
+			return fmt.Errorf("Invalid latency for method %s: %v", method, b.Latency.Duration)
+		}
+		if _, ok := errorTypes[b.ErrorType]; b.ErrorType != "" && !ok {
+			return fmt.Errorf("Invalid error type for method %s: %q", method, b.ErrorType)
+		}
+		if b.FailFirst < 0 || b.FailEvery < 0 {
+			return fmt.Errorf("Invalid failure counts for method %s: counts cannot be negative", method)
+		}
+	}
+	var previous time.Duration
+	for _, change := range config.CapacityChanges {
+		if change.After.Duration < previous {
+			return fmt.Errorf("Invalid capacity changes: changes must be ordered by time")
+		}
+		previous = change.After.Duration
+		for _, q := range []string{change.CPU, change.Memory, change.Pods} {
+			if _, err := resource.ParseQuantity(q); q != "" && err != nil {
+				return fmt.Errorf("Invalid capacity change after %v: invalid value %v", change.After.Duration, q)
+			}
+		}
+	}
+	return nil
+}
+
+// fails decides whether the given call to the method, counting from 1, fails.
+func (b MethodBehavior) fails(call int) bool {
+	if b.Error == "" {
+		return false
+	}
+	if b.FailFirst == 0 && b.FailEvery == 0 {
+		return true
+	}
+	return call <= b.FailFirst || (b.FailEvery > 0 && call%b.FailEvery == 0)
+}
+
+// err builds the error returned by failing calls.
+func (b MethodBehavior) err() error {
+	err := errors.New(b.Error)
+	if wrap, ok := errorTypes[b.ErrorType]; ok {
+		return wrap(err)
+	}
+	return err
+}
+
+// inject applies the behavior configured for the given method to a call: the call is delayed by its latency, and the returned error is the one it fails with, if any.
+func (p *MockProvider) inject(ctx context.Context, method string) error {
+	b, ok := p.config.Methods[method]
+	if !ok {
+		return nil
+	}
+	p.mu.Lock()
+	p.calls[method]++
+	call := p.calls[method]
+	p.mu.Unlock()
+
+	if b.Latency.Duration > 0 {
+		select {
+		case <-p.clock.After(b.Latency.Duration):
+		case <-ctx.Done():
+			return strongerrors.FromContext(ctx)
+		}
+	}
+	if b.fails(call) {
+		return b.err()
+	}
+	return nil
+}
+
+// containerStatus simulates the status of a container at the given time, from the lifecycle of its image and the restart policy of its pod.
+func (b ImageBehavior) containerStatus(c *v1.Container, restartPolicy v1.RestartPolicy, createdAt, now time.Time) v1.ContainerStatus {
+	status := v1.ContainerStatus{
+		Name:  c.Name,
+		Image: c.Image,
+	}
+	startedAt := createdAt.Add(b.PendingDuration.Duration)
+	if now.Before(startedAt) {
+		status.State.Waiting = &v1.ContainerStateWaiting{Reason: "ContainerCreating"}
+		return status
+	}
+
+	run := b.RunDuration.Duration
+	restarts := restartPolicy == v1.RestartPolicyAlways || restartPolicy == "" || (restartPolicy == v1.RestartPolicyOnFailure && b.ExitCode != 0)
+	if run > 0 && restarts {
+		// Each attempt runs for the run duration, and the next one starts right away.
+		attempt := int32(now.Sub(startedAt) / run)
+		startedAt = startedAt.Add(time.Duration(attempt) * run)
+		status.RestartCount = attempt
+		if attempt > 0 {
+			status.LastTerminationState.Terminated = b.terminatedState(startedAt.Add(-run), startedAt)
+		}
+	}
+	if run > 0 && !restarts && !now.Before(startedAt.Add(run)) {
+		status.State.Terminated = b.terminatedState(startedAt, startedAt.Add(run))
+		return status
+	}
+	status.State.Running = &v1.ContainerStateRunning{StartedAt: metav1.NewTime(startedAt)}
+	status.Ready = true
+	return status
+}
+
+// terminatedState builds the state of a container which ran between the given times.
+func (b ImageBehavior) terminatedState(startedAt, finishedAt time.Time) *v1.ContainerStateTerminated {
+	reason := "Completed"
+	if b.ExitCode != 0 {
+		reason = "Error"
+	}
+	return &v1.ContainerStateTerminated{
+		ExitCode:   b.ExitCode,
+		Reason:     reason,
+		StartedAt:  metav1.NewTime(startedAt),
+		FinishedAt: metav1.NewTime(finishedAt),
+	}
+}
+
+// podPhase derives the phase of a pod from the statuses of its containers, as the kubelet does.
+func podPhase(statuses []v1.ContainerStatus) v1.PodPhase {
+	var running, succeeded, failed int
+	for _, cs := range statuses {
+		switch {
+		case cs.State.Waiting != nil:
+			return v1.PodPending
+		case cs.State.Running != nil:
+			running++
+		case cs.State.Terminated.ExitCode == 0:
+			succeeded++
+		default:
+			failed++
+		}
+	}
+	switch {
+	case running > 0 || len(statuses) == 0:
+		return v1.PodRunning
+	case failed > 0:
+		return v1.PodFailed
+	default:
+		return v1.PodSucceeded
+	}
+}
+
+// logLines returns the deterministic log lines written by the given container once it has started.
+func (b ImageBehavior) logLines(namespace, podName, containerName string) []string {
+	count := defaultLogLines
+	if b.LogLines != nil {
+		count = *b.LogLines
+	}
+	lines := make([]string, count)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("%s/%s/%s: log line %d", namespace, podName, containerName, i+1)
+	}
+	return lines
+}
+
+// capacity returns the capacity of the node at the given time since the start of the provider, after the capacity changes which already applied.
+func (config MockConfig) capacity(elapsed time.Duration) (cpu, memory, pods string) {
+	cpu, memory, pods = config.CPU, config.Memory, config.Pods
+	for _, change := range config.CapacityChanges {
+		if change.After.Duration > elapsed {
+			break
+		}
+		if change.CPU != "" {
+			cpu = change.CPU
+		}
+		if change.Memory != "" {
+			memory = change.Memory
+		}
+		if change.Pods != "" {
+			pods = change.Pods
+		}
+	}
+	return cpu, memory, pods
+}
@@ -8,6 +8,8 @@ import (
 	"io/ioutil"
 	"log"
 	"math/rand"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/cpuguy83/strongerrors"
@@ -16,6 +18,7 @@ import (
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/clock"
 	"k8s.io/client-go/tools/remotecommand"
 	stats "k8s.io/kubernetes/pkg/kubelet/apis/stats/v1alpha1"
 
@@ -35,14 +38,20 @@ const (
 )
 
 // MockProvider implements the virtual-kubelet provider interface and stores pods in memory.
+// The statuses of the containers of its pods follow the lifecycles configured for their images.
 type MockProvider struct {
 	nodeName           string
 	operatingSystem    string
 	internalIP         string
 	daemonEndpointPort int32
-	pods               map[string]*v1.Pod
 	config             MockConfig
+	clock              clock.Clock
 	startTime          time.Time
+
+	mu        sync.Mutex
+	pods      map[string]*v1.Pod
+	createdAt map[string]time.Time // Creation times of the pods, from which the statuses of their containers are simulated
+	calls     map[string]int       // Number of calls to each method into which behaviors are injected
 }
 
 // MockConfig contains a mock virtual-kubelet's configurable parameters.
@@ -50,6 +59,14 @@ type MockConfig struct {
 	CPU    string `json:"cpu,omitempty"`
 	Memory string `json:"memory,omitempty"`
 	Pods   string `json:"pods,omitempty"`
+
+	// Images configures the lifecycle of the containers running each image, indexed by image name.
+	// Containers running other images start as soon as their pod is created and never exit.
+	Images map[string]ImageBehavior `json:"images,omitempty"`
+	// Methods injects latency and failures into the provider methods, indexed by method name (e.g. "CreatePod").
+	Methods map[string]MethodBehavior `json:"methods,omitempty"`
+	// CapacityChanges change the capacity of the node over time. They must be ordered by time.
+	CapacityChanges []CapacityChange `json:"capacityChanges,omitempty"`
 }
 
 // NewMockProvider creates a new MockProvider
@@ -64,9 +81,12 @@ func NewMockProvider(providerConfig, nodeName, operatingSystem string, internalI
 		operatingSystem:    operatingSystem,
 		internalIP:         internalIP,
 		daemonEndpointPort: daemonEndpointPort,
-		pods:               make(map[string]*v1.Pod),
 		config:             config,
+		clock:              clock.RealClock{},
 		startTime:          time.Now(),
+		pods:               make(map[string]*v1.Pod),
+		createdAt:          make(map[string]time.Time),
+		calls:              make(map[string]int),
 	}
 	return &provider, nil
 }
@@ -105,6 +125,9 @@ func loadConfig(providerConfig, nodeName string) (config MockConfig, err error)
 	if _, err = resource.ParseQuantity(config.Pods); err != nil {
 		return config, fmt.Errorf("Invalid pods value %v", config.Pods)
 	}
+	if err = validateBehaviors(config); err != nil {
+		return config, err
+	}
 	return config, nil
 }
 
@@ -118,12 +141,19 @@ func (p *MockProvider) CreatePod(ctx context.Context, pod *v1.Pod) error {
 
 	log.Printf("receive CreatePod %q\n", pod.Name)
 
+	if err := p.inject(ctx, "CreatePod"); err != nil {
+		return err
+	}
+
 	key, err := buildKey(pod)
 	if err != nil {
 		return err
 	}
 
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	p.pods[key] = pod
+	p.createdAt[key] = p.clock.Now()
 
 	return nil
 }
@@ -138,12 +168,21 @@ func (p *MockProvider) UpdatePod(ctx context.Context, pod *v1.Pod) error {
 
 	log.Printf("receive UpdatePod %q\n", pod.Name)
 
+	if err := p.inject(ctx, "UpdatePod"); err != nil {
+		return err
+	}
+
 	key, err := buildKey(pod)
 	if err != nil {
 		return err
 	}
 
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	p.pods[key] = pod
+	if _, ok := p.createdAt[key]; !ok {
+		p.createdAt[key] = p.clock.Now()
+	}
 
 	return nil
 }
@@ -158,16 +197,23 @@ func (p *MockProvider) DeletePod(ctx context.Context, pod *v1.Pod) (err error) {
 
 	log.Printf("receive DeletePod %q\n", pod.Name)
 
+	if err := p.inject(ctx, "DeletePod"); err != nil {
+		return err
+	}
+
 	key, err := buildKey(pod)
 	if err != nil {
 		return err
 	}
 
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	if _, exists := p.pods[key]; !exists {
 		return strongerrors.NotFound(fmt.Errorf("pod not found"))
 	}
 
 	delete(p.pods, key)
+	delete(p.createdAt, key)
 
 	return nil
 }
@@ -182,15 +228,43 @@ func (p *MockProvider) GetPod(ctx context.Context, namespace, name string) (pod
 
 	log.Printf("receive GetPod %q\n", name)
 
+	if err := p.inject(ctx, "GetPod"); err != nil {
+		return nil, err
+	}
+
+	pod, _, err = p.getPod(namespace, name)
+	return pod, err
+}
+
+// getPod returns a pod by name that is stored in memory, along with the time it was created at.
+func (p *MockProvider) getPod(namespace, name string) (*v1.Pod, time.Time, error) {
 	key, err := buildKeyFromNames(namespace, name)
 	if err != nil {
-		return nil, err
+		return nil, time.Time{}, err
 	}
 
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	if pod, ok := p.pods[key]; ok {
-		return pod, nil
+		return pod, p.createdAt[key], nil
+	}
+	return nil, time.Time{}, strongerrors.NotFound(fmt.Errorf("pod \"%s/%s\" is not known to the provider", namespace, name))
+}
+
+// getContainer returns a container of a pod by name, along with the status it has at the current time.
+func (p *MockProvider) getContainer(namespace, podName, containerName string) (*v1.Container, *v1.ContainerStatus, error) {
+	pod, createdAt, err := p.getPod(namespace, podName)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range pod.Spec.Containers {
+		c := &pod.Spec.Containers[i]
+		if c.Name == containerName {
+			status := p.config.Images[c.Image].containerStatus(c, pod.Spec.RestartPolicy, createdAt, p.clock.Now())
+			return c, &status, nil
+		}
 	}
-	return nil, strongerrors.NotFound(fmt.Errorf("pod \"%s/%s\" is not known to the provider", namespace, name))
+	return nil, nil, strongerrors.NotFound(fmt.Errorf("container %q not found in pod \"%s/%s\"", containerName, namespace, podName))
 }
 
 // GetContainerLogs retrieves the logs of a container by name from the provider.
@@ -202,7 +276,27 @@ func (p *MockProvider) GetContainerLogs(ctx context.Context, namespace, podName,
 	addAttributes(span, namespaceKey, namespace, nameKey, podName, containerNameKey, containerName)
 
 	log.Printf("receive GetContainerLogs %q\n", podName)
-	return "", nil
+
+	if err := p.inject(ctx, "GetContainerLogs"); err != nil {
+		return "", err
+	}
+
+	c, status, err := p.getContainer(namespace, podName, containerName)
+	if err != nil {
+		return "", err
+	}
+	if status.State.Waiting != nil {
+		// Containers only write logs once they have started.
+		return "", nil
+	}
+	lines := p.config.Images[c.Image].logLines(namespace, podName, containerName)
+	if tail > 0 && tail < len(lines) {
+		lines = lines[len(lines)-tail:]
+	}
+	if len(lines) == 0 {
+		return "", nil
+	}
+	return strings.Join(lines, "\n") + "\n", nil
 }
 
 // Get full pod name as defined in the provider context
@@ -213,12 +307,47 @@ func (p *MockProvider) GetPodFullName(namespace string, pod string) string {
 
 // ExecInContainer executes a command in a container in the pod, copying data
 // between in/out/err and the container's stdin/stdout/stderr.
+// Commands behave like echo: their arguments are written to stdout, followed by their stdin.
 func (p *MockProvider) ExecInContainer(name string, uid types.UID, container string, cmd []string, in io.Reader, out, err io.WriteCloser, tty bool, resize <-chan remotecommand.TerminalSize, timeout time.Duration) error {
 	log.Printf("receive ExecInContainer %q\n", container)
+
+	if err := p.inject(context.Background(), "ExecInContainer"); err != nil {
+		return err
+	}
+
+	// The name of the pod is built the same way as the keys of the pods.
+	p.mu.Lock()
+	pod, ok := p.pods[name]
+	p.mu.Unlock()
+	if !ok {
+		return strongerrors.NotFound(fmt.Errorf("pod %q is not known to the provider", name))
+	}
+	_, status, getErr := p.getContainer(pod.Namespace, pod.Name, container)
+	if getErr != nil {
+		return getErr
+	}
+	if status.State.Running == nil {
+		return fmt.Errorf("container %q is not running", container)
+	}
+
+	if _, writeErr := fmt.Fprintln(out, strings.Join(cmd, " ")); writeErr != nil {
+		return writeErr
+	}
+	if in != nil {
+		if _, copyErr := io.Copy(out, in); copyErr != nil {
+			return copyErr
+		}
+	}
 	return nil
 }
 
-// GetPodStatus returns the status of a pod by name that is "running".
+// ReportsExecExitStatus returns true, as commands behave like echo and exit with a zero status unless a failure of ExecInContainer is injected.
+// This lets exec probes be exercised against the mock provider.
+func (p *MockProvider) ReportsExecExitStatus() bool {
+	return true
+}
+
+// GetPodStatus returns the status of a pod by name, as simulated from the lifecycles of the images of its containers.
 // returns nil if a pod by that name is not found.
 func (p *MockProvider) GetPodStatus(ctx context.Context, namespace, name string) (*v1.PodStatus, error) {
 	ctx, span := trace.StartSpan(ctx, "GetPodStatus")
@@ -229,13 +358,34 @@ func (p *MockProvider) GetPodStatus(ctx context.Context, namespace, name string)
 
 	log.Printf("receive GetPodStatus %q\n", name)
 
-	now := metav1.NewTime(time.Now())
+	if err := p.inject(ctx, "GetPodStatus"); err != nil {
+		return nil, err
+	}
+
+	pod, createdAt, err := p.getPod(namespace, name)
+	if err != nil {
+		return nil, err
+	}
+
+	// Simulate the statuses of the containers from the lifecycles of their images.
+	now := p.clock.Now()
+	ready := v1.ConditionTrue
+	var containerStatuses []v1.ContainerStatus
+	for i := range pod.Spec.Containers {
+		c := &pod.Spec.Containers[i]
+		cs := p.config.Images[c.Image].containerStatus(c, pod.Spec.RestartPolicy, createdAt, now)
+		if !cs.Ready {
+			ready = v1.ConditionFalse
+		}
+		containerStatuses = append(containerStatuses, cs)
+	}
+	startTime := metav1.NewTime(createdAt)
 
 	status := &v1.PodStatus{
-		Phase:     v1.PodRunning,
+		Phase:     podPhase(containerStatuses),
 		HostIP:    "1.2.3.4",
 		PodIP:     "5.6.7.8",
-		StartTime: &now,
+		StartTime: &startTime,
 		Conditions: []v1.PodCondition{
 			{
 				Type:   v1.PodInitialized,
@@ -243,32 +393,14 @@ func (p *MockProvider) GetPodStatus(ctx context.Context, namespace, name string)
 			},
 			{
 				Type:   v1.PodReady,
-				Status: v1.ConditionTrue,
+				Status: ready,
 			},
 			{
 				Type:   v1.PodScheduled,
 				Status: v1.ConditionTrue,
 			},
 		},
-	}
-
-	pod, err := p.GetPod(ctx, namespace, name)
-	if err != nil {
-		return nil, err
-	}
-
-	for _, container := range pod.Spec.Containers {
-		status.ContainerStatuses = append(status.ContainerStatuses, v1.ContainerStatus{
-			Name:         container.Name,
-			Image:        container.Image,
-			Ready:        true,
-			RestartCount: 0,
-			State: v1.ContainerState{
-				Running: &v1.ContainerStateRunning{
-					StartedAt: now,
-				},
-			},
-		})
+		ContainerStatuses: containerStatuses,
 	}
 
 	return status, nil
@@ -281,8 +413,14 @@ func (p *MockProvider) GetPods(ctx context.Context) ([]*v1.Pod, error) {
 
 	log.Printf("receive GetPods\n")
 
+	if err := p.inject(ctx, "GetPods"); err != nil {
+		return nil, err
+	}
+
 	var pods []*v1.Pod
 
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	for _, pod := range p.pods {
 		pods = append(pods, pod)
 	}
@@ -290,15 +428,18 @@ func (p *MockProvider) GetPods(ctx context.Context) ([]*v1.Pod, error) {
 	return pods, nil
 }
 
-// Capacity returns a resource list containing the capacity limits.
+// Capacity returns a resource list containing the capacity limits, after the capacity changes which already applied.
 func (p *MockProvider) Capacity(ctx context.Context) v1.ResourceList {
 	ctx, span := trace.StartSpan(ctx, "Capacity")
 	defer span.End()
 
+	p.inject(ctx, "Capacity")
+
+	cpu, memory, pods := p.config.capacity(p.clock.Since(p.startTime))
 	return v1.ResourceList{
-		"cpu":    resource.MustParse(p.config.CPU),
-		"memory": resource.MustParse(p.config.Memory),
-		"pods":   resource.MustParse(p.config.Pods),
+		"cpu":    resource.MustParse(cpu),
+		"memory": resource.MustParse(memory),
+		"pods":   resource.MustParse(pods),
 	}
 }
 
@@ -308,6 +449,8 @@ func (p *MockProvider) NodeConditions(ctx context.Context) []v1.NodeCondition {
 	ctx, span := trace.StartSpan(ctx, "NodeConditions")
 	defer span.End()
 
+	p.inject(ctx, "NodeConditions")
+
 	// TODO: Make this configurable
 	return []v1.NodeCondition{
 		{
@@ -360,6 +503,8 @@ func (p *MockProvider) NodeAddresses(ctx context.Context) []v1.NodeAddress {
 	ctx, span := trace.StartSpan(ctx, "NodeAddresses")
 	defer span.End()
 
+	p.inject(ctx, "NodeAddresses")
+
 	return []v1.NodeAddress{
 		{
 			Type:    "InternalIP",
@@ -374,6 +519,8 @@ func (p *MockProvider) NodeDaemonEndpoints(ctx context.Context) *v1.NodeDaemonEn
 	ctx, span := trace.StartSpan(ctx, "NodeDaemonEndpoints")
 	defer span.End()
 
+	p.inject(ctx, "NodeDaemonEndpoints")
+
 	return &v1.NodeDaemonEndpoints{
 		KubeletEndpoint: v1.DaemonEndpoint{
 			Port: p.daemonEndpointPort,
@@ -392,6 +539,10 @@ func (p *MockProvider) GetStatsSummary(ctx context.Context) (*stats.Summary, err
 	ctx, span := trace.StartSpan(ctx, "GetStatsSummary")
 	defer span.End()
 
+	if err := p.inject(ctx, "GetStatsSummary"); err != nil {
+		return nil, err
+	}
+
 	// Grab the current timestamp so we can report it as the time the stats were generated.
 	time := metav1.NewTime(time.Now())
 
@@ -405,6 +556,8 @@ func (p *MockProvider) GetStatsSummary(ctx context.Context) (*stats.Summary, err
 	}
 
 	// Populate the Summary object with dummy stats for each pod known by this provider.
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	for _, pod := range p.pods {
 		var (
 			// totalUsageNanoCores will be populated with the sum of the values of UsageNanoCores computes across all containers in the pod.
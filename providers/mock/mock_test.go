@@ -0,0 +1,304 @@
+package mock
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cpuguy83/strongerrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/clock"
+
+	"github.com/virtual-kubelet/virtual-kubelet/providers"
+	"github.com/virtual-kubelet/virtual-kubelet/providers/conformance"
+)
+
+// TestConformance runs the provider conformance suite against the mock provider.
+func TestConformance(t *testing.T) {
+	conformance.Run(t, func(cfg conformance.ProviderConfig) (providers.Provider, error) {
+		f, err := ioutil.TempFile("", "vk-mock")
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(f.Name())
+		if _, err := fmt.Fprintf(f, `{%q: {}}`, cfg.NodeName); err != nil {
+			return nil, err
+		}
+		if err := f.Close(); err != nil {
+			return nil, err
+		}
+		return NewMockProvider(f.Name(), cfg.NodeName, cfg.OperatingSystem, "127.0.0.1", 10250)
+	}, conformance.Options{
+		// The mock provider doesn't run commands in containers, nor does it materialize their volumes.
+		SkipExec: true,
+	})
+}
+
+// newTestProvider creates a mock provider for the node "vk-mock" with the given configuration, whose time is given by the returned clock.
+func newTestProvider(t *testing.T, config string) (*MockProvider, *clock.FakeClock) {
+	f, err := ioutil.TempFile("", "vk-mock")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	_, err = fmt.Fprintf(f, `{"vk-mock": %s}`, config)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	p, err := NewMockProvider(f.Name(), "vk-mock", "Linux", "127.0.0.1", 10250)
+	require.NoError(t, err)
+	c := clock.NewFakeClock(time.Now())
+	p.clock = c
+	p.startTime = c.Now()
+	return p, c
+}
+
+func newTestPod(name string, restartPolicy v1.RestartPolicy, images ...string) *v1.Pod {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name},
+		Spec:       v1.PodSpec{RestartPolicy: restartPolicy},
+	}
+	for _, image := range images {
+		pod.Spec.Containers = append(pod.Spec.Containers, v1.Container{Name: image, Image: image})
+	}
+	return pod
+}
+
+func TestLoadConfigBehaviors(t *testing.T) {
+	p, _ := newTestProvider(t, `{
+		"images": {"job": {"pendingDuration": "5s", "runDuration": "1m", "exitCode": 1, "logLines": 3}},
+		"methods": {"CreatePod": {"latency": "100ms", "error": "quota exceeded", "errorType": "Exhausted", "failFirst": 2}},
+		"capacityChanges": [{"after": "1m", "pods": "1"}]
+	}`)
+	assert.Equal(t, defaultCPUCapacity, p.config.CPU)
+	assert.Equal(t, 5*time.Second, p.config.Images["job"].PendingDuration.Duration)
+	assert.Equal(t, time.Minute, p.config.Images["job"].RunDuration.Duration)
+	assert.Equal(t, int32(1), p.config.Images["job"].ExitCode)
+	assert.Equal(t, 3, *p.config.Images["job"].LogLines)
+	assert.Equal(t, MethodBehavior{Latency: Duration{100 * time.Millisecond}, Error: "quota exceeded", ErrorType: "Exhausted", FailFirst: 2}, p.config.Methods["CreatePod"])
+	assert.Equal(t, []CapacityChange{{After: Duration{time.Minute}, Pods: "1"}}, p.config.CapacityChanges)
+
+	for name, config := range map[string]string{
+		"duration":     `{"images": {"job": {"runDuration": "soon"}}}`,
+		"negative":     `{"images": {"job": {"pendingDuration": "-1s"}}}`,
+		"method":       `{"methods": {"OperatingSystem": {"latency": "1s"}}}`,
+		"error type":   `{"methods": {"GetPod": {"error": "boom", "errorType": "Boom"}}}`,
+		"count":        `{"methods": {"GetPod": {"error": "boom", "failEvery": -1}}}`,
+		"order":        `{"capacityChanges": [{"after": "2m", "pods": "1"}, {"after": "1m", "pods": "2"}]}`,
+		"quantity":     `{"capacityChanges": [{"after": "1m", "cpu": "many"}]}`,
+		"unknown type": `{"methods": {"GetPod": {"latency": 10}}}`,
+	} {
+		f, err := ioutil.TempFile("", "vk-mock")
+		require.NoError(t, err)
+		fmt.Fprintf(f, `{"vk-mock": %s}`, config)
+		f.Close()
+		_, err = NewMockProvider(f.Name(), "vk-mock", "Linux", "127.0.0.1", 10250)
+		os.Remove(f.Name())
+		assert.Error(t, err, name)
+	}
+}
+
+// TestPodLifecycle verifies that the statuses of containers follow the lifecycles of their images and the restart policies of their pods.
+func TestPodLifecycle(t *testing.T) {
+	p, c := newTestProvider(t, `{"images": {
+		"slow": {"pendingDuration": "5s"},
+		"job": {"runDuration": "10s"},
+		"crash": {"runDuration": "10s", "exitCode": 1}
+	}}`)
+	ctx := context.Background()
+	getStatus := func(name string) *v1.PodStatus {
+		status, err := p.GetPodStatus(ctx, "default", name)
+		require.NoError(t, err)
+		return status
+	}
+	for _, pod := range []*v1.Pod{
+		newTestPod("slow", v1.RestartPolicyNever, "slow", "job"),
+		newTestPod("crash", v1.RestartPolicyNever, "crash"),
+		newTestPod("restarted", v1.RestartPolicyAlways, "crash"),
+		newTestPod("job", v1.RestartPolicyOnFailure, "job", "nginx"),
+	} {
+		require.NoError(t, p.CreatePod(ctx, pod))
+	}
+
+	status := getStatus("slow")
+	assert.Equal(t, v1.PodPending, status.Phase)
+	require.Len(t, status.ContainerStatuses, 2)
+	require.NotNil(t, status.ContainerStatuses[0].State.Waiting)
+	assert.Equal(t, "ContainerCreating", status.ContainerStatuses[0].State.Waiting.Reason)
+	assert.NotNil(t, status.ContainerStatuses[1].State.Running)
+	assert.Equal(t, v1.PodRunning, getStatus("crash").Phase)
+
+	c.Step(5 * time.Second)
+	status = getStatus("slow")
+	assert.Equal(t, v1.PodRunning, status.Phase)
+	assert.True(t, status.ContainerStatuses[0].Ready)
+	assert.Equal(t, c.Now().Unix(), status.ContainerStatuses[0].State.Running.StartedAt.Unix())
+
+	c.Step(20 * time.Second)
+	status = getStatus("slow")
+	assert.Equal(t, v1.PodRunning, status.Phase)
+	require.NotNil(t, status.ContainerStatuses[1].State.Terminated)
+	assert.Equal(t, "Completed", status.ContainerStatuses[1].State.Terminated.Reason)
+
+	status = getStatus("crash")
+	assert.Equal(t, v1.PodFailed, status.Phase)
+	require.NotNil(t, status.ContainerStatuses[0].State.Terminated)
+	assert.Equal(t, int32(1), status.ContainerStatuses[0].State.Terminated.ExitCode)
+	assert.Equal(t, "Error", status.ContainerStatuses[0].State.Terminated.Reason)
+
+	// Containers which exit are restarted according to the restart policy of their pod.
+	status = getStatus("restarted")
+	assert.Equal(t, v1.PodRunning, status.Phase)
+	cs := status.ContainerStatuses[0]
+	assert.Equal(t, int32(2), cs.RestartCount)
+	assert.NotNil(t, cs.State.Running)
+	require.NotNil(t, cs.LastTerminationState.Terminated)
+	assert.Equal(t, int32(1), cs.LastTerminationState.Terminated.ExitCode)
+
+	status = getStatus("job")
+	assert.Equal(t, v1.PodRunning, status.Phase, "the containers which don't exit keep the pod running")
+	assert.Equal(t, int32(0), status.ContainerStatuses[0].RestartCount)
+	assert.NotNil(t, status.ContainerStatuses[0].State.Terminated)
+	assert.Equal(t, v1.ConditionFalse, status.Conditions[1].Status)
+}
+
+// TestMethodBehaviors verifies that failures and latency are injected into calls to provider methods.
+func TestMethodBehaviors(t *testing.T) {
+	p, c := newTestProvider(t, `{"methods": {
+		"CreatePod": {"error": "quota exceeded", "errorType": "Exhausted", "failFirst": 2},
+		"GetPodStatus": {"error": "lost", "errorType": "NotFound", "failEvery": 2},
+		"GetPods": {"latency": "1m"},
+		"DeletePod": {"error": "boom"}
+	}}`)
+	ctx := context.Background()
+	pod := newTestPod("pod", v1.RestartPolicyAlways, "nginx")
+
+	for i := 0; i < 2; i++ {
+		err := p.CreatePod(ctx, pod)
+		assert.True(t, strongerrors.IsExhausted(err), "call %d: %v", i+1, err)
+	}
+	require.NoError(t, p.CreatePod(ctx, pod))
+
+	_, err := p.GetPodStatus(ctx, "default", "pod")
+	assert.NoError(t, err)
+	_, err = p.GetPodStatus(ctx, "default", "pod")
+	assert.True(t, strongerrors.IsNotFound(err))
+	_, err = p.GetPodStatus(ctx, "default", "pod")
+	assert.NoError(t, err)
+
+	assert.EqualError(t, p.DeletePod(ctx, pod), "boom")
+	assert.EqualError(t, p.DeletePod(ctx, pod), "boom")
+
+	done := make(chan []*v1.Pod)
+	go func() {
+		pods, err := p.GetPods(ctx)
+		assert.NoError(t, err)
+		done <- pods
+	}()
+	for !c.HasWaiters() {
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case <-done:
+		t.Fatal("GetPods should be delayed")
+	default:
+	}
+	c.Step(time.Minute)
+	assert.Len(t, <-done, 1)
+
+	// Calls are cancelled along with their context.
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = p.GetPods(cancelled)
+	assert.True(t, strongerrors.IsCancelled(err))
+}
+
+func TestExecInContainer(t *testing.T) {
+	p, c := newTestProvider(t, `{"images": {"slow": {"pendingDuration": "5s"}}}`)
+	require.NoError(t, p.CreatePod(context.Background(), newTestPod("pod", v1.RestartPolicyAlways, "nginx", "slow")))
+
+	var out bytes.Buffer
+	err := p.ExecInContainer("default-pod", "", "nginx", []string{"hello", "world"}, strings.NewReader("input"), nopCloser{&out}, nopCloser{&bytes.Buffer{}}, false, nil, time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world\ninput", out.String())
+
+	assert.Error(t, p.ExecInContainer("default-pod", "", "slow", []string{"true"}, nil, nopCloser{&out}, nopCloser{&out}, false, nil, time.Second), "the container isn't running yet")
+	c.Step(5 * time.Second)
+	assert.NoError(t, p.ExecInContainer("default-pod", "", "slow", []string{"true"}, nil, nopCloser{&out}, nopCloser{&out}, false, nil, time.Second))
+
+	err = p.ExecInContainer("default-pod", "", "missing", nil, nil, nopCloser{&out}, nopCloser{&out}, false, nil, time.Second)
+	assert.True(t, strongerrors.IsNotFound(err))
+	err = p.ExecInContainer("default-missing", "", "nginx", nil, nil, nopCloser{&out}, nopCloser{&out}, false, nil, time.Second)
+	assert.True(t, strongerrors.IsNotFound(err))
+}
+
+func TestGetContainerLogs(t *testing.T) {
+	p, c := newTestProvider(t, `{"images": {"slow": {"pendingDuration": "5s", "logLines": 3}, "quiet": {"logLines": 0}}}`)
+	ctx := context.Background()
+	require.NoError(t, p.CreatePod(ctx, newTestPod("pod", v1.RestartPolicyAlways, "nginx", "slow", "quiet")))
+
+	logs, err := p.GetContainerLogs(ctx, "default", "pod", "nginx", 0)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSuffix(logs, "\n"), "\n")
+	assert.Len(t, lines, defaultLogLines)
+	assert.Equal(t, "default/pod/nginx: log line 1", lines[0])
+	again, err := p.GetContainerLogs(ctx, "default", "pod", "nginx", 0)
+	require.NoError(t, err)
+	assert.Equal(t, logs, again, "logs should be deterministic")
+
+	logs, err = p.GetContainerLogs(ctx, "default", "pod", "slow", 0)
+	require.NoError(t, err)
+	assert.Empty(t, logs, "containers which haven't started have no logs")
+	c.Step(5 * time.Second)
+	logs, err = p.GetContainerLogs(ctx, "default", "pod", "slow", 2)
+	require.NoError(t, err)
+	assert.Equal(t, "default/pod/slow: log line 2\ndefault/pod/slow: log line 3\n", logs)
+
+	logs, err = p.GetContainerLogs(ctx, "default", "pod", "quiet", 0)
+	require.NoError(t, err)
+	assert.Empty(t, logs)
+	_, err = p.GetContainerLogs(ctx, "default", "pod", "missing", 0)
+	assert.True(t, strongerrors.IsNotFound(err))
+}
+
+func TestCapacityChanges(t *testing.T) {
+	p, c := newTestProvider(t, `{"cpu": "4", "pods": "10", "capacityChanges": [
+		{"after": "1m", "pods": "5"},
+		{"after": "2m", "cpu": "2", "memory": "1Gi"},
+		{"after": "3m", "pods": "10"}
+	]}`)
+	ctx := context.Background()
+	capacity := func() (string, string, string) {
+		list := p.Capacity(ctx)
+		cpu, memory, pods := list[v1.ResourceCPU], list[v1.ResourceMemory], list[v1.ResourcePods]
+		return cpu.String(), memory.String(), pods.String()
+	}
+
+	cpu, memory, pods := capacity()
+	assert.Equal(t, []string{"4", defaultMemoryCapacity, "10"}, []string{cpu, memory, pods})
+	c.Step(time.Minute)
+	cpu, memory, pods = capacity()
+	assert.Equal(t, []string{"4", defaultMemoryCapacity, "5"}, []string{cpu, memory, pods})
+	c.Step(90 * time.Second)
+	cpu, memory, pods = capacity()
+	assert.Equal(t, []string{"2", "1Gi", "5"}, []string{cpu, memory, pods})
+	c.Step(time.Hour)
+	cpu, memory, pods = capacity()
+	assert.Equal(t, []string{"2", "1Gi", "10"}, []string{cpu, memory, pods})
+}
+
+// nopCloser turns a writer into a WriteCloser.
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
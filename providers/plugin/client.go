@@ -0,0 +1,418 @@
+package plugin
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cpuguy83/strongerrors"
+	errstatus "github.com/cpuguy83/strongerrors/status"
+	pkgerrors "github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	grpcstatus "google.golang.org/grpc/status"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/remotecommand"
+	stats "k8s.io/kubernetes/pkg/kubelet/apis/stats/v1alpha1"
+
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+	"github.com/virtual-kubelet/virtual-kubelet/providers"
+)
+
+// Client is a providers.Provider backed by a plugin.
+// Client implements all the optional provider interfaces, returning a "not implemented" error when the plugin doesn't.
+type Client struct {
+	conn            *grpc.ClientConn
+	capabilities    Capabilities
+	operatingSystem string
+
+	mu sync.Mutex
+	// capacity is the last capacity reported by the plugin, which is used when the plugin cannot be reached.
+	capacity v1.ResourceList
+}
+
+var (
+	_ providers.Provider               = &Client{}
+	_ providers.PodMetricsProvider     = &Client{}
+	_ providers.ContainerRestarter     = &Client{}
+	_ providers.NativeProber           = &Client{}
+	_ providers.ExecExitStatusReporter = &Client{}
+	_ providers.PodVolumesUpdater      = &Client{}
+	_ providers.StreamingProvider      = &Client{}
+)
+
+func unixDialer(addr string, timeout time.Duration) (net.Conn, error) {
+	return net.DialTimeout("unix", addr, timeout)
+}
+
+// Dial connects to the plugin listening on the unix socket at the specified path and performs the protocol handshake.
+func Dial(ctx context.Context, socketPath string) (*Client, error) {
+	conn, err := grpc.DialContext(ctx, socketPath,
+		grpc.WithInsecure(),
+		grpc.WithBlock(),
+		grpc.WithDialer(unixDialer),
+		grpc.WithDefaultCallOptions(grpc.CallContentSubtype(codecName)),
+	)
+	if err != nil {
+		return nil, pkgerrors.Wrapf(err, "failed to connect to plugin at %q", socketPath)
+	}
+
+	c := &Client{conn: conn}
+	var res HandshakeResponse
+	if err := c.invoke(ctx, "Handshake", &HandshakeRequest{ProtocolVersion: ProtocolVersion}, &res); err != nil {
+		conn.Close()
+		return nil, pkgerrors.Wrap(err, "plugin handshake failed")
+	}
+	if res.ProtocolVersion != ProtocolVersion {
+		conn.Close()
+		return nil, pkgerrors.Errorf("plugin implements protocol version %q, but %q is required", res.ProtocolVersion, ProtocolVersion)
+	}
+	c.capabilities = res.Capabilities
+
+	var osRes OperatingSystemResponse
+	if err := c.invoke(ctx, "OperatingSystem", &Empty{}, &osRes); err != nil {
+		conn.Close()
+		return nil, pkgerrors.Wrap(err, "failed to get the plugin's operating system")
+	}
+	c.operatingSystem = osRes.OperatingSystem
+
+	log.G(ctx).WithField("socket", socketPath).WithField("capabilities", res.Capabilities).Info("Connected to provider plugin")
+	return c, nil
+}
+
+// Close closes the connection to the plugin.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// fromGRPC converts the specified error returned by a gRPC call to the plugin into an error classified by the "strongerrors" package.
+// If the specified trailer indicates that the plugin is being throttled, the error implements providers.ErrThrottled.
+func fromGRPC(err error, trailer metadata.MD) error {
+	if err == nil {
+		return nil
+	}
+	s, ok := grpcstatus.FromError(err)
+	if !ok {
+		return err
+	}
+	res := errstatus.FromGRPC(s)
+	if v := trailer.Get(retryAfterTrailer); len(v) > 0 {
+		if ms, err := strconv.ParseInt(v[0], 10, 64); err == nil {
+			res = providers.Throttled(res, time.Duration(ms)*time.Millisecond)
+		}
+	}
+	return res
+}
+
+// invoke calls the specified unary method of the plugin.
+func (c *Client) invoke(ctx context.Context, method string, req, res interface{}) error {
+	var trailer metadata.MD
+	err := c.conn.Invoke(ctx, methodName(method), req, res, grpc.Trailer(&trailer))
+	return fromGRPC(err, trailer)
+}
+
+// CreatePod takes a Kubernetes Pod and deploys it within the provider.
+func (c *Client) CreatePod(ctx context.Context, pod *v1.Pod) error {
+	return c.invoke(ctx, "CreatePod", &PodRequest{Pod: pod}, &Empty{})
+}
+
+// UpdatePod takes a Kubernetes Pod and updates it within the provider.
+func (c *Client) UpdatePod(ctx context.Context, pod *v1.Pod) error {
+	return c.invoke(ctx, "UpdatePod", &PodRequest{Pod: pod}, &Empty{})
+}
+
+// DeletePod takes a Kubernetes Pod and deletes it from the provider.
+func (c *Client) DeletePod(ctx context.Context, pod *v1.Pod) error {
+	return c.invoke(ctx, "DeletePod", &PodRequest{Pod: pod}, &Empty{})
+}
+
+// GetPod retrieves a pod by name from the provider.
+func (c *Client) GetPod(ctx context.Context, namespace, name string) (*v1.Pod, error) {
+	var res PodResponse
+	if err := c.invoke(ctx, "GetPod", &PodKey{Namespace: namespace, Name: name}, &res); err != nil {
+		return nil, err
+	}
+	return res.Pod, nil
+}
+
+// GetContainerLogs retrieves the logs of a container by name from the provider.
+func (c *Client) GetContainerLogs(ctx context.Context, namespace, podName, containerName string, tail int) (string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	desc := &grpc.StreamDesc{StreamName: "GetContainerLogs", ServerStreams: true}
+	stream, err := c.conn.NewStream(ctx, desc, methodName(desc.StreamName))
+	if err != nil {
+		return "", fromGRPC(err, nil)
+	}
+	if err := stream.SendMsg(&LogsRequest{Namespace: namespace, PodName: podName, ContainerName: containerName, Tail: tail}); err != nil {
+		return "", fromGRPC(err, stream.Trailer())
+	}
+	if err := stream.CloseSend(); err != nil {
+		return "", fromGRPC(err, stream.Trailer())
+	}
+
+	var logs strings.Builder
+	for {
+		var chunk LogsChunk
+		err := stream.RecvMsg(&chunk)
+		if err == io.EOF {
+			return logs.String(), nil
+		}
+		if err != nil {
+			return "", fromGRPC(err, stream.Trailer())
+		}
+		logs.Write(chunk.Data)
+	}
+}
+
+// ExecInContainer executes a command in a container in the pod, copying data between in/out/err and the container's stdin/stdout/stderr.
+// Standard input is copied by a goroutine which stops forwarding it once the session ends, but can only return once its pending read from in does.
+// Callers must therefore close in (or make its reads return) after ExecInContainer returns, as the exec handler of the API server does by closing the connection.
+func (c *Client) ExecInContainer(name string, uid types.UID, container string, cmd []string, in io.Reader, out, errOut io.WriteCloser, tty bool, resize <-chan remotecommand.TerminalSize, timeout time.Duration) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	desc := &grpc.StreamDesc{StreamName: "ExecInContainer", ServerStreams: true, ClientStreams: true}
+	stream, err := c.conn.NewStream(ctx, desc, methodName(desc.StreamName))
+	if err != nil {
+		return fromGRPC(err, nil)
+	}
+	start := &ExecStart{
+		Name:      name,
+		UID:       uid,
+		Container: container,
+		Command:   cmd,
+		Stdin:     in != nil,
+		Stdout:    out != nil,
+		Stderr:    errOut != nil,
+		TTY:       tty,
+		Timeout:   timeout,
+	}
+	if err := stream.SendMsg(&ExecRequest{Start: start}); err != nil {
+		return fromGRPC(err, stream.Trailer())
+	}
+
+	// Messages must not be sent concurrently on the same stream.
+	var mu sync.Mutex
+	send := func(req *ExecRequest) error {
+		mu.Lock()
+		defer mu.Unlock()
+		return stream.SendMsg(req)
+	}
+	if in != nil {
+		go func() {
+			buf := make([]byte, 32*1024)
+			for {
+				n, err := in.Read(buf)
+				// Nothing is sent once the session has ended, as the stream is done.
+				if ctx.Err() != nil {
+					return
+				}
+				if n > 0 {
+					if send(&ExecRequest{Stdin: buf[:n]}) != nil {
+						return
+					}
+				}
+				if err != nil {
+					send(&ExecRequest{CloseStdin: true})
+					return
+				}
+			}
+		}()
+	}
+	if resize != nil {
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case size, ok := <-resize:
+					if !ok {
+						return
+					}
+					if send(&ExecRequest{Resize: &TerminalSize{Width: size.Width, Height: size.Height}}) != nil {
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	for {
+		var res ExecResponse
+		err := stream.RecvMsg(&res)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fromGRPC(err, stream.Trailer())
+		}
+		if len(res.Stdout) > 0 && out != nil {
+			if _, err := out.Write(res.Stdout); err != nil {
+				return err
+			}
+		}
+		if len(res.Stderr) > 0 && errOut != nil {
+			if _, err := errOut.Write(res.Stderr); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// GetPodStatus retrieves the status of a pod by name from the provider.
+func (c *Client) GetPodStatus(ctx context.Context, namespace, name string) (*v1.PodStatus, error) {
+	var res PodStatusResponse
+	if err := c.invoke(ctx, "GetPodStatus", &PodKey{Namespace: namespace, Name: name}, &res); err != nil {
+		return nil, err
+	}
+	return res.Status, nil
+}
+
+// GetPods retrieves a list of all pods running on the provider.
+func (c *Client) GetPods(ctx context.Context) ([]*v1.Pod, error) {
+	var res PodsResponse
+	if err := c.invoke(ctx, "GetPods", &Empty{}, &res); err != nil {
+		return nil, err
+	}
+	return res.Pods, nil
+}
+
+// Capacity returns a resource list with the capacity constraints of the provider.
+// The last capacity reported by the plugin is returned if the plugin cannot be reached, so that the node's capacity isn't wiped by a single failed call.
+func (c *Client) Capacity(ctx context.Context) v1.ResourceList {
+	var res CapacityResponse
+	err := c.invoke(ctx, "Capacity", &Empty{}, &res)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		log.G(ctx).WithError(err).Error("Failed to get capacity from plugin")
+		return c.capacity
+	}
+	c.capacity = res.Capacity
+	return res.Capacity
+}
+
+// NodeConditions returns a list of conditions (Ready, OutOfDisk, etc), which is polled periodically to update the node status within Kubernetes.
+// If the plugin cannot be reached, the node is reported as not ready.
+func (c *Client) NodeConditions(ctx context.Context) []v1.NodeCondition {
+	var res NodeConditionsResponse
+	if err := c.invoke(ctx, "NodeConditions", &Empty{}, &res); err != nil {
+		log.G(ctx).WithError(err).Error("Failed to get node conditions from plugin")
+		now := metav1.Now()
+		return []v1.NodeCondition{
+			{
+				Type:               v1.NodeReady,
+				Status:             v1.ConditionUnknown,
+				LastHeartbeatTime:  now,
+				LastTransitionTime: now,
+				Reason:             "PluginUnreachable",
+				Message:            err.Error(),
+			},
+		}
+	}
+	return res.Conditions
+}
+
+// NodeAddresses returns a list of addresses for the node status within Kubernetes.
+func (c *Client) NodeAddresses(ctx context.Context) []v1.NodeAddress {
+	var res NodeAddressesResponse
+	if err := c.invoke(ctx, "NodeAddresses", &Empty{}, &res); err != nil {
+		log.G(ctx).WithError(err).Error("Failed to get node addresses from plugin")
+	}
+	return res.Addresses
+}
+
+// NodeDaemonEndpoints returns NodeDaemonEndpoints for the node status within Kubernetes.
+func (c *Client) NodeDaemonEndpoints(ctx context.Context) *v1.NodeDaemonEndpoints {
+	var res NodeDaemonEndpointsResponse
+	if err := c.invoke(ctx, "NodeDaemonEndpoints", &Empty{}, &res); err != nil {
+		log.G(ctx).WithError(err).Error("Failed to get node daemon endpoints from plugin")
+	}
+	if res.Endpoints == nil {
+		return &v1.NodeDaemonEndpoints{}
+	}
+	return res.Endpoints
+}
+
+// OperatingSystem returns the operating system the provider is for, as reported by the plugin upon connecting.
+func (c *Client) OperatingSystem() string {
+	return c.operatingSystem
+}
+
+// GetStatsSummary returns the stats summary reported by the plugin, if it implements providers.PodMetricsProvider.
+func (c *Client) GetStatsSummary(ctx context.Context) (*stats.Summary, error) {
+	if !c.capabilities.PodMetrics {
+		return nil, strongerrors.NotImplemented(pkgerrors.New("plugin does not support pod metrics"))
+	}
+	var res StatsSummaryResponse
+	if err := c.invoke(ctx, "GetStatsSummary", &Empty{}, &res); err != nil {
+		return nil, err
+	}
+	return res.Summary, nil
+}
+
+// RestartContainer restarts the specified container using the plugin, if it implements providers.ContainerRestarter.
+func (c *Client) RestartContainer(ctx context.Context, pod *v1.Pod, containerName string) error {
+	if !c.capabilities.ContainerRestart {
+		return strongerrors.NotImplemented(pkgerrors.New("plugin does not support restarting containers"))
+	}
+	return c.invoke(ctx, "RestartContainer", &RestartContainerRequest{Pod: pod, ContainerName: containerName}, &Empty{})
+}
+
+// UpdatePodVolumes refreshes the volumes of the specified pod using the plugin, if it implements providers.PodVolumesUpdater.
+func (c *Client) UpdatePodVolumes(ctx context.Context, pod *v1.Pod) error {
+	if !c.capabilities.PodVolumesUpdate {
+		return strongerrors.NotImplemented(pkgerrors.New("plugin does not support updating pod volumes"))
+	}
+	return c.invoke(ctx, "UpdatePodVolumes", &PodRequest{Pod: pod}, &Empty{})
+}
+
+// getStreamingURL calls the specified streaming method of the plugin, if it implements providers.StreamingProvider, and returns the URL it responds with.
+func (c *Client) getStreamingURL(ctx context.Context, method string, req *StreamingRequest) (*url.URL, error) {
+	if !c.capabilities.Streaming {
+		return nil, strongerrors.NotImplemented(pkgerrors.New("plugin does not support streaming sessions"))
+	}
+	var res StreamingResponse
+	if err := c.invoke(ctx, method, req, &res); err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(res.URL)
+	if err != nil {
+		return nil, pkgerrors.Wrapf(err, "plugin returned an invalid URL for %s", method)
+	}
+	return u, nil
+}
+
+// GetExec returns the URL serving the specified exec session, as returned by the plugin.
+func (c *Client) GetExec(ctx context.Context, namespace, podName, containerName string, cmd []string, opts providers.StreamOptions) (*url.URL, error) {
+	return c.getStreamingURL(ctx, "GetExec", &StreamingRequest{Namespace: namespace, PodName: podName, ContainerName: containerName, Command: cmd, Options: opts})
+}
+
+// GetAttach returns the URL serving the specified attach session, as returned by the plugin.
+func (c *Client) GetAttach(ctx context.Context, namespace, podName, containerName string, opts providers.StreamOptions) (*url.URL, error) {
+	return c.getStreamingURL(ctx, "GetAttach", &StreamingRequest{Namespace: namespace, PodName: podName, ContainerName: containerName, Options: opts})
+}
+
+// GetPortForward returns the URL serving the specified port-forward session, as returned by the plugin.
+func (c *Client) GetPortForward(ctx context.Context, namespace, podName string, ports []int32) (*url.URL, error) {
+	return c.getStreamingURL(ctx, "GetPortForward", &StreamingRequest{Namespace: namespace, PodName: podName, Ports: ports})
+}
+
+// SupportsNativeProbes returns whether the plugin runs liveness and readiness probes natively.
+func (c *Client) SupportsNativeProbes() bool {
+	return c.capabilities.NativeProbes
+}
+
+// ReportsExecExitStatus returns whether the plugin reports the exit status of the commands it runs.
+func (c *Client) ReportsExecExitStatus() bool {
+	return c.capabilities.ExecExitStatus
+}
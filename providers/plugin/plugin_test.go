@@ -0,0 +1,177 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cpuguy83/strongerrors"
+	pkgerrors "github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/remotecommand"
+
+	"github.com/virtual-kubelet/virtual-kubelet/providers"
+	"github.com/virtual-kubelet/virtual-kubelet/providers/mock"
+)
+
+const (
+	nodeName = "vk"
+	// throttledPodName is the name of the pod whose creation is throttled by the test provider.
+	throttledPodName = "throttled"
+)
+
+// testProvider is a mock provider that returns non-trivial logs, echoes the standard input of exec sessions, and throttles the creation of some pods.
+type testProvider struct {
+	*mock.MockProvider
+	logs string
+}
+
+func (p *testProvider) CreatePod(ctx context.Context, pod *v1.Pod) error {
+	if pod.Name == throttledPodName {
+		return providers.Throttled(pkgerrors.New("too many requests"), 5*time.Second)
+	}
+	return p.MockProvider.CreatePod(ctx, pod)
+}
+
+func (p *testProvider) ReportsExecExitStatus() bool {
+	return true
+}
+
+func (p *testProvider) GetContainerLogs(ctx context.Context, namespace, podName, containerName string, tail int) (string, error) {
+	return p.logs, nil
+}
+
+func (p *testProvider) ExecInContainer(name string, uid types.UID, container string, cmd []string, in io.Reader, out, err io.WriteCloser, tty bool, resize <-chan remotecommand.TerminalSize, timeout time.Duration) error {
+	io.WriteString(err, strings.Join(cmd, " "))
+	_, copyErr := io.Copy(out, in)
+	return copyErr
+}
+
+// nopCloser is a buffer implementing io.WriteCloser.
+type nopCloser struct {
+	bytes.Buffer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+// startPlugin serves a test provider as a plugin, and returns a client connected to it along with a function that stops the plugin.
+func startPlugin(t *testing.T) (*Client, func()) {
+	dir, err := ioutil.TempDir("", "vk-plugin")
+	require.NoError(t, err)
+	config := filepath.Join(dir, "config.json")
+	require.NoError(t, ioutil.WriteFile(config, []byte(`{"vk": {}}`), 0600))
+	mp, err := mock.NewMockProvider(config, nodeName, providers.OperatingSystemLinux, "10.0.0.1", 10250)
+	require.NoError(t, err)
+	p := &testProvider{MockProvider: mp, logs: strings.Repeat("log line\n", 10000)}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	socket := filepath.Join(dir, "plugin.sock")
+	served := make(chan error, 1)
+	go func() {
+		served <- Serve(ctx, p, socket)
+	}()
+
+	dialCtx, dialCancel := context.WithTimeout(ctx, 10*time.Second)
+	defer dialCancel()
+	c, err := Dial(dialCtx, socket)
+	require.NoError(t, err)
+
+	return c, func() {
+		c.Close()
+		cancel()
+		assert.NoError(t, <-served)
+		os.RemoveAll(dir)
+	}
+}
+
+// TestPlugin verifies that calls made to a plugin are correctly forwarded to the provider it serves.
+func TestPlugin(t *testing.T) {
+	c, stop := startPlugin(t)
+	defer stop()
+	ctx := context.Background()
+
+	assert.Equal(t, providers.OperatingSystemLinux, c.OperatingSystem())
+	assert.True(t, c.capabilities.PodMetrics)
+	assert.False(t, c.SupportsNativeProbes())
+	assert.True(t, c.ReportsExecExitStatus())
+
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pod-0"},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{{Name: "container-0", Image: "image-0"}},
+		},
+	}
+	require.NoError(t, c.CreatePod(ctx, pod))
+	res, err := c.GetPod(ctx, pod.Namespace, pod.Name)
+	require.NoError(t, err)
+	assert.Equal(t, pod.Spec, res.Spec)
+	pods, err := c.GetPods(ctx)
+	require.NoError(t, err)
+	assert.Len(t, pods, 1)
+	status, err := c.GetPodStatus(ctx, pod.Namespace, pod.Name)
+	require.NoError(t, err)
+	assert.Equal(t, v1.PodRunning, status.Phase)
+
+	// Errors are classified the same way on both sides.
+	_, err = c.GetPod(ctx, pod.Namespace, "missing")
+	assert.True(t, strongerrors.IsNotFound(err))
+	err = c.RestartContainer(ctx, pod, "container-0")
+	assert.True(t, strongerrors.IsNotImplemented(err))
+	err = c.UpdatePodVolumes(ctx, pod)
+	assert.True(t, strongerrors.IsNotImplemented(err))
+	assert.False(t, c.capabilities.Streaming)
+	_, err = c.GetExec(ctx, pod.Namespace, pod.Name, "container-0", []string{"sh"}, providers.StreamOptions{Stdout: true})
+	assert.True(t, strongerrors.IsNotImplemented(err))
+
+	// Throttling hints are forwarded.
+	err = c.CreatePod(ctx, &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: throttledPodName}})
+	assert.True(t, strongerrors.IsExhausted(err))
+	d, ok := providers.RetryAfter(err)
+	assert.True(t, ok)
+	assert.Equal(t, 5*time.Second, d)
+
+	capacity := c.Capacity(ctx)
+	assert.NotEmpty(t, capacity)
+	// The last capacity is reported when the plugin cannot be reached.
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	assert.Equal(t, capacity, c.Capacity(cancelled))
+	assert.NotEmpty(t, c.NodeConditions(ctx))
+	assert.Equal(t, int32(10250), c.NodeDaemonEndpoints(ctx).KubeletEndpoint.Port)
+	summary, err := c.GetStatsSummary(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, nodeName, summary.Node.NodeName)
+
+	require.NoError(t, c.DeletePod(ctx, pod))
+	_, err = c.GetPod(ctx, pod.Namespace, pod.Name)
+	assert.True(t, strongerrors.IsNotFound(err))
+}
+
+// TestPluginStreams verifies that container logs and exec sessions are streamed to and from the plugin.
+func TestPluginStreams(t *testing.T) {
+	c, stop := startPlugin(t)
+	defer stop()
+
+	// The logs are larger than a single chunk.
+	logs, err := c.GetContainerLogs(context.Background(), "default", "pod-0", "container-0", 0)
+	require.NoError(t, err)
+	assert.Equal(t, strings.Repeat("log line\n", 10000), logs)
+
+	var stdout, stderr nopCloser
+	err = c.ExecInContainer("default-pod-0", "uid-0", "container-0", []string{"cat", "-"}, strings.NewReader("hello\nworld\n"), &stdout, &stderr, false, nil, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, "hello\nworld\n", stdout.String())
+	assert.Equal(t, "cat -", stderr.String())
+}
@@ -0,0 +1,224 @@
+// Package plugin implements a gRPC protocol allowing providers to run out-of-process, as plugins developed and released independently of virtual-kubelet.
+//
+// The protocol mirrors the providers.Provider interface along with its optional interfaces, and streams container logs and exec sessions.
+// Plugins listen on a unix socket, which virtual-kubelet dials using Dial (see the "grpc" provider in providers/register).
+// Any existing providers.Provider can be served as a plugin using Serve.
+//
+// Messages are encoded as JSON (using the "application/grpc+json" content type), embedding Kubernetes API objects in their usual JSON representation.
+// This allows plugins to be written in any language that has a gRPC implementation without requiring protobuf definitions of the Kubernetes API.
+// The wire schema of the messages, the version handshake and the mapping of errors are documented in README.md.
+package plugin
+
+import (
+	"encoding/json"
+	"time"
+
+	"google.golang.org/grpc/encoding"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	stats "k8s.io/kubernetes/pkg/kubelet/apis/stats/v1alpha1"
+
+	"github.com/virtual-kubelet/virtual-kubelet/providers"
+)
+
+const (
+	// ProtocolVersion is the version of the protocol implemented by this package.
+	// It is exchanged during the handshake, and must be the same on both sides.
+	ProtocolVersion = "v1"
+
+	// ServiceName is the fully-qualified name of the gRPC service implemented by plugins.
+	ServiceName = "virtualkubelet.provider.v1.Provider"
+
+	// codecName is the name of the codec used to encode messages, which is used as the gRPC content subtype.
+	codecName = "json"
+
+	// retryAfterTrailer is the trailer set by plugins on responses whose error signals that the plugin is being throttled.
+	// Its value is the number of milliseconds to wait before issuing further requests.
+	retryAfterTrailer = "vk-retry-after-ms"
+
+	// logsChunkSize is the maximum size of the log chunks sent by plugins.
+	logsChunkSize = 32 * 1024
+)
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+// jsonCodec encodes gRPC messages as JSON.
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (jsonCodec) Name() string {
+	return codecName
+}
+
+// Empty is used for requests and responses carrying no data.
+type Empty struct{}
+
+// HandshakeRequest is sent by virtual-kubelet right after connecting to a plugin.
+type HandshakeRequest struct {
+	ProtocolVersion string `json:"protocolVersion"`
+}
+
+// HandshakeResponse describes the plugin.
+type HandshakeResponse struct {
+	ProtocolVersion string       `json:"protocolVersion"`
+	Capabilities    Capabilities `json:"capabilities"`
+}
+
+// Capabilities reports which optional provider interfaces a plugin implements.
+type Capabilities struct {
+	// PodMetrics is true if the plugin implements providers.PodMetricsProvider.
+	PodMetrics bool `json:"podMetrics,omitempty"`
+	// ContainerRestart is true if the plugin implements providers.ContainerRestarter.
+	ContainerRestart bool `json:"containerRestart,omitempty"`
+	// NativeProbes is true if the plugin implements providers.NativeProber and runs probes natively.
+	NativeProbes bool `json:"nativeProbes,omitempty"`
+	// ExecExitStatus is true if the plugin implements providers.ExecExitStatusReporter and reports the exit status of the commands it runs.
+	ExecExitStatus bool `json:"execExitStatus,omitempty"`
+	// PodVolumesUpdate is true if the plugin implements providers.PodVolumesUpdater.
+	PodVolumesUpdate bool `json:"podVolumesUpdate,omitempty"`
+	// Streaming is true if the plugin implements providers.StreamingProvider.
+	Streaming bool `json:"streaming,omitempty"`
+}
+
+// PodRequest is used by the CreatePod, UpdatePod, DeletePod and UpdatePodVolumes methods.
+type PodRequest struct {
+	Pod *v1.Pod `json:"pod"`
+}
+
+// PodKey identifies a pod.
+type PodKey struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+}
+
+// PodResponse is returned by the GetPod method.
+// Pod is nil if the pod is not known to the plugin.
+type PodResponse struct {
+	Pod *v1.Pod `json:"pod,omitempty"`
+}
+
+// PodStatusResponse is returned by the GetPodStatus method.
+// Status is nil if the pod is not known to the plugin.
+type PodStatusResponse struct {
+	Status *v1.PodStatus `json:"status,omitempty"`
+}
+
+// PodsResponse is returned by the GetPods method.
+type PodsResponse struct {
+	Pods []*v1.Pod `json:"pods"`
+}
+
+// LogsRequest is used by the GetContainerLogs method.
+type LogsRequest struct {
+	Namespace     string `json:"namespace"`
+	PodName       string `json:"podName"`
+	ContainerName string `json:"containerName"`
+	Tail          int    `json:"tail"`
+}
+
+// LogsChunk is streamed by plugins in response to a LogsRequest.
+type LogsChunk struct {
+	Data []byte `json:"data"`
+}
+
+// ExecRequest is streamed by virtual-kubelet to plugins during an exec session.
+// The first message of a session must have Start set, while subsequent messages carry standard input or terminal resize events.
+type ExecRequest struct {
+	Start      *ExecStart    `json:"start,omitempty"`
+	Stdin      []byte        `json:"stdin,omitempty"`
+	CloseStdin bool          `json:"closeStdin,omitempty"`
+	Resize     *TerminalSize `json:"resize,omitempty"`
+}
+
+// ExecStart describes the command to run in an exec session.
+type ExecStart struct {
+	Name      string        `json:"name"`
+	UID       types.UID     `json:"uid"`
+	Container string        `json:"container"`
+	Command   []string      `json:"command"`
+	Stdin     bool          `json:"stdin,omitempty"`
+	Stdout    bool          `json:"stdout,omitempty"`
+	Stderr    bool          `json:"stderr,omitempty"`
+	TTY       bool          `json:"tty,omitempty"`
+	Timeout   time.Duration `json:"timeout,omitempty"`
+}
+
+// TerminalSize is the size of the terminal attached to an exec session.
+type TerminalSize struct {
+	Width  uint16 `json:"width"`
+	Height uint16 `json:"height"`
+}
+
+// ExecResponse is streamed by plugins during an exec session, carrying the command's standard output and error.
+// The session ends when the plugin closes the stream, with an error if the command could not be run.
+type ExecResponse struct {
+	Stdout []byte `json:"stdout,omitempty"`
+	Stderr []byte `json:"stderr,omitempty"`
+}
+
+// CapacityResponse is returned by the Capacity method.
+type CapacityResponse struct {
+	Capacity v1.ResourceList `json:"capacity"`
+}
+
+// NodeConditionsResponse is returned by the NodeConditions method.
+type NodeConditionsResponse struct {
+	Conditions []v1.NodeCondition `json:"conditions"`
+}
+
+// NodeAddressesResponse is returned by the NodeAddresses method.
+type NodeAddressesResponse struct {
+	Addresses []v1.NodeAddress `json:"addresses"`
+}
+
+// NodeDaemonEndpointsResponse is returned by the NodeDaemonEndpoints method.
+type NodeDaemonEndpointsResponse struct {
+	Endpoints *v1.NodeDaemonEndpoints `json:"endpoints"`
+}
+
+// OperatingSystemResponse is returned by the OperatingSystem method.
+type OperatingSystemResponse struct {
+	OperatingSystem string `json:"operatingSystem"`
+}
+
+// StatsSummaryResponse is returned by the GetStatsSummary method.
+type StatsSummaryResponse struct {
+	Summary *stats.Summary `json:"summary"`
+}
+
+// RestartContainerRequest is used by the RestartContainer method.
+type RestartContainerRequest struct {
+	Pod           *v1.Pod `json:"pod"`
+	ContainerName string  `json:"containerName"`
+}
+
+// StreamingRequest is used by the GetExec, GetAttach and GetPortForward methods.
+// Command and Options are only used by GetExec and GetAttach, and Ports only by GetPortForward.
+type StreamingRequest struct {
+	Namespace     string                  `json:"namespace"`
+	PodName       string                  `json:"podName"`
+	ContainerName string                  `json:"containerName,omitempty"`
+	Command       []string                `json:"command,omitempty"`
+	Options       providers.StreamOptions `json:"options"`
+	Ports         []int32                 `json:"ports,omitempty"`
+}
+
+// StreamingResponse is returned by the GetExec, GetAttach and GetPortForward methods.
+// URL must be reachable by virtual-kubelet, which proxies streaming sessions to it.
+type StreamingResponse struct {
+	URL string `json:"url"`
+}
+
+// methodName returns the full name of the specified method of the plugin service, as used by gRPC.
+func methodName(method string) string {
+	return "/" + ServiceName + "/" + method
+}
@@ -0,0 +1,402 @@
+package plugin
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/cpuguy83/strongerrors"
+	errstatus "github.com/cpuguy83/strongerrors/status"
+	pkgerrors "github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"k8s.io/client-go/tools/remotecommand"
+
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+	"github.com/virtual-kubelet/virtual-kubelet/providers"
+)
+
+// Server exposes a providers.Provider through the plugin protocol.
+type Server struct {
+	provider providers.Provider
+}
+
+// NewServer returns a gRPC server serving the specified provider as a plugin.
+func NewServer(p providers.Provider) *grpc.Server {
+	s := grpc.NewServer()
+	s.RegisterService(&serviceDesc, &Server{provider: p})
+	return s
+}
+
+// Serve serves the specified provider as a plugin on a unix socket at the specified path, until the specified context is cancelled.
+// Any existing file at the specified path is removed beforehand.
+func Serve(ctx context.Context, p providers.Provider, socketPath string) error {
+	if err := os.Remove(socketPath); err != nil && !os.IsNotExist(err) {
+		return pkgerrors.Wrapf(err, "failed to remove existing socket %q", socketPath)
+	}
+	l, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return pkgerrors.Wrapf(err, "failed to listen on %q", socketPath)
+	}
+
+	s := NewServer(p)
+	go func() {
+		<-ctx.Done()
+		s.GracefulStop()
+	}()
+	log.G(ctx).WithField("socket", socketPath).Info("Serving provider plugin")
+	return s.Serve(l)
+}
+
+// toGRPC converts the specified error returned by the provider to a gRPC status error.
+// If the error signals that the provider is being throttled, the duration to wait before retrying is sent as a trailer using the specified function.
+func toGRPC(err error, setTrailer func(metadata.MD)) error {
+	if err == nil {
+		return nil
+	}
+	if d, ok := providers.RetryAfter(err); ok {
+		setTrailer(metadata.Pairs(retryAfterTrailer, strconv.FormatInt(int64(d/1e6), 10)))
+	}
+	return errstatus.ToGRPC(err)
+}
+
+// notImplemented returns the error returned for optional methods not implemented by the served provider.
+func notImplemented(method string) error {
+	return errstatus.ToGRPC(strongerrors.NotImplemented(pkgerrors.Errorf("provider does not implement %s", method)))
+}
+
+func (s *Server) handshake(ctx context.Context, req *HandshakeRequest) (*HandshakeResponse, error) {
+	if req.ProtocolVersion != ProtocolVersion {
+		return nil, errstatus.ToGRPC(strongerrors.InvalidArgument(pkgerrors.Errorf("unsupported protocol version %q (want %q)", req.ProtocolVersion, ProtocolVersion)))
+	}
+	res := &HandshakeResponse{ProtocolVersion: ProtocolVersion}
+	_, res.Capabilities.PodMetrics = s.provider.(providers.PodMetricsProvider)
+	_, res.Capabilities.ContainerRestart = s.provider.(providers.ContainerRestarter)
+	_, res.Capabilities.PodVolumesUpdate = s.provider.(providers.PodVolumesUpdater)
+	_, res.Capabilities.Streaming = s.provider.(providers.StreamingProvider)
+	if np, ok := s.provider.(providers.NativeProber); ok {
+		res.Capabilities.NativeProbes = np.SupportsNativeProbes()
+	}
+	if r, ok := s.provider.(providers.ExecExitStatusReporter); ok {
+		res.Capabilities.ExecExitStatus = r.ReportsExecExitStatus()
+	}
+	return res, nil
+}
+
+func (s *Server) getContainerLogs(req *LogsRequest, stream grpc.ServerStream) error {
+	logs, err := s.provider.GetContainerLogs(stream.Context(), req.Namespace, req.PodName, req.ContainerName, req.Tail)
+	if err != nil {
+		return toGRPC(err, stream.SetTrailer)
+	}
+	data := []byte(logs)
+	for len(data) > 0 {
+		n := len(data)
+		if n > logsChunkSize {
+			n = logsChunkSize
+		}
+		if err := stream.SendMsg(&LogsChunk{Data: data[:n]}); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+// execOutput is an io.WriteCloser sending everything written to it to the client of an exec session.
+type execOutput struct {
+	mu     *sync.Mutex
+	stream grpc.ServerStream
+	stderr bool
+}
+
+func (o *execOutput) Write(p []byte) (int, error) {
+	// Messages are marshalled synchronously, so there is no need to copy p.
+	msg := &ExecResponse{Stdout: p}
+	if o.stderr {
+		msg = &ExecResponse{Stderr: p}
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if err := o.stream.SendMsg(msg); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (o *execOutput) Close() error {
+	return nil
+}
+
+func (s *Server) execInContainer(stream grpc.ServerStream) error {
+	var req ExecRequest
+	if err := stream.RecvMsg(&req); err != nil {
+		return err
+	}
+	if req.Start == nil {
+		return errstatus.ToGRPC(strongerrors.InvalidArgument(pkgerrors.New("the first message of an exec session must describe the command to run")))
+	}
+	start := req.Start
+
+	var (
+		mu     sync.Mutex
+		in     io.Reader
+		out    io.WriteCloser
+		errOut io.WriteCloser
+		resize = make(chan remotecommand.TerminalSize)
+		done   = make(chan struct{})
+	)
+	defer close(done)
+	if start.Stdout {
+		out = &execOutput{mu: &mu, stream: stream}
+	}
+	if start.Stderr {
+		errOut = &execOutput{mu: &mu, stream: stream, stderr: true}
+	}
+	inR, inW := io.Pipe()
+	if start.Stdin {
+		in = inR
+	}
+
+	// Forward standard input and resize events until the client is done sending.
+	go func() {
+		defer close(resize)
+		for {
+			var req ExecRequest
+			if err := stream.RecvMsg(&req); err != nil {
+				inW.CloseWithError(err)
+				return
+			}
+			if len(req.Stdin) > 0 {
+				if _, err := inW.Write(req.Stdin); err != nil {
+					return
+				}
+			}
+			if req.CloseStdin {
+				inW.Close()
+			}
+			if req.Resize != nil {
+				select {
+				case resize <- remotecommand.TerminalSize{Width: req.Resize.Width, Height: req.Resize.Height}:
+				case <-done:
+					return
+				}
+			}
+		}
+	}()
+
+	err := s.provider.ExecInContainer(start.Name, start.UID, start.Container, start.Command, in, out, errOut, start.TTY, resize, start.Timeout)
+	inR.Close()
+	return toGRPC(err, stream.SetTrailer)
+}
+
+// unaryHandler returns a handler for the specified gRPC method, decoding requests of the type returned by "newRequest" and passing them to "call".
+func unaryHandler(method string, newRequest func() interface{}, call func(s *Server, ctx context.Context, req interface{}) (interface{}, error)) func(interface{}, context.Context, func(interface{}) error, grpc.UnaryServerInterceptor) (interface{}, error) {
+	return func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+		req := newRequest()
+		if err := dec(req); err != nil {
+			return nil, err
+		}
+		f := func(ctx context.Context, req interface{}) (interface{}, error) {
+			res, err := call(srv.(*Server), ctx, req)
+			if err != nil {
+				return nil, toGRPC(err, func(md metadata.MD) { grpc.SetTrailer(ctx, md) })
+			}
+			return res, nil
+		}
+		if interceptor == nil {
+			return f(ctx, req)
+		}
+		return interceptor(ctx, req, &grpc.UnaryServerInfo{Server: srv, FullMethod: methodName(method)}, f)
+	}
+}
+
+func newEmpty() interface{}      { return &Empty{} }
+func newPodRequest() interface{} { return &PodRequest{} }
+func newPodKey() interface{}     { return &PodKey{} }
+
+func newStreamingRequest() interface{} { return &StreamingRequest{} }
+
+// newStreamingResponse returns the response to a GetExec, GetAttach or GetPortForward request.
+func newStreamingResponse(u *url.URL, err error) (interface{}, error) {
+	if err != nil {
+		return nil, err
+	}
+	return &StreamingResponse{URL: u.String()}, nil
+}
+
+// serviceDesc describes the plugin service.
+// It is written by hand, as messages are encoded as JSON rather than protobuf.
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*interface{})(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Handshake",
+			Handler: unaryHandler("Handshake", func() interface{} { return &HandshakeRequest{} }, func(s *Server, ctx context.Context, req interface{}) (interface{}, error) {
+				return s.handshake(ctx, req.(*HandshakeRequest))
+			}),
+		},
+		{
+			MethodName: "CreatePod",
+			Handler: unaryHandler("CreatePod", newPodRequest, func(s *Server, ctx context.Context, req interface{}) (interface{}, error) {
+				return &Empty{}, s.provider.CreatePod(ctx, req.(*PodRequest).Pod)
+			}),
+		},
+		{
+			MethodName: "UpdatePod",
+			Handler: unaryHandler("UpdatePod", newPodRequest, func(s *Server, ctx context.Context, req interface{}) (interface{}, error) {
+				return &Empty{}, s.provider.UpdatePod(ctx, req.(*PodRequest).Pod)
+			}),
+		},
+		{
+			MethodName: "DeletePod",
+			Handler: unaryHandler("DeletePod", newPodRequest, func(s *Server, ctx context.Context, req interface{}) (interface{}, error) {
+				return &Empty{}, s.provider.DeletePod(ctx, req.(*PodRequest).Pod)
+			}),
+		},
+		{
+			MethodName: "GetPod",
+			Handler: unaryHandler("GetPod", newPodKey, func(s *Server, ctx context.Context, req interface{}) (interface{}, error) {
+				key := req.(*PodKey)
+				pod, err := s.provider.GetPod(ctx, key.Namespace, key.Name)
+				return &PodResponse{Pod: pod}, err
+			}),
+		},
+		{
+			MethodName: "GetPodStatus",
+			Handler: unaryHandler("GetPodStatus", newPodKey, func(s *Server, ctx context.Context, req interface{}) (interface{}, error) {
+				key := req.(*PodKey)
+				status, err := s.provider.GetPodStatus(ctx, key.Namespace, key.Name)
+				return &PodStatusResponse{Status: status}, err
+			}),
+		},
+		{
+			MethodName: "GetPods",
+			Handler: unaryHandler("GetPods", newEmpty, func(s *Server, ctx context.Context, req interface{}) (interface{}, error) {
+				pods, err := s.provider.GetPods(ctx)
+				return &PodsResponse{Pods: pods}, err
+			}),
+		},
+		{
+			MethodName: "Capacity",
+			Handler: unaryHandler("Capacity", newEmpty, func(s *Server, ctx context.Context, req interface{}) (interface{}, error) {
+				return &CapacityResponse{Capacity: s.provider.Capacity(ctx)}, nil
+			}),
+		},
+		{
+			MethodName: "NodeConditions",
+			Handler: unaryHandler("NodeConditions", newEmpty, func(s *Server, ctx context.Context, req interface{}) (interface{}, error) {
+				return &NodeConditionsResponse{Conditions: s.provider.NodeConditions(ctx)}, nil
+			}),
+		},
+		{
+			MethodName: "NodeAddresses",
+			Handler: unaryHandler("NodeAddresses", newEmpty, func(s *Server, ctx context.Context, req interface{}) (interface{}, error) {
+				return &NodeAddressesResponse{Addresses: s.provider.NodeAddresses(ctx)}, nil
+			}),
+		},
+		{
+			MethodName: "NodeDaemonEndpoints",
+			Handler: unaryHandler("NodeDaemonEndpoints", newEmpty, func(s *Server, ctx context.Context, req interface{}) (interface{}, error) {
+				return &NodeDaemonEndpointsResponse{Endpoints: s.provider.NodeDaemonEndpoints(ctx)}, nil
+			}),
+		},
+		{
+			MethodName: "OperatingSystem",
+			Handler: unaryHandler("OperatingSystem", newEmpty, func(s *Server, ctx context.Context, req interface{}) (interface{}, error) {
+				return &OperatingSystemResponse{OperatingSystem: s.provider.OperatingSystem()}, nil
+			}),
+		},
+		{
+			MethodName: "GetStatsSummary",
+			Handler: unaryHandler("GetStatsSummary", newEmpty, func(s *Server, ctx context.Context, req interface{}) (interface{}, error) {
+				mp, ok := s.provider.(providers.PodMetricsProvider)
+				if !ok {
+					return nil, notImplemented("GetStatsSummary")
+				}
+				summary, err := mp.GetStatsSummary(ctx)
+				return &StatsSummaryResponse{Summary: summary}, err
+			}),
+		},
+		{
+			MethodName: "RestartContainer",
+			Handler: unaryHandler("RestartContainer", func() interface{} { return &RestartContainerRequest{} }, func(s *Server, ctx context.Context, req interface{}) (interface{}, error) {
+				cr, ok := s.provider.(providers.ContainerRestarter)
+				if !ok {
+					return nil, notImplemented("RestartContainer")
+				}
+				r := req.(*RestartContainerRequest)
+				return &Empty{}, cr.RestartContainer(ctx, r.Pod, r.ContainerName)
+			}),
+		},
+		{
+			MethodName: "UpdatePodVolumes",
+			Handler: unaryHandler("UpdatePodVolumes", newPodRequest, func(s *Server, ctx context.Context, req interface{}) (interface{}, error) {
+				vu, ok := s.provider.(providers.PodVolumesUpdater)
+				if !ok {
+					return nil, notImplemented("UpdatePodVolumes")
+				}
+				return &Empty{}, vu.UpdatePodVolumes(ctx, req.(*PodRequest).Pod)
+			}),
+		},
+		{
+			MethodName: "GetExec",
+			Handler: unaryHandler("GetExec", newStreamingRequest, func(s *Server, ctx context.Context, req interface{}) (interface{}, error) {
+				sp, ok := s.provider.(providers.StreamingProvider)
+				if !ok {
+					return nil, notImplemented("GetExec")
+				}
+				r := req.(*StreamingRequest)
+				return newStreamingResponse(sp.GetExec(ctx, r.Namespace, r.PodName, r.ContainerName, r.Command, r.Options))
+			}),
+		},
+		{
+			MethodName: "GetAttach",
+			Handler: unaryHandler("GetAttach", newStreamingRequest, func(s *Server, ctx context.Context, req interface{}) (interface{}, error) {
+				sp, ok := s.provider.(providers.StreamingProvider)
+				if !ok {
+					return nil, notImplemented("GetAttach")
+				}
+				r := req.(*StreamingRequest)
+				return newStreamingResponse(sp.GetAttach(ctx, r.Namespace, r.PodName, r.ContainerName, r.Options))
+			}),
+		},
+		{
+			MethodName: "GetPortForward",
+			Handler: unaryHandler("GetPortForward", newStreamingRequest, func(s *Server, ctx context.Context, req interface{}) (interface{}, error) {
+				sp, ok := s.provider.(providers.StreamingProvider)
+				if !ok {
+					return nil, notImplemented("GetPortForward")
+				}
+				r := req.(*StreamingRequest)
+				return newStreamingResponse(sp.GetPortForward(ctx, r.Namespace, r.PodName, r.Ports))
+			}),
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName: "GetContainerLogs",
+			Handler: func(srv interface{}, stream grpc.ServerStream) error {
+				var req LogsRequest
+				if err := stream.RecvMsg(&req); err != nil {
+					return err
+				}
+				return srv.(*Server).getContainerLogs(&req, stream)
+			},
+			ServerStreams: true,
+		},
+		{
+			StreamName: "ExecInContainer",
+			Handler: func(srv interface{}, stream grpc.ServerStream) error {
+				return srv.(*Server).execInContainer(stream)
+			},
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "virtualkubelet/provider/v1",
+}
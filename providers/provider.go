@@ -3,6 +3,7 @@ package providers
 import (
 	"context"
 	"io"
+	"net/url"
 	"time"
 
 	"k8s.io/api/core/v1"
@@ -30,6 +31,7 @@ type Provider interface {
 
 	// ExecInContainer executes a command in a container in the pod, copying data
 	// between in/out/err and the container's stdin/stdout/stderr.
+	// Exec probes require a real implementation reporting the exit status of commands (see ExecExitStatusReporter).
 	ExecInContainer(name string, uid types.UID, container string, cmd []string, in io.Reader, out, err io.WriteCloser, tty bool, resize <-chan remotecommand.TerminalSize, timeout time.Duration) error
 
 	// GetPodStatus retrieves the status of a pod by name from the provider.
@@ -61,3 +63,51 @@ type Provider interface {
 type PodMetricsProvider interface {
 	GetStatsSummary(context.Context) (*stats.Summary, error)
 }
+
+// ContainerRestarter is an optional interface that providers can implement to restart containers that fail their liveness probe.
+// Liveness probes are run by virtual-kubelet for providers that don't run probes natively (see NativeProber).
+type ContainerRestarter interface {
+	// RestartContainer restarts the specified container in the specified pod.
+	RestartContainer(ctx context.Context, pod *v1.Pod, containerName string) error
+}
+
+// NativeProber is an optional interface that providers can implement to indicate whether they run liveness and readiness probes natively.
+// virtual-kubelet does not run probes against pods managed by providers that do.
+type NativeProber interface {
+	SupportsNativeProbes() bool
+}
+
+// ExecExitStatusReporter is an optional interface that providers can implement to indicate whether ExecInContainer actually runs commands and reports their exit status, i.e. returns nil only for commands exiting with a zero status.
+// Exec probes run by virtual-kubelet (see NativeProber) fail for providers that don't, as they would otherwise always succeed.
+type ExecExitStatusReporter interface {
+	ReportsExecExitStatus() bool
+}
+
+// PodVolumesUpdater is an optional interface that providers can implement to refresh the volumes of running pods when the Secrets and ConfigMaps they reference change, or when their projected service account tokens are about to expire.
+// virtual-kubelet records an event on pods managed by providers that don't implement it, as their volumes keep the contents they had when the pods were created.
+type PodVolumesUpdater interface {
+	// UpdatePodVolumes refreshes the contents of the Secret, ConfigMap and Projected volumes of the specified pod (see manager.ResourceManager.ResolveVolumes).
+	// Updates should be atomic, so that containers never observe a mix of old and new files.
+	UpdatePodVolumes(ctx context.Context, pod *v1.Pod) error
+}
+
+// StreamOptions describes the streams attached to an exec or attach session.
+type StreamOptions struct {
+	Stdin  bool
+	Stdout bool
+	Stderr bool
+	TTY    bool
+}
+
+// StreamingProvider is an optional interface that providers can implement to serve exec, attach and port-forward sessions from a streaming server of their own, such as the one of a CRI runtime.
+// virtual-kubelet proxies the corresponding requests it receives to the URLs returned by these methods, so that sessions are served with the same protocols, terminal handling and resizing as by the kubelet.
+// Exec requests are served using ExecInContainer when GetExec returns a "not implemented" error, while attach and port-forward requests fail.
+type StreamingProvider interface {
+	// GetExec returns the URL serving an exec session running the specified command in the specified container.
+	GetExec(ctx context.Context, namespace, podName, containerName string, cmd []string, opts StreamOptions) (*url.URL, error)
+	// GetAttach returns the URL serving an attach session to the specified container.
+	GetAttach(ctx context.Context, namespace, podName, containerName string, opts StreamOptions) (*url.URL, error)
+	// GetPortForward returns the URL serving a port-forward session to the specified ports of the specified pod.
+	// If ports is empty, the ports are specified by the client for each stream.
+	GetPortForward(ctx context.Context, namespace, podName string, ports []int32) (*url.URL, error)
+}
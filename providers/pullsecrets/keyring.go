@@ -0,0 +1,169 @@
+package pullsecrets
+
+import (
+	"net"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// defaultRegistryHost is the host of the registry images are pulled from when their name doesn't specify one.
+	defaultRegistryHost = "index.docker.io"
+)
+
+// Keyring holds registry credentials, and looks up those to use for a given image in the same way the kubelet's keyring does.
+// https://github.com/kubernetes/kubernetes/blob/v1.13.1/pkg/credentialprovider/keyring.go
+type Keyring struct {
+	// creds holds the credentials for each registry, indexed by the host and path they apply to.
+	creds map[string][]Credential
+	// index holds the keys of creds in reverse order, so that more specific paths are matched first.
+	index []string
+	// all holds all the credentials, in the order they were added.
+	all []Credential
+}
+
+// NewKeyring returns an empty keyring.
+func NewKeyring() *Keyring {
+	return &Keyring{creds: make(map[string][]Credential)}
+}
+
+// Add adds the specified credentials to the keyring.
+// Credentials whose server can't be parsed are ignored when looking up credentials, but are still returned by Credentials.
+func (k *Keyring) Add(creds ...Credential) {
+	for _, cred := range creds {
+		k.all = append(k.all, cred)
+		key, ok := keyFor(cred.Server)
+		if !ok {
+			continue
+		}
+		if _, exists := k.creds[key]; !exists {
+			k.index = append(k.index, key)
+		}
+		k.creds[key] = append(k.creds[key], cred)
+	}
+	// For example, the credentials for "quay.io/coreos" must be tried before those for "quay.io" when pulling "quay.io/coreos/etcd".
+	sort.Sort(sort.Reverse(sort.StringSlice(k.index)))
+}
+
+// Credentials returns all the credentials in the keyring, in the order they were added.
+func (k *Keyring) Credentials() []Credential {
+	return k.all
+}
+
+// Lookup returns the credentials that apply to the specified image, the most specific ones first.
+// The image may include a tag or a digest.
+// Credentials for the default registry ("index.docker.io") apply to images whose name doesn't specify a registry, and to those pulled from "docker.io".
+func (k *Keyring) Lookup(image string) []Credential {
+	repo := repository(image)
+	var res []Credential
+	for _, key := range k.index {
+		if urlsMatchStr(key, repo) {
+			res = append(res, k.creds[key]...)
+		}
+	}
+	if len(res) > 0 {
+		return res
+	}
+	if isDefaultRegistryMatch(repo) {
+		return k.creds[defaultRegistryHost]
+	}
+	return nil
+}
+
+// keyFor returns the key under which the credentials for the specified server are indexed.
+// As the docker client does, it allows exact matches on "host/path" as well as matches on the host only, and considers "/v1/" and "/v2/" paths to be equivalent to the host.
+func keyFor(server string) (string, bool) {
+	value := server
+	if !strings.HasPrefix(value, "https://") && !strings.HasPrefix(value, "http://") {
+		value = "https://" + value
+	}
+	parsed, err := url.Parse(value)
+	if err != nil {
+		return "", false
+	}
+	effectivePath := parsed.Path
+	if strings.HasPrefix(effectivePath, "/v2/") || strings.HasPrefix(effectivePath, "/v1/") {
+		effectivePath = effectivePath[3:]
+	}
+	if len(effectivePath) > 0 && effectivePath != "/" {
+		return parsed.Host + effectivePath, true
+	}
+	return parsed.Host, true
+}
+
+// repository returns the specified image without its tag or digest.
+func repository(image string) string {
+	if idx := strings.Index(image, "@"); idx >= 0 {
+		image = image[:idx]
+	}
+	// A colon after the last slash separates the tag, whereas one before it separates the port of the registry.
+	if idx := strings.LastIndex(image, ":"); idx > strings.LastIndex(image, "/") {
+		image = image[:idx]
+	}
+	return image
+}
+
+// isDefaultRegistryMatch returns whether the specified repository is hosted on the default registry.
+// As docker does, the first part of the repository is considered to be a registry only if it contains a "." (domain separator) or a ":" (port separator).
+func isDefaultRegistryMatch(repo string) bool {
+	parts := strings.SplitN(repo, "/", 2)
+	if len(parts[0]) == 0 {
+		return false
+	}
+	if len(parts) == 1 {
+		// An official image, such as "ubuntu".
+		return true
+	}
+	if parts[0] == "docker.io" || parts[0] == defaultRegistryHost {
+		return true
+	}
+	return !strings.ContainsAny(parts[0], ".:")
+}
+
+// parseSchemelessURL parses the specified URL, which has no scheme.
+func parseSchemelessURL(schemelessURL string) (*url.URL, error) {
+	parsed, err := url.Parse("https://" + schemelessURL)
+	if err != nil {
+		return nil, err
+	}
+	parsed.Scheme = ""
+	return parsed, nil
+}
+
+// splitURL returns the parts of the host of the specified URL, and its port.
+func splitURL(u *url.URL) ([]string, string) {
+	host, port, err := net.SplitHostPort(u.Host)
+	if err != nil {
+		host, port = u.Host, ""
+	}
+	return strings.Split(host, "."), port
+}
+
+// urlsMatchStr returns whether the specified target matches the specified glob.
+// Each part of the host of the glob may contain wildcards (e.g. "*.azurecr.io"), and its port and path (if any) must match those of the target exactly and as a prefix respectively.
+func urlsMatchStr(glob, target string) bool {
+	globURL, err := parseSchemelessURL(glob)
+	if err != nil {
+		return false
+	}
+	targetURL, err := parseSchemelessURL(target)
+	if err != nil {
+		return false
+	}
+	globParts, globPort := splitURL(globURL)
+	targetParts, targetPort := splitURL(targetURL)
+	if globPort != targetPort || len(globParts) != len(targetParts) {
+		return false
+	}
+	if !strings.HasPrefix(targetURL.Path, globURL.Path) {
+		return false
+	}
+	for idx, globPart := range globParts {
+		if matched, err := filepath.Match(globPart, targetParts[idx]); err != nil || !matched {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,61 @@
+package pullsecrets
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestKeyringLookup verifies that images are matched against registries in the same way as in the kubelet's keyring.
+func TestKeyringLookup(t *testing.T) {
+	k := NewKeyring()
+	k.Add(
+		Credential{Server: "https://index.docker.io/v1/", Username: "docker"},
+		Credential{Server: "quay.io", Username: "quay"},
+		Credential{Server: "quay.io/coreos", Username: "coreos"},
+		Credential{Server: "*.azurecr.io", Username: "azure"},
+		Credential{Server: "registry.example.com:5000", Username: "example"},
+		Credential{Server: "http://insecure.example.com/v2/", Username: "insecure"},
+	)
+
+	tests := []struct {
+		image    string
+		expected []string
+	}{
+		{"busybox", []string{"docker"}},
+		{"busybox:1.29", []string{"docker"}},
+		{"library/busybox", []string{"docker"}},
+		{"docker.io/library/busybox:latest", []string{"docker"}},
+		{"index.docker.io/user/app", []string{"docker"}},
+		{"quay.io/prometheus/prometheus", []string{"quay"}},
+		// More specific paths are matched first.
+		{"quay.io/coreos/etcd:v3.3.10", []string{"coreos", "quay"}},
+		{"myregistry.azurecr.io/app@sha256:0123456789abcdef", []string{"azure"}},
+		// Wildcards only match a single part of the host.
+		{"a.b.azurecr.io/app", nil},
+		{"registry.example.com:5000/app:1.0", []string{"example"}},
+		// Ports must match exactly.
+		{"registry.example.com/app", nil},
+		{"registry.example.com:5001/app", nil},
+		{"insecure.example.com/app", []string{"insecure"}},
+		{"gcr.io/google-containers/pause", nil},
+		{"localhost:5000/app", nil},
+	}
+	for _, test := range tests {
+		var actual []string
+		for _, cred := range k.Lookup(test.image) {
+			actual = append(actual, cred.Username)
+		}
+		assert.Equal(t, test.expected, actual, "image: %q", test.image)
+	}
+	assert.Len(t, k.Credentials(), 6)
+}
+
+// TestRepository verifies that tags and digests are stripped from image names, but not ports.
+func TestRepository(t *testing.T) {
+	assert.Equal(t, "busybox", repository("busybox"))
+	assert.Equal(t, "busybox", repository("busybox:1.29"))
+	assert.Equal(t, "localhost:5000/app", repository("localhost:5000/app"))
+	assert.Equal(t, "localhost:5000/app", repository("localhost:5000/app:1.0"))
+	assert.Equal(t, "localhost:5000/app", repository("localhost:5000/app:1.0@sha256:0123456789abcdef"))
+}
@@ -0,0 +1,181 @@
+// Package pullsecrets resolves the image pull secrets of pods into credentials for the registries their images are pulled from.
+//
+// The secrets referenced by the ".spec.imagePullSecrets" field of a pod and by the ".imagePullSecrets" field of its service account are taken into account.
+// The resulting keyring matches images against registries in the same way the kubelet's keyring does, so that providers pulling images themselves can pick the right credentials for each image, while providers handing registry credentials over to their backend can simply list them all.
+package pullsecrets
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+
+	"github.com/virtual-kubelet/virtual-kubelet/manager"
+)
+
+// ResourceGetter provides access to the secrets and service accounts referenced by pods.
+// It is implemented by "manager".ResourceManager.
+type ResourceGetter interface {
+	// GetSecret returns the specified secret.
+	GetSecret(name, namespace string) (*v1.Secret, error)
+	// GetServiceAccount returns the specified service account.
+	GetServiceAccount(name, namespace string) (*v1.ServiceAccount, error)
+}
+
+// AuthConfig is an entry of a ".dockercfg" file or of the "auths" section of a ".dockerconfigjson" file.
+type AuthConfig struct {
+	Username      string `json:"username,omitempty"`
+	Password      string `json:"password,omitempty"`
+	Auth          string `json:"auth,omitempty"`
+	Email         string `json:"email,omitempty"`
+	ServerAddress string `json:"serveraddress,omitempty"`
+	IdentityToken string `json:"identitytoken,omitempty"`
+	RegistryToken string `json:"registrytoken,omitempty"`
+}
+
+// Credential holds the credentials to use when pulling images from a registry.
+type Credential struct {
+	// Server is the registry the credential applies to, as specified in the pull secret (e.g. "quay.io", "https://index.docker.io/v1/" or "*.azurecr.io").
+	Server string
+	// Username and Password are the credentials to authenticate with.
+	// They are decoded from the "auth" field of the pull secret if not specified otherwise.
+	Username string
+	Password string
+	// Email is the email address associated with the credential, if any.
+	Email string
+	// IdentityToken is used to authenticate the user and get an access token for the registry, if any.
+	IdentityToken string
+	// RegistryToken is a bearer token to be sent to the registry, if any.
+	RegistryToken string
+}
+
+// ForPod returns a keyring holding the credentials in the image pull secrets of the specified pod and of its service account.
+// Service accounts that don't exist are ignored, whereas image pull secrets that don't exist or that can't be parsed make ForPod fail.
+func ForPod(rm ResourceGetter, pod *v1.Pod) (*Keyring, error) {
+	names, err := secretNames(rm, pod)
+	if err != nil {
+		return nil, err
+	}
+	keyring := NewKeyring()
+	for _, name := range names {
+		secret, err := rm.GetSecret(name, pod.Namespace)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get image pull secret %q: %v", name, err)
+		}
+		if secret == nil {
+			return nil, fmt.Errorf("error getting image pull secret %q", name)
+		}
+		creds, err := ParseSecret(secret)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse image pull secret %q: %v", name, err)
+		}
+		keyring.Add(creds...)
+	}
+	return keyring, nil
+}
+
+// secretNames returns the names of the image pull secrets referenced by the specified pod, followed by those referenced by its service account only.
+func secretNames(rm ResourceGetter, pod *v1.Pod) ([]string, error) {
+	var res []string
+	seen := make(map[string]bool)
+	add := func(refs []v1.LocalObjectReference) {
+		for _, ref := range refs {
+			if ref.Name != "" && !seen[ref.Name] {
+				seen[ref.Name] = true
+				res = append(res, ref.Name)
+			}
+		}
+	}
+	add(pod.Spec.ImagePullSecrets)
+	if pod.Spec.ServiceAccountName != "" {
+		sa, err := rm.GetServiceAccount(pod.Spec.ServiceAccountName, pod.Namespace)
+		switch {
+		case errors.IsNotFound(err), manager.IsNoLister(err):
+			// Only the image pull secrets of the pod itself are taken into account when its service account can't be accessed.
+		case err != nil:
+			return nil, fmt.Errorf("failed to get service account %q: %v", pod.Spec.ServiceAccountName, err)
+		default:
+			add(sa.ImagePullSecrets)
+		}
+	}
+	return res, nil
+}
+
+// ParseSecret returns the credentials held by the specified secret, which must be of the "kubernetes.io/dockercfg" or "kubernetes.io/dockerconfigjson" type.
+// The credentials are sorted by server.
+func ParseSecret(secret *v1.Secret) ([]Credential, error) {
+	var authConfigs map[string]AuthConfig
+	switch secret.Type {
+	case v1.SecretTypeDockercfg:
+		repoData, ok := secret.Data[v1.DockerConfigKey]
+		if !ok {
+			return nil, fmt.Errorf("no dockercfg present in secret")
+		}
+		if err := json.Unmarshal(repoData, &authConfigs); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal dockercfg: %v", err)
+		}
+	case v1.SecretTypeDockerConfigJson:
+		repoData, ok := secret.Data[v1.DockerConfigJsonKey]
+		if !ok {
+			return nil, fmt.Errorf("no dockerconfigjson present in secret")
+		}
+		var config map[string]map[string]AuthConfig
+		if err := json.Unmarshal(repoData, &config); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal dockerconfigjson: %v", err)
+		}
+		if authConfigs, ok = config["auths"]; !ok {
+			return nil, fmt.Errorf("malformed dockerconfigjson in secret")
+		}
+	default:
+		return nil, fmt.Errorf("image pull secret type is not one of kubernetes.io/dockercfg or kubernetes.io/dockerconfigjson")
+	}
+
+	res := make([]Credential, 0, len(authConfigs))
+	for _, server := range sortedServers(authConfigs) {
+		cred, err := makeCredential(server, authConfigs[server])
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, cred)
+	}
+	return res, nil
+}
+
+// makeCredential returns the credential described by the specified entry of a ".dockercfg" or ".dockerconfigjson" file.
+func makeCredential(server string, authConfig AuthConfig) (Credential, error) {
+	cred := Credential{
+		Server:        server,
+		Username:      authConfig.Username,
+		Password:      authConfig.Password,
+		Email:         authConfig.Email,
+		IdentityToken: authConfig.IdentityToken,
+		RegistryToken: authConfig.RegistryToken,
+	}
+	if cred.Username == "" && authConfig.Auth != "" {
+		decoded, err := base64.StdEncoding.DecodeString(authConfig.Auth)
+		if err != nil {
+			return Credential{}, fmt.Errorf("error decoding the auth for server: %s Error: %v", server, err)
+		}
+		parts := strings.SplitN(string(decoded), ":", 2)
+		if len(parts) != 2 {
+			return Credential{}, fmt.Errorf("malformed auth for server: %s", server)
+		}
+		cred.Username = parts[0]
+		cred.Password = parts[1]
+	}
+	return cred, nil
+}
+
+// sortedServers returns the servers in the specified auth configs, sorted.
+func sortedServers(authConfigs map[string]AuthConfig) []string {
+	res := make([]string, 0, len(authConfigs))
+	for server := range authConfigs {
+		res = append(res, server)
+	}
+	sort.Strings(res)
+	return res
+}
@@ -0,0 +1,120 @@
+package pullsecrets_test
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/virtual-kubelet/virtual-kubelet/manager"
+	"github.com/virtual-kubelet/virtual-kubelet/providers/pullsecrets"
+	testutil "github.com/virtual-kubelet/virtual-kubelet/test/util"
+)
+
+func dockerConfigJSONSecret(name, config string) *v1.Secret {
+	return &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "namespace-0", Name: name},
+		Type:       v1.SecretTypeDockerConfigJson,
+		Data:       map[string][]byte{v1.DockerConfigJsonKey: []byte(config)},
+	}
+}
+
+// TestParseSecret verifies that credentials are read from both types of image pull secrets, and that usernames and passwords are decoded from the "auth" field.
+func TestParseSecret(t *testing.T) {
+	auth := base64.StdEncoding.EncodeToString([]byte("user-1:pass:1"))
+	creds, err := pullsecrets.ParseSecret(dockerConfigJSONSecret("secret-0", `{"auths": {
+		"registry-1.example.com": {"auth": "`+auth+`"},
+		"registry-0.example.com": {"username": "user-0", "password": "pass-0", "email": "user-0@example.com"}
+	}}`))
+	require.NoError(t, err)
+	assert.Equal(t, []pullsecrets.Credential{
+		{Server: "registry-0.example.com", Username: "user-0", Password: "pass-0", Email: "user-0@example.com"},
+		{Server: "registry-1.example.com", Username: "user-1", Password: "pass:1"},
+	}, creds)
+
+	creds, err = pullsecrets.ParseSecret(&v1.Secret{
+		Type: v1.SecretTypeDockercfg,
+		Data: map[string][]byte{v1.DockerConfigKey: []byte(`{"registry.example.com": {"identitytoken": "token"}}`)},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []pullsecrets.Credential{{Server: "registry.example.com", IdentityToken: "token"}}, creds)
+
+	tests := []struct {
+		name        string
+		secret      *v1.Secret
+		failMessage string
+	}{
+		{"Invalid Auth", dockerConfigJSONSecret("secret", `{"auths": {"server": {"auth": "123"}}}`), "error decoding the auth for server"},
+		{"Malformed Auth", dockerConfigJSONSecret("secret", `{"auths": {"server": {"auth": "`+base64.StdEncoding.EncodeToString([]byte("123"))+`"}}}`), "malformed auth for server"},
+		{"Missing Auths", dockerConfigJSONSecret("secret", `{}`), "malformed dockerconfigjson in secret"},
+		{"Missing Key", &v1.Secret{Type: v1.SecretTypeDockercfg}, "no dockercfg present in secret"},
+		{"Unsupported Type", &v1.Secret{Type: v1.SecretTypeOpaque}, "image pull secret type is not one of"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := pullsecrets.ParseSecret(tc.secret)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.failMessage)
+		})
+	}
+}
+
+// TestForPod verifies that the image pull secrets of both the pod and its service account are taken into account.
+func TestForPod(t *testing.T) {
+	rm := testutil.FakeResourceManager(
+		dockerConfigJSONSecret("secret-0", `{"auths": {"registry-0.example.com": {"username": "user-0"}}}`),
+		dockerConfigJSONSecret("secret-1", `{"auths": {"registry-1.example.com": {"username": "user-1"}}}`),
+		&v1.ServiceAccount{
+			ObjectMeta:       metav1.ObjectMeta{Namespace: "namespace-0", Name: "sa-0"},
+			ImagePullSecrets: []v1.LocalObjectReference{{Name: "secret-0"}, {Name: "secret-1"}},
+		},
+	)
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "namespace-0", Name: "pod-0"},
+		Spec: v1.PodSpec{
+			ServiceAccountName: "sa-0",
+			ImagePullSecrets:   []v1.LocalObjectReference{{Name: "secret-0"}},
+		},
+	}
+	keyring, err := pullsecrets.ForPod(rm, pod)
+	require.NoError(t, err)
+	// Secrets referenced by both the pod and its service account are only taken into account once.
+	require.Len(t, keyring.Credentials(), 2)
+	creds := keyring.Lookup("registry-1.example.com/app:1.0")
+	require.Len(t, creds, 1)
+	assert.Equal(t, "user-1", creds[0].Username)
+
+	// Missing service accounts are ignored, whereas missing secrets are not.
+	pod.Spec.ServiceAccountName = "missing"
+	keyring, err = pullsecrets.ForPod(rm, pod)
+	require.NoError(t, err)
+	assert.Len(t, keyring.Credentials(), 1)
+	pod.Spec.ImagePullSecrets = append(pod.Spec.ImagePullSecrets, v1.LocalObjectReference{Name: "missing"})
+	_, err = pullsecrets.ForPod(rm, pod)
+	assert.Error(t, err)
+}
+
+// TestForPodWithoutServiceAccountLister verifies that only the image pull secrets of the pod are taken into account when the resource manager has no access to service accounts.
+func TestForPodWithoutServiceAccountLister(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	require.NoError(t, indexer.Add(dockerConfigJSONSecret("secret-0", `{"auths": {"registry-0.example.com": {"username": "user-0"}}}`)))
+	rm, err := manager.NewResourceManager(nil, corev1listers.NewSecretLister(indexer), nil)
+	require.NoError(t, err)
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "namespace-0", Name: "pod-0"},
+		Spec: v1.PodSpec{
+			ServiceAccountName: "sa-0",
+			ImagePullSecrets:   []v1.LocalObjectReference{{Name: "secret-0"}},
+		},
+	}
+	keyring, err := pullsecrets.ForPod(rm, pod)
+	require.NoError(t, err)
+	creds := keyring.Lookup("registry-0.example.com/app:1.0")
+	require.Len(t, creds, 1)
+	assert.Equal(t, "user-0", creds[0].Username)
+}
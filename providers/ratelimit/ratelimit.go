@@ -0,0 +1,350 @@
+// Package ratelimit provides a provider-agnostic wrapper around providers.Provider that bounds the rate at which calls reach the provider's backing API.
+package ratelimit
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/cpuguy83/strongerrors"
+	pkgerrors "github.com/pkg/errors"
+	"go.opencensus.io/trace"
+	"golang.org/x/time/rate"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/remotecommand"
+	stats "k8s.io/kubernetes/pkg/kubelet/apis/stats/v1alpha1"
+
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+	"github.com/virtual-kubelet/virtual-kubelet/providers"
+)
+
+// Limit configures a token bucket.
+// Calls are allowed at a sustained rate of QPS calls per second, with bursts of up to Burst calls.
+// A non-positive QPS disables rate limiting.
+type Limit struct {
+	QPS   float64
+	Burst int
+}
+
+// Config configures the limits applied to each class of provider methods.
+type Config struct {
+	// Mutations limits calls to CreatePod, UpdatePod, DeletePod, RestartContainer and UpdatePodVolumes.
+	Mutations Limit
+	// Reads limits calls to GetPod, GetPods, GetPodStatus and GetStatsSummary.
+	Reads Limit
+	// Streams limits calls to GetContainerLogs, ExecInContainer, GetExec, GetAttach and GetPortForward.
+	Streams Limit
+}
+
+// Enabled returns whether at least one of the configured limits is enabled.
+func (c Config) Enabled() bool {
+	return c.Mutations.QPS > 0 || c.Reads.QPS > 0 || c.Streams.QPS > 0
+}
+
+// Provider wraps a providers.Provider in order to rate limit the calls made to it.
+// Methods reporting the node's status (e.g. Capacity or NodeConditions) are not rate limited, as they are expected to be served locally.
+// When a call fails with an error implementing providers.ErrThrottled, further calls of the same class are held back until the indicated duration has elapsed.
+// Provider implements all the optional provider interfaces, returning a "not implemented" error when the wrapped provider doesn't.
+type Provider struct {
+	provider  providers.Provider
+	mutations *limiter
+	reads     *limiter
+	streams   *limiter
+}
+
+var (
+	_ providers.Provider               = &Provider{}
+	_ providers.PodMetricsProvider     = &Provider{}
+	_ providers.ContainerRestarter     = &Provider{}
+	_ providers.NativeProber           = &Provider{}
+	_ providers.ExecExitStatusReporter = &Provider{}
+	_ providers.PodVolumesUpdater      = &Provider{}
+	_ providers.StreamingProvider      = &Provider{}
+)
+
+// New wraps the specified provider so that calls made to it are rate limited according to the specified configuration.
+func New(p providers.Provider, cfg Config) *Provider {
+	return &Provider{
+		provider:  p,
+		mutations: newLimiter("mutations", cfg.Mutations),
+		reads:     newLimiter("reads", cfg.Reads),
+		streams:   newLimiter("streams", cfg.Streams),
+	}
+}
+
+// limiter rate limits a class of provider methods.
+type limiter struct {
+	class   string
+	limiter *rate.Limiter
+
+	mu sync.Mutex
+	// pausedUntil is the time until which calls are held back because the provider's backing API is throttling requests.
+	pausedUntil time.Time
+}
+
+func newLimiter(class string, l Limit) *limiter {
+	res := &limiter{class: class}
+	if l.QPS > 0 {
+		burst := l.Burst
+		if burst < 1 {
+			burst = 1
+		}
+		res.limiter = rate.NewLimiter(rate.Limit(l.QPS), burst)
+	}
+	return res
+}
+
+// call waits until a call of the limiter's class is allowed, runs the specified function and takes note of any "Retry-After" hint in the error it returns.
+func (l *limiter) call(ctx context.Context, method string, f func() error) error {
+	ctx, span := trace.StartSpan(ctx, "ratelimit.wait")
+	span.AddAttributes(
+		trace.StringAttribute("class", l.class),
+		trace.StringAttribute("method", method),
+	)
+	err := l.wait(ctx)
+	span.End()
+	if err != nil {
+		return strongerrors.Cancelled(pkgerrors.Wrapf(err, "rate limited call to %s was cancelled", method))
+	}
+
+	err = f()
+	if d, ok := providers.RetryAfter(err); ok && d > 0 {
+		log.G(ctx).WithField("method", method).WithField("class", l.class).WithField("retryAfter", d).Warn("Provider is throttling requests, holding back further calls")
+		l.pause(d)
+	}
+	return err
+}
+
+// wait blocks until the limiter is no longer paused and a token is available, or until the specified context is done.
+func (l *limiter) wait(ctx context.Context) error {
+	l.mu.Lock()
+	until := l.pausedUntil
+	l.mu.Unlock()
+
+	if d := time.Until(until); d > 0 {
+		t := time.NewTimer(d)
+		defer t.Stop()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+	if l.limiter == nil {
+		return nil
+	}
+	return l.limiter.Wait(ctx)
+}
+
+// pause holds back calls for the specified duration.
+func (l *limiter) pause(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if until := time.Now().Add(d); until.After(l.pausedUntil) {
+		l.pausedUntil = until
+	}
+}
+
+// CreatePod takes a Kubernetes Pod and deploys it within the provider.
+func (p *Provider) CreatePod(ctx context.Context, pod *v1.Pod) error {
+	return p.mutations.call(ctx, "CreatePod", func() error {
+		return p.provider.CreatePod(ctx, pod)
+	})
+}
+
+// UpdatePod takes a Kubernetes Pod and updates it within the provider.
+func (p *Provider) UpdatePod(ctx context.Context, pod *v1.Pod) error {
+	return p.mutations.call(ctx, "UpdatePod", func() error {
+		return p.provider.UpdatePod(ctx, pod)
+	})
+}
+
+// DeletePod takes a Kubernetes Pod and deletes it from the provider.
+func (p *Provider) DeletePod(ctx context.Context, pod *v1.Pod) error {
+	return p.mutations.call(ctx, "DeletePod", func() error {
+		return p.provider.DeletePod(ctx, pod)
+	})
+}
+
+// GetPod retrieves a pod by name from the provider.
+func (p *Provider) GetPod(ctx context.Context, namespace, name string) (*v1.Pod, error) {
+	var res *v1.Pod
+	err := p.reads.call(ctx, "GetPod", func() error {
+		var err error
+		res, err = p.provider.GetPod(ctx, namespace, name)
+		return err
+	})
+	return res, err
+}
+
+// GetContainerLogs retrieves the logs of a container by name from the provider.
+func (p *Provider) GetContainerLogs(ctx context.Context, namespace, podName, containerName string, tail int) (string, error) {
+	var res string
+	err := p.streams.call(ctx, "GetContainerLogs", func() error {
+		var err error
+		res, err = p.provider.GetContainerLogs(ctx, namespace, podName, containerName, tail)
+		return err
+	})
+	return res, err
+}
+
+// ExecInContainer executes a command in a container in the pod.
+// Since no context is available, the call waits for as long as necessary for the rate limit to allow it.
+func (p *Provider) ExecInContainer(name string, uid types.UID, container string, cmd []string, in io.Reader, out, err io.WriteCloser, tty bool, resize <-chan remotecommand.TerminalSize, timeout time.Duration) error {
+	return p.streams.call(context.Background(), "ExecInContainer", func() error {
+		return p.provider.ExecInContainer(name, uid, container, cmd, in, out, err, tty, resize, timeout)
+	})
+}
+
+// GetPodStatus retrieves the status of a pod by name from the provider.
+func (p *Provider) GetPodStatus(ctx context.Context, namespace, name string) (*v1.PodStatus, error) {
+	var res *v1.PodStatus
+	err := p.reads.call(ctx, "GetPodStatus", func() error {
+		var err error
+		res, err = p.provider.GetPodStatus(ctx, namespace, name)
+		return err
+	})
+	return res, err
+}
+
+// GetPods retrieves a list of all pods running on the provider.
+func (p *Provider) GetPods(ctx context.Context) ([]*v1.Pod, error) {
+	var res []*v1.Pod
+	err := p.reads.call(ctx, "GetPods", func() error {
+		var err error
+		res, err = p.provider.GetPods(ctx)
+		return err
+	})
+	return res, err
+}
+
+// Capacity returns a resource list with the capacity constraints of the provider.
+func (p *Provider) Capacity(ctx context.Context) v1.ResourceList {
+	return p.provider.Capacity(ctx)
+}
+
+// NodeConditions returns a list of conditions (Ready, OutOfDisk, etc), which is polled periodically to update the node status within Kubernetes.
+func (p *Provider) NodeConditions(ctx context.Context) []v1.NodeCondition {
+	return p.provider.NodeConditions(ctx)
+}
+
+// NodeAddresses returns a list of addresses for the node status within Kubernetes.
+func (p *Provider) NodeAddresses(ctx context.Context) []v1.NodeAddress {
+	return p.provider.NodeAddresses(ctx)
+}
+
+// NodeDaemonEndpoints returns NodeDaemonEndpoints for the node status within Kubernetes.
+func (p *Provider) NodeDaemonEndpoints(ctx context.Context) *v1.NodeDaemonEndpoints {
+	return p.provider.NodeDaemonEndpoints(ctx)
+}
+
+// OperatingSystem returns the operating system the provider is for.
+func (p *Provider) OperatingSystem() string {
+	return p.provider.OperatingSystem()
+}
+
+// GetStatsSummary returns the stats summary reported by the wrapped provider, if it implements providers.PodMetricsProvider.
+func (p *Provider) GetStatsSummary(ctx context.Context) (*stats.Summary, error) {
+	mp, ok := p.provider.(providers.PodMetricsProvider)
+	if !ok {
+		return nil, strongerrors.NotImplemented(pkgerrors.New("provider does not support pod metrics"))
+	}
+	var res *stats.Summary
+	err := p.reads.call(ctx, "GetStatsSummary", func() error {
+		var err error
+		res, err = mp.GetStatsSummary(ctx)
+		return err
+	})
+	return res, err
+}
+
+// RestartContainer restarts the specified container using the wrapped provider, if it implements providers.ContainerRestarter.
+func (p *Provider) RestartContainer(ctx context.Context, pod *v1.Pod, containerName string) error {
+	cr, ok := p.provider.(providers.ContainerRestarter)
+	if !ok {
+		return strongerrors.NotImplemented(pkgerrors.New("provider does not support restarting containers"))
+	}
+	return p.mutations.call(ctx, "RestartContainer", func() error {
+		return cr.RestartContainer(ctx, pod, containerName)
+	})
+}
+
+// UpdatePodVolumes refreshes the volumes of the specified pod using the wrapped provider, if it implements providers.PodVolumesUpdater.
+func (p *Provider) UpdatePodVolumes(ctx context.Context, pod *v1.Pod) error {
+	vu, ok := p.provider.(providers.PodVolumesUpdater)
+	if !ok {
+		return strongerrors.NotImplemented(pkgerrors.New("provider does not support updating pod volumes"))
+	}
+	return p.mutations.call(ctx, "UpdatePodVolumes", func() error {
+		return vu.UpdatePodVolumes(ctx, pod)
+	})
+}
+
+// streamingProvider returns the wrapped provider as a providers.StreamingProvider, or an error if it doesn't implement it.
+func (p *Provider) streamingProvider() (providers.StreamingProvider, error) {
+	sp, ok := p.provider.(providers.StreamingProvider)
+	if !ok {
+		return nil, strongerrors.NotImplemented(pkgerrors.New("provider does not support streaming sessions"))
+	}
+	return sp, nil
+}
+
+// GetExec returns the URL serving the specified exec session using the wrapped provider, if it implements providers.StreamingProvider.
+func (p *Provider) GetExec(ctx context.Context, namespace, podName, containerName string, cmd []string, opts providers.StreamOptions) (*url.URL, error) {
+	sp, err := p.streamingProvider()
+	if err != nil {
+		return nil, err
+	}
+	var res *url.URL
+	err = p.streams.call(ctx, "GetExec", func() error {
+		var err error
+		res, err = sp.GetExec(ctx, namespace, podName, containerName, cmd, opts)
+		return err
+	})
+	return res, err
+}
+
+// GetAttach returns the URL serving the specified attach session using the wrapped provider, if it implements providers.StreamingProvider.
+func (p *Provider) GetAttach(ctx context.Context, namespace, podName, containerName string, opts providers.StreamOptions) (*url.URL, error) {
+	sp, err := p.streamingProvider()
+	if err != nil {
+		return nil, err
+	}
+	var res *url.URL
+	err = p.streams.call(ctx, "GetAttach", func() error {
+		var err error
+		res, err = sp.GetAttach(ctx, namespace, podName, containerName, opts)
+		return err
+	})
+	return res, err
+}
+
+// GetPortForward returns the URL serving the specified port-forward session using the wrapped provider, if it implements providers.StreamingProvider.
+func (p *Provider) GetPortForward(ctx context.Context, namespace, podName string, ports []int32) (*url.URL, error) {
+	sp, err := p.streamingProvider()
+	if err != nil {
+		return nil, err
+	}
+	var res *url.URL
+	err = p.streams.call(ctx, "GetPortForward", func() error {
+		var err error
+		res, err = sp.GetPortForward(ctx, namespace, podName, ports)
+		return err
+	})
+	return res, err
+}
+
+// SupportsNativeProbes returns whether the wrapped provider runs liveness and readiness probes natively.
+func (p *Provider) SupportsNativeProbes() bool {
+	np, ok := p.provider.(providers.NativeProber)
+	return ok && np.SupportsNativeProbes()
+}
+
+// ReportsExecExitStatus returns whether the wrapped provider reports the exit status of the commands it runs.
+func (p *Provider) ReportsExecExitStatus() bool {
+	r, ok := p.provider.(providers.ExecExitStatusReporter)
+	return ok && r.ReportsExecExitStatus()
+}
@@ -0,0 +1,91 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cpuguy83/strongerrors"
+	pkgerrors "github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/api/core/v1"
+
+	"github.com/virtual-kubelet/virtual-kubelet/providers"
+)
+
+// fakeProvider is a provider whose CreatePod and GetPod methods return the configured error.
+type fakeProvider struct {
+	providers.Provider
+	err   error
+	calls int
+}
+
+func (p *fakeProvider) CreatePod(ctx context.Context, pod *v1.Pod) error {
+	p.calls++
+	return p.err
+}
+
+func (p *fakeProvider) GetPod(ctx context.Context, namespace, name string) (*v1.Pod, error) {
+	p.calls++
+	return nil, p.err
+}
+
+// TestReadsAreRateLimited verifies that calls beyond the configured burst wait for tokens to become available.
+func TestReadsAreRateLimited(t *testing.T) {
+	fp := &fakeProvider{}
+	p := New(fp, Config{Reads: Limit{QPS: 10, Burst: 1}})
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		_, err := p.GetPod(context.Background(), "default", "pod-0")
+		assert.NoError(t, err)
+	}
+	// The first call consumes the burst, and each of the two following ones must wait for 100ms.
+	assert.True(t, time.Since(start) >= 150*time.Millisecond)
+	assert.Equal(t, 3, fp.calls)
+}
+
+// TestRetryAfterIsHonored verifies that calls are held back after the provider reports that it is being throttled, and that only the affected class of methods is held back.
+func TestRetryAfterIsHonored(t *testing.T) {
+	fp := &fakeProvider{err: pkgerrors.Wrap(providers.Throttled(pkgerrors.New("too many requests"), 200*time.Millisecond), "failed to create pod")}
+	p := New(fp, Config{})
+
+	err := p.CreatePod(context.Background(), &v1.Pod{})
+	assert.True(t, strongerrors.IsExhausted(err))
+	fp.err = nil
+
+	// Reads are not affected.
+	start := time.Now()
+	_, err = p.GetPod(context.Background(), "default", "pod-0")
+	assert.NoError(t, err)
+	assert.True(t, time.Since(start) < 100*time.Millisecond)
+
+	// Mutations are held back until the hint expires.
+	assert.NoError(t, p.CreatePod(context.Background(), &v1.Pod{}))
+	assert.True(t, time.Since(start) >= 150*time.Millisecond)
+
+	// Waiting is cancelled along with the call's context.
+	fp.err = providers.Throttled(pkgerrors.New("too many requests"), time.Hour)
+	p.CreatePod(context.Background(), &v1.Pod{})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err = p.CreatePod(ctx, &v1.Pod{})
+	assert.True(t, strongerrors.IsCancelled(err))
+	assert.Equal(t, 4, fp.calls)
+}
+
+// TestOptionalInterfaces verifies that optional interfaces not implemented by the wrapped provider are reported as such.
+func TestOptionalInterfaces(t *testing.T) {
+	p := New(&fakeProvider{}, Config{})
+
+	_, err := p.GetStatsSummary(context.Background())
+	assert.True(t, strongerrors.IsNotImplemented(err))
+	assert.True(t, strongerrors.IsNotImplemented(p.RestartContainer(context.Background(), &v1.Pod{}, "container-0")))
+	assert.True(t, strongerrors.IsNotImplemented(p.UpdatePodVolumes(context.Background(), &v1.Pod{})))
+	_, err = p.GetExec(context.Background(), "namespace-0", "pod-0", "container-0", []string{"sh"}, providers.StreamOptions{})
+	assert.True(t, strongerrors.IsNotImplemented(err))
+	_, err = p.GetPortForward(context.Background(), "namespace-0", "pod-0", nil)
+	assert.True(t, strongerrors.IsNotImplemented(err))
+	assert.False(t, p.SupportsNativeProbes())
+	assert.False(t, p.ReportsExecExitStatus())
+}
@@ -13,6 +13,7 @@ func init() {
 
 func criInit(cfg InitConfig) (providers.Provider, error) {
 	return cri.NewCRIProvider(
+		cfg.ConfigPath,
 		cfg.NodeName,
 		cfg.OperatingSystem,
 		cfg.InternalIP,
@@ -0,0 +1,31 @@
+// +build !no_grpc_provider
+
+package register
+
+import (
+	"context"
+	"time"
+
+	"github.com/cpuguy83/strongerrors"
+	"github.com/pkg/errors"
+	"github.com/virtual-kubelet/virtual-kubelet/providers"
+	"github.com/virtual-kubelet/virtual-kubelet/providers/plugin"
+)
+
+// grpcDialTimeout is the maximum amount of time to wait for a provider plugin to accept connections.
+const grpcDialTimeout = 30 * time.Second
+
+func init() {
+	register("grpc", initGRPC)
+}
+
+// initGRPC connects to an out-of-process provider plugin.
+// The provider configuration path (i.e. "--provider-config") is the path to the unix socket the plugin listens on.
+func initGRPC(cfg InitConfig) (providers.Provider, error) {
+	if cfg.ConfigPath == "" {
+		return nil, strongerrors.InvalidArgument(errors.New("the path to the plugin's unix socket must be specified as the provider config"))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), grpcDialTimeout)
+	defer cancel()
+	return plugin.Dial(ctx, cfg.ConfigPath)
+}
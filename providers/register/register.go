@@ -16,7 +16,10 @@ type InitConfig struct {
 	OperatingSystem string
 	InternalIP      string
 	DaemonPort      int32
+	// ResourceManager is deprecated in favor of Resources, which it implements.
 	ResourceManager *manager.ResourceManager
+	// Resources provides read access to the Kubernetes resources providers may need (e.g. to resolve persistent volume claims).
+	Resources manager.ResourceAccessor
 }
 
 type initFunc func(InitConfig) (providers.Provider, error)
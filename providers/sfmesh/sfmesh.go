@@ -2,7 +2,6 @@ package sfmesh
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -15,6 +14,7 @@ import (
 	"github.com/Azure/go-autorest/autorest/azure/auth"
 	"github.com/virtual-kubelet/virtual-kubelet/manager"
 	"github.com/virtual-kubelet/virtual-kubelet/providers"
+	"github.com/virtual-kubelet/virtual-kubelet/providers/pullsecrets"
 	"k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -47,17 +47,6 @@ type SFMeshProvider struct {
 	resourceManager    *manager.ResourceManager
 }
 
-// AuthConfig is the secret returned from an ImageRegistryCredential
-type AuthConfig struct {
-	Username      string `json:"username,omitempty"`
-	Password      string `json:"password,omitempty"`
-	Auth          string `json:"auth,omitempty"`
-	Email         string `json:"email,omitempty"`
-	ServerAddress string `json:"serveraddress,omitempty"`
-	IdentityToken string `json:"identitytoken,omitempty"`
-	RegistryToken string `json:"registrytoken,omitempty"`
-}
-
 // NewSFMeshProvider creates a new SFMeshProvider
 func NewSFMeshProvider(rm *manager.ResourceManager, nodeName, operatingSystem string, internalIP string, daemonEndpointPort int32) (*SFMeshProvider, error) {
 	azureSubscriptionID := os.Getenv("AZURE_SUBSCRIPTION_ID")
@@ -117,89 +106,21 @@ func NewSFMeshProvider(rm *manager.ResourceManager, nodeName, operatingSystem st
 	return &provider, nil
 }
 
-func readDockerCfgSecret(secret *v1.Secret, ips []servicefabricmesh.ImageRegistryCredential) ([]servicefabricmesh.ImageRegistryCredential, error) {
-	var err error
-	var authConfigs map[string]AuthConfig
-	repoData, ok := secret.Data[string(v1.DockerConfigKey)]
-
-	if !ok {
-		return ips, fmt.Errorf("no dockercfg present in secret")
-	}
-
-	err = json.Unmarshal(repoData, &authConfigs)
-	if err != nil {
-		return ips, err
-	}
-
-	for server, authConfig := range authConfigs {
-		ips = append(ips, servicefabricmesh.ImageRegistryCredential{
-			Password: &authConfig.Password,
-			Server:   &server,
-			Username: &authConfig.Username,
-		})
-	}
-
-	return ips, err
+// getImagePullSecrets returns the keyring holding the credentials in the image pull secrets of the specified pod.
+func (p *SFMeshProvider) getImagePullSecrets(pod *v1.Pod) (*pullsecrets.Keyring, error) {
+	return pullsecrets.ForPod(p.resourceManager, pod)
 }
 
-func readDockerConfigJSONSecret(secret *v1.Secret, ips []servicefabricmesh.ImageRegistryCredential) ([]servicefabricmesh.ImageRegistryCredential, error) {
-	var err error
-	repoData, ok := secret.Data[string(v1.DockerConfigJsonKey)]
-
-	if !ok {
-		return ips, fmt.Errorf("no dockerconfigjson present in secret")
-	}
-
-	var authConfigs map[string]map[string]AuthConfig
-
-	err = json.Unmarshal(repoData, &authConfigs)
-	if err != nil {
-		return ips, err
-	}
-
-	auths, ok := authConfigs["auths"]
-
-	if !ok {
-		return ips, fmt.Errorf("malformed dockerconfigjson in secret")
+// makeRegistryCredential returns the first of the specified credentials, as Mesh supports only a single credential per container, or nil if there are none.
+func makeRegistryCredential(creds []pullsecrets.Credential) *servicefabricmesh.ImageRegistryCredential {
+	if len(creds) == 0 {
+		return nil
 	}
-
-	for server, authConfig := range auths {
-		ips = append(ips, servicefabricmesh.ImageRegistryCredential{
-			Password: &authConfig.Password,
-			Server:   &server,
-			Username: &authConfig.Username,
-		})
+	return &servicefabricmesh.ImageRegistryCredential{
+		Server:   &creds[0].Server,
+		Username: &creds[0].Username,
+		Password: &creds[0].Password,
 	}
-
-	return ips, err
-}
-
-func (p *SFMeshProvider) getImagePullSecrets(pod *v1.Pod) ([]servicefabricmesh.ImageRegistryCredential, error) {
-	ips := make([]servicefabricmesh.ImageRegistryCredential, 0, len(pod.Spec.ImagePullSecrets))
-	for _, ref := range pod.Spec.ImagePullSecrets {
-		secret, err := p.resourceManager.GetSecret(ref.Name, pod.Namespace)
-		if err != nil {
-			return ips, err
-		}
-		if secret == nil {
-			return nil, fmt.Errorf("error getting image pull secret")
-		}
-
-		switch secret.Type {
-		case v1.SecretTypeDockercfg:
-			ips, err = readDockerCfgSecret(secret, ips)
-		case v1.SecretTypeDockerConfigJson:
-			ips, err = readDockerConfigJSONSecret(secret, ips)
-		default:
-			return nil, fmt.Errorf("image pull secret type is not one of kubernetes.io/dockercfg or kubernetes.io/dockerconfigjson")
-		}
-
-		if err != nil {
-			return ips, err
-		}
-
-	}
-	return ips, nil
 }
 
 func (p *SFMeshProvider) getMeshApplication(pod *v1.Pod) (servicefabricmesh.ApplicationResourceDescription, error) {
@@ -243,12 +164,8 @@ func (p *SFMeshProvider) getMeshApplication(pod *v1.Pod) (servicefabricmesh.Appl
 		codePackage.Image = &container.Image
 		codePackage.Name = &container.Name
 
-		if creds != nil {
-			if len(creds) > 0 {
-				// Mesh ImageRegistryCredential supports only a single credential
-				codePackage.ImageRegistryCredential = &creds[0]
-			}
-		}
+		// Mesh ImageRegistryCredential supports only a single credential, so pick the one that best matches the container's image.
+		codePackage.ImageRegistryCredential = makeRegistryCredential(creds.Lookup(container.Image))
 
 		requirements := servicefabricmesh.ResourceRequirements{}
 		requests := servicefabricmesh.ResourceRequests{}
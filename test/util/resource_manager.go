@@ -13,22 +13,40 @@ import (
 )
 
 // FakeResourceManager returns an instance of the resource manager that will return the specified objects when its "GetX" methods are called.
-// Objects can be any valid Kubernetes object (corev1.Pod, corev1.ConfigMap, corev1.Secret, ...).
+// Objects can be any valid Kubernetes object (corev1.Pod, corev1.ConfigMap, corev1.Secret, corev1.PersistentVolumeClaim, corev1.Service, ...).
+// The returned resource manager can be used wherever a manager.ResourceAccessor is expected.
 func FakeResourceManager(objects ...runtime.Object) *manager.ResourceManager {
 	// Create a fake Kubernetes client that will list the specified objects.
 	kubeClient := fake.NewSimpleClientset(objects...)
 	// Create a shared informer factory from where we can grab informers and listers for pods, configmaps and secrets.
 	kubeInformerFactory := informers.NewSharedInformerFactory(kubeClient, 30*time.Second)
-	// Grab informers for pods, configmaps and secrets.
+	// Grab informers for pods, configmaps and secrets, as well as for the other resources accessible through the resource manager.
 	pInformer := kubeInformerFactory.Core().V1().Pods()
 	mInformer := kubeInformerFactory.Core().V1().ConfigMaps()
 	sInformer := kubeInformerFactory.Core().V1().Secrets()
+	pvcInformer := kubeInformerFactory.Core().V1().PersistentVolumeClaims()
+	pvInformer := kubeInformerFactory.Core().V1().PersistentVolumes()
+	saInformer := kubeInformerFactory.Core().V1().ServiceAccounts()
+	svcInformer := kubeInformerFactory.Core().V1().Services()
+	nInformer := kubeInformerFactory.Core().V1().Nodes()
+	informers := []cache.SharedIndexInformer{
+		pInformer.Informer(),
+		mInformer.Informer(),
+		sInformer.Informer(),
+		pvcInformer.Informer(),
+		pvInformer.Informer(),
+		saInformer.Informer(),
+		svcInformer.Informer(),
+		nInformer.Informer(),
+	}
 	// Start all the required informers.
-	go pInformer.Informer().Run(wait.NeverStop)
-	go mInformer.Informer().Run(wait.NeverStop)
-	go sInformer.Informer().Run(wait.NeverStop)
+	hasSynced := make([]cache.InformerSynced, 0, len(informers))
+	for _, informer := range informers {
+		go informer.Run(wait.NeverStop)
+		hasSynced = append(hasSynced, informer.HasSynced)
+	}
 	// Wait for the caches to be synced.
-	if !cache.WaitForCacheSync(wait.NeverStop, pInformer.Informer().HasSynced, mInformer.Informer().HasSynced, sInformer.Informer().HasSynced) {
+	if !cache.WaitForCacheSync(wait.NeverStop, hasSynced...) {
 		panic("failed to wait for caches to be synced")
 	}
 	// Create a new instance of the resource manager using the listers for pods, configmaps and secrets.
@@ -36,5 +54,13 @@ func FakeResourceManager(objects ...runtime.Object) *manager.ResourceManager {
 	if err != nil {
 		panic(err)
 	}
+	// Make the resource manager use the listers for the other resources.
+	r.SetListers(manager.Listers{
+		PersistentVolumeClaims: pvcInformer.Lister(),
+		PersistentVolumes:      pvInformer.Lister(),
+		ServiceAccounts:        saInformer.Lister(),
+		Services:               svcInformer.Lister(),
+		Nodes:                  nInformer.Lister(),
+	})
 	return r
 }
@@ -0,0 +1,30 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/cpuguy83/strongerrors"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"k8s.io/kubernetes/pkg/kubelet/server/remotecommand"
+
+	"github.com/virtual-kubelet/virtual-kubelet/providers"
+)
+
+// PodAttachHandlerFunc makes an http handler func from a provider which attaches to a pod's container, by proxying the request to the URL returned by the provider's GetAttach method.
+// Note that this handler depends on gorilla/mux to get url parts as variables.
+func PodAttachHandlerFunc(backend providers.StreamingProvider) http.HandlerFunc {
+	return handleError(func(w http.ResponseWriter, req *http.Request) error {
+		vars := mux.Vars(req)
+
+		opts, err := remotecommand.NewOptions(req)
+		if err != nil {
+			return strongerrors.InvalidArgument(err)
+		}
+		location, err := backend.GetAttach(req.Context(), vars["namespace"], vars["pod"], vars["container"], streamOptions(opts))
+		if err != nil {
+			return errors.Wrap(err, "error getting attach URL")
+		}
+		return proxyStream(w, req, location)
+	})
+}
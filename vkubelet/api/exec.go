@@ -3,14 +3,21 @@ package api
 import (
 	"fmt"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 
+	"github.com/cpuguy83/strongerrors"
 	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
 	"k8s.io/kubernetes/pkg/kubelet/server/remotecommand"
+
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+	"github.com/virtual-kubelet/virtual-kubelet/providers"
 )
 
 // PodExecHandlerFunc makes an http handler func from a Provider which execs a command in a pod's container
+// If the backend implements providers.StreamingProvider, the request is proxied to the URL returned by its GetExec method, unless it returns a "not implemented" error.
 // Note that this handler currently depends on gorrilla/mux to get url parts as variables.
 // TODO(@cpuguy83): don't force gorilla/mux on consumers of this function
 func PodExecHandlerFunc(backend remotecommand.Executor) http.HandlerFunc {
@@ -26,6 +33,20 @@ func PodExecHandlerFunc(backend remotecommand.Executor) http.HandlerFunc {
 		q := req.URL.Query()
 		command := q["command"]
 
+		if sp, ok := backend.(providers.StreamingProvider); ok {
+			location, err := getExecURL(req, sp, namespace, pod, container, command)
+			if err == nil || !strongerrors.IsNotImplemented(err) {
+				handleError(func(w http.ResponseWriter, req *http.Request) error {
+					if err != nil {
+						return err
+					}
+					return proxyStream(w, req, location)
+				})(w, req)
+				return
+			}
+			log.G(req.Context()).Debug("Provider does not support streaming exec sessions, falling back to ExecInContainer")
+		}
+
 		// TODO: tty flag causes remotecommand.createStreams to wait for the wrong number of streams
 		streamOpts := &remotecommand.Options{
 			Stdin:  true,
@@ -40,3 +61,26 @@ func PodExecHandlerFunc(backend remotecommand.Executor) http.HandlerFunc {
 		remotecommand.ServeExec(w, req, backend, fmt.Sprintf("%s-%s", namespace, pod), "", container, command, streamOpts, idleTimeout, streamCreationTimeout, supportedStreamProtocols)
 	}
 }
+
+// getExecURL returns the URL serving the specified exec request, as returned by the specified provider.
+func getExecURL(req *http.Request, sp providers.StreamingProvider, namespace, pod, container string, command []string) (*url.URL, error) {
+	opts, err := remotecommand.NewOptions(req)
+	if err != nil {
+		return nil, strongerrors.InvalidArgument(err)
+	}
+	location, err := sp.GetExec(req.Context(), namespace, pod, container, command, streamOptions(opts))
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting exec URL")
+	}
+	return location, nil
+}
+
+// streamOptions converts the specified options parsed from an exec or attach request.
+func streamOptions(opts *remotecommand.Options) providers.StreamOptions {
+	return providers.StreamOptions{
+		Stdin:  opts.Stdin,
+		Stdout: opts.Stdout,
+		Stderr: opts.Stderr,
+		TTY:    opts.TTY,
+	}
+}
@@ -0,0 +1,35 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/cpuguy83/strongerrors"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+
+	"github.com/virtual-kubelet/virtual-kubelet/providers"
+)
+
+// PodPortForwardHandlerFunc makes an http handler func from a provider which forwards ports of a pod, by proxying the request to the URL returned by the provider's GetPortForward method.
+// The ports may be specified using "port" query parameters, as done by WebSocket clients, or by SPDY clients for each stream.
+// Note that this handler depends on gorilla/mux to get url parts as variables.
+func PodPortForwardHandlerFunc(backend providers.StreamingProvider) http.HandlerFunc {
+	return handleError(func(w http.ResponseWriter, req *http.Request) error {
+		vars := mux.Vars(req)
+
+		var ports []int32
+		for _, val := range req.URL.Query()["port"] {
+			port, err := strconv.ParseUint(val, 10, 16)
+			if err != nil || port == 0 {
+				return strongerrors.InvalidArgument(errors.Errorf("invalid port %q", val))
+			}
+			ports = append(ports, int32(port))
+		}
+		location, err := backend.GetPortForward(req.Context(), vars["namespace"], vars["pod"], ports)
+		if err != nil {
+			return errors.Wrap(err, "error getting port-forward URL")
+		}
+		return proxyStream(w, req, location)
+	})
+}
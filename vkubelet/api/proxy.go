@@ -0,0 +1,118 @@
+package api
+
+import (
+	"bufio"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/cpuguy83/strongerrors"
+	"github.com/pkg/errors"
+
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+)
+
+// streamDialTimeout is the maximum amount of time spent connecting to the streaming servers requests are proxied to.
+const streamDialTimeout = 30 * time.Second
+
+// proxyStream proxies the specified request, which is expected to upgrade the connection to a streaming protocol (SPDY or WebSocket), to the specified location.
+// Once the location accepts the upgrade, data is copied both ways until either side closes the connection.
+// If the location doesn't accept the upgrade, its response is returned to the client as is.
+func proxyStream(w http.ResponseWriter, req *http.Request, location *url.URL) error {
+	backendConn, err := dialLocation(location)
+	if err != nil {
+		return strongerrors.Unavailable(errors.Wrapf(err, "error connecting to %s", location.Host))
+	}
+	defer backendConn.Close()
+
+	// The request is sent to the location as is, except for its URL, so that the upgrade headers are preserved.
+	outReq := &http.Request{
+		Method:     req.Method,
+		URL:        location,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     cloneHeader(req.Header),
+		Host:       location.Host,
+	}
+	if err := outReq.Write(backendConn); err != nil {
+		return strongerrors.Unavailable(errors.Wrapf(err, "error sending request to %s", location.Host))
+	}
+	backendReader := bufio.NewReader(backendConn)
+	res, err := http.ReadResponse(backendReader, outReq)
+	if err != nil {
+		return strongerrors.Unavailable(errors.Wrapf(err, "error reading response from %s", location.Host))
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		for k, v := range res.Header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(res.StatusCode)
+		if _, err := io.Copy(w, res.Body); err != nil {
+			log.G(req.Context()).WithError(err).Debug("Error forwarding response to client")
+		}
+		return nil
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		return strongerrors.NotImplemented(errors.New("connection can't be hijacked"))
+	}
+	clientConn, clientBuf, err := hj.Hijack()
+	if err != nil {
+		return strongerrors.Unknown(errors.Wrap(err, "error hijacking connection"))
+	}
+	defer clientConn.Close()
+	if err := res.Write(clientConn); err != nil {
+		log.G(req.Context()).WithError(err).Debug("Error forwarding upgrade response to client")
+		return nil
+	}
+
+	// Copy data both ways, including whatever has already been buffered on either side, until either side closes the connection.
+	var once sync.Once
+	done := make(chan struct{})
+	closeDone := func() { once.Do(func() { close(done) }) }
+	go func() {
+		io.Copy(backendConn, clientBuf)
+		closeDone()
+	}()
+	go func() {
+		io.Copy(clientConn, backendReader)
+		closeDone()
+	}()
+	<-done
+	return nil
+}
+
+// dialLocation connects to the host of the specified location, using TLS if its scheme is "https" or "wss".
+func dialLocation(location *url.URL) (net.Conn, error) {
+	host := location.Host
+	useTLS := location.Scheme == "https" || location.Scheme == "wss"
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		if useTLS {
+			host = net.JoinHostPort(host, "443")
+		} else {
+			host = net.JoinHostPort(host, "80")
+		}
+	}
+	dialer := &net.Dialer{Timeout: streamDialTimeout}
+	if useTLS {
+		return tls.DialWithDialer(dialer, "tcp", host, &tls.Config{ServerName: location.Hostname()})
+	}
+	return dialer.Dial("tcp", host)
+}
+
+// cloneHeader returns a deep copy of the specified header.
+func cloneHeader(h http.Header) http.Header {
+	res := make(http.Header, len(h))
+	for k, v := range h {
+		res[k] = append([]string(nil), v...)
+	}
+	return res
+}
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newEchoBackend returns a server which upgrades connections requested with the "echo" protocol, and echoes whatever is then sent over them.
+// Requests for any other protocol are rejected.
+func newEchoBackend() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Upgrade") != "echo" || req.URL.Query().Get("token") != "secret" {
+			http.Error(w, "upgrade required", http.StatusBadRequest)
+			return
+		}
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.WriteString(conn, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+		io.Copy(conn, buf)
+	}))
+}
+
+// newProxy returns a server proxying all requests to the specified location.
+func newProxy(location *url.URL) *httptest.Server {
+	return httptest.NewServer(handleError(func(w http.ResponseWriter, req *http.Request) error {
+		return proxyStream(w, req, location)
+	}))
+}
+
+func TestProxyStreamUpgrade(t *testing.T) {
+	backend := newEchoBackend()
+	defer backend.Close()
+	location, err := url.Parse(backend.URL + "/exec/session?token=secret")
+	require.NoError(t, err)
+	proxy := newProxy(location)
+	defer proxy.Close()
+
+	conn, err := net.Dial("tcp", proxy.Listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = io.WriteString(conn, "POST /exec/default/pod/container HTTP/1.1\r\nHost: vk\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+	require.NoError(t, err)
+
+	r := bufio.NewReader(conn)
+	res, err := http.ReadResponse(r, nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusSwitchingProtocols, res.StatusCode)
+	assert.Equal(t, "echo", res.Header.Get("Upgrade"))
+
+	_, err = io.WriteString(conn, "ping")
+	require.NoError(t, err)
+	b := make([]byte, 4)
+	_, err = io.ReadFull(r, b)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(b))
+}
+
+func TestProxyStreamRejected(t *testing.T) {
+	backend := newEchoBackend()
+	defer backend.Close()
+	location, err := url.Parse(backend.URL + "/exec/session?token=invalid")
+	require.NoError(t, err)
+	proxy := newProxy(location)
+	defer proxy.Close()
+
+	req, err := http.NewRequest("POST", proxy.URL+"/exec/default/pod/container", nil)
+	require.NoError(t, err)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "echo")
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+}
+
+func TestProxyStreamUnavailable(t *testing.T) {
+	backend := newEchoBackend()
+	location, err := url.Parse(backend.URL)
+	require.NoError(t, err)
+	backend.Close()
+	proxy := newProxy(location)
+	defer proxy.Close()
+
+	res, err := http.Post(proxy.URL, "text/plain", nil)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+}
@@ -26,7 +26,15 @@ func PodHandler(p providers.Provider) http.Handler {
 	r := mux.NewRouter()
 
 	r.HandleFunc("/containerLogs/{namespace}/{pod}/{container}", api.PodLogsHandlerFunc(p)).Methods("GET")
-	r.HandleFunc("/exec/{namespace}/{pod}/{container}", api.PodExecHandlerFunc(p)).Methods("POST")
+	r.HandleFunc("/exec/{namespace}/{pod}/{container}", api.PodExecHandlerFunc(p)).Methods("GET", "POST")
+
+	// Attaching to containers and forwarding ports are only supported by providers implementing providers.StreamingProvider.
+	attach, portForward := NotImplemented, NotImplemented
+	if sp, ok := p.(providers.StreamingProvider); ok {
+		attach, portForward = api.PodAttachHandlerFunc(sp), api.PodPortForwardHandlerFunc(sp)
+	}
+	r.HandleFunc("/attach/{namespace}/{pod}/{container}", attach).Methods("GET", "POST")
+	r.HandleFunc("/portForward/{namespace}/{pod}", portForward).Methods("GET", "POST")
 	r.NotFoundHandler = http.HandlerFunc(NotFound)
 	return r
 }
@@ -0,0 +1,125 @@
+package vkubelet
+
+import (
+	"context"
+	"time"
+
+	"github.com/cpuguy83/strongerrors/status/ocstatus"
+	pkgerrors "github.com/pkg/errors"
+	"go.opencensus.io/trace"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+)
+
+const (
+	// podStatusReasonDeadlineExceeded is the reason set on pods (and used in events) when they are terminated for exceeding ".spec.activeDeadlineSeconds".
+	// This is the same reason used by the Kubelet.
+	// https://github.com/kubernetes/kubernetes/blob/v1.13.1/pkg/kubelet/active_deadline.go#L32
+	podStatusReasonDeadlineExceeded = "DeadlineExceeded"
+	// podStatusMessageDeadlineExceeded is the message set on pods (and used in events) when they are terminated for exceeding ".spec.activeDeadlineSeconds".
+	podStatusMessageDeadlineExceeded = "Pod was active on the node longer than the specified deadline"
+)
+
+// trackPodStartTime records the current time as the time at which the specified pod was started in the provider.
+// It is used as a fallback for providers that don't report ".status.startTime".
+func (s *Server) trackPodStartTime(pod *corev1.Pod) {
+	s.podStartTimes.LoadOrStore(pod.UID, metav1.Now())
+}
+
+// forgetPodStartTime stops tracking the start time of the pod with the specified UID.
+func (s *Server) forgetPodStartTime(pod *corev1.Pod) {
+	s.podStartTimes.Delete(pod.UID)
+}
+
+// podStartTime returns the time at which the specified pod was started, or nil if it is unknown.
+// The value reported in the pod's status takes precedence over the one tracked by virtual-kubelet.
+func (s *Server) podStartTime(pod *corev1.Pod) *metav1.Time {
+	if pod.Status.StartTime != nil && !pod.Status.StartTime.IsZero() {
+		return pod.Status.StartTime
+	}
+	if v, ok := s.podStartTimes.Load(pod.UID); ok {
+		t := v.(metav1.Time)
+		return &t
+	}
+	return nil
+}
+
+// pastActiveDeadline returns whether the specified pod, started at the specified time, has been active for longer than its ".spec.activeDeadlineSeconds".
+// https://github.com/kubernetes/kubernetes/blob/v1.13.1/pkg/kubelet/active_deadline.go#L80-L98
+func pastActiveDeadline(pod *corev1.Pod, startTime *metav1.Time, now time.Time) bool {
+	if pod.Spec.ActiveDeadlineSeconds == nil || startTime == nil {
+		return false
+	}
+	duration := now.Sub(startTime.Time)
+	allowedDuration := time.Duration(*pod.Spec.ActiveDeadlineSeconds) * time.Second
+	return duration >= allowedDuration
+}
+
+// enforceActiveDeadline checks whether the specified pod has exceeded its active deadline, in which case it is deleted from the provider and marked as failed in Kubernetes.
+// It returns whether the deadline has been exceeded.
+func (s *Server) enforceActiveDeadline(ctx context.Context, pod *corev1.Pod, recorder record.EventRecorder) (bool, error) {
+	startTime := s.podStartTime(pod)
+	if !pastActiveDeadline(pod, startTime, time.Now()) {
+		return false, nil
+	}
+
+	ctx, span := trace.StartSpan(ctx, "enforceActiveDeadline")
+	defer span.End()
+	addPodAttributes(span, pod)
+
+	logger := log.G(ctx).WithField("pod", pod.GetName()).WithField("namespace", pod.GetNamespace())
+
+	// Terminate the pod in the provider.
+	if err := s.provider.DeletePod(ctx, pod); err != nil && !errors.IsNotFound(err) {
+		span.SetStatus(ocstatus.FromError(err))
+		return true, pkgerrors.Wrap(err, "failed to delete pod past its active deadline from the provider")
+	}
+	span.Annotate(nil, "Deleted pod past its active deadline from provider")
+
+	// Mark the pod as failed (along with all its containers) the same way the Kubelet does.
+	_, err := s.patchPodStatus(ctx, pod, func(pod *corev1.Pod) {
+		now := metav1.Now()
+		pod.Status.Phase = corev1.PodFailed
+		pod.Status.Reason = podStatusReasonDeadlineExceeded
+		pod.Status.Message = podStatusMessageDeadlineExceeded
+		pod.Status.StartTime = startTime
+		for i, c := range pod.Status.ContainerStatuses {
+			if c.State.Terminated != nil {
+				continue
+			}
+			var startedAt metav1.Time
+			if c.State.Running != nil {
+				startedAt = c.State.Running.StartedAt
+			}
+			pod.Status.ContainerStatuses[i].Ready = false
+			pod.Status.ContainerStatuses[i].State = corev1.ContainerState{
+				Terminated: &corev1.ContainerStateTerminated{
+					ExitCode:    137,
+					Reason:      podStatusReasonDeadlineExceeded,
+					Message:     podStatusMessageDeadlineExceeded,
+					StartedAt:   startedAt,
+					FinishedAt:  now,
+					ContainerID: c.ContainerID,
+				},
+			}
+		}
+	})
+	if err != nil {
+		span.SetStatus(ocstatus.FromError(err))
+		return true, pkgerrors.Wrap(err, "error while updating status of pod past its active deadline in kubernetes")
+	}
+	span.Annotate(nil, "Marked pod past its active deadline as failed in k8s")
+
+	recorder.Event(pod, corev1.EventTypeNormal, podStatusReasonDeadlineExceeded, podStatusMessageDeadlineExceeded)
+	s.forgetPodStartTime(pod)
+	if s.prober != nil {
+		s.prober.forget(pod)
+	}
+	logger.Info("Pod terminated for exceeding its active deadline")
+
+	return true, nil
+}
@@ -0,0 +1,65 @@
+package vkubelet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	testutil "github.com/virtual-kubelet/virtual-kubelet/test/util"
+)
+
+// TestPastActiveDeadline verifies that a pod is only considered past its active deadline when ".spec.activeDeadlineSeconds" is set and has elapsed since the pod started.
+func TestPastActiveDeadline(t *testing.T) {
+	now := time.Now()
+	deadline := int64(30)
+
+	pod := testutil.FakePodWithSingleContainer(namespace, "pod-0", "image-0")
+	startTime := metav1.NewTime(now.Add(-time.Minute))
+
+	// A pod without an active deadline never exceeds it.
+	assert.False(t, pastActiveDeadline(pod, &startTime, now))
+
+	pod.Spec.ActiveDeadlineSeconds = &deadline
+
+	// A pod whose start time is unknown cannot have exceeded its deadline.
+	assert.False(t, pastActiveDeadline(pod, nil, now))
+	// A pod started a minute ago has exceeded a 30 second deadline.
+	assert.True(t, pastActiveDeadline(pod, &startTime, now))
+	// A pod started ten seconds ago has not exceeded a 30 second deadline.
+	startTime = metav1.NewTime(now.Add(-10 * time.Second))
+	assert.False(t, pastActiveDeadline(pod, &startTime, now))
+}
+
+// TestPodStartTime verifies that the start time reported in the pod's status takes precedence over the one tracked by virtual-kubelet.
+func TestPodStartTime(t *testing.T) {
+	s := &Server{}
+
+	pod := testutil.FakePodWithSingleContainer(namespace, "pod-0", "image-0")
+	pod.UID = types.UID("uid-0")
+
+	// Nothing is known about the pod's start time yet.
+	assert.Nil(t, s.podStartTime(pod))
+
+	// Start tracking the pod, and make sure the tracked start time is used.
+	s.trackPodStartTime(pod)
+	tracked := s.podStartTime(pod)
+	if assert.NotNil(t, tracked) {
+		// Tracking the pod again must not reset the tracked start time.
+		s.trackPodStartTime(pod)
+		assert.Equal(t, *tracked, *s.podStartTime(pod))
+	}
+
+	// The start time reported in the pod's status takes precedence.
+	reported := metav1.NewTime(time.Now().Add(-time.Hour))
+	pod.Status = corev1.PodStatus{StartTime: &reported}
+	assert.Equal(t, reported, *s.podStartTime(pod))
+
+	// Forgetting the pod must stop tracking its start time.
+	pod.Status = corev1.PodStatus{}
+	s.forgetPodStartTime(pod)
+	assert.Nil(t, s.podStartTime(pod))
+}
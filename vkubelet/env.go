@@ -49,17 +49,23 @@ const (
 )
 
 // populateEnvironmentVariables populates the environment of each container (and init container) in the specified pod.
+// If masterServiceOverride is not nil, it is used in place of the "kubernetes" service when generating the "KUBERNETES_SERVICE_*" environment variables.
 // TODO Make this the single exported function of a "pkg/environment" package in the future.
-func populateEnvironmentVariables(ctx context.Context, pod *corev1.Pod, rm *manager.ResourceManager, recorder record.EventRecorder) error {
+func populateEnvironmentVariables(ctx context.Context, pod *corev1.Pod, rm *manager.ResourceManager, masterServiceOverride *corev1.Service, recorder record.EventRecorder) error {
+	// Create an "environment map" describing the services visible to the pod, which is shared by all its containers.
+	serviceEnv, err := makeServiceEnvironmentMap(pod, rm, masterServiceOverride)
+	if err != nil {
+		return err
+	}
 	// Populate each init container's environment.
 	for idx := range pod.Spec.InitContainers {
-		if err := populateContainerEnvironment(ctx, pod, &pod.Spec.InitContainers[idx], rm, recorder); err != nil {
+		if err := populateContainerEnvironment(ctx, pod, &pod.Spec.InitContainers[idx], rm, serviceEnv, recorder); err != nil {
 			return err
 		}
 	}
 	// Populate each container's environment.
 	for idx := range pod.Spec.Containers {
-		if err := populateContainerEnvironment(ctx, pod, &pod.Spec.Containers[idx], rm, recorder); err != nil {
+		if err := populateContainerEnvironment(ctx, pod, &pod.Spec.Containers[idx], rm, serviceEnv, recorder); err != nil {
 			return err
 		}
 	}
@@ -67,14 +73,16 @@ func populateEnvironmentVariables(ctx context.Context, pod *corev1.Pod, rm *mana
 }
 
 // populateContainerEnvironment populates the environment of a single container in the specified pod.
-func populateContainerEnvironment(ctx context.Context, pod *corev1.Pod, container *corev1.Container, rm *manager.ResourceManager, recorder record.EventRecorder) error {
+// serviceEnv holds the environment variables describing the services visible to the pod, as returned by makeServiceEnvironmentMap.
+func populateContainerEnvironment(ctx context.Context, pod *corev1.Pod, container *corev1.Container, rm *manager.ResourceManager, serviceEnv map[string]string, recorder record.EventRecorder) error {
 	// Create an "environment map" based on the value of the specified container's ".envFrom" field.
 	envFrom, err := makeEnvironmentMapBasedOnEnvFrom(ctx, pod, container, rm, recorder)
 	if err != nil {
 		return err
 	}
 	// Create an "environment map" based on the value of the specified container's ".env" field.
-	env, err := makeEnvironmentMapBasedOnEnv(ctx, pod, container, rm, recorder)
+	// References to other variables in the values of ".env" are expanded using "envFrom", "serviceEnv" and the preceding entries in ".env".
+	env, err := makeEnvironmentMapBasedOnEnv(ctx, pod, container, rm, envFrom, serviceEnv, recorder)
 	if err != nil {
 		return err
 	}
@@ -82,11 +90,46 @@ func populateContainerEnvironment(ctx context.Context, pod *corev1.Pod, containe
 	// Values in "env" (sourced from ".env") will override any values with the same key defined in "envFrom" (sourced from ".envFrom").
 	// This is in accordance with what the Kubelet itself does.
 	// https://github.com/kubernetes/kubernetes/blob/v1.13.1/pkg/kubelet/kubelet_pods.go#L557-L558
+	// Variables describing services are only added when not already defined by the container, as the Kubelet does.
+	// https://github.com/kubernetes/kubernetes/blob/v1.13.1/pkg/kubelet/kubelet_pods.go#L642-L650
 	container.EnvFrom = []corev1.EnvFromSource{}
 	container.Env = mergeEnvironments(envFrom, env)
+	// Iterate over the sorted keys of "serviceEnv" so that the resulting environment doesn't change between calls.
+	serviceKeys := make([]string, 0, len(serviceEnv))
+	for key := range serviceEnv {
+		serviceKeys = append(serviceKeys, key)
+	}
+	sort.Strings(serviceKeys)
+	for _, key := range serviceKeys {
+		if _, exists := envFrom[key]; exists {
+			continue
+		}
+		if _, exists := env[key]; exists {
+			continue
+		}
+		container.Env = append(container.Env, corev1.EnvVar{Name: key, Value: serviceEnv[key]})
+	}
+	// Expand references to environment variables in the container's command and arguments using its final environment, as the Kubelet does.
+	// https://github.com/kubernetes/kubernetes/blob/v1.13.1/pkg/kubelet/kuberuntime/kuberuntime_container.go#L193-L195
+	mapping := expansionMappingFor(env, envFrom, serviceEnv)
+	container.Command = expandAll(container.Command, mapping)
+	container.Args = expandAll(container.Args, mapping)
 	return nil
 }
 
+// expandAll returns a copy of the specified strings with their "$(VAR_NAME)" references expanded using the specified mapping function.
+// A copy is returned so that the expansion never applies twice to the same strings, as these may be shared with the informers' caches.
+func expandAll(input []string, mapping func(string) string) []string {
+	if input == nil {
+		return nil
+	}
+	res := make([]string, len(input))
+	for idx, val := range input {
+		res[idx] = expand(val, mapping)
+	}
+	return res
+}
+
 // makeEnvironmentMapBasedOnEnvFrom returns a map representing the resolved environment of the specified container after being populated from the entries in the ".envFrom" field.
 func makeEnvironmentMapBasedOnEnvFrom(ctx context.Context, pod *corev1.Pod, container *corev1.Container, rm *manager.ResourceManager, recorder record.EventRecorder) (map[string]string, error) {
 	// Create a map to hold the resulting environment.
@@ -210,7 +253,9 @@ loop:
 }
 
 // makeEnvironmentMapBasedOnEnv returns a map representing the resolved environment of the specified container after being populated from the entries in the ".env" field.
-func makeEnvironmentMapBasedOnEnv(ctx context.Context, pod *corev1.Pod, container *corev1.Container, rm *manager.ResourceManager, recorder record.EventRecorder) (map[string]string, error) {
+// "$(VAR_NAME)" references in the values of entries are expanded using the entries preceding them, then envFrom and serviceEnv, in accordance with what the Kubelet does.
+// https://github.com/kubernetes/kubernetes/blob/v1.13.1/pkg/kubelet/kubelet_pods.go#L590-L602
+func makeEnvironmentMapBasedOnEnv(ctx context.Context, pod *corev1.Pod, container *corev1.Container, rm *manager.ResourceManager, envFrom, serviceEnv map[string]string, recorder record.EventRecorder) (map[string]string, error) {
 	// Create a map to hold the resolved environment variables.
 	res := make(map[string]string, len(container.Env))
 	// Iterate over environment variables in order to populate the map.
@@ -219,7 +264,7 @@ loop:
 		switch {
 		// Handle values that have been directly provided.
 		case env.Value != "":
-			res[env.Name] = env.Value
+			res[env.Name] = expand(env.Value, expansionMappingFor(res, envFrom, serviceEnv))
 			continue loop
 		// Handle population from a configmap key.
 		case env.ValueFrom != nil && env.ValueFrom.ConfigMapKeyRef != nil:
@@ -189,7 +189,7 @@ func TestPopulatePodWithInitContainersUsingEnv(t *testing.T) {
 	}
 
 	// Populate the pod's environment.
-	err := populateEnvironmentVariables(context.Background(), pod, rm, er)
+	err := populateEnvironmentVariables(context.Background(), pod, rm, nil, er)
 	assert.NoError(t, err)
 
 	// Make sure that all the containers' environments contain all the expected keys and values.
@@ -305,7 +305,7 @@ func TestPopulatePodWithInitContainersUsingEnvFrom(t *testing.T) {
 	}
 
 	// Populate the pod's environment.
-	err := populateEnvironmentVariables(context.Background(), pod, rm, er)
+	err := populateEnvironmentVariables(context.Background(), pod, rm, nil, er)
 	assert.NoError(t, err)
 
 	// Make sure that all the containers' environments contain all the expected keys and values.
@@ -383,7 +383,7 @@ func TestEnvFromTwoConfigMapsAndOneSecret(t *testing.T) {
 	}
 
 	// Populate the container's environment.
-	err := populateContainerEnvironment(context.Background(), pod, &pod.Spec.Containers[0], rm, er)
+	err := populateContainerEnvironment(context.Background(), pod, &pod.Spec.Containers[0], rm, nil, er)
 	assert.NoError(t, err)
 
 	// Make sure that the container's environment contains all the expected keys and values.
@@ -444,7 +444,7 @@ func TestEnvFromConfigMapAndSecretWithInvalidKeys(t *testing.T) {
 	}
 
 	// Populate the pods's environment.
-	err := populateEnvironmentVariables(context.Background(), pod, rm, er)
+	err := populateEnvironmentVariables(context.Background(), pod, rm, nil, er)
 	assert.NoError(t, err)
 
 	// Make sure that the container's environment has two variables (corresponding to the single valid key in both the configmap and the secret).
@@ -514,7 +514,7 @@ func TestEnvOverridesEnvFrom(t *testing.T) {
 	}
 
 	// Populate the pods's environment.
-	err := populateEnvironmentVariables(context.Background(), pod, rm, er)
+	err := populateEnvironmentVariables(context.Background(), pod, rm, nil, er)
 	assert.NoError(t, err)
 
 	// Make sure that the container's environment contains all the expected keys and values.
@@ -578,7 +578,7 @@ func TestEnvFromInexistentConfigMaps(t *testing.T) {
 	}
 
 	// Populate the pods's environment.
-	err := populateEnvironmentVariables(context.Background(), pod, rm, er)
+	err := populateEnvironmentVariables(context.Background(), pod, rm, nil, er)
 	assert.Error(t, err)
 
 	// Make sure that two events have been recorded with the correct reason and message.
@@ -634,7 +634,7 @@ func TestEnvFromInexistentSecrets(t *testing.T) {
 	}
 
 	// Populate the pods's environment.
-	err := populateEnvironmentVariables(context.Background(), pod, rm, er)
+	err := populateEnvironmentVariables(context.Background(), pod, rm, nil, er)
 	assert.Error(t, err)
 
 	// Make sure that two events have been recorded with the correct reason and message.
@@ -686,7 +686,7 @@ func TestEnvReferencingInexistentConfigMapKey(t *testing.T) {
 	}
 
 	// Populate the pods's environment.
-	err := populateEnvironmentVariables(context.Background(), pod, rm, er)
+	err := populateEnvironmentVariables(context.Background(), pod, rm, nil, er)
 	assert.Error(t, err)
 
 	// Make sure that two events have been recorded with the correct reason and message.
@@ -735,7 +735,7 @@ func TestEnvReferencingInexistentSecretKey(t *testing.T) {
 	}
 
 	// Populate the pods's environment.
-	err := populateEnvironmentVariables(context.Background(), pod, rm, er)
+	err := populateEnvironmentVariables(context.Background(), pod, rm, nil, er)
 	assert.Error(t, err)
 
 	// Make sure that two events have been recorded with the correct reason and message.
@@ -0,0 +1,74 @@
+package vkubelet
+
+import (
+	"bytes"
+)
+
+const (
+	// expansionOperator is the character introducing a variable reference, or escaping another operator.
+	expansionOperator = '$'
+	// expansionReferenceOpener is the character opening the name of a referenced variable.
+	expansionReferenceOpener = '('
+	// expansionReferenceCloser is the character closing the name of a referenced variable.
+	expansionReferenceCloser = ')'
+)
+
+// expansionMappingFor returns a function which returns the value of the specified variable in the first of the specified environments defining it.
+// References to variables which aren't defined by any of the environments are left untouched, as the kubelet does.
+func expansionMappingFor(envs ...map[string]string) func(string) string {
+	return func(name string) string {
+		for _, env := range envs {
+			if val, ok := env[name]; ok {
+				return val
+			}
+		}
+		return string(expansionOperator) + string(expansionReferenceOpener) + name + string(expansionReferenceCloser)
+	}
+}
+
+// expand replaces the "$(VAR_NAME)" references in the specified string with the values returned by the specified mapping function, in the same way the kubelet does.
+// "$$" is an escaped "$", so "$$(VAR_NAME)" expands to the literal "$(VAR_NAME)", and any other use of "$" is left untouched.
+// https://github.com/kubernetes/kubernetes/blob/v1.13.1/third_party/forked/golang/expansion/expand.go
+func expand(input string, mapping func(string) string) string {
+	var buf bytes.Buffer
+	checkpoint := 0
+	for cursor := 0; cursor < len(input); cursor++ {
+		if input[cursor] == expansionOperator && cursor+1 < len(input) {
+			// Copy the portion of the input preceding the operator.
+			buf.WriteString(input[checkpoint:cursor])
+			read, isVar, advance := tryReadVariableName(input[cursor+1:])
+			if isVar {
+				buf.WriteString(mapping(read))
+			} else {
+				buf.WriteString(read)
+			}
+			// Skip what has just been read.
+			cursor += advance
+			checkpoint = cursor + 1
+		}
+	}
+	// Copy the remainder of the input.
+	return buf.String() + input[checkpoint:]
+}
+
+// tryReadVariableName reads what follows an operator in the specified (non-empty) string.
+// It returns the name of the referenced variable (or the literal to write, if there is no reference), whether a variable is referenced, and the number of bytes read.
+func tryReadVariableName(input string) (string, bool, int) {
+	switch input[0] {
+	case expansionOperator:
+		// "$$" is an escaped operator.
+		return input[0:1], false, 1
+	case expansionReferenceOpener:
+		// Look for the end of the variable name.
+		for i := 1; i < len(input); i++ {
+			if input[i] == expansionReferenceCloser {
+				return input[1:i], true, i + 1
+			}
+		}
+		// The reference isn't closed, so the operator and opener are written verbatim.
+		return string(expansionOperator) + string(expansionReferenceOpener), false, 1
+	default:
+		// The operator isn't followed by a reference, so it is written verbatim along with the following character.
+		return string(expansionOperator) + string(input[0]), false, 1
+	}
+}
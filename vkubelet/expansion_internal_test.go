@@ -0,0 +1,100 @@
+package vkubelet
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+
+	testutil "github.com/virtual-kubelet/virtual-kubelet/test/util"
+)
+
+// TestExpand verifies that "$(VAR_NAME)" references are expanded according to the same rules as in the kubelet.
+func TestExpand(t *testing.T) {
+	mapping := expansionMappingFor(map[string]string{
+		"VAR_A":     "A",
+		"VAR_B":     "B",
+		"VAR_EMPTY": "",
+		"VAR_REF":   "$(VAR_A)",
+	}, map[string]string{
+		"VAR_A": "shadowed",
+		"VAR_C": "C",
+	})
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"", ""},
+		{"no references", "no references"},
+		{"$(VAR_A)", "A"},
+		{"___$(VAR_B)___", "___B___"},
+		{"$(VAR_A)-$(VAR_B)-$(VAR_C)", "A-B-C"},
+		{"$(VAR_EMPTY)", ""},
+		// Expanded values are not expanded again.
+		{"$(VAR_REF)", "$(VAR_A)"},
+		// References to undefined variables are left untouched.
+		{"$(UNDEFINED)", "$(UNDEFINED)"},
+		{"$()", "$()"},
+		// "$$" is an escaped "$".
+		{"$$(VAR_A)", "$(VAR_A)"},
+		{"$$$(VAR_A)", "$A"},
+		{"$$$$(VAR_A)", "$$(VAR_A)"},
+		{"$$", "$"},
+		// Any other use of "$" is left untouched.
+		{"$VAR_A", "$VAR_A"},
+		{"${VAR_A}", "${VAR_A}"},
+		{"$", "$"},
+		{"foo$", "foo$"},
+		{"$(VAR_A", "$(VAR_A"},
+		{"$(VAR_A)$(", "A$("},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, expand(test.input, mapping), "input: %q", test.input)
+	}
+}
+
+// TestPopulateEnvironmentVariablesExpandsReferences verifies that references in ".env", ".command" and ".args" are expanded using the resolved environment, and that the values in ".env" only see the entries preceding them.
+func TestPopulateEnvironmentVariablesExpandsReferences(t *testing.T) {
+	rm := testutil.FakeResourceManager(configMap1, masterService)
+	er := record.NewFakeRecorder(defaultEventRecorderBufferSize)
+
+	command := []string{"/bin/sh", "-c", "echo $(FOO) $(LATER) $$(FOO)"}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{
+					Command: command,
+					Args:    []string{"--endpoint=https://$(KUBERNETES_SERVICE_HOST):$(KUBERNETES_SERVICE_PORT)", "$(FROM_CONFIGMAP_1_FOO)"},
+					EnvFrom: []corev1.EnvFromSource{
+						{
+							Prefix: prefixConfigMap1,
+							ConfigMapRef: &corev1.ConfigMapEnvSource{
+								LocalObjectReference: corev1.LocalObjectReference{Name: configMap1.Name},
+							},
+						},
+					},
+					Env: []corev1.EnvVar{
+						{Name: "FOO", Value: "foo-$(FROM_CONFIGMAP_1_FOO)"},
+						{Name: "BAR", Value: "$(FOO)/$(LATER)/$(KUBERNETES_SERVICE_HOST)"},
+						{Name: "LATER", Value: "later"},
+					},
+				},
+			},
+		},
+	}
+	require.NoError(t, populateEnvironmentVariables(context.Background(), pod, rm, nil, er))
+
+	c := pod.Spec.Containers[0]
+	env := envMap(c.Env)
+	assert.Equal(t, "foo-__foo__", env["FOO"])
+	assert.Equal(t, "foo-__foo__/$(LATER)/10.0.0.1", env["BAR"])
+	assert.Equal(t, []string{"/bin/sh", "-c", "echo foo-__foo__ later $(FOO)"}, c.Command)
+	assert.Equal(t, []string{"--endpoint=https://10.0.0.1:443", "__foo__"}, c.Args)
+	// The original command is left untouched, as it may be shared with the informers' caches.
+	assert.Equal(t, "echo $(FOO) $(LATER) $$(FOO)", command[2])
+}
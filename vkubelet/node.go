@@ -11,6 +11,8 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
 )
 
 var (
@@ -94,16 +96,40 @@ func (s *Server) updateNode(ctx context.Context) {
 		return
 	}
 
-	n.ResourceVersion = "" // Blank out resource version to prevent object has been modified error
-	n.Status.Conditions = s.provider.NodeConditions(ctx)
-
+	// Grab the node's status from the provider once, as doing so may be expensive.
+	conditions := s.provider.NodeConditions(ctx)
 	capacity := s.provider.Capacity(ctx)
-	n.Status.Capacity = capacity
-	n.Status.Allocatable = capacity
-
-	n.Status.Addresses = s.provider.NodeAddresses(ctx)
+	addresses := s.provider.NodeAddresses(ctx)
+
+	err = retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		desired := n.Status.DeepCopy()
+		desired.Conditions = mergeNodeConditions(n.Status.Conditions, conditions)
+		desired.Capacity = capacity
+		desired.Allocatable = capacity
+		desired.Addresses = addresses
+
+		// Only send the fields that have changed so that fields set by other writers (e.g. the node lifecycle controller) are not clobbered.
+		patch, err := createStrategicMergePatch(corev1.Node{Status: n.Status}, corev1.Node{Status: *desired}, corev1.Node{}, n.ResourceVersion)
+		if err != nil {
+			return err
+		}
+		if isEmptyPatch(patch) {
+			span.Annotate(nil, "Node status is unchanged")
+			return nil
+		}
 
-	n, err = s.k8sClient.CoreV1().Nodes().UpdateStatus(n)
+		_, err = s.k8sClient.CoreV1().Nodes().Patch(n.Name, types.StrategicMergePatchType, patch, "status")
+		if errors.IsConflict(err) {
+			// The node has been modified since we last read it, so we grab its latest version and try again.
+			span.Annotate(nil, "Conflict while patching node status, retrying")
+			latest, getErr := s.k8sClient.CoreV1().Nodes().Get(n.Name, opts)
+			if getErr != nil {
+				return getErr
+			}
+			n = latest
+		}
+		return err
+	})
 	if err != nil {
 		log.G(ctx).WithError(err).Error("Failed to update node")
 		span.SetStatus(trace.Status{Code: trace.StatusCodeUnknown, Message: err.Error()})
@@ -111,6 +137,28 @@ func (s *Server) updateNode(ctx context.Context) {
 	}
 }
 
+// mergeNodeConditions returns the conditions reported by the provider, along with any existing condition of a type the provider doesn't report (e.g. conditions set by node problem detectors).
+// The transition time of conditions whose status hasn't changed is preserved.
+func mergeNodeConditions(existing, reported []corev1.NodeCondition) []corev1.NodeCondition {
+	res := make([]corev1.NodeCondition, 0, len(existing)+len(reported))
+	seen := make(map[corev1.NodeConditionType]bool, len(reported))
+	for _, c := range reported {
+		seen[c.Type] = true
+		for _, old := range existing {
+			if old.Type == c.Type && old.Status == c.Status && !old.LastTransitionTime.IsZero() {
+				c.LastTransitionTime = old.LastTransitionTime
+			}
+		}
+		res = append(res, c)
+	}
+	for _, c := range existing {
+		if !seen[c.Type] {
+			res = append(res, c)
+		}
+	}
+	return res
+}
+
 type taintsStringer []corev1.Taint
 
 func (t taintsStringer) String() string {
@@ -0,0 +1,47 @@
+package vkubelet
+
+import (
+	"encoding/json"
+
+	pkgerrors "github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+)
+
+// createStrategicMergePatch creates a strategic merge patch that transforms "original" into "modified", which must both be of the same type as "dataStruct".
+// Unless the resulting patch is empty, the specified resource version (if any) is added to it as a precondition.
+// This causes the API server to reject the patch with a conflict if the object has been modified since "original" was read, instead of silently applying a patch computed against stale data.
+func createStrategicMergePatch(original, modified, dataStruct interface{}, resourceVersion string) ([]byte, error) {
+	oldData, err := json.Marshal(original)
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "failed to marshal the original object")
+	}
+	newData, err := json.Marshal(modified)
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "failed to marshal the modified object")
+	}
+	patch, err := strategicpatch.CreateTwoWayMergePatch(oldData, newData, dataStruct)
+	if err != nil {
+		return nil, err
+	}
+	if isEmptyPatch(patch) || resourceVersion == "" {
+		return patch, nil
+	}
+
+	// Add the resource version precondition to the patch.
+	var p map[string]interface{}
+	if err := json.Unmarshal(patch, &p); err != nil {
+		return nil, pkgerrors.Wrap(err, "failed to unmarshal patch")
+	}
+	metadata, ok := p["metadata"].(map[string]interface{})
+	if !ok {
+		metadata = make(map[string]interface{})
+		p["metadata"] = metadata
+	}
+	metadata["resourceVersion"] = resourceVersion
+	return json.Marshal(p)
+}
+
+// isEmptyPatch returns whether the specified patch is a no-op.
+func isEmptyPatch(patch []byte) bool {
+	return string(patch) == "{}"
+}
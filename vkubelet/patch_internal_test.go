@@ -0,0 +1,234 @@
+package vkubelet
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/virtual-kubelet/virtual-kubelet/providers"
+	testutil "github.com/virtual-kubelet/virtual-kubelet/test/util"
+)
+
+const (
+	// nodeConditionFromOtherWriter is the type of a node condition set by a writer other than the virtual-kubelet (e.g. a node problem detector).
+	nodeConditionFromOtherWriter = corev1.NodeConditionType("example.com/Problem")
+)
+
+// newFakeClientset returns a fake clientset backed by an object tracker containing the specified objects.
+// Unlike the one created by "fake.NewSimpleClientset", it bumps the resource version of objects upon patching, and rejects patches whose resource version precondition doesn't match with a conflict, as the API server does.
+func newFakeClientset(objects ...runtime.Object) (*fake.Clientset, k8stesting.ObjectTracker) {
+	tracker := k8stesting.NewObjectTracker(scheme.Scheme, scheme.Codecs.UniversalDecoder())
+	for _, obj := range objects {
+		if err := tracker.Add(obj); err != nil {
+			panic(err)
+		}
+	}
+	reaction := k8stesting.ObjectReaction(tracker)
+
+	cs := &fake.Clientset{}
+	cs.AddReactor("patch", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		patch := action.(k8stesting.PatchActionImpl)
+		obj, err := tracker.Get(patch.GetResource(), patch.GetNamespace(), patch.GetName())
+		if err != nil {
+			return true, nil, err
+		}
+		current, err := meta.Accessor(obj)
+		if err != nil {
+			return true, nil, err
+		}
+		var precondition struct {
+			Metadata struct {
+				ResourceVersion string `json:"resourceVersion"`
+			} `json:"metadata"`
+		}
+		if err := json.Unmarshal(patch.GetPatch(), &precondition); err != nil {
+			return true, nil, err
+		}
+		if rv := precondition.Metadata.ResourceVersion; rv != "" && rv != current.GetResourceVersion() {
+			return true, nil, errors.NewConflict(patch.GetResource().GroupResource(), patch.GetName(), nil)
+		}
+
+		// The patch is applied to a new object, as the reaction of the object tracker wouldn't clear the fields removed by the patch.
+		data, err := json.Marshal(obj)
+		if err != nil {
+			return true, nil, err
+		}
+		data, err = strategicpatch.StrategicMergePatch(data, patch.GetPatch(), obj)
+		if err != nil {
+			return true, nil, err
+		}
+		res := reflect.New(reflect.TypeOf(obj).Elem()).Interface().(runtime.Object)
+		if err := json.Unmarshal(data, res); err != nil {
+			return true, nil, err
+		}
+		patched, err := meta.Accessor(res)
+		if err != nil {
+			return true, nil, err
+		}
+		patched.SetResourceVersion(nextResourceVersion(current.GetResourceVersion()))
+		return true, res, tracker.Update(patch.GetResource(), res, patch.GetNamespace())
+	})
+	cs.AddReactor("*", "*", reaction)
+	return cs, tracker
+}
+
+// nextResourceVersion returns the resource version following the specified one.
+func nextResourceVersion(rv string) string {
+	v, _ := strconv.Atoi(rv)
+	return strconv.Itoa(v + 1)
+}
+
+// countPatches returns the number of patch requests issued using the specified fake clientset.
+func countPatches(cs *fake.Clientset) int {
+	n := 0
+	for _, action := range cs.Actions() {
+		if action.GetVerb() == "patch" {
+			n++
+		}
+	}
+	return n
+}
+
+// fakeNodeProvider is a provider reporting a fixed node status.
+type fakeNodeProvider struct {
+	providers.Provider
+	conditions []corev1.NodeCondition
+	capacity   corev1.ResourceList
+	addresses  []corev1.NodeAddress
+}
+
+func (p *fakeNodeProvider) NodeConditions(ctx context.Context) []corev1.NodeCondition {
+	return p.conditions
+}
+
+func (p *fakeNodeProvider) Capacity(ctx context.Context) corev1.ResourceList {
+	return p.capacity
+}
+
+func (p *fakeNodeProvider) NodeAddresses(ctx context.Context) []corev1.NodeAddress {
+	return p.addresses
+}
+
+// TestPatchPodStatusRetriesOnConflict verifies that the pod status patch is retried against the latest version of the pod when it has been modified concurrently, and that the fields set by the other writer are preserved.
+func TestPatchPodStatusRetriesOnConflict(t *testing.T) {
+	pod := testutil.FakePodWithSingleContainer(namespace, "pod-0", "image-0")
+	pod.ResourceVersion = "1"
+	pod.Status.Phase = corev1.PodPending
+	cs, tracker := newFakeClientset(pod)
+	s := &Server{k8sClient: cs}
+
+	// Another writer sets a readiness gate condition after we've read the pod.
+	latest := pod.DeepCopy()
+	latest.ResourceVersion = "2"
+	latest.Status.Conditions = []corev1.PodCondition{
+		{Type: readinessGate1, Status: corev1.ConditionTrue},
+	}
+	require.NoError(t, tracker.Update(corev1.SchemeGroupVersion.WithResource("pods"), latest, namespace))
+
+	patched, err := s.patchPodStatus(context.Background(), pod, func(pod *corev1.Pod) {
+		pod.Status.Phase = corev1.PodRunning
+	})
+	require.NoError(t, err)
+	require.NotNil(t, patched)
+	assert.Equal(t, 2, countPatches(cs))
+
+	res, err := cs.CoreV1().Pods(namespace).Get(pod.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, corev1.PodRunning, res.Status.Phase)
+	if c := findPodCondition(res.Status.Conditions, readinessGate1); assert.NotNil(t, c) {
+		assert.Equal(t, corev1.ConditionTrue, c.Status)
+	}
+}
+
+// TestPatchPodStatusUnchanged verifies that no request is issued when the pod's status doesn't change.
+func TestPatchPodStatusUnchanged(t *testing.T) {
+	pod := testutil.FakePodWithSingleContainer(namespace, "pod-0", "image-0")
+	pod.ResourceVersion = "1"
+	pod.Status.Phase = corev1.PodRunning
+	cs, _ := newFakeClientset(pod)
+	s := &Server{k8sClient: cs}
+
+	patched, err := s.patchPodStatus(context.Background(), pod, func(pod *corev1.Pod) {
+		pod.Status.Phase = corev1.PodRunning
+	})
+	assert.NoError(t, err)
+	assert.Nil(t, patched)
+	assert.Empty(t, cs.Actions())
+}
+
+// TestUpdateNodeRetriesOnConflict verifies that the node status patch is retried when the node has been modified concurrently, and that node conditions not reported by the provider are preserved.
+func TestUpdateNodeRetriesOnConflict(t *testing.T) {
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "vk",
+			ResourceVersion: "1",
+		},
+	}
+	cs, tracker := newFakeClientset(node)
+	s := &Server{
+		nodeName:  node.Name,
+		k8sClient: cs,
+		provider: &fakeNodeProvider{
+			conditions: []corev1.NodeCondition{
+				{Type: corev1.NodeReady, Status: corev1.ConditionTrue},
+			},
+			capacity: corev1.ResourceList{
+				corev1.ResourcePods: resource.MustParse("20"),
+			},
+			addresses: []corev1.NodeAddress{
+				{Type: corev1.NodeInternalIP, Address: "10.0.0.1"},
+			},
+		},
+	}
+
+	// Another writer sets a node condition right before our first patch reaches the API server.
+	conflicted := false
+	cs.PrependReactor("patch", "nodes", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if !conflicted {
+			conflicted = true
+			latest := node.DeepCopy()
+			latest.ResourceVersion = "2"
+			latest.Status.Conditions = []corev1.NodeCondition{
+				{Type: nodeConditionFromOtherWriter, Status: corev1.ConditionTrue},
+			}
+			if err := tracker.Update(corev1.SchemeGroupVersion.WithResource("nodes"), latest, ""); err != nil {
+				return true, nil, err
+			}
+		}
+		return false, nil, nil
+	})
+
+	s.updateNode(context.Background())
+	assert.Equal(t, 2, countPatches(cs))
+
+	res, err := cs.CoreV1().Nodes().Get(node.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	statuses := make(map[corev1.NodeConditionType]corev1.ConditionStatus)
+	for _, c := range res.Status.Conditions {
+		statuses[c.Type] = c.Status
+	}
+	assert.Equal(t, map[corev1.NodeConditionType]corev1.ConditionStatus{
+		corev1.NodeReady:             corev1.ConditionTrue,
+		nodeConditionFromOtherWriter: corev1.ConditionTrue,
+	}, statuses)
+	pods := res.Status.Capacity[corev1.ResourcePods]
+	assert.Equal(t, int64(20), pods.Value())
+	pods = res.Status.Allocatable[corev1.ResourcePods]
+	assert.Equal(t, int64(20), pods.Value())
+	assert.Equal(t, []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "10.0.0.1"}}, res.Status.Addresses)
+}
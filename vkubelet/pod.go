@@ -15,6 +15,7 @@ import (
 	"k8s.io/client-go/tools/record"
 
 	"github.com/virtual-kubelet/virtual-kubelet/log"
+	"github.com/virtual-kubelet/virtual-kubelet/providers"
 )
 
 func addPodAttributes(span *trace.Span, pod *corev1.Pod) {
@@ -42,7 +43,7 @@ func (s *Server) createOrUpdatePod(ctx context.Context, pod *corev1.Pod, recorde
 	defer span.End()
 	addPodAttributes(span, pod)
 
-	if err := populateEnvironmentVariables(ctx, pod, s.resourceManager, recorder); err != nil {
+	if err := populateEnvironmentVariables(ctx, pod, s.resourceManager, s.masterServiceOverride, recorder); err != nil {
 		span.SetStatus(trace.Status{Code: trace.StatusCodeInvalidArgument, Message: err.Error()})
 		return err
 	}
@@ -50,17 +51,20 @@ func (s *Server) createOrUpdatePod(ctx context.Context, pod *corev1.Pod, recorde
 	logger := log.G(ctx).WithField("pod", pod.GetName()).WithField("namespace", pod.GetNamespace())
 
 	if origErr := s.provider.CreatePod(ctx, pod); origErr != nil {
+		class := providers.ClassifyError(origErr)
 		podPhase := corev1.PodPending
-		if pod.Spec.RestartPolicy == corev1.RestartPolicyNever {
+		// Pods the provider deems invalid can never be run, so they are failed immediately.
+		// Pods failing for any other reason are left pending, as creating them is retried.
+		if class == providers.ErrorClassInvalidSpec {
 			podPhase = corev1.PodFailed
 		}
+		recorder.Eventf(pod, corev1.EventTypeWarning, podEventReasonProviderCreateFailed, "Failed to create pod in the provider (%s): %v", class, origErr)
 
-		pod.ResourceVersion = "" // Blank out resource version to prevent object has been modified error
-		pod.Status.Phase = podPhase
-		pod.Status.Reason = podStatusReasonProviderFailed
-		pod.Status.Message = origErr.Error()
-
-		_, err := s.k8sClient.CoreV1().Pods(pod.Namespace).UpdateStatus(pod)
+		_, err := s.patchPodStatus(ctx, pod, func(pod *corev1.Pod) {
+			pod.Status.Phase = podPhase
+			pod.Status.Reason = podStatusReasonProviderFailed
+			pod.Status.Message = origErr.Error()
+		})
 		if err != nil {
 			logger.WithError(err).Warn("Failed to update pod status")
 		} else {
@@ -71,6 +75,17 @@ func (s *Server) createOrUpdatePod(ctx context.Context, pod *corev1.Pod, recorde
 		return origErr
 	}
 	span.Annotate(nil, "Created pod in provider")
+	s.trackPodStartTime(pod)
+
+	// Clear the reason set by a previous failed attempt, as the status of the pod wouldn't be synced otherwise.
+	if pod.Status.Reason == podStatusReasonProviderFailed {
+		if _, err := s.patchPodStatus(ctx, pod, func(pod *corev1.Pod) {
+			pod.Status.Reason = ""
+			pod.Status.Message = ""
+		}); err != nil {
+			logger.WithError(err).Warn("Failed to update pod status")
+		}
+	}
 
 	logger.Info("Pod created")
 
@@ -91,22 +106,24 @@ func (s *Server) deletePod(ctx context.Context, namespace, name string) error {
 	defer span.End()
 	addPodAttributes(span, pod)
 
-	var delErr error
-	if delErr = s.provider.DeletePod(ctx, pod); delErr != nil && errors.IsNotFound(delErr) {
-		span.SetStatus(trace.Status{Code: trace.StatusCodeUnknown, Message: delErr.Error()})
+	// A pod that is not found in the provider has already been deleted, which is what we want.
+	if delErr := s.provider.DeletePod(ctx, pod); delErr != nil && providers.ClassifyError(delErr) != providers.ErrorClassNotFound {
+		span.SetStatus(ocstatus.FromError(delErr))
 		return delErr
 	}
 	span.Annotate(nil, "Deleted pod from provider")
+	s.forgetPodStartTime(pod)
+	if s.prober != nil {
+		s.prober.forget(pod)
+	}
 
 	logger := log.G(ctx).WithField("pod", pod.GetName()).WithField("namespace", pod.GetNamespace())
-	if !errors.IsNotFound(delErr) {
-		if err := s.forceDeletePodResource(ctx, namespace, name); err != nil {
-			span.SetStatus(ocstatus.FromError(err))
-			return err
-		}
-		span.Annotate(nil, "Deleted pod from k8s")
-		logger.Info("Pod deleted")
+	if err := s.forceDeletePodResource(ctx, namespace, name); err != nil {
+		span.SetStatus(ocstatus.FromError(err))
+		return err
 	}
+	span.Annotate(nil, "Deleted pod from k8s")
+	logger.Info("Pod deleted")
 
 	return nil
 }
@@ -132,7 +149,7 @@ func (s *Server) forceDeletePodResource(ctx context.Context, namespace, name str
 }
 
 // updatePodStatuses syncs the providers pod status with the kubernetes pod status.
-func (s *Server) updatePodStatuses(ctx context.Context) {
+func (s *Server) updatePodStatuses(ctx context.Context, recorder record.EventRecorder) {
 	ctx, span := trace.StartSpan(ctx, "updatePodStatuses")
 	defer span.End()
 
@@ -156,7 +173,7 @@ func (s *Server) updatePodStatuses(ctx context.Context) {
 			}
 			defer func() { <-sema }()
 
-			if err := s.updatePodStatus(ctx, pod); err != nil {
+			if err := s.updatePodStatus(ctx, pod, recorder); err != nil {
 				logger := log.G(ctx).WithField("pod", pod.GetName()).WithField("namespace", pod.GetNamespace()).WithField("status", pod.Status.Phase).WithField("reason", pod.Status.Reason)
 				logger.Error(err)
 			}
@@ -167,7 +184,7 @@ func (s *Server) updatePodStatuses(ctx context.Context) {
 	wg.Wait()
 }
 
-func (s *Server) updatePodStatus(ctx context.Context, pod *corev1.Pod) error {
+func (s *Server) updatePodStatus(ctx context.Context, pod *corev1.Pod, recorder record.EventRecorder) error {
 	ctx, span := trace.StartSpan(ctx, "updatePodStatus")
 	defer span.End()
 	addPodAttributes(span, pod)
@@ -178,41 +195,74 @@ func (s *Server) updatePodStatus(ctx context.Context, pod *corev1.Pod) error {
 		return nil
 	}
 
+	// Terminate the pod if it has been active for longer than its ".spec.activeDeadlineSeconds".
+	if exceeded, err := s.enforceActiveDeadline(ctx, pod, recorder); exceeded || err != nil {
+		if err != nil {
+			span.SetStatus(ocstatus.FromError(err))
+		}
+		return err
+	}
+
 	status, err := s.provider.GetPodStatus(ctx, pod.Namespace, pod.Name)
 	if err != nil {
 		span.SetStatus(ocstatus.FromError(err))
 		return pkgerrors.Wrap(err, "error retreiving pod status")
 	}
 
-	// Update the pod's status
+	var mutate func(*corev1.Pod)
 	if status != nil {
-		pod.Status = *status
+		// Work on a copy of the pod so that we don't mutate the informer's cache.
+		reported := pod.DeepCopy()
+		reported.Status = *status
+		// Fall back to the time at which the pod was created in the provider if the provider does not report a start time.
+		if reported.Status.StartTime == nil {
+			reported.Status.StartTime = s.podStartTime(pod)
+		}
+		// Run liveness and readiness probes on behalf of the provider, if required.
+		if s.prober != nil {
+			s.prober.probe(ctx, reported, recorder)
+		}
+		mutate = func(pod *corev1.Pod) {
+			// Preserve conditions owned by other controllers and evaluate readiness gates.
+			pod.Status = mergePodStatus(pod, pod.Status, reported.Status)
+		}
 	} else {
-		// Only change the status when the pod was already up
-		// Only doing so when the pod was successfully running makes sure we don't run into race conditions during pod creation.
-		if pod.Status.Phase == corev1.PodRunning || pod.ObjectMeta.CreationTimestamp.Add(time.Minute).Before(time.Now()) {
-			// Set the pod to failed, this makes sure if the underlying container implementation is gone that a new pod will be created.
-			pod.Status.Phase = corev1.PodFailed
-			pod.Status.Reason = "NotFound"
-			pod.Status.Message = "The pod status was not found and may have been deleted from the provider"
-			for i, c := range pod.Status.ContainerStatuses {
-				pod.Status.ContainerStatuses[i].State.Terminated = &corev1.ContainerStateTerminated{
-					ExitCode:    -137,
-					Reason:      "NotFound",
-					Message:     "Container was not found and was likely deleted",
-					FinishedAt:  metav1.NewTime(time.Now()),
-					StartedAt:   c.State.Running.StartedAt,
-					ContainerID: c.ContainerID,
+		mutate = func(pod *corev1.Pod) {
+			// Only change the status when the pod was already up
+			// Only doing so when the pod was successfully running makes sure we don't run into race conditions during pod creation.
+			if pod.Status.Phase == corev1.PodRunning || pod.ObjectMeta.CreationTimestamp.Add(time.Minute).Before(time.Now()) {
+				// Set the pod to failed, this makes sure if the underlying container implementation is gone that a new pod will be created.
+				pod.Status.Phase = corev1.PodFailed
+				pod.Status.Reason = "NotFound"
+				pod.Status.Message = "The pod status was not found and may have been deleted from the provider"
+				for i, c := range pod.Status.ContainerStatuses {
+					var startedAt metav1.Time
+					if c.State.Running != nil {
+						startedAt = c.State.Running.StartedAt
+					}
+					pod.Status.ContainerStatuses[i].State.Terminated = &corev1.ContainerStateTerminated{
+						ExitCode:    -137,
+						Reason:      "NotFound",
+						Message:     "Container was not found and was likely deleted",
+						FinishedAt:  metav1.NewTime(time.Now()),
+						StartedAt:   startedAt,
+						ContainerID: c.ContainerID,
+					}
+					pod.Status.ContainerStatuses[i].State.Running = nil
 				}
-				pod.Status.ContainerStatuses[i].State.Running = nil
 			}
 		}
 	}
 
-	if _, err := s.k8sClient.CoreV1().Pods(pod.Namespace).UpdateStatus(pod); err != nil {
+	// Only send the fields that have changed, and skip the update altogether if nothing has.
+	pod, err = s.patchPodStatus(ctx, pod, mutate)
+	if err != nil {
 		span.SetStatus(ocstatus.FromError(err))
 		return pkgerrors.Wrap(err, "error while updating pod status in kubernetes")
 	}
+	if pod == nil {
+		return nil
+	}
 
 	span.Annotate([]trace.Attribute{
 		trace.StringAttribute("new phase", string(pod.Status.Phase)),
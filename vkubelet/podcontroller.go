@@ -37,11 +37,15 @@ import (
 	"k8s.io/client-go/util/workqueue"
 
 	"github.com/virtual-kubelet/virtual-kubelet/log"
+	"github.com/virtual-kubelet/virtual-kubelet/providers"
 )
 
 const (
 	// maxRetries is the number of times we try to process a given key before permanently forgetting it.
+	// Only failures caused by transient errors count towards this limit.
 	maxRetries = 20
+	// quotaExceededRequeuePeriod is the delay after which we retry processing a given key that failed to sync because the provider's quota is exhausted.
+	quotaExceededRequeuePeriod = 1 * time.Minute
 )
 
 // PodController is the controller implementation for Pod resources.
@@ -193,15 +197,7 @@ func (pc *PodController) processNextWorkItem(ctx context.Context, workerId strin
 		span.AddAttributes(trace.StringAttribute("key", key))
 		// Run the syncHandler, passing it the namespace/name string of the Pod resource to be synced.
 		if err := pc.syncHandler(ctx, key); err != nil {
-			if pc.workqueue.NumRequeues(key) < maxRetries {
-				// Put the item back on the work queue to handle any transient errors.
-				log.G(ctx).Warnf("requeuing %q due to failed sync: %v", key, err)
-				pc.workqueue.AddRateLimited(key)
-				return nil
-			}
-			// We've exceeded the maximum retries, so we must forget the key.
-			pc.workqueue.Forget(key)
-			return pkgerrors.Wrapf(err, "forgetting %q due to maximum retries reached", key)
+			return pc.handleSyncError(ctx, key, err)
 		}
 		// Finally, if no error occurs we Forget this item so it does not get queued again until another change happens.
 		pc.workqueue.Forget(obj)
@@ -218,6 +214,62 @@ func (pc *PodController) processNextWorkItem(ctx context.Context, workerId strin
 	return true
 }
 
+// deletePodError wraps the errors that caused deleting a pod to fail, so that handleSyncError can tell them from the errors that caused creating it to fail.
+type deletePodError struct {
+	error
+}
+
+// Cause returns the wrapped error, so that the error can still be classified.
+func (e deletePodError) Cause() error {
+	return e.error
+}
+
+// handleSyncError decides whether and when to retry syncing the pod with the specified key, based on the class of the error that caused the sync to fail.
+// See the documentation of the "providers" package for the list of error classes.
+func (pc *PodController) handleSyncError(ctx context.Context, key string, err error) error {
+	span := trace.FromContext(ctx)
+	class := providers.ClassifyError(err)
+	span.AddAttributes(trace.StringAttribute("errorClass", string(class)))
+
+	switch class {
+	case providers.ErrorClassThrottled:
+		// Retry once the delay indicated by the provider has elapsed, without counting this attempt towards the maximum number of retries.
+		delay, _ := providers.RetryAfter(err)
+		if delay <= 0 {
+			// Requeuing right away would retry in a hot loop (e.g. on "Retry-After: 0"), so we back off as for transient errors instead.
+			log.G(ctx).Warnf("requeuing %q with a back-off as the provider is throttling requests: %v", key, err)
+			pc.workqueue.AddRateLimited(key)
+			return nil
+		}
+		log.G(ctx).Warnf("requeuing %q in %s as the provider is throttling requests: %v", key, delay, err)
+		pc.workqueue.AddAfter(key, delay)
+		return nil
+	case providers.ErrorClassQuotaExceeded:
+		// Quota may become available at any time (e.g. when other pods are deleted), so we keep on retrying periodically.
+		log.G(ctx).Warnf("requeuing %q in %s as the provider's quota is exhausted: %v", key, quotaExceededRequeuePeriod, err)
+		pc.workqueue.AddAfter(key, quotaExceededRequeuePeriod)
+		return nil
+	case providers.ErrorClassInvalidSpec:
+		// The pod can never be run by the provider (and has already been marked as failed), so there is no point in retrying.
+		// Failures to delete the pod are however retried as transient errors, as the pod would otherwise keep running in the provider.
+		if _, ok := err.(deletePodError); !ok {
+			pc.workqueue.Forget(key)
+			return pkgerrors.Wrapf(err, "forgetting %q as the provider deems its spec invalid", key)
+		}
+	}
+
+	// At this point the error is deemed transient.
+	if pc.workqueue.NumRequeues(key) < maxRetries {
+		// Put the item back on the work queue to handle any transient errors.
+		log.G(ctx).Warnf("requeuing %q due to failed sync: %v", key, err)
+		pc.workqueue.AddRateLimited(key)
+		return nil
+	}
+	// We've exceeded the maximum retries, so we must forget the key.
+	pc.workqueue.Forget(key)
+	return pkgerrors.Wrapf(err, "forgetting %q due to maximum retries reached", key)
+}
+
 // syncHandler compares the actual state with the desired, and attempts to converge the two.
 func (pc *PodController) syncHandler(ctx context.Context, key string) error {
 	ctx, span := trace.StartSpan(ctx, "syncHandler")
@@ -249,7 +301,7 @@ func (pc *PodController) syncHandler(ctx context.Context, key string) error {
 		if err := pc.server.deletePod(ctx, namespace, name); err != nil {
 			err := pkgerrors.Wrapf(err, "failed to delete pod %q in the provider", loggablePodNameFromCoordinates(namespace, name))
 			span.SetStatus(ocstatus.FromError(err))
-			return err
+			return deletePodError{err}
 		}
 		return nil
 	}
@@ -271,7 +323,7 @@ func (pc *PodController) syncPodInProvider(ctx context.Context, pod *corev1.Pod)
 		if err := pc.server.deletePod(ctx, pod.Namespace, pod.Name); err != nil {
 			err := pkgerrors.Wrapf(err, "failed to delete pod %q in the provider", loggablePodName(pod))
 			span.SetStatus(ocstatus.FromError(err))
-			return err
+			return deletePodError{err}
 		}
 		return nil
 	}
@@ -0,0 +1,141 @@
+package vkubelet
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cpuguy83/strongerrors"
+	pkgerrors "github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/workqueue"
+
+	"github.com/virtual-kubelet/virtual-kubelet/providers"
+	testutil "github.com/virtual-kubelet/virtual-kubelet/test/util"
+)
+
+// recordingQueue is a work queue that records how keys are requeued.
+type recordingQueue struct {
+	workqueue.RateLimitingInterface
+	addedAfter  map[interface{}]time.Duration
+	rateLimited map[interface{}]int
+	forgotten   map[interface{}]bool
+}
+
+func newRecordingQueue() *recordingQueue {
+	return &recordingQueue{
+		RateLimitingInterface: workqueue.NewRateLimitingQueue(workqueue.NewItemExponentialFailureRateLimiter(time.Millisecond, time.Millisecond)),
+		addedAfter:            make(map[interface{}]time.Duration),
+		rateLimited:           make(map[interface{}]int),
+		forgotten:             make(map[interface{}]bool),
+	}
+}
+
+func (q *recordingQueue) AddAfter(item interface{}, d time.Duration) {
+	q.addedAfter[item] = d
+}
+
+func (q *recordingQueue) AddRateLimited(item interface{}) {
+	q.rateLimited[item]++
+	q.RateLimitingInterface.AddRateLimited(item)
+}
+
+func (q *recordingQueue) Forget(item interface{}) {
+	q.forgotten[item] = true
+	q.RateLimitingInterface.Forget(item)
+}
+
+// TestHandleSyncError verifies that keys failing to sync are requeued according to the class of the error returned by the provider.
+func TestHandleSyncError(t *testing.T) {
+	q := newRecordingQueue()
+	defer q.ShutDown()
+	pc := &PodController{workqueue: q}
+	ctx := context.Background()
+	err := pkgerrors.New("error")
+
+	// Throttling errors are retried after the delay indicated by the provider, even when wrapped.
+	assert.NoError(t, pc.handleSyncError(ctx, "ns/throttled", pkgerrors.Wrap(providers.Throttled(err, 3*time.Second), "failed to create pod")))
+	assert.Equal(t, 3*time.Second, q.addedAfter["ns/throttled"])
+	assert.Zero(t, q.rateLimited["ns/throttled"])
+
+	// Throttling errors without a delay are retried with a back-off rather than right away.
+	assert.NoError(t, pc.handleSyncError(ctx, "ns/retry-now", providers.Throttled(err, 0)))
+	_, ok := q.addedAfter["ns/retry-now"]
+	assert.False(t, ok)
+	assert.Equal(t, 1, q.rateLimited["ns/retry-now"])
+
+	// Quota errors are retried periodically.
+	assert.NoError(t, pc.handleSyncError(ctx, "ns/quota", providers.QuotaExceeded(err)))
+	assert.Equal(t, quotaExceededRequeuePeriod, q.addedAfter["ns/quota"])
+
+	// Invalid specs are never retried.
+	assert.Error(t, pc.handleSyncError(ctx, "ns/invalid", providers.InvalidSpec(err)))
+	assert.True(t, q.forgotten["ns/invalid"])
+	assert.Zero(t, q.rateLimited["ns/invalid"])
+
+	// Pods which the provider fails to delete are retried, even when it deems them invalid.
+	assert.NoError(t, pc.handleSyncError(ctx, "ns/invalid-delete", deletePodError{providers.InvalidSpec(err)}))
+	assert.False(t, q.forgotten["ns/invalid-delete"])
+	assert.Equal(t, 1, q.rateLimited["ns/invalid-delete"])
+
+	// Transient errors (including unclassified ones) are retried with a back-off up to a maximum number of times.
+	for i := 0; i < maxRetries; i++ {
+		assert.NoError(t, pc.handleSyncError(ctx, "ns/transient", strongerrors.Unavailable(err)))
+	}
+	assert.Equal(t, maxRetries, q.rateLimited["ns/transient"])
+	assert.Error(t, pc.handleSyncError(ctx, "ns/transient", err))
+	assert.True(t, q.forgotten["ns/transient"])
+}
+
+// fakeCreateProvider is a provider failing to create pods with the specified error.
+type fakeCreateProvider struct {
+	providers.Provider
+	err error
+}
+
+func (p *fakeCreateProvider) GetPod(ctx context.Context, namespace, name string) (*corev1.Pod, error) {
+	return nil, nil
+}
+
+func (p *fakeCreateProvider) CreatePod(ctx context.Context, pod *corev1.Pod) error {
+	return p.err
+}
+
+// TestCreatePodFailure verifies that only pods the provider deems invalid are failed, and that the reason set by a failed attempt is cleared once the pod is created.
+func TestCreatePodFailure(t *testing.T) {
+	pod := testutil.FakePodWithSingleContainer(namespace, "pod-0", "image-0")
+	pod.ResourceVersion = "1"
+	pod.Spec.RestartPolicy = corev1.RestartPolicyNever
+	pod.Status.Phase = corev1.PodPending
+	cs, _ := newFakeClientset(pod)
+	p := &fakeCreateProvider{err: providers.Transient(pkgerrors.New("error"))}
+	s := &Server{k8sClient: cs, provider: p, resourceManager: testutil.FakeResourceManager()}
+	recorder := testutil.FakeEventRecorder(5)
+	ctx := context.Background()
+
+	// Pods failing to be created for transient reasons are left pending, even if they are never restarted.
+	assert.Error(t, s.createOrUpdatePod(ctx, pod.DeepCopy(), recorder))
+	res, err := cs.CoreV1().Pods(namespace).Get(pod.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, corev1.PodPending, res.Status.Phase)
+	assert.Equal(t, podStatusReasonProviderFailed, res.Status.Reason)
+
+	// Once the pod is created, the reason is cleared so that its status is synced again.
+	p.err = nil
+	require.NoError(t, s.createOrUpdatePod(ctx, res, recorder))
+	res, err = cs.CoreV1().Pods(namespace).Get(pod.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, corev1.PodPending, res.Status.Phase)
+	assert.Empty(t, res.Status.Reason)
+	assert.Empty(t, res.Status.Message)
+
+	// Pods the provider deems invalid are failed.
+	p.err = providers.InvalidSpec(pkgerrors.New("error"))
+	assert.Error(t, s.createOrUpdatePod(ctx, res, recorder))
+	res, err = cs.CoreV1().Pods(namespace).Get(pod.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, corev1.PodFailed, res.Status.Phase)
+}
@@ -0,0 +1,182 @@
+package vkubelet
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/cpuguy83/strongerrors/status/ocstatus"
+	pkgerrors "github.com/pkg/errors"
+	"go.opencensus.io/trace"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	// podConditionReasonReadinessGatesNotReady is the reason set on the "Ready" condition of pods whose readiness gates are not all satisfied.
+	// This is the same reason used by the Kubelet.
+	// https://github.com/kubernetes/kubernetes/blob/v1.13.1/pkg/kubelet/status/generate.go#L31
+	podConditionReasonReadinessGatesNotReady = "ReadinessGatesNotReady"
+)
+
+// mergePodStatus merges the status reported by the provider for the specified pod with the pod's current status in Kubernetes.
+// Conditions not reported by the provider (e.g. "PodScheduled" or conditions backing readiness gates, which are set by other controllers) are preserved,
+// as are the transition times of conditions whose status hasn't changed and the pod's start time.
+// The pod's readiness gates are then evaluated in order to compute the final value of its "Ready" condition.
+// https://github.com/kubernetes/kubernetes/blob/v1.13.1/pkg/kubelet/status/status_manager.go#L663-L680
+func mergePodStatus(pod *corev1.Pod, oldStatus, newStatus corev1.PodStatus) corev1.PodStatus {
+	res := *newStatus.DeepCopy()
+
+	// The start time of a pod never changes once it has been set.
+	if oldStatus.StartTime != nil && !oldStatus.StartTime.IsZero() {
+		res.StartTime = oldStatus.StartTime.DeepCopy()
+	}
+
+	// Preserve the transition time of conditions whose status hasn't changed.
+	reported := make(map[corev1.PodConditionType]bool, len(res.Conditions))
+	for idx := range res.Conditions {
+		c := &res.Conditions[idx]
+		reported[c.Type] = true
+		if old := findPodCondition(oldStatus.Conditions, c.Type); old != nil && old.Status == c.Status {
+			c.LastTransitionTime = old.LastTransitionTime
+		}
+	}
+	// Preserve conditions not reported by the provider.
+	for _, c := range oldStatus.Conditions {
+		if !reported[c.Type] {
+			res.Conditions = append(res.Conditions, *c.DeepCopy())
+		}
+	}
+
+	evaluateReadinessGates(pod, &res)
+	return res
+}
+
+// evaluateReadinessGates marks the specified pod status as not ready unless every readiness gate defined in the pod's spec is satisfied.
+// https://github.com/kubernetes/kubernetes/blob/v1.13.1/pkg/kubelet/status/generate.go#L59-L96
+func evaluateReadinessGates(pod *corev1.Pod, status *corev1.PodStatus) {
+	if len(pod.Spec.ReadinessGates) == 0 {
+		return
+	}
+	ready := findPodCondition(status.Conditions, corev1.PodReady)
+	if ready != nil && ready.Status != corev1.ConditionTrue {
+		// The pod is already not ready, regardless of its readiness gates.
+		return
+	}
+	if ready == nil && !(status.Phase == corev1.PodRunning && podContainersReady(&corev1.Pod{Spec: pod.Spec, Status: *status})) {
+		setPodCondition(status, corev1.PodReady, false, "", "")
+		return
+	}
+
+	unmet := make([]string, 0)
+	for _, gate := range pod.Spec.ReadinessGates {
+		c := findPodCondition(status.Conditions, gate.ConditionType)
+		if c == nil {
+			unmet = append(unmet, fmt.Sprintf("corresponding condition of pod readiness gate %q does not exist.", gate.ConditionType))
+			continue
+		}
+		if c.Status != corev1.ConditionTrue {
+			unmet = append(unmet, fmt.Sprintf("the status of pod readiness gate %q is not \"True\", but %v.", gate.ConditionType, c.Status))
+		}
+	}
+	if len(unmet) > 0 {
+		setPodCondition(status, corev1.PodReady, false, podConditionReasonReadinessGatesNotReady, strings.Join(unmet, ", "))
+		return
+	}
+	setPodCondition(status, corev1.PodReady, true, "", "")
+}
+
+// findPodCondition returns the condition of the specified type, or nil if it does not exist.
+func findPodCondition(conditions []corev1.PodCondition, conditionType corev1.PodConditionType) *corev1.PodCondition {
+	for idx := range conditions {
+		if conditions[idx].Type == conditionType {
+			return &conditions[idx]
+		}
+	}
+	return nil
+}
+
+// setPodCondition sets the condition of the specified type in the specified pod status, updating its transition time if its status changes.
+func setPodCondition(status *corev1.PodStatus, conditionType corev1.PodConditionType, value bool, reason, message string) {
+	cs := corev1.ConditionFalse
+	if value {
+		cs = corev1.ConditionTrue
+	}
+	if c := findPodCondition(status.Conditions, conditionType); c != nil {
+		if c.Status != cs {
+			c.Status = cs
+			c.LastTransitionTime = metav1.Now()
+		}
+		c.Reason = reason
+		c.Message = message
+		return
+	}
+	status.Conditions = append(status.Conditions, corev1.PodCondition{
+		Type:               conditionType,
+		Status:             cs,
+		Reason:             reason,
+		Message:            message,
+		LastTransitionTime: metav1.Now(),
+	})
+}
+
+// createPodStatusPatch creates a strategic merge patch that transforms the specified pod's status into the specified status.
+// An empty patch (i.e. "{}") is returned if the two statuses are semantically equal.
+// Otherwise, the pod's resource version is included in the patch as a precondition.
+func createPodStatusPatch(pod *corev1.Pod, status corev1.PodStatus) ([]byte, error) {
+	return createStrategicMergePatch(corev1.Pod{Status: pod.Status}, corev1.Pod{Status: status}, corev1.Pod{}, pod.ResourceVersion)
+}
+
+// patchPodStatus updates the status of the specified pod in Kubernetes using a strategic merge patch.
+// "mutate" is called with a copy of the pod and must modify its status as desired.
+// Only the fields that differ between the pod's current status and the desired one are sent, so that fields set by other writers are not clobbered.
+// The patch is conditioned on the pod's resource version, and is retried against the latest version of the pod (re-running "mutate") in case of a conflict.
+// It returns the patched pod, or nil if nothing has changed and the pod was not patched.
+func (s *Server) patchPodStatus(ctx context.Context, pod *corev1.Pod, mutate func(*corev1.Pod)) (*corev1.Pod, error) {
+	ctx, span := trace.StartSpan(ctx, "patchPodStatus")
+	defer span.End()
+	addPodAttributes(span, pod)
+
+	var (
+		current = pod
+		patched *corev1.Pod
+	)
+	err := retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		desired := current.DeepCopy()
+		mutate(desired)
+
+		patch, err := createPodStatusPatch(current, desired.Status)
+		if err != nil {
+			return pkgerrors.Wrap(err, "failed to create pod status patch")
+		}
+		if isEmptyPatch(patch) {
+			span.Annotate(nil, "Pod status is unchanged")
+			return nil
+		}
+
+		res, err := s.k8sClient.CoreV1().Pods(current.Namespace).Patch(current.Name, types.StrategicMergePatchType, patch, "status")
+		if errors.IsConflict(err) {
+			// The pod has been modified since we last read it, so we grab its latest version and try again.
+			span.Annotate(nil, "Conflict while patching pod status, retrying")
+			latest, getErr := s.k8sClient.CoreV1().Pods(current.Namespace).Get(current.Name, metav1.GetOptions{})
+			if getErr != nil {
+				return getErr
+			}
+			current = latest
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		patched = res
+		return nil
+	})
+	if err != nil {
+		span.SetStatus(ocstatus.FromError(err))
+		return nil, err
+	}
+	return patched, nil
+}
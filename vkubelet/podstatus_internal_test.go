@@ -0,0 +1,124 @@
+package vkubelet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	testutil "github.com/virtual-kubelet/virtual-kubelet/test/util"
+)
+
+const (
+	// readinessGate1 is the condition type of a readiness gate set by an external controller.
+	readinessGate1 = corev1.PodConditionType("example.com/feature-1")
+)
+
+// TestMergePodStatus verifies that conditions not reported by the provider are preserved, as are the transition times of unchanged conditions.
+func TestMergePodStatus(t *testing.T) {
+	pod := testutil.FakePodWithSingleContainer(namespace, "pod-0", "image-0")
+	then := metav1.NewTime(time.Now().Add(-time.Hour).Truncate(time.Second))
+	now := metav1.NewTime(time.Now().Truncate(time.Second))
+
+	oldStatus := corev1.PodStatus{
+		Phase:     corev1.PodRunning,
+		StartTime: &then,
+		Conditions: []corev1.PodCondition{
+			{Type: corev1.PodScheduled, Status: corev1.ConditionTrue, LastTransitionTime: then},
+			{Type: corev1.PodInitialized, Status: corev1.ConditionTrue, LastTransitionTime: then},
+			{Type: corev1.PodReady, Status: corev1.ConditionFalse, LastTransitionTime: then},
+		},
+	}
+	newStatus := corev1.PodStatus{
+		Phase:     corev1.PodRunning,
+		StartTime: &now,
+		Conditions: []corev1.PodCondition{
+			{Type: corev1.PodInitialized, Status: corev1.ConditionTrue, LastTransitionTime: now},
+			{Type: corev1.PodReady, Status: corev1.ConditionTrue, LastTransitionTime: now},
+		},
+	}
+
+	res := mergePodStatus(pod, oldStatus, newStatus)
+
+	// The start time must not change once it has been set.
+	assert.Equal(t, then, *res.StartTime)
+	// The "PodScheduled" condition must be preserved even though the provider didn't report it.
+	if c := findPodCondition(res.Conditions, corev1.PodScheduled); assert.NotNil(t, c) {
+		assert.Equal(t, corev1.ConditionTrue, c.Status)
+	}
+	// The transition time of the "Initialized" condition must be preserved since its status didn't change.
+	if c := findPodCondition(res.Conditions, corev1.PodInitialized); assert.NotNil(t, c) {
+		assert.Equal(t, then, c.LastTransitionTime)
+	}
+	// The "Ready" condition changed, so its new transition time must be used.
+	if c := findPodCondition(res.Conditions, corev1.PodReady); assert.NotNil(t, c) {
+		assert.Equal(t, corev1.ConditionTrue, c.Status)
+		assert.Equal(t, now, c.LastTransitionTime)
+	}
+}
+
+// TestMergePodStatusReadinessGates verifies that a pod is only reported as ready when all its readiness gates are satisfied.
+func TestMergePodStatusReadinessGates(t *testing.T) {
+	pod := testutil.FakePodWithSingleContainer(namespace, "pod-0", "image-0")
+	pod.Spec.ReadinessGates = []corev1.PodReadinessGate{
+		{ConditionType: readinessGate1},
+	}
+	newStatus := corev1.PodStatus{
+		Phase: corev1.PodRunning,
+		Conditions: []corev1.PodCondition{
+			{Type: corev1.PodReady, Status: corev1.ConditionTrue},
+		},
+	}
+
+	// The readiness gate's condition does not exist yet, so the pod must not be ready.
+	res := mergePodStatus(pod, corev1.PodStatus{}, newStatus)
+	if c := findPodCondition(res.Conditions, corev1.PodReady); assert.NotNil(t, c) {
+		assert.Equal(t, corev1.ConditionFalse, c.Status)
+		assert.Equal(t, podConditionReasonReadinessGatesNotReady, c.Reason)
+	}
+
+	// The readiness gate's condition is set by an external controller, but is not satisfied.
+	oldStatus := corev1.PodStatus{
+		Conditions: []corev1.PodCondition{
+			{Type: readinessGate1, Status: corev1.ConditionFalse},
+		},
+	}
+	res = mergePodStatus(pod, oldStatus, newStatus)
+	if c := findPodCondition(res.Conditions, corev1.PodReady); assert.NotNil(t, c) {
+		assert.Equal(t, corev1.ConditionFalse, c.Status)
+	}
+
+	// The readiness gate is satisfied, so the pod must be ready.
+	oldStatus.Conditions[0].Status = corev1.ConditionTrue
+	res = mergePodStatus(pod, oldStatus, newStatus)
+	if c := findPodCondition(res.Conditions, corev1.PodReady); assert.NotNil(t, c) {
+		assert.Equal(t, corev1.ConditionTrue, c.Status)
+		assert.Empty(t, c.Reason)
+	}
+	if c := findPodCondition(res.Conditions, readinessGate1); assert.NotNil(t, c) {
+		assert.Equal(t, corev1.ConditionTrue, c.Status)
+	}
+}
+
+// TestCreatePodStatusPatch verifies that no patch is produced when the pod's status hasn't changed, and that only changed fields are patched otherwise.
+func TestCreatePodStatusPatch(t *testing.T) {
+	pod := testutil.FakePodWithSingleContainer(namespace, "pod-0", "image-0")
+	pod.Status = corev1.PodStatus{
+		Phase: corev1.PodPending,
+		Conditions: []corev1.PodCondition{
+			{Type: corev1.PodScheduled, Status: corev1.ConditionTrue},
+		},
+	}
+
+	patch, err := createPodStatusPatch(pod, *pod.Status.DeepCopy())
+	assert.NoError(t, err)
+	assert.True(t, isEmptyPatch(patch))
+
+	status := *pod.Status.DeepCopy()
+	status.Phase = corev1.PodRunning
+	patch, err = createPodStatusPatch(pod, status)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"status":{"phase":"Running"}}`, string(patch))
+}
@@ -0,0 +1,410 @@
+package vkubelet
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cpuguy83/strongerrors"
+	pkgerrors "github.com/pkg/errors"
+	"go.opencensus.io/trace"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+	"github.com/virtual-kubelet/virtual-kubelet/providers"
+)
+
+const (
+	// Probe defaults, as defined by the Kubernetes API.
+	// https://github.com/kubernetes/kubernetes/blob/v1.13.1/pkg/apis/core/v1/defaults.go#L202-L216
+	defaultProbeTimeoutSeconds    = 1
+	defaultProbePeriodSeconds     = 10
+	defaultProbeSuccessThreshold  = 1
+	defaultProbeFailureThreshold  = 3
+	probeLiveness                 = "Liveness"
+	probeReadiness                = "Readiness"
+	reasonContainerUnhealthy      = "Unhealthy"
+	reasonContainerKilling        = "Killing"
+	reasonContainerRestartFailure = "FailedToRestart"
+)
+
+// probeKey uniquely identifies a probe defined for a given container in a given pod.
+type probeKey struct {
+	podUID        types.UID
+	containerName string
+	probeType     string
+}
+
+// probeState holds the state of a single probe across successive runs.
+type probeState struct {
+	// containerID is the ID of the container the state refers to.
+	// The state is reset whenever the container is replaced (e.g. restarted).
+	containerID string
+	// lastRun is the time at which the probe was last run.
+	lastRun time.Time
+	// successes and failures are the number of consecutive successful and failed runs, respectively.
+	successes int32
+	failures  int32
+	// result is the current result of the probe, after applying the success and failure thresholds.
+	result bool
+	// initialized indicates whether the probe has run at least once.
+	initialized bool
+}
+
+// prober runs liveness and readiness probes against pods managed by providers that don't run probes natively.
+// HTTP and TCP probes are run against the pod's IP, while exec probes are run via the provider's ExecInContainer.
+// Probes are evaluated on every provider sync, honoring the period, thresholds and initial delay of each probe.
+type prober struct {
+	provider   providers.Provider
+	httpClient *http.Client
+
+	mu     sync.Mutex
+	states map[probeKey]*probeState
+}
+
+// newProber creates a prober for pods managed by the specified provider.
+func newProber(provider providers.Provider) *prober {
+	return &prober{
+		provider: provider,
+		httpClient: &http.Client{
+			Transport: &http.Transport{
+				// The Kubelet does not verify the certificates presented by containers either.
+				TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+				DisableKeepAlives: true,
+				Proxy:             http.ProxyURL(nil),
+			},
+		},
+		states: make(map[probeKey]*probeState),
+	}
+}
+
+// needsProber returns whether virtual-kubelet should run probes for pods managed by the specified provider.
+func needsProber(provider providers.Provider) bool {
+	np, ok := provider.(providers.NativeProber)
+	return !ok || !np.SupportsNativeProbes()
+}
+
+// forget drops the state of every probe defined for the specified pod.
+func (p *prober) forget(pod *corev1.Pod) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for key := range p.states {
+		if key.podUID == pod.UID {
+			delete(p.states, key)
+		}
+	}
+}
+
+// probe runs the liveness and readiness probes defined for the containers in the specified pod, and updates the pod's status accordingly.
+// The pod's status is expected to have just been reported by the provider.
+func (p *prober) probe(ctx context.Context, pod *corev1.Pod, recorder record.EventRecorder) {
+	ctx, span := trace.StartSpan(ctx, "probe")
+	defer span.End()
+	addPodAttributes(span, pod)
+
+	now := time.Now()
+	hasReadinessProbes := false
+
+	for idx := range pod.Status.ContainerStatuses {
+		cs := &pod.Status.ContainerStatuses[idx]
+		c := findContainer(pod, cs.Name)
+		if c == nil {
+			continue
+		}
+
+		if c.LivenessProbe != nil && cs.State.Running != nil {
+			if !p.run(ctx, pod, c, cs, probeLiveness, c.LivenessProbe, now, recorder) {
+				p.handleLivenessFailure(ctx, pod, c, recorder)
+			}
+		}
+
+		if c.ReadinessProbe != nil {
+			hasReadinessProbes = true
+			cs.Ready = cs.State.Running != nil && p.run(ctx, pod, c, cs, probeReadiness, c.ReadinessProbe, now, recorder)
+		}
+	}
+
+	// Only override the readiness reported by the provider when readiness probes have been run.
+	if hasReadinessProbes {
+		ready := podContainersReady(pod)
+		setPodCondition(&pod.Status, corev1.ContainersReady, ready, "", "")
+		setPodCondition(&pod.Status, corev1.PodReady, ready && pod.Status.Phase == corev1.PodRunning, "", "")
+	}
+}
+
+// run runs the specified probe if its period has elapsed, and returns its current result.
+func (p *prober) run(ctx context.Context, pod *corev1.Pod, c *corev1.Container, cs *corev1.ContainerStatus, probeType string, probe *corev1.Probe, now time.Time, recorder record.EventRecorder) bool {
+	// Readiness probes start out as failed, while liveness probes start out as successful.
+	// https://github.com/kubernetes/kubernetes/blob/v1.13.1/pkg/kubelet/prober/worker.go#L87-L97
+	initialResult := probeType == probeLiveness
+
+	// Do not run the probe until the initial delay has passed since the container started.
+	if cs.State.Running != nil && now.Before(cs.State.Running.StartedAt.Add(time.Duration(probe.InitialDelaySeconds)*time.Second)) {
+		return initialResult
+	}
+
+	p.mu.Lock()
+	key := probeKey{podUID: pod.UID, containerName: c.Name, probeType: probeType}
+	state, ok := p.states[key]
+	if !ok || state.containerID != cs.ContainerID {
+		state = &probeState{containerID: cs.ContainerID, result: initialResult}
+		p.states[key] = state
+	}
+	if state.initialized && now.Sub(state.lastRun) < time.Duration(valueOrDefaultInt32(probe.PeriodSeconds, defaultProbePeriodSeconds))*time.Second {
+		result := state.result
+		p.mu.Unlock()
+		return result
+	}
+	p.mu.Unlock()
+
+	logger := log.G(ctx).WithField("pod", pod.GetName()).WithField("namespace", pod.GetNamespace()).WithField("container", c.Name).WithField("probe", probeType)
+
+	ok, output, err := p.runProbe(ctx, pod, c, probe)
+	if err != nil {
+		// The probe could not be run, so we keep the current result.
+		log.Trace(logger.WithError(err), "skipping probe")
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		return state.result
+	}
+	if !ok {
+		recorder.Eventf(pod, corev1.EventTypeWarning, reasonContainerUnhealthy, "%s probe failed: %s", probeType, output)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	state.lastRun = now
+	state.initialized = true
+	if ok {
+		state.successes++
+		state.failures = 0
+		if state.successes >= valueOrDefaultInt32(probe.SuccessThreshold, defaultProbeSuccessThreshold) {
+			state.result = true
+		}
+	} else {
+		state.failures++
+		state.successes = 0
+		if state.failures >= valueOrDefaultInt32(probe.FailureThreshold, defaultProbeFailureThreshold) {
+			state.result = false
+		}
+	}
+	return state.result
+}
+
+// handleLivenessFailure reports a failed liveness probe to the provider, if it supports restarting containers.
+func (p *prober) handleLivenessFailure(ctx context.Context, pod *corev1.Pod, c *corev1.Container, recorder record.EventRecorder) {
+	logger := log.G(ctx).WithField("pod", pod.GetName()).WithField("namespace", pod.GetNamespace()).WithField("container", c.Name)
+
+	// Reset the liveness probe's state so that the container is given a fresh set of attempts.
+	p.mu.Lock()
+	delete(p.states, probeKey{podUID: pod.UID, containerName: c.Name, probeType: probeLiveness})
+	p.mu.Unlock()
+
+	cr, ok := p.provider.(providers.ContainerRestarter)
+	if !ok {
+		logger.Warn("Container failed its liveness probe but the provider does not support restarting containers")
+		return
+	}
+
+	err := cr.RestartContainer(ctx, pod, c.Name)
+	if strongerrors.IsNotImplemented(err) {
+		// Wrappers around providers (e.g. rate limiters) implement this interface regardless of whether the wrapped provider does.
+		logger.Warn("Container failed its liveness probe but the provider does not support restarting containers")
+		return
+	}
+	recorder.Eventf(pod, corev1.EventTypeNormal, reasonContainerKilling, "Container %s failed liveness probe, will be restarted", c.Name)
+	if err != nil {
+		logger.WithError(err).Error("Failed to restart container that failed its liveness probe")
+		recorder.Eventf(pod, corev1.EventTypeWarning, reasonContainerRestartFailure, "Failed to restart container %s: %v", c.Name, err)
+	}
+}
+
+// runProbe runs the specified probe once.
+// It returns whether the probe succeeded and its output, or an error if the probe could not be run.
+func (p *prober) runProbe(ctx context.Context, pod *corev1.Pod, c *corev1.Container, probe *corev1.Probe) (bool, string, error) {
+	timeout := time.Duration(valueOrDefaultInt32(probe.TimeoutSeconds, defaultProbeTimeoutSeconds)) * time.Second
+
+	switch {
+	case probe.Exec != nil:
+		return p.runExecProbe(pod, c, probe.Exec, timeout)
+	case probe.HTTPGet != nil:
+		return p.runHTTPProbe(ctx, pod, c, probe.HTTPGet, timeout)
+	case probe.TCPSocket != nil:
+		return p.runTCPProbe(pod, c, probe.TCPSocket, timeout)
+	}
+	return false, "", fmt.Errorf("probe does not specify a handler")
+}
+
+// runExecProbe runs the specified command in the container via the provider.
+// Commands exiting with a non-zero status are reported by providers as errors.
+// The probe fails if the provider doesn't support exec or doesn't report the exit status of commands (see providers.ExecExitStatusReporter), as it can't tell whether the command succeeded.
+func (p *prober) runExecProbe(pod *corev1.Pod, c *corev1.Container, action *corev1.ExecAction, timeout time.Duration) (bool, string, error) {
+	if r, ok := p.provider.(providers.ExecExitStatusReporter); !ok || !r.ReportsExecExitStatus() {
+		return false, "the provider does not report the exit status of commands", nil
+	}
+	var stdout, stderr bytes.Buffer
+	// The pod name is built the same way as in the exec handler.
+	name := fmt.Sprintf("%s-%s", pod.Namespace, pod.Name)
+	err := p.provider.ExecInContainer(name, pod.UID, c.Name, action.Command, nil, nopWriteCloser{&stdout}, nopWriteCloser{&stderr}, false, nil, timeout)
+	if err != nil {
+		if strongerrors.IsNotImplemented(err) {
+			return false, fmt.Sprintf("the provider does not support exec: %v", err), nil
+		}
+		return false, fmt.Sprintf("%v: %s%s", err, stdout.String(), stderr.String()), nil
+	}
+	return true, stdout.String(), nil
+}
+
+// runHTTPProbe performs an HTTP GET request against the container.
+// Any status code greater than or equal to 200 and less than 400 indicates success.
+func (p *prober) runHTTPProbe(ctx context.Context, pod *corev1.Pod, c *corev1.Container, action *corev1.HTTPGetAction, timeout time.Duration) (bool, string, error) {
+	host := action.Host
+	if host == "" {
+		host = pod.Status.PodIP
+	}
+	if host == "" {
+		return false, "", fmt.Errorf("pod has no IP address")
+	}
+	port, err := resolveProbePort(action.Port, c)
+	if err != nil {
+		return false, "", err
+	}
+	u, err := url.Parse(action.Path)
+	if err != nil {
+		return false, "", pkgerrors.Wrapf(err, "invalid probe path %q", action.Path)
+	}
+	u.Scheme = strings.ToLower(string(action.Scheme))
+	if u.Scheme == "" {
+		u.Scheme = "http"
+	}
+	u.Host = net.JoinHostPort(host, strconv.Itoa(port))
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return false, "", err
+	}
+	req.Header.Set("User-Agent", "kube-probe/"+vkVersion)
+	for _, h := range action.HTTPHeaders {
+		if h.Name == "Host" {
+			req.Host = h.Value
+			continue
+		}
+		req.Header.Add(h.Name, h.Value)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	res, err := p.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return false, err.Error(), nil
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= http.StatusOK && res.StatusCode < http.StatusBadRequest {
+		return true, res.Status, nil
+	}
+	return false, fmt.Sprintf("HTTP probe failed with statuscode: %d", res.StatusCode), nil
+}
+
+// runTCPProbe attempts to open a TCP connection to the container.
+func (p *prober) runTCPProbe(pod *corev1.Pod, c *corev1.Container, action *corev1.TCPSocketAction, timeout time.Duration) (bool, string, error) {
+	host := action.Host
+	if host == "" {
+		host = pod.Status.PodIP
+	}
+	if host == "" {
+		return false, "", fmt.Errorf("pod has no IP address")
+	}
+	port, err := resolveProbePort(action.Port, c)
+	if err != nil {
+		return false, "", err
+	}
+
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, strconv.Itoa(port)), timeout)
+	if err != nil {
+		return false, err.Error(), nil
+	}
+	conn.Close()
+	return true, "", nil
+}
+
+// resolveProbePort resolves the port targeted by a probe, which may reference one of the container's ports by name.
+// https://github.com/kubernetes/kubernetes/blob/v1.13.1/pkg/kubelet/prober/prober.go#L187-L207
+func resolveProbePort(port intstr.IntOrString, c *corev1.Container) (int, error) {
+	var res int
+	switch port.Type {
+	case intstr.Int:
+		res = port.IntValue()
+	case intstr.String:
+		for _, p := range c.Ports {
+			if p.Name == port.StrVal {
+				res = int(p.ContainerPort)
+				break
+			}
+		}
+		if res == 0 {
+			// Fall back to interpreting the value as a port number.
+			n, err := strconv.Atoi(port.StrVal)
+			if err != nil {
+				return 0, fmt.Errorf("couldn't find port %q in container %q", port.StrVal, c.Name)
+			}
+			res = n
+		}
+	}
+	if res <= 0 || res > 65535 {
+		return 0, fmt.Errorf("invalid port number: %v", port.String())
+	}
+	return res, nil
+}
+
+// findContainer returns the container with the specified name in the specified pod, or nil if it doesn't exist.
+func findContainer(pod *corev1.Pod, name string) *corev1.Container {
+	for idx := range pod.Spec.Containers {
+		if pod.Spec.Containers[idx].Name == name {
+			return &pod.Spec.Containers[idx]
+		}
+	}
+	return nil
+}
+
+// podContainersReady returns whether all containers in the specified pod are reported as ready.
+func podContainersReady(pod *corev1.Pod) bool {
+	if len(pod.Status.ContainerStatuses) < len(pod.Spec.Containers) {
+		return false
+	}
+	for _, cs := range pod.Status.ContainerStatuses {
+		if !cs.Ready {
+			return false
+		}
+	}
+	return true
+}
+
+// valueOrDefaultInt32 returns the specified value, or the specified default if the value is zero.
+func valueOrDefaultInt32(value, defaultValue int32) int32 {
+	if value == 0 {
+		return defaultValue
+	}
+	return value
+}
+
+// nopWriteCloser wraps a bytes.Buffer so it can be used where an io.WriteCloser is required.
+type nopWriteCloser struct {
+	*bytes.Buffer
+}
+
+// Close does nothing.
+func (nopWriteCloser) Close() error {
+	return nil
+}
@@ -0,0 +1,215 @@
+package vkubelet
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/cpuguy83/strongerrors"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/tools/remotecommand"
+
+	"github.com/virtual-kubelet/virtual-kubelet/providers"
+	testutil "github.com/virtual-kubelet/virtual-kubelet/test/util"
+)
+
+// fakeRunningPod returns a running pod having a single running container that defines the specified readiness probe.
+func fakeRunningPod(readinessProbe *corev1.Probe) *corev1.Pod {
+	pod := testutil.FakePodWithSingleContainer(namespace, "pod-0", "image-0")
+	pod.UID = "uid-0"
+	pod.Spec.Containers[0].ReadinessProbe = readinessProbe
+	pod.Spec.Containers[0].Ports = []corev1.ContainerPort{
+		{
+			Name:          "http",
+			ContainerPort: 8080,
+		},
+	}
+	pod.Status = corev1.PodStatus{
+		Phase: corev1.PodRunning,
+		PodIP: "127.0.0.1",
+		ContainerStatuses: []corev1.ContainerStatus{
+			{
+				Name:        pod.Spec.Containers[0].Name,
+				ContainerID: "container-0",
+				Ready:       true,
+				State: corev1.ContainerState{
+					Running: &corev1.ContainerStateRunning{
+						StartedAt: metav1.NewTime(time.Now().Add(-time.Minute)),
+					},
+				},
+			},
+		},
+	}
+	return pod
+}
+
+// podCondition returns the status of the condition of the specified type in the specified pod.
+func podCondition(pod *corev1.Pod, conditionType corev1.PodConditionType) corev1.ConditionStatus {
+	for _, c := range pod.Status.Conditions {
+		if c.Type == conditionType {
+			return c.Status
+		}
+	}
+	return corev1.ConditionUnknown
+}
+
+// TestProbeHTTP verifies that HTTP readiness probes are run against the pod's IP and that their result is reflected in the pod's status.
+func TestProbeHTTP(t *testing.T) {
+	healthy := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !healthy {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, err := net.SplitHostPort(u.Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pr := newProber(nil)
+	recorder := testutil.FakeEventRecorder(defaultEventRecorderBufferSize)
+	probe := &corev1.Probe{
+		Handler: corev1.Handler{
+			HTTPGet: &corev1.HTTPGetAction{
+				Path: "/healthz",
+				Port: intstr.FromInt(p),
+			},
+		},
+		FailureThreshold: 1,
+	}
+
+	// The container is healthy, so it must be reported as ready.
+	pod := fakeRunningPod(probe)
+	pr.probe(context.Background(), pod, recorder)
+	assert.True(t, pod.Status.ContainerStatuses[0].Ready)
+	assert.Equal(t, corev1.ConditionTrue, podCondition(pod, corev1.PodReady))
+
+	// The container becomes unhealthy, so it must be reported as not ready once the probe runs again.
+	healthy = false
+	for _, s := range pr.states {
+		s.lastRun = time.Time{}
+	}
+	pod = fakeRunningPod(probe)
+	pr.probe(context.Background(), pod, recorder)
+	assert.False(t, pod.Status.ContainerStatuses[0].Ready)
+	assert.Equal(t, corev1.ConditionFalse, podCondition(pod, corev1.PodReady))
+	assert.Len(t, recorder.Events, 1)
+
+	// Forgetting the pod must drop the state of its probes.
+	pr.forget(pod)
+	assert.Len(t, pr.states, 0)
+}
+
+// fakeExecProvider is a provider running commands with the specified result, and which may not report their exit status.
+type fakeExecProvider struct {
+	providers.Provider
+	reportsExitStatus bool
+	err               error
+}
+
+func (p *fakeExecProvider) ExecInContainer(name string, uid types.UID, container string, cmd []string, in io.Reader, out, err io.WriteCloser, tty bool, resize <-chan remotecommand.TerminalSize, timeout time.Duration) error {
+	return p.err
+}
+
+func (p *fakeExecProvider) ReportsExecExitStatus() bool {
+	return p.reportsExitStatus
+}
+
+// TestProbeExec verifies that exec probes only succeed when the provider reports that the command exited with a zero status.
+func TestProbeExec(t *testing.T) {
+	probe := &corev1.Probe{
+		Handler: corev1.Handler{
+			Exec: &corev1.ExecAction{Command: []string{"true"}},
+		},
+		FailureThreshold: 1,
+	}
+	for _, tc := range []struct {
+		name     string
+		provider *fakeExecProvider
+		ready    bool
+	}{
+		{name: "success", provider: &fakeExecProvider{reportsExitStatus: true}, ready: true},
+		{name: "failure", provider: &fakeExecProvider{reportsExitStatus: true, err: errors.New("exit status 1")}},
+		{name: "not implemented", provider: &fakeExecProvider{reportsExitStatus: true, err: strongerrors.NotImplemented(errors.New("not implemented"))}},
+		{name: "no exit status", provider: &fakeExecProvider{}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			pr := newProber(tc.provider)
+			recorder := testutil.FakeEventRecorder(defaultEventRecorderBufferSize)
+			pod := fakeRunningPod(probe)
+			pr.probe(context.Background(), pod, recorder)
+			assert.Equal(t, tc.ready, pod.Status.ContainerStatuses[0].Ready)
+			if !tc.ready {
+				assert.Len(t, recorder.Events, 1)
+			}
+		})
+	}
+}
+
+// TestProbeInitialDelay verifies that readiness probes report the container as not ready until their initial delay has passed.
+func TestProbeInitialDelay(t *testing.T) {
+	pr := newProber(nil)
+	pod := fakeRunningPod(&corev1.Probe{
+		Handler: corev1.Handler{
+			TCPSocket: &corev1.TCPSocketAction{
+				Port: intstr.FromString("http"),
+			},
+		},
+		InitialDelaySeconds: 3600,
+	})
+	pr.probe(context.Background(), pod, testutil.FakeEventRecorder(defaultEventRecorderBufferSize))
+	assert.False(t, pod.Status.ContainerStatuses[0].Ready)
+	assert.Len(t, pr.states, 0)
+}
+
+// TestResolveProbePort verifies that probe ports can be specified both by number and by name.
+func TestResolveProbePort(t *testing.T) {
+	c := &corev1.Container{
+		Name: "foo",
+		Ports: []corev1.ContainerPort{
+			{
+				Name:          "http",
+				ContainerPort: 8080,
+			},
+		},
+	}
+
+	port, err := resolveProbePort(intstr.FromInt(80), c)
+	assert.NoError(t, err)
+	assert.Equal(t, 80, port)
+
+	port, err = resolveProbePort(intstr.FromString("http"), c)
+	assert.NoError(t, err)
+	assert.Equal(t, 8080, port)
+
+	port, err = resolveProbePort(intstr.FromString("8081"), c)
+	assert.NoError(t, err)
+	assert.Equal(t, 8081, port)
+
+	_, err = resolveProbePort(intstr.FromString("https"), c)
+	assert.Error(t, err)
+
+	_, err = resolveProbePort(intstr.FromInt(0), c)
+	assert.Error(t, err)
+}
@@ -0,0 +1,143 @@
+package vkubelet
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/virtual-kubelet/virtual-kubelet/manager"
+)
+
+const (
+	// masterServiceNamespace is the namespace of the service exposing the API server.
+	masterServiceNamespace = metav1.NamespaceDefault
+	// masterServiceName is the name of the service exposing the API server.
+	masterServiceName = "kubernetes"
+	// defaultMasterServicePort is the port used by the master service override when none is specified.
+	defaultMasterServicePort = 443
+)
+
+// newMasterServiceOverride returns a service which is used in place of the master service when generating the environment of containers, so that they reach the API server at the specified address.
+// It returns nil if host is empty, in which case the actual master service is used.
+func newMasterServiceOverride(host string, port int32) *corev1.Service {
+	if host == "" {
+		return nil
+	}
+	if port == 0 {
+		port = defaultMasterServicePort
+	}
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: masterServiceNamespace,
+			Name:      masterServiceName,
+		},
+		Spec: corev1.ServiceSpec{
+			ClusterIP: host,
+			Ports: []corev1.ServicePort{
+				{Name: "https", Port: port, Protocol: corev1.ProtocolTCP},
+			},
+		},
+	}
+}
+
+// makeServiceEnvironmentMap returns the environment variables describing the services visible to the specified pod, as the kubelet does.
+// The master service is always visible, and the other services in the pod's namespace are visible unless ".spec.enableServiceLinks" is false.
+// If masterServiceOverride is not nil, it is used in place of the actual master service.
+// https://github.com/kubernetes/kubernetes/blob/v1.13.1/pkg/kubelet/kubelet_pods.go#L508-L549
+func makeServiceEnvironmentMap(pod *corev1.Pod, rm *manager.ResourceManager, masterServiceOverride *corev1.Service) (map[string]string, error) {
+	var services []*corev1.Service
+	if masterServiceOverride != nil {
+		services = append(services, masterServiceOverride)
+	} else {
+		masterNamespaceServices, err := listServices(rm, masterServiceNamespace)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list services in namespace %q: %v", masterServiceNamespace, err)
+		}
+		for _, service := range masterNamespaceServices {
+			if service.Name == masterServiceName {
+				services = append(services, service)
+			}
+		}
+	}
+	// A nil value of ".spec.enableServiceLinks" means "true", as the API server would have defaulted it.
+	if pod.Spec.EnableServiceLinks == nil || *pod.Spec.EnableServiceLinks {
+		namespaceServices, err := listServices(rm, pod.Namespace)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list services in namespace %q: %v", pod.Namespace, err)
+		}
+		for _, service := range namespaceServices {
+			// The master service has already been taken into account, and must not be overridden.
+			if service.Namespace == masterServiceNamespace && service.Name == masterServiceName {
+				continue
+			}
+			services = append(services, service)
+		}
+	}
+
+	res := make(map[string]string)
+	for _, service := range services {
+		for _, env := range makeServiceEnvironmentVariables(service) {
+			res[env.Name] = env.Value
+		}
+	}
+	return res, nil
+}
+
+// listServices returns the services in the specified namespace.
+// No services are returned if the resource manager can't access services, as the kubelet does when it has no service lister.
+// https://github.com/kubernetes/kubernetes/blob/v1.13.1/pkg/kubelet/kubelet_pods.go#L512-L514
+func listServices(rm *manager.ResourceManager, namespace string) ([]*corev1.Service, error) {
+	services, err := rm.ListServices(namespace)
+	if manager.IsNoLister(err) {
+		return nil, nil
+	}
+	return services, err
+}
+
+// makeServiceEnvironmentVariables returns the environment variables describing the specified service, including the docker-links-style ones.
+// Headless services, and services without ports, are not described.
+// https://github.com/kubernetes/kubernetes/blob/v1.13.1/pkg/kubelet/envvars/envvars.go
+func makeServiceEnvironmentVariables(service *corev1.Service) []corev1.EnvVar {
+	if service.Spec.ClusterIP == "" || service.Spec.ClusterIP == corev1.ClusterIPNone || len(service.Spec.Ports) == 0 {
+		return nil
+	}
+	prefix := makeEnvironmentVariableName(service.Name)
+	res := []corev1.EnvVar{
+		{Name: prefix + "_SERVICE_HOST", Value: service.Spec.ClusterIP},
+		// The first port is given the backwards-compatible name.
+		{Name: prefix + "_SERVICE_PORT", Value: strconv.Itoa(int(service.Spec.Ports[0].Port))},
+	}
+	for _, port := range service.Spec.Ports {
+		if port.Name != "" {
+			res = append(res, corev1.EnvVar{Name: prefix + "_SERVICE_PORT_" + makeEnvironmentVariableName(port.Name), Value: strconv.Itoa(int(port.Port))})
+		}
+	}
+	for idx, port := range service.Spec.Ports {
+		protocol := string(corev1.ProtocolTCP)
+		if port.Protocol != "" {
+			protocol = string(port.Protocol)
+		}
+		url := fmt.Sprintf("%s://%s", strings.ToLower(protocol), net.JoinHostPort(service.Spec.ClusterIP, strconv.Itoa(int(port.Port))))
+		// Docker special-cases the first port.
+		if idx == 0 {
+			res = append(res, corev1.EnvVar{Name: prefix + "_PORT", Value: url})
+		}
+		portPrefix := fmt.Sprintf("%s_PORT_%d_%s", prefix, port.Port, strings.ToUpper(protocol))
+		res = append(res,
+			corev1.EnvVar{Name: portPrefix, Value: url},
+			corev1.EnvVar{Name: portPrefix + "_PROTO", Value: strings.ToLower(protocol)},
+			corev1.EnvVar{Name: portPrefix + "_PORT", Value: strconv.Itoa(int(port.Port))},
+			corev1.EnvVar{Name: portPrefix + "_ADDR", Value: service.Spec.ClusterIP},
+		)
+	}
+	return res
+}
+
+// makeEnvironmentVariableName returns the prefix used for the environment variables describing a service with the specified name.
+func makeEnvironmentVariableName(name string) string {
+	return strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
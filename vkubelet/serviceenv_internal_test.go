@@ -0,0 +1,176 @@
+package vkubelet
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/virtual-kubelet/virtual-kubelet/manager"
+	testutil "github.com/virtual-kubelet/virtual-kubelet/test/util"
+)
+
+var (
+	// masterService is the service exposing the API server.
+	masterService = &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: masterServiceNamespace, Name: masterServiceName},
+		Spec: corev1.ServiceSpec{
+			ClusterIP: "10.0.0.1",
+			Ports:     []corev1.ServicePort{{Name: "https", Port: 443, Protocol: corev1.ProtocolTCP}},
+		},
+	}
+	// webService is a service in the namespace of the pods used in the tests, exposing two named ports.
+	webService = &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "my-web"},
+		Spec: corev1.ServiceSpec{
+			ClusterIP: "10.0.0.2",
+			Ports: []corev1.ServicePort{
+				{Name: "http", Port: 80, Protocol: corev1.ProtocolTCP},
+				{Name: "dns-udp", Port: 53, Protocol: corev1.ProtocolUDP},
+			},
+		},
+	}
+	// headlessService is a headless service in the namespace of the pods used in the tests, which must not be described.
+	headlessService = &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "headless"},
+		Spec: corev1.ServiceSpec{
+			ClusterIP: corev1.ClusterIPNone,
+			Ports:     []corev1.ServicePort{{Port: 80}},
+		},
+	}
+	// otherService is a service in another namespace, which must not be described.
+	otherService = &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "other", Name: "other"},
+		Spec: corev1.ServiceSpec{
+			ClusterIP: "10.0.0.3",
+			Ports:     []corev1.ServicePort{{Port: 80}},
+		},
+	}
+)
+
+// envMap returns the specified environment variables indexed by name.
+func envMap(env []corev1.EnvVar) map[string]string {
+	res := make(map[string]string, len(env))
+	for _, e := range env {
+		res[e.Name] = e.Value
+	}
+	return res
+}
+
+// TestServiceEnvironmentVariables verifies that the environment of containers describes the master service and the services in the pod's namespace, in the same way the kubelet does, without overriding variables defined by the containers.
+func TestServiceEnvironmentVariables(t *testing.T) {
+	rm := testutil.FakeResourceManager(masterService, webService, headlessService, otherService)
+	er := record.NewFakeRecorder(defaultEventRecorderBufferSize)
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{
+					Env: []corev1.EnvVar{{Name: "MY_WEB_SERVICE_HOST", Value: "overridden"}},
+				},
+			},
+		},
+	}
+	require.NoError(t, populateEnvironmentVariables(context.Background(), pod, rm, nil, er))
+
+	assert.Equal(t, map[string]string{
+		"KUBERNETES_SERVICE_HOST":       "10.0.0.1",
+		"KUBERNETES_SERVICE_PORT":       "443",
+		"KUBERNETES_SERVICE_PORT_HTTPS": "443",
+		"KUBERNETES_PORT":               "tcp://10.0.0.1:443",
+		"KUBERNETES_PORT_443_TCP":       "tcp://10.0.0.1:443",
+		"KUBERNETES_PORT_443_TCP_PROTO": "tcp",
+		"KUBERNETES_PORT_443_TCP_PORT":  "443",
+		"KUBERNETES_PORT_443_TCP_ADDR":  "10.0.0.1",
+		"MY_WEB_SERVICE_HOST":           "overridden",
+		"MY_WEB_SERVICE_PORT":           "80",
+		"MY_WEB_SERVICE_PORT_HTTP":      "80",
+		"MY_WEB_SERVICE_PORT_DNS_UDP":   "53",
+		"MY_WEB_PORT":                   "tcp://10.0.0.2:80",
+		"MY_WEB_PORT_80_TCP":            "tcp://10.0.0.2:80",
+		"MY_WEB_PORT_80_TCP_PROTO":      "tcp",
+		"MY_WEB_PORT_80_TCP_PORT":       "80",
+		"MY_WEB_PORT_80_TCP_ADDR":       "10.0.0.2",
+		"MY_WEB_PORT_53_UDP":            "udp://10.0.0.2:53",
+		"MY_WEB_PORT_53_UDP_PROTO":      "udp",
+		"MY_WEB_PORT_53_UDP_PORT":       "53",
+		"MY_WEB_PORT_53_UDP_ADDR":       "10.0.0.2",
+	}, envMap(pod.Spec.Containers[0].Env))
+	assert.Len(t, pod.Spec.Containers[0].Env, 21)
+	// The variables describing services follow those defined by the container, in a stable order.
+	var names []string
+	for _, env := range pod.Spec.Containers[0].Env[1:] {
+		names = append(names, env.Name)
+	}
+	assert.True(t, sort.StringsAreSorted(names))
+}
+
+// TestServiceEnvironmentVariablesWithoutServiceLister verifies that no services are described when the resource manager can't access services, as the kubelet does.
+func TestServiceEnvironmentVariablesWithoutServiceLister(t *testing.T) {
+	rm, err := manager.NewResourceManager(nil, nil, nil)
+	require.NoError(t, err)
+	er := record.NewFakeRecorder(defaultEventRecorderBufferSize)
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{
+					Env: []corev1.EnvVar{{Name: "FOO", Value: "bar"}},
+				},
+			},
+		},
+	}
+	require.NoError(t, populateEnvironmentVariables(context.Background(), pod, rm, nil, er))
+	assert.Equal(t, []corev1.EnvVar{{Name: "FOO", Value: "bar"}}, pod.Spec.Containers[0].Env)
+}
+
+// TestServiceEnvironmentVariablesWithoutServiceLinks verifies that only the master service is described when ".spec.enableServiceLinks" is false.
+func TestServiceEnvironmentVariablesWithoutServiceLinks(t *testing.T) {
+	rm := testutil.FakeResourceManager(masterService, webService)
+	er := record.NewFakeRecorder(defaultEventRecorderBufferSize)
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace},
+		Spec: corev1.PodSpec{
+			EnableServiceLinks: &bFalse,
+			Containers:         []corev1.Container{{}},
+		},
+	}
+	require.NoError(t, populateEnvironmentVariables(context.Background(), pod, rm, nil, er))
+
+	env := envMap(pod.Spec.Containers[0].Env)
+	assert.Equal(t, "10.0.0.1", env["KUBERNETES_SERVICE_HOST"])
+	assert.NotContains(t, env, "MY_WEB_SERVICE_HOST")
+}
+
+// TestServiceEnvironmentVariablesWithMasterServiceOverride verifies that the master service override is described in place of the actual master service, including to pods in the master service's namespace.
+func TestServiceEnvironmentVariablesWithMasterServiceOverride(t *testing.T) {
+	rm := testutil.FakeResourceManager(masterService)
+	er := record.NewFakeRecorder(defaultEventRecorderBufferSize)
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: masterServiceNamespace},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{}},
+		},
+	}
+	require.NoError(t, populateEnvironmentVariables(context.Background(), pod, rm, newMasterServiceOverride("apiserver.example.com", 0), er))
+
+	env := envMap(pod.Spec.Containers[0].Env)
+	assert.Equal(t, "apiserver.example.com", env["KUBERNETES_SERVICE_HOST"])
+	assert.Equal(t, "443", env["KUBERNETES_SERVICE_PORT"])
+	assert.Equal(t, "tcp://apiserver.example.com:443", env["KUBERNETES_PORT"])
+	assert.Len(t, pod.Spec.Containers[0].Env, 8)
+
+	assert.Nil(t, newMasterServiceOverride("", 443))
+	// IPv6 addresses are enclosed in brackets in URLs.
+	override := newMasterServiceOverride("fd00::1", 6443)
+	assert.Equal(t, "tcp://[fd00::1]:6443", envMap(makeServiceEnvironmentVariables(override))["KUBERNETES_PORT"])
+}
@@ -2,12 +2,14 @@ package vkubelet
 
 import (
 	"context"
+	"sync"
 	"time"
 
 	"go.opencensus.io/trace"
 	corev1 "k8s.io/api/core/v1"
 	corev1informers "k8s.io/client-go/informers/core/v1"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
 
 	"github.com/virtual-kubelet/virtual-kubelet/manager"
 	"github.com/virtual-kubelet/virtual-kubelet/providers"
@@ -15,23 +17,35 @@ import (
 
 const (
 	podStatusReasonProviderFailed = "ProviderFailed"
+	// podEventReasonProviderCreateFailed is the reason of the event recorded when the provider fails to create a pod.
+	podEventReasonProviderCreateFailed = "ProviderCreateFailed"
 )
 
 // Server masquarades itself as a kubelet and allows for the virtual node to be backed by non-vm/node providers.
 type Server struct {
 	nodeName        string
 	namespace       string
-	k8sClient       *kubernetes.Clientset
+	k8sClient       kubernetes.Interface
 	taint           *corev1.Taint
 	provider        providers.Provider
 	resourceManager *manager.ResourceManager
 	podSyncWorkers  int
 	podInformer     corev1informers.PodInformer
+	// secretInformer and configMapInformer are used to watch the secrets and config maps referenced by the volumes of pods, if set.
+	secretInformer    corev1informers.SecretInformer
+	configMapInformer corev1informers.ConfigMapInformer
+	// podStartTimes holds the time at which each pod was created in the provider, indexed by UID.
+	podStartTimes sync.Map
+	// prober runs liveness and readiness probes for providers that don't run them natively.
+	// It is nil otherwise.
+	prober *prober
+	// masterServiceOverride is used in place of the "kubernetes" service when generating the environment of containers, if set.
+	masterServiceOverride *corev1.Service
 }
 
 // Config is used to configure a new server.
 type Config struct {
-	Client          *kubernetes.Clientset
+	Client          kubernetes.Interface
 	Namespace       string
 	NodeName        string
 	Provider        providers.Provider
@@ -39,6 +53,14 @@ type Config struct {
 	Taint           *corev1.Taint
 	PodSyncWorkers  int
 	PodInformer     corev1informers.PodInformer
+	// SecretInformer and ConfigMapInformer are optional.
+	// The volumes of running pods are refreshed (or an event is recorded, if the provider can't do so) whenever the secrets and config maps they reference change, as observed by these informers or by the resource manager itself.
+	SecretInformer    corev1informers.SecretInformer
+	ConfigMapInformer corev1informers.ConfigMapInformer
+	// KubernetesServiceHost and KubernetesServicePort are optional.
+	// When KubernetesServiceHost is set, containers are told to reach the API server at this address (and at KubernetesServicePort, or 443 if unset) through the "KUBERNETES_SERVICE_*" environment variables, instead of at the cluster IP of the "kubernetes" service, which pods running outside of the cluster network usually can't reach.
+	KubernetesServiceHost string
+	KubernetesServicePort int32
 }
 
 // New creates a new virtual-kubelet server.
@@ -47,16 +69,23 @@ type Config struct {
 // This creates but does not start the server.
 // You must call `Run` on the returned object to start the server.
 func New(cfg Config) *Server {
-	return &Server{
-		namespace:       cfg.Namespace,
-		nodeName:        cfg.NodeName,
-		taint:           cfg.Taint,
-		k8sClient:       cfg.Client,
-		resourceManager: cfg.ResourceManager,
-		provider:        cfg.Provider,
-		podSyncWorkers:  cfg.PodSyncWorkers,
-		podInformer:     cfg.PodInformer,
+	s := &Server{
+		namespace:             cfg.Namespace,
+		nodeName:              cfg.NodeName,
+		taint:                 cfg.Taint,
+		k8sClient:             cfg.Client,
+		resourceManager:       cfg.ResourceManager,
+		provider:              cfg.Provider,
+		podSyncWorkers:        cfg.PodSyncWorkers,
+		podInformer:           cfg.PodInformer,
+		secretInformer:        cfg.SecretInformer,
+		configMapInformer:     cfg.ConfigMapInformer,
+		masterServiceOverride: newMasterServiceOverride(cfg.KubernetesServiceHost, cfg.KubernetesServicePort),
 	}
+	if needsProber(cfg.Provider) {
+		s.prober = newProber(cfg.Provider)
+	}
+	return s
 }
 
 // Run creates and starts an instance of the pod controller, blocking until it stops.
@@ -69,13 +98,19 @@ func (s *Server) Run(ctx context.Context) error {
 		return err
 	}
 
-	go s.providerSyncLoop(ctx)
+	pc := NewPodController(s)
+
+	go s.providerSyncLoop(ctx, pc.recorder)
+
+	if s.resourceManager != nil {
+		go newVolumeUpdater(s, pc.recorder).run(ctx)
+	}
 
-	return NewPodController(s).Run(ctx, s.podSyncWorkers)
+	return pc.Run(ctx, s.podSyncWorkers)
 }
 
 // providerSyncLoop syncronizes pod states from the provider back to kubernetes
-func (s *Server) providerSyncLoop(ctx context.Context) {
+func (s *Server) providerSyncLoop(ctx context.Context, recorder record.EventRecorder) {
 	const sleepTime = 5 * time.Second
 
 	t := time.NewTimer(sleepTime)
@@ -90,7 +125,7 @@ func (s *Server) providerSyncLoop(ctx context.Context) {
 
 			ctx, span := trace.StartSpan(ctx, "syncActualState")
 			s.updateNode(ctx)
-			s.updatePodStatuses(ctx)
+			s.updatePodStatuses(ctx, recorder)
 			span.End()
 
 			// restart the timer
@@ -0,0 +1,133 @@
+package vkubelet
+
+import (
+	"context"
+	"time"
+
+	"github.com/cpuguy83/strongerrors"
+	"github.com/cpuguy83/strongerrors/status/ocstatus"
+	"go.opencensus.io/trace"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+	"github.com/virtual-kubelet/virtual-kubelet/providers"
+)
+
+const (
+	// podEventReasonVolumesNotUpdated is the reason of the event recorded when the secrets, config maps or service account tokens projected into the volumes of a pod change, but the provider can't update them in place.
+	podEventReasonVolumesNotUpdated = "VolumesNotUpdated"
+	// podEventReasonFailedToUpdateVolumes is the reason of the event recorded when the provider fails to update the volumes of a pod.
+	podEventReasonFailedToUpdateVolumes = "FailedToUpdateVolumes"
+)
+
+// volumeUpdater refreshes the volumes of running pods when the secrets and config maps they reference change, or when their service account tokens must be refreshed.
+type volumeUpdater struct {
+	server *Server
+	// workqueue holds the keys of the pods whose volumes must be refreshed.
+	// Using a work queue guarantees that the volumes of a given pod are never refreshed concurrently, and that bursts of changes are coalesced.
+	workqueue workqueue.RateLimitingInterface
+	recorder  record.EventRecorder
+}
+
+// newVolumeUpdater returns a volume updater that is notified of changes to secrets and config maps by the server's resource manager.
+func newVolumeUpdater(s *Server, recorder record.EventRecorder) *volumeUpdater {
+	vu := &volumeUpdater{
+		server:    s,
+		workqueue: workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "volumes"),
+		recorder:  recorder,
+	}
+	var secretInformer, configMapInformer cache.SharedInformer
+	if s.secretInformer != nil {
+		secretInformer = s.secretInformer.Informer()
+	}
+	if s.configMapInformer != nil {
+		configMapInformer = s.configMapInformer.Informer()
+	}
+	s.resourceManager.WatchVolumeSources(secretInformer, configMapInformer, func(pod *corev1.Pod) {
+		if key, err := cache.MetaNamespaceKeyFunc(pod); err != nil {
+			log.L.Error(err)
+		} else {
+			vu.workqueue.AddRateLimited(key)
+		}
+	})
+	// Keep watching the secrets and config maps referenced by the pods' volumes when they are fetched on demand, even though running pods don't access them.
+	s.resourceManager.TrackPodReferences(s.podInformer.Informer())
+	return vu
+}
+
+// run processes the keys of pods whose volumes must be refreshed until the specified context is cancelled.
+func (vu *volumeUpdater) run(ctx context.Context) {
+	go func() {
+		<-ctx.Done()
+		vu.workqueue.ShutDown()
+	}()
+	wait.Until(func() {
+		for vu.processNextWorkItem(ctx) {
+		}
+	}, time.Second, ctx.Done())
+}
+
+// processNextWorkItem reads a single key off the work queue and refreshes the volumes of the corresponding pod.
+func (vu *volumeUpdater) processNextWorkItem(ctx context.Context) bool {
+	obj, shutdown := vu.workqueue.Get()
+	if shutdown {
+		return false
+	}
+	defer vu.workqueue.Done(obj)
+
+	ctx, span := trace.StartSpan(ctx, "updatePodVolumes")
+	defer span.End()
+
+	key := obj.(string)
+	span.AddAttributes(trace.StringAttribute("key", key))
+	if err := vu.updatePodVolumes(ctx, key); err != nil {
+		span.SetStatus(ocstatus.FromError(err))
+		if vu.workqueue.NumRequeues(key) < maxRetries {
+			log.G(ctx).Warnf("requeuing %q due to failed volume update: %v", key, err)
+			vu.workqueue.AddRateLimited(key)
+			return true
+		}
+		log.G(ctx).WithError(err).Errorf("forgetting %q due to maximum retries reached", key)
+	}
+	vu.workqueue.Forget(key)
+	return true
+}
+
+// updatePodVolumes asks the provider to refresh the volumes of the pod with the specified key, or records an event if it can't.
+func (vu *volumeUpdater) updatePodVolumes(ctx context.Context, key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return err
+	}
+	pod, err := vu.server.podInformer.Lister().Pods(namespace).Get(name)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	// Volumes of pods that are terminated or being deleted don't matter anymore.
+	if pod.DeletionTimestamp != nil || pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+		return nil
+	}
+
+	pvu, ok := vu.server.provider.(providers.PodVolumesUpdater)
+	if ok {
+		err = pvu.UpdatePodVolumes(ctx, pod)
+	}
+	if !ok || strongerrors.IsNotImplemented(err) {
+		vu.recorder.Event(pod, corev1.EventTypeWarning, podEventReasonVolumesNotUpdated, "The secrets, config maps or service account tokens projected into the pod's volumes changed, but the provider can't update volumes in place. Recreate the pod to use the new contents.")
+		return nil
+	}
+	if err != nil {
+		vu.recorder.Eventf(pod, corev1.EventTypeWarning, podEventReasonFailedToUpdateVolumes, "Failed to update the pod's volumes: %v", err)
+		return err
+	}
+	log.G(ctx).WithField("pod", pod.Name).WithField("namespace", pod.Namespace).Info("Updated pod volumes")
+	return nil
+}
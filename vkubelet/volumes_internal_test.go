@@ -0,0 +1,55 @@
+package vkubelet
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/virtual-kubelet/virtual-kubelet/providers"
+	testutil "github.com/virtual-kubelet/virtual-kubelet/test/util"
+)
+
+// fakeVolumesProvider is a provider recording the pods whose volumes it is asked to update.
+type fakeVolumesProvider struct {
+	providers.Provider
+	updated []string
+}
+
+func (p *fakeVolumesProvider) UpdatePodVolumes(ctx context.Context, pod *corev1.Pod) error {
+	p.updated = append(p.updated, pod.Name)
+	return nil
+}
+
+// TestUpdatePodVolumes verifies that providers implementing providers.PodVolumesUpdater are asked to update the volumes of pods, and that an event is recorded for the others.
+func TestUpdatePodVolumes(t *testing.T) {
+	pod := testutil.FakePodWithSingleContainer(namespace, "pod-0", "image-0")
+	factory := informers.NewSharedInformerFactory(fake.NewSimpleClientset(pod), 0)
+	podInformer := factory.Core().V1().Pods()
+	podInformer.Informer()
+	stop := make(chan struct{})
+	defer close(stop)
+	factory.Start(stop)
+	require.True(t, cache.WaitForCacheSync(stop, podInformer.Informer().HasSynced))
+
+	p := &fakeVolumesProvider{}
+	recorder := testutil.FakeEventRecorder(5)
+	vu := &volumeUpdater{server: &Server{provider: p, podInformer: podInformer}, recorder: recorder}
+	require.NoError(t, vu.updatePodVolumes(context.Background(), namespace+"/pod-0"))
+	assert.Equal(t, []string{"pod-0"}, p.updated)
+	assert.Empty(t, recorder.Events)
+
+	// Pods that no longer exist are ignored.
+	require.NoError(t, vu.updatePodVolumes(context.Background(), namespace+"/missing"))
+	assert.Equal(t, []string{"pod-0"}, p.updated)
+
+	// Providers that can't update volumes in place cause an event to be recorded.
+	vu.server.provider = &fakeNodeProvider{}
+	require.NoError(t, vu.updatePodVolumes(context.Background(), namespace+"/pod-0"))
+	assert.Contains(t, <-recorder.Events, podEventReasonVolumesNotUpdated)
+}